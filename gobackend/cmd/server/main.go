@@ -2,13 +2,12 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
-	"runtime"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v3"
@@ -16,10 +15,13 @@ import (
 	"github.com/gofiber/fiber/v3/middleware/logger"
 	"github.com/gofiber/fiber/v3/middleware/recover"
 
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
 	"github.com/gabriel/open_upload_gobackend/internal/auth"
 	"github.com/gabriel/open_upload_gobackend/internal/config"
 	"github.com/gabriel/open_upload_gobackend/internal/db"
+	"github.com/gabriel/open_upload_gobackend/internal/jobs"
 	"github.com/gabriel/open_upload_gobackend/internal/routes"
+	"github.com/gabriel/open_upload_gobackend/internal/thumbcache"
 )
 
 func main() {
@@ -47,22 +49,56 @@ func main() {
 		log.Fatalf("failed to ensure bucket %q: %v", minioCfg.Bucket, err)
 	}
 
+	// Cache for imgproxy-generated images (nil when THUMBNAIL_CACHE_DIR is unset)
+	thumbCache := thumbcache.New(minioCfg.ThumbnailCacheDir, minioCfg.ThumbnailCacheTTL, minioCfg.ThumbnailCacheMaxBytes)
+
 	// Fiber app
-	app := fiber.New(fiber.Config{
+	fiberCfg := fiber.Config{
 		AppName:      "OpenUpload Go Backend",
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
-	})
+		// Bodies up to JSON_BODY_LIMIT are read before routing; larger ones are
+		// streamed, so only the upload routes read them (UPLOAD_BODY_LIMIT below)
+		BodyLimit:         int(appCfg.JSONBodyLimit),
+		StreamRequestBody: true,
+		// Parse multipart forms in the upload handlers, after the limits ran
+		DisablePreParseMultipartForm: true,
+		// Render all errors as JSON {"detail", "code"}
+		ErrorHandler: apierror.Handler,
+	}
+	// Behind a reverse proxy, take the client IP from ProxyHeader, but only when
+	// the direct peer is a trusted proxy so clients can't spoof it.
+	if len(appCfg.TrustedProxies) > 0 {
+		fiberCfg.TrustProxy = true
+		fiberCfg.ProxyHeader = appCfg.ProxyHeader
+		// Pick the first valid IP from the header instead of returning it verbatim
+		fiberCfg.EnableIPValidation = true
+		for _, p := range appCfg.TrustedProxies {
+			switch strings.ToLower(p) {
+			case "loopback":
+				fiberCfg.TrustProxyConfig.Loopback = true
+			case "private":
+				fiberCfg.TrustProxyConfig.Private = true
+			case "linklocal":
+				fiberCfg.TrustProxyConfig.LinkLocal = true
+			default:
+				fiberCfg.TrustProxyConfig.Proxies = append(fiberCfg.TrustProxyConfig.Proxies, p)
+			}
+		}
+		log.Printf("trusting %s from proxies: %s", appCfg.ProxyHeader, strings.Join(appCfg.TrustedProxies, ", "))
+	}
+	app := fiber.New(fiberCfg)
 
 	app.Use(recover.New())
 	app.Use(logger.New())
+	app.Use(routes.CloseStreamedBody())
 
 	// CORS for authenticated routes (mirror Python's FRONTEND_URL)
 	// Note: Public file routes have their own permissive CORS below
 	corsConfig := cors.Config{
 		AllowCredentials: true,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Authorization", "Content-Type", "X-API-Key"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Authorization", "Content-Type", "X-API-Key", "If-None-Match", auth.TenantHeader, auth.CSRFHeader},
 	}
 	if appCfg.FrontendURL != "" {
 		corsConfig.AllowOrigins = []string{appCfg.FrontendURL}
@@ -79,92 +115,129 @@ func main() {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
 			log.Printf("auth: /me missing Authorization header")
-			return fiber.NewError(http.StatusUnauthorized, "Authorization header is required")
+			return apierror.New(http.StatusUnauthorized, apierror.MissingAuth, "Authorization header is required")
 		}
 
 		parts := strings.SplitN(authHeader, " ", 2)
 		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
 			log.Printf("auth: /me malformed Authorization header: %q", authHeader)
-			return fiber.NewError(http.StatusUnauthorized, "Authorization header must be Bearer token")
+			return apierror.New(http.StatusUnauthorized, apierror.MalformedAuth, "Authorization header must be Bearer token")
 		}
 
 		token := parts[1]
 		// Increased timeout to allow Firebase SDK to fetch public keys on first request
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		fbUser, err := auth.VerifyIDToken(ctx, token)
+		fbUser, err := auth.VerifyTenantIDToken(ctx, c.Get(auth.TenantHeader), token)
 		if err != nil {
 			log.Printf("auth: /me VerifyIDToken error: %v (token_len=%d)", err, len(token))
-			return fiber.NewError(http.StatusUnauthorized, fmt.Sprintf("Invalid Firebase ID token: %v", err))
+			return auth.TokenError(err)
 		}
 
 		// get-or-create DB user
 		dbUser, err := auth.GetOrCreateDBUser(ctx, fbUser)
 		if err != nil {
 			log.Printf("GetOrCreateDBUser error: %v", err)
-			return fiber.NewError(http.StatusInternalServerError, "Failed to load user profile")
-		}
-
-		return c.JSON(dbUser)
-	})
-
-	// OpenAPI spec for Swagger UI at /docs (frontend calls /openapi.json).
-	app.Get("/openapi.json", func(c fiber.Ctx) error {
-		// Try to find openapi.json in common locations
-		// First try relative to current working directory, then relative to executable
-		possiblePaths := []string{
-			"openapi.json",
-			"../../openapi.json",
-			"../../../openapi.json",
+			return apierror.New(http.StatusInternalServerError, apierror.InternalError, "Failed to load user profile")
 		}
 
-		// Also try relative to the source file location (for development)
-		if _, filename, _, ok := runtime.Caller(0); ok {
-			sourceDir := filepath.Dir(filename)
-			possiblePaths = append(possiblePaths,
-				filepath.Join(sourceDir, "../../openapi.json"),
-			)
+		// ?include=roles,projects bundles what the frontend otherwise fetches
+		// separately on load; without it the bare user is returned as before.
+		include := c.Query("include")
+		if include == "" {
+			return c.JSON(dbUser)
 		}
 
-		var specData []byte
-		var err error
-		for _, path := range possiblePaths {
-			specData, err = os.ReadFile(path)
-			if err == nil {
-				break
+		resp := fiber.Map{"user": dbUser}
+		for _, part := range strings.Split(include, ",") {
+			switch strings.TrimSpace(part) {
+			case "roles":
+				roles := fbUser.Roles
+				if roles == nil {
+					roles = make([]string, 0)
+				}
+				resp["roles"] = roles
+			case "projects":
+				conn, err := db.GetDB()
+				if err != nil {
+					return apierror.New(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+				}
+				var projectCount int64
+				if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM project WHERE user_firebase_uid = ?`, fbUser.UID).Scan(&projectCount); err != nil {
+					log.Printf("/me: failed to load project summary: %v", err)
+					return apierror.New(http.StatusInternalServerError, apierror.InternalError, "Failed to load project summary")
+				}
+				// Same figure the storage quota is checked against
+				storageUsed, err := routes.UserStorageUsage(ctx, conn, fbUser.UID)
+				if err != nil {
+					log.Printf("/me: failed to load storage usage: %v", err)
+					return apierror.New(http.StatusInternalServerError, apierror.InternalError, "Failed to load project summary")
+				}
+				resp["project_count"] = projectCount
+				resp["storage_used"] = storageUsed
+			case "":
+			default:
+				return apierror.New(http.StatusBadRequest, apierror.InvalidRequest, "include must be a comma-separated list of: roles, projects")
 			}
 		}
 
-		if err != nil {
-			log.Printf("Failed to read openapi.json from any path: %v", err)
-			return fiber.NewError(http.StatusInternalServerError, "Failed to load API specification")
-		}
-
-		c.Set("Content-Type", "application/json")
-		return c.Send(specData)
+		return c.JSON(resp)
 	})
 
+	// OpenAPI spec for Swagger UI at /docs (frontend calls /openapi.json),
+	// generated from the registered routes.
+	app.Get("/openapi.json", routes.OpenAPIHandler(app, appCfg.OpenAPISpecFile))
+
+	// Routes that take file uploads accept up to UPLOAD_BODY_LIMIT bytes
+	app.Use([]string{"/api/v1/files", "/upload", "/projects/import", "/frontend/files/upload"}, routes.LimitBody(appCfg.UploadBodyLimit))
+
 	// API routes
 	api := app.Group("/api/v1")
 	files := api.Group("/files", auth.APIKeyMiddleware())
 	routes.RegisterFileRoutes(files, minioClient, minioCfg)
 
-	// Frontend-style routes (no /api/v1 prefix) to match existing frontend/apiClient.ts
-	projects := app.Group("/projects")
-	routes.RegisterProjectRoutes(projects)
+	// Frontend-style routes (no /api/v1 prefix) to match existing frontend/apiClient.ts.
+	// Groups without uploads only accept JSON_BODY_LIMIT bytes of body.
+	jsonBodyLimit := routes.LimitBody(appCfg.JSONBodyLimit)
+
+	projects := app.Group("/projects", routes.LimitBody(appCfg.JSONBodyLimit, "/projects/import"))
+	routes.RegisterProjectRoutes(projects, minioClient, minioCfg)
 
-	apiKeys := app.Group("/api-keys")
-	routes.RegisterAPIKeyRoutes(apiKeys)
+	apiKeys := app.Group("/api-keys", jsonBodyLimit)
+	routes.RegisterAPIKeyRoutes(apiKeys, minioCfg)
 
-	frontendAPIKeys := app.Group("/frontend/api-keys")
+	frontendAPIKeys := app.Group("/frontend/api-keys", jsonBodyLimit)
 	routes.RegisterFrontendAPIKeyRoutes(frontendAPIKeys)
 
-	usage := app.Group("/usage")
+	usage := app.Group("/usage", jsonBodyLimit)
 	routes.RegisterUsageRoutes(usage, minioClient, minioCfg)
 
 	// Frontend file routes (Firebase auth) and public file-by-id download
-	frontendFiles := app.Group("/frontend/files")
-	routes.RegisterFrontendFileRoutes(frontendFiles, minioClient, minioCfg)
+	frontendFiles := app.Group("/frontend/files", routes.LimitBody(appCfg.JSONBodyLimit, "/frontend/files/upload"))
+	routes.RegisterFrontendFileRoutes(frontendFiles, minioClient, minioCfg, thumbCache)
+
+	// Operator endpoints (developer role)
+	admin := app.Group("/admin", jsonBodyLimit)
+	routes.RegisterAdminRoutes(admin)
+
+	// Browser uploads authenticated by tokens from /frontend/files/upload-token
+	tokenUploads := app.Group("/upload")
+	routes.RegisterTokenUploadRoutes(tokenUploads, minioClient, minioCfg)
+
+	// Cookie sessions exchanged for a Firebase ID token (SESSION_SECRET)
+	authRoutes := app.Group("/auth", jsonBodyLimit)
+	routes.RegisterSessionRoutes(authRoutes)
+	if auth.SessionsEnabled() {
+		log.Printf("session cookies enabled (ttl %s)", auth.SessionTTL())
+	}
+
+	// Share links (public, optionally password-protected)
+	shares := app.Group("/share", jsonBodyLimit)
+	routes.RegisterShareRoutes(shares, minioClient, minioCfg)
+
+	// Content-addressed blob URLs (Firebase auth)
+	blobs := app.Group("/blob", jsonBodyLimit)
+	routes.RegisterBlobRoutes(blobs, minioClient, minioCfg)
 
 	// Public file routes with permissive CORS (allow all origins)
 	publicFiles := app.Group("/files")
@@ -174,11 +247,33 @@ func main() {
 		AllowCredentials: false,
 		AllowOriginsFunc: func(origin string) bool { return true }, // Allow all origins
 	}))
-	routes.RegisterPublicFileRoutes(publicFiles, minioClient, minioCfg)
+	publicFiles.Use(routes.RestrictPublicReferers(minioCfg))
+	routes.RegisterPublicFileRoutes(publicFiles, minioClient, minioCfg, thumbCache)
+
+	// Background jobs (post-upload processing)
+	routes.RegisterJobHandlers(minioClient, minioCfg, thumbCache)
+	jobPool, err := jobs.Start(max(appCfg.JobWorkers, 1), 2*time.Second)
+	if err != nil {
+		log.Fatalf("failed to start job workers: %v", err)
+	}
+	routes.RegisterPeriodicJobs(jobPool, appCfg, minioClient, minioCfg)
+
+	// Graceful shutdown: stop accepting requests, then let running jobs finish
+	shutdownCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-shutdownCtx.Done()
+		log.Printf("shutting down...")
+		if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
+			log.Printf("server shutdown error: %v", err)
+		}
+	}()
 
 	log.Printf("Starting Go backend on :%s", appCfg.Port)
 
 	if err := app.Listen(":" + appCfg.Port); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("server error: %v", err)
 	}
+
+	jobPool.Stop(30 * time.Second)
 }
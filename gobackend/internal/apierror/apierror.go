@@ -0,0 +1,115 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Code is a stable, machine-readable error identifier returned alongside the
+// human-readable detail so clients don't have to string-match messages.
+type Code string
+
+const (
+	InvalidRequest       Code = "INVALID_REQUEST"
+	BodyTooLarge         Code = "BODY_TOO_LARGE"
+	Unauthenticated      Code = "UNAUTHENTICATED"
+	MissingAuth          Code = "MISSING_AUTH"
+	MalformedAuth        Code = "MALFORMED_AUTH"
+	InvalidToken         Code = "INVALID_TOKEN"
+	ExpiredToken         Code = "EXPIRED_TOKEN"
+	Forbidden            Code = "FORBIDDEN"
+	MissingRole          Code = "MISSING_ROLE"
+	NotFound             Code = "NOT_FOUND"
+	ProjectNotFound      Code = "PROJECT_NOT_FOUND"
+	APIKeyNotFound       Code = "API_KEY_NOT_FOUND"
+	FileNotFound         Code = "FILE_NOT_FOUND"
+	MissingAPIKey        Code = "MISSING_API_KEY"
+	InvalidAPIKey        Code = "INVALID_API_KEY"
+	IPNotAllowed         Code = "IP_NOT_ALLOWED"
+	StorageLimitExceeded Code = "STORAGE_LIMIT_EXCEEDED"
+	FileLimitExceeded    Code = "FILE_LIMIT_EXCEEDED"
+	FileTooLarge         Code = "FILE_TOO_LARGE"
+	FileLocked           Code = "FILE_LOCKED"
+	ShareExpired         Code = "SHARE_EXPIRED"
+	PasswordRequired     Code = "PASSWORD_REQUIRED"
+	InvalidPassword      Code = "INVALID_PASSWORD"
+	TooManyAttempts      Code = "TOO_MANY_ATTEMPTS"
+	PreconditionFailed   Code = "PRECONDITION_FAILED"
+	NotAnImage           Code = "NOT_AN_IMAGE"
+	NotText              Code = "NOT_TEXT"
+	NotAVideo            Code = "NOT_A_VIDEO"
+	InvalidSVG           Code = "INVALID_SVG"
+	UnsupportedFileType  Code = "UNSUPPORTED_FILE_TYPE"
+	DatabaseUnavailable  Code = "DATABASE_UNAVAILABLE"
+	StorageError         Code = "STORAGE_ERROR"
+	StorageUnavailable   Code = "STORAGE_UNAVAILABLE"
+	ImageServiceError    Code = "IMAGE_SERVICE_ERROR"
+	InternalError        Code = "INTERNAL_ERROR"
+)
+
+// Error is an HTTP error carrying a Code. Handlers return it like a *fiber.Error.
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	// Field names the request field that failed validation, if any.
+	Field string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// New creates an Error with the given HTTP status, code and message.
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// NewField creates an Error about one request field.
+func NewField(status int, code Code, field, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message, Field: field}
+}
+
+// Body is the JSON error body. "detail" matches the Python backend (and what
+// the frontend reads); "code" is the machine-readable identifier.
+type Body struct {
+	Detail string `json:"detail"`
+	Code   Code   `json:"code"`
+	Field  string `json:"field,omitempty"`
+}
+
+// Handler is a fiber.ErrorHandler that renders every error as a JSON Body.
+// Plain *fiber.Error values (e.g. from middleware) get a code derived from
+// their status so the body shape is always the same.
+func Handler(c fiber.Ctx, err error) error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return c.Status(apiErr.Status).JSON(Body{Detail: apiErr.Message, Code: apiErr.Code, Field: apiErr.Field})
+	}
+
+	status := http.StatusInternalServerError
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	}
+	return c.Status(status).JSON(Body{Detail: err.Error(), Code: codeForStatus(status)})
+}
+
+func codeForStatus(status int) Code {
+	switch status {
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return Forbidden
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusRequestEntityTooLarge:
+		return BodyTooLarge
+	}
+	if status >= 400 && status < 500 {
+		return InvalidRequest
+	}
+	return InternalError
+}
@@ -0,0 +1,67 @@
+// Package audit records who created, changed or deleted what in the
+// audit_log table, for later investigation through /admin/audit.
+package audit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// Actions recorded in audit_log.action.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionImport = "import"
+	// ActionRestore is a file restored from the trash.
+	ActionRestore = "restore"
+)
+
+// Target types recorded in audit_log.target_type.
+const (
+	TargetFile    = "file"
+	TargetProject = "project"
+	TargetAPIKey  = "api_key"
+	// TargetObject is a storage object deleted by key through the API.
+	TargetObject = "object"
+	// TargetShare is a share link to a file.
+	TargetShare = "share"
+	// TargetWebhook is a project webhook.
+	TargetWebhook = "webhook"
+)
+
+// IsAction reports whether action is one of the recorded actions.
+func IsAction(action string) bool {
+	switch action {
+	case ActionCreate, ActionUpdate, ActionDelete, ActionImport, ActionRestore:
+		return true
+	}
+	return false
+}
+
+// Record writes an audit entry. projectID 0 means no project. Failures are
+// logged rather than returned so auditing never fails the request itself.
+func Record(ctx context.Context, actorUID, action, targetType, targetID string, projectID int64, detail string) {
+	conn, err := db.GetDB()
+	if err != nil {
+		log.Printf("audit: database unavailable: %v", err)
+		return
+	}
+
+	var project, det any
+	if projectID > 0 {
+		project = projectID
+	}
+	if detail != "" {
+		det = detail
+	}
+	if _, err := db.ExecWithRetry(ctx, conn, `
+		INSERT INTO audit_log (timestamp, actor_uid, action, target_type, target_id, project_id, detail)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, time.Now().UTC(), actorUID, action, targetType, targetID, project, det); err != nil {
+		log.Printf("audit: failed to record %s %s %s: %v", action, targetType, targetID, err)
+	}
+}
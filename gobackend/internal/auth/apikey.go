@@ -4,8 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"net/http"
+	"net/netip"
+	"strings"
 	"time"
 
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
 	"github.com/gabriel/open_upload_gobackend/internal/db"
 	"github.com/gofiber/fiber/v3"
 )
@@ -24,7 +27,7 @@ func APIKeyMiddleware() fiber.Handler {
 	return func(c fiber.Ctx) error {
 		apiKey := c.Get("X-API-Key")
 		if apiKey == "" {
-			return fiber.NewError(http.StatusUnauthorized, "X-API-Key header is required")
+			return apierror.New(http.StatusUnauthorized, apierror.MissingAPIKey, "X-API-Key header is required")
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -32,35 +35,24 @@ func APIKeyMiddleware() fiber.Handler {
 
 		conn, err := db.GetDB()
 		if err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "database not available")
+			return apierror.New(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 		}
 
 		var key db.ApiKey
-		row := conn.QueryRowContext(ctx, `
-			SELECT id, key, name, is_active, created_at, last_used_at, user_firebase_uid, project_id
+		if err := db.ScanAPIKey(conn.QueryRowContext(ctx, `
+			SELECT `+db.APIKeyColumns+`
 			FROM apikey
 			WHERE key = ? AND is_active = 1
-		`, apiKey)
-
-		var lastUsed sql.NullTime
-		if err := row.Scan(
-			&key.ID,
-			&key.Key,
-			&key.Name,
-			&key.IsActive,
-			&key.CreatedAt,
-			&lastUsed,
-			&key.UserFirebaseUID,
-			&key.ProjectID,
-		); err != nil {
+		`, apiKey), &key); err != nil {
 			if err == sql.ErrNoRows {
-				return fiber.NewError(http.StatusUnauthorized, "Invalid or inactive API key")
+				return apierror.New(http.StatusUnauthorized, apierror.InvalidAPIKey, "Invalid or inactive API key")
 			}
-			return fiber.NewError(http.StatusInternalServerError, "Failed to load API key")
+			return apierror.New(http.StatusInternalServerError, apierror.InternalError, "Failed to load API key")
 		}
-		if lastUsed.Valid {
-			t := lastUsed.Time
-			key.LastUsedAt = &t
+
+		// Keys with an allowlist may only be used from those addresses
+		if !IPAllowed(c.IP(), key.AllowedIPs) {
+			return apierror.New(http.StatusForbidden, apierror.IPNotAllowed, "API key is not allowed from this IP address")
 		}
 
 		// Update last_used_at (best-effort, ignore error)
@@ -69,15 +61,16 @@ func APIKeyMiddleware() fiber.Handler {
 		// Load user and project
 		var user db.User
 		if err := conn.QueryRowContext(ctx, `
-			SELECT firebase_uid, email, created_at
+			SELECT firebase_uid, email, created_at, storage_limit
 			FROM user
 			WHERE firebase_uid = ?
 		`, key.UserFirebaseUID).Scan(
 			&user.FirebaseUID,
 			&user.Email,
 			&user.CreatedAt,
+			&user.StorageLimit,
 		); err != nil {
-			return fiber.NewError(http.StatusUnauthorized, "API key is invalid (missing user)")
+			return apierror.New(http.StatusUnauthorized, apierror.InvalidAPIKey, "API key is invalid (missing user)")
 		}
 
 		var project db.Project
@@ -93,7 +86,7 @@ func APIKeyMiddleware() fiber.Handler {
 			&project.CreatedAt,
 			&project.UserFirebaseUID,
 		); err != nil {
-			return fiber.NewError(http.StatusUnauthorized, "API key is invalid (missing project)")
+			return apierror.New(http.StatusUnauthorized, apierror.InvalidAPIKey, "API key is invalid (missing project)")
 		}
 		if desc.Valid {
 			project.Description = &desc.String
@@ -115,7 +108,46 @@ func GetAPIKeyContext(c fiber.Ctx) (*APIKeyContext, error) {
 	val := c.Locals(apiKeyContextKey)
 	ctxVal, ok := val.(*APIKeyContext)
 	if !ok || ctxVal == nil {
-		return nil, fiber.NewError(http.StatusUnauthorized, "API key context not set")
+		return nil, apierror.New(http.StatusUnauthorized, apierror.Unauthenticated, "API key context not set")
 	}
 	return ctxVal, nil
 }
+
+// ParseAllowedIP parses an allowlist entry: a CIDR ("10.0.0.0/8") or a single
+// address ("203.0.113.7", treated as /32 or /128).
+func ParseAllowedIP(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// IPAllowed reports whether ip matches the allowlist. An empty list allows
+// every address; unparseable IPs or entries never match.
+func IPAllowed(ip string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, entry := range allowed {
+		prefix, err := ParseAllowedIP(entry)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -19,10 +19,10 @@ func GetOrCreateDBUser(ctx context.Context, fbUser *FirebaseUser) (*db.User, err
 
 	var u db.User
 	err = conn.QueryRowContext(ctx, `
-		SELECT firebase_uid, email, created_at
+		SELECT firebase_uid, email, created_at, storage_limit
 		FROM user
 		WHERE firebase_uid = ?
-	`, fbUser.UID).Scan(&u.FirebaseUID, &u.Email, &u.CreatedAt)
+	`, fbUser.UID).Scan(&u.FirebaseUID, &u.Email, &u.CreatedAt, &u.StorageLimit)
 
 	if err == nil {
 		return &u, nil
@@ -44,6 +44,7 @@ func GetOrCreateDBUser(ctx context.Context, fbUser *FirebaseUser) (*db.User, err
 	u.FirebaseUID = fbUser.UID
 	u.Email = fbUser.Email
 	u.CreatedAt = now
+	u.StorageLimit = db.DefaultStorageLimit
 
 	return &u, nil
 }
@@ -2,14 +2,19 @@ package auth
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	firebase "firebase.google.com/go/v4"
+	fbauth "firebase.google.com/go/v4/auth"
 	"google.golang.org/api/option"
 )
 
@@ -20,6 +25,8 @@ type FirebaseUser struct {
 	Roles []string
 	Name  string
 	Token string
+	// Tenant is the Firebase tenant that issued the token.
+	Tenant string
 }
 
 type cachedToken struct {
@@ -27,49 +34,319 @@ type cachedToken struct {
 	expiresAt time.Time
 }
 
+// DefaultTenant is the tenant backed by FIREBASE_CREDENTIALS_PATH.
+const DefaultTenant = "default"
+
+// TenantHeader names the Firebase tenant a request's token belongs to. Without
+// it the tenant is picked from the token's aud claim.
+const TenantHeader = "X-Firebase-Tenant"
+
+// tenant is one Firebase project that may issue ID tokens. Its app and Auth
+// client are initialized lazily; only success is cached, so after a failure
+// the next request retries once the backoff has elapsed.
+type tenant struct {
+	name      string
+	credsPath string
+	projectID string
+	// developerRole lets the tenant's tokens grant the developer role;
+	// always true for the default tenant, opt-in (FIREBASE_DEVELOPER_TENANTS)
+	// for the others.
+	developerRole bool
+
+	mu          sync.Mutex
+	client      *fbauth.Client
+	err         error
+	nextAttempt time.Time
+	backoff     time.Duration
+}
+
 var (
-	fbOnce sync.Once
-	fbApp  *firebase.App
-	fbErr  error
+	// Tenants keyed by name, loaded once from the environment.
+	tenantsOnce sync.Once
+	tenants     map[string]*tenant
 
-	// Token cache: map[token] -> cachedToken
+	// Token cache: map[tenant + "\x00" + token] -> cachedToken
 	tokenCache    = make(map[string]*cachedToken)
 	tokenCacheMu  sync.RWMutex
 	tokenCacheTTL = 5 * time.Minute // Cache tokens for 5 minutes (tokens typically last 1 hour)
 )
 
-// initFirebaseApp initializes the global Firebase app using a service account JSON.
-// It expects FIREBASE_CREDENTIALS_PATH to point to a JSON file, similar to the
-// Python backend's firebase_credentials.json.
-func initFirebaseApp(ctx context.Context) (*firebase.App, error) {
-	fbOnce.Do(func() {
-		credsPath := os.Getenv("FIREBASE_CREDENTIALS_PATH")
-		if credsPath == "" {
-			fbErr = errors.New("FIREBASE_CREDENTIALS_PATH is not set")
-			log.Printf("firebase: FIREBASE_CREDENTIALS_PATH is not set")
+const (
+	fbInitBackoffMin = 1 * time.Second
+	fbInitBackoffMax = 1 * time.Minute
+
+	// maxClockSkew is the tolerance the Firebase SDK itself applies to the
+	// iat and exp claims; FIREBASE_CLOCK_SKEW can only tighten it.
+	maxClockSkew = 5 * time.Minute
+)
+
+// Reasons a token fails verification. The error returned by VerifyIDToken
+// wraps one of these around the SDK's message.
+var (
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenNotYetValid = errors.New("token not yet valid")
+	ErrTokenSignature   = errors.New("token signature invalid")
+	ErrTokenInvalid     = errors.New("token invalid")
+	ErrCertificateFetch = errors.New("failed to fetch Firebase signing certificates")
+)
+
+var (
+	clockSkewOnce sync.Once
+	clockSkew     time.Duration
+)
+
+// clockSkewLeeway is how far iat may lie in the future and exp in the past,
+// from FIREBASE_CLOCK_SKEW (Go duration, default and maximum 5m).
+func clockSkewLeeway() time.Duration {
+	clockSkewOnce.Do(func() {
+		clockSkew = maxClockSkew
+		v := os.Getenv("FIREBASE_CLOCK_SKEW")
+		if v == "" {
+			return
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("firebase: invalid FIREBASE_CLOCK_SKEW=%q, using %s", v, maxClockSkew)
 			return
 		}
+		if d > maxClockSkew {
+			log.Printf("firebase: FIREBASE_CLOCK_SKEW=%s is above the SDK's %s tolerance, using %s", d, maxClockSkew, maxClockSkew)
+			return
+		}
+		clockSkew = d
+	})
+	return clockSkew
+}
 
-		log.Printf("firebase: initializing Firebase app with credentials file: %s", credsPath)
+// checkTokenTimes applies the configured leeway to a verified token's iat and
+// exp claims.
+func checkTokenTimes(token *fbauth.Token, now time.Time) error {
+	leeway := clockSkewLeeway()
+	if issued := time.Unix(token.IssuedAt, 0); issued.After(now.Add(leeway)) {
+		return fmt.Errorf("%w: issued at %s, %s ahead of server clock (leeway %s)", ErrTokenNotYetValid, issued.UTC().Format(time.RFC3339), issued.Sub(now).Round(time.Second), leeway)
+	}
+	if expires := time.Unix(token.Expires, 0); expires.Before(now.Add(-leeway)) {
+		return fmt.Errorf("%w: expired at %s (leeway %s)", ErrTokenExpired, expires.UTC().Format(time.RFC3339), leeway)
+	}
+	return nil
+}
 
-		app, err := firebase.NewApp(ctx, nil, option.WithCredentialsFile(credsPath))
-		if err != nil {
-			fbErr = err
-			log.Printf("firebase: failed to initialize app with credentials %s: %v", credsPath, err)
-			return
+// classifyTokenError wraps an SDK verification error with the reason it
+// failed.
+func classifyTokenError(err error) error {
+	var reason error
+	switch msg := err.Error(); {
+	case fbauth.IsIDTokenExpired(err):
+		reason = ErrTokenExpired
+	case fbauth.IsCertificateFetchFailed(err):
+		reason = ErrCertificateFetch
+	case strings.Contains(msg, "issued at future timestamp"):
+		reason = ErrTokenNotYetValid
+	case strings.Contains(msg, "failed to verify token signature"):
+		reason = ErrTokenSignature
+	default:
+		reason = ErrTokenInvalid
+	}
+	return fmt.Errorf("%w: %v", reason, err)
+}
+
+// loadTenants builds the tenant set: FIREBASE_CREDENTIALS_PATH as the default
+// tenant plus FIREBASE_TENANTS, a JSON object of tenant name to service
+// account JSON path (e.g. {"shop":"/run/secrets/shop.json"}). Each tenant's
+// Firebase project ID is read from its credentials file so tokens can be
+// matched by their aud claim. Tenant names can't contain ":", the separator
+// of namespaced UIDs (see tenantUID).
+func loadTenants() map[string]*tenant {
+	tenantsOnce.Do(func() {
+		tenants = make(map[string]*tenant)
+		if credsPath := os.Getenv("FIREBASE_CREDENTIALS_PATH"); credsPath != "" {
+			tenants[DefaultTenant] = &tenant{name: DefaultTenant, credsPath: credsPath, developerRole: true}
+		}
+		developerTenants := strings.Split(os.Getenv("FIREBASE_DEVELOPER_TENANTS"), ",")
+		if v := os.Getenv("FIREBASE_TENANTS"); v != "" {
+			var paths map[string]string
+			if err := json.Unmarshal([]byte(v), &paths); err != nil {
+				log.Printf("firebase: invalid FIREBASE_TENANTS, ignoring: %v", err)
+			}
+			for name, credsPath := range paths {
+				if name == "" || credsPath == "" || strings.Contains(name, ":") {
+					log.Printf("firebase: ignoring FIREBASE_TENANTS entry %q with empty name or path or a \":\" in the name", name)
+					continue
+				}
+				tenants[name] = &tenant{name: name, credsPath: credsPath, developerRole: slices.Contains(developerTenants, name)}
+			}
+		}
+		for _, t := range tenants {
+			t.projectID = credentialsProjectID(t.credsPath)
 		}
-		log.Printf("firebase: Firebase app initialized successfully with credentials %s", credsPath)
-		fbApp = app
 	})
-	return fbApp, fbErr
+	return tenants
+}
+
+// credentialsProjectID reads project_id from a service account JSON file.
+// Failures are logged; the tenant can then only be selected by name.
+func credentialsProjectID(credsPath string) string {
+	data, err := os.ReadFile(credsPath)
+	if err != nil {
+		log.Printf("firebase: cannot read credentials %s: %v", credsPath, err)
+		return ""
+	}
+	var creds struct {
+		ProjectID string `json:"project_id"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		log.Printf("firebase: cannot parse credentials %s: %v", credsPath, err)
+		return ""
+	}
+	return creds.ProjectID
+}
+
+// resolveTenant picks the tenant that should verify idToken: the named one
+// when the client sent TenantHeader, otherwise the tenant whose project ID
+// (or name) matches the token's aud claim, falling back to the default
+// tenant or the only configured one.
+func resolveTenant(name, idToken string) (*tenant, error) {
+	all := loadTenants()
+	if len(all) == 0 {
+		log.Printf("firebase: FIREBASE_CREDENTIALS_PATH is not set")
+		return nil, errors.New("FIREBASE_CREDENTIALS_PATH is not set")
+	}
+	if name != "" {
+		t, ok := all[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown Firebase tenant %q", name)
+		}
+		return t, nil
+	}
+
+	if aud := tokenAudience(idToken); aud != "" {
+		for _, t := range all {
+			if t.projectID == aud {
+				return t, nil
+			}
+		}
+		if t, ok := all[aud]; ok {
+			return t, nil
+		}
+	}
+	if t, ok := all[DefaultTenant]; ok {
+		return t, nil
+	}
+	if len(all) == 1 {
+		for _, t := range all {
+			return t, nil
+		}
+	}
+	return nil, errors.New("no Firebase tenant matches the token audience")
+}
+
+// tokenAudience returns the aud claim of a JWT without verifying it. It only
+// routes the token to a tenant; that tenant's verification still checks the
+// signature and audience.
+func tokenAudience(idToken string) string {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Aud
+}
+
+// authClient returns the tenant's cached Firebase Auth client, initializing
+// the app on first use. A failed init is retried on a later call with
+// exponential backoff (1s up to 1m); until then the last error is returned
+// without another attempt.
+func (t *tenant) authClient(ctx context.Context) (*fbauth.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.client != nil {
+		return t.client, nil
+	}
+	if t.err != nil && time.Now().Before(t.nextAttempt) {
+		return nil, t.err
+	}
+
+	client, err := newFirebaseAuthClient(ctx, t)
+	if err != nil {
+		if t.backoff == 0 {
+			t.backoff = fbInitBackoffMin
+		} else {
+			t.backoff = min(t.backoff*2, fbInitBackoffMax)
+		}
+		t.err = err
+		t.nextAttempt = time.Now().Add(t.backoff)
+		log.Printf("firebase: tenant %s: init failed, retrying in %s: %v", t.name, t.backoff, err)
+		return nil, err
+	}
+
+	t.client = client
+	t.err = nil
+	t.backoff = 0
+	return t.client, nil
+}
+
+func newFirebaseAuthClient(ctx context.Context, t *tenant) (*fbauth.Client, error) {
+	log.Printf("firebase: tenant %s: initializing Firebase app with credentials file: %s", t.name, t.credsPath)
+
+	app, err := firebase.NewApp(ctx, nil, option.WithCredentialsFile(t.credsPath))
+	if err != nil {
+		log.Printf("firebase: tenant %s: failed to initialize app with credentials %s: %v", t.name, t.credsPath, err)
+		return nil, err
+	}
+	client, err := app.Auth(ctx)
+	if err != nil {
+		log.Printf("firebase: tenant %s: failed to create Auth client: %v", t.name, err)
+		return nil, err
+	}
+	log.Printf("firebase: tenant %s: Firebase app initialized successfully with credentials %s", t.name, t.credsPath)
+	return client, nil
+}
+
+// tenantUID is the UID a tenant's user is stored under. Each Firebase
+// project issues its own UIDs, so users of tenants other than the default are
+// namespaced as "<tenant>:<uid>" and can't act as the default tenant's user
+// with the same UID. A default-tenant UID that looks like a namespaced one is
+// rejected for the same reason.
+func tenantUID(all map[string]*tenant, t *tenant, uid string) (string, error) {
+	if t.name != DefaultTenant {
+		return t.name + ":" + uid, nil
+	}
+	if prefix, _, ok := strings.Cut(uid, ":"); ok {
+		if _, taken := all[prefix]; taken && prefix != DefaultTenant {
+			return "", fmt.Errorf("%w: uid collides with tenant %q", ErrTokenInvalid, prefix)
+		}
+	}
+	return uid, nil
 }
 
 // VerifyIDToken parses and verifies a Firebase ID token and returns a FirebaseUser.
 // Results are cached to avoid repeated Firebase API calls.
 func VerifyIDToken(ctx context.Context, idToken string) (*FirebaseUser, error) {
+	return VerifyTenantIDToken(ctx, "", idToken)
+}
+
+// VerifyTenantIDToken is VerifyIDToken against a named tenant. An empty name
+// picks the tenant from the token's aud claim.
+func VerifyTenantIDToken(ctx context.Context, tenantName, idToken string) (*FirebaseUser, error) {
+	t, err := resolveTenant(tenantName, idToken)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := t.name + "\x00" + idToken
+
 	// Check cache first
 	tokenCacheMu.RLock()
-	cached, ok := tokenCache[idToken]
+	cached, ok := tokenCache[cacheKey]
 	tokenCacheMu.RUnlock()
 
 	if ok && time.Now().Before(cached.expiresAt) {
@@ -77,25 +354,28 @@ func VerifyIDToken(ctx context.Context, idToken string) (*FirebaseUser, error) {
 		return cached.user, nil
 	}
 
-	// Cache miss or expired - verify with Firebase
-	app, err := initFirebaseApp(ctx)
+	// Cache miss or expired - verify with the tenant's Firebase project
+	client, err := t.authClient(ctx)
 	if err != nil {
-		log.Printf("firebase: initFirebaseApp error: %v", err)
 		return nil, err
 	}
 
-	client, err := app.Auth(ctx)
+	token, err := client.VerifyIDToken(ctx, idToken)
 	if err != nil {
+		err = classifyTokenError(err)
+		log.Printf("firebase: tenant %s: VerifyIDToken failed: %v", t.name, err)
+		return nil, err
+	}
+	if err := checkTokenTimes(token, time.Now()); err != nil {
+		log.Printf("firebase: tenant %s: VerifyIDToken failed: %v", t.name, err)
 		return nil, err
 	}
 
-	token, err := client.VerifyIDToken(ctx, idToken)
+	uid, err := tenantUID(loadTenants(), t, token.UID)
 	if err != nil {
-		log.Printf("firebase: VerifyIDToken failed: %v", err)
+		log.Printf("firebase: tenant %s: VerifyIDToken failed: %v", t.name, err)
 		return nil, err
 	}
-
-	uid := token.UID
 	email, _ := token.Claims["email"].(string)
 	name, _ := token.Claims["name"].(string)
 
@@ -118,18 +398,23 @@ func VerifyIDToken(ctx context.Context, idToken string) (*FirebaseUser, error) {
 			log.Printf("unexpected roles claim type: %T", rawRoles)
 		}
 	}
+	if !t.developerRole && slices.Contains(roles, "developer") {
+		log.Printf("firebase: tenant %s: ignoring developer role of %s (not in FIREBASE_DEVELOPER_TENANTS)", t.name, uid)
+		roles = slices.DeleteFunc(roles, func(r string) bool { return r == "developer" })
+	}
 
 	user := &FirebaseUser{
-		UID:   uid,
-		Email: email,
-		Roles: roles,
-		Name:  name,
-		Token: idToken,
+		UID:    uid,
+		Email:  email,
+		Roles:  roles,
+		Name:   name,
+		Token:  idToken,
+		Tenant: t.name,
 	}
 
 	// Cache the result
 	tokenCacheMu.Lock()
-	tokenCache[idToken] = &cachedToken{
+	tokenCache[cacheKey] = &cachedToken{
 		user:      user,
 		expiresAt: time.Now().Add(tokenCacheTTL),
 	}
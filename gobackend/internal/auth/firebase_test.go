@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTenantUID(t *testing.T) {
+	def := &tenant{name: DefaultTenant, developerRole: true}
+	shop := &tenant{name: "shop"}
+	all := map[string]*tenant{DefaultTenant: def, "shop": shop}
+
+	tests := []struct {
+		name    string
+		tenant  *tenant
+		uid     string
+		want    string
+		wantErr bool
+	}{
+		{"default tenant keeps the uid", def, "abc123", "abc123", false},
+		{"other tenants are namespaced", shop, "abc123", "shop:abc123", false},
+		{"namespaced uids can't be forged", shop, "default:abc123", "shop:default:abc123", false},
+		{"default uid posing as a tenant's", def, "shop:abc123", "", true},
+		{"colon without a tenant prefix", def, "legacy:abc123", "legacy:abc123", false},
+	}
+	for _, tt := range tests {
+		got, err := tenantUID(all, tt.tenant, tt.uid)
+		if tt.wantErr {
+			if !errors.Is(err, ErrTokenInvalid) {
+				t.Errorf("%s: err = %v, want ErrTokenInvalid", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: tenantUID = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
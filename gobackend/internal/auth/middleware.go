@@ -10,39 +10,42 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
 )
 
 const userContextKey = "firebase_user"
 
-// FirebaseAuthMiddleware validates the Bearer Firebase ID token and stores
-// the FirebaseUser in the Fiber context (Locals) under userContextKey.
+// FirebaseAuthMiddleware validates the Bearer Firebase ID token, against the
+// tenant named by TenantHeader if sent, and stores the FirebaseUser in the
+// Fiber context (Locals) under userContextKey. When session cookies are
+// enabled, a valid SessionCookieName cookie sent with CSRFHeader is accepted
+// instead, skipping the Firebase verification; an invalid or expired one, or
+// one without the header, falls back to the Bearer token.
 func FirebaseAuthMiddleware() fiber.Handler {
 	return func(c fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			log.Printf("auth: missing Authorization header on %s %s", c.Method(), c.Path())
-			return fiber.NewError(http.StatusUnauthorized, "Authorization header is required")
-		}
-
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-			log.Printf("auth: malformed Authorization header on %s %s: %q", c.Method(), c.Path(), authHeader)
-			return fiber.NewError(http.StatusUnauthorized, "Authorization header must be Bearer token")
+		if cookie := c.Cookies(SessionCookieName); cookie != "" && SessionsEnabled() {
+			if c.Get(CSRFHeader) == "" {
+				if c.Get("Authorization") == "" {
+					log.Printf("auth: session cookie without %s on %s %s", CSRFHeader, c.Method(), c.Path())
+					return apierror.New(http.StatusForbidden, apierror.Forbidden, "The session cookie requires the "+CSRFHeader+" header")
+				}
+			} else {
+				user, err := VerifySessionValue(cookie, time.Now())
+				if err == nil {
+					c.Locals(userContextKey, user)
+					return c.Next()
+				}
+				if c.Get("Authorization") == "" {
+					log.Printf("auth: session cookie rejected on %s %s: %v", c.Method(), c.Path(), err)
+					return TokenError(err)
+				}
+			}
 		}
 
-		token := parts[1]
-		// Use context with timeout to prevent hanging on slow Firebase calls
-		// Increased to 10s to allow Firebase SDK to fetch public keys on first request
-		// Firebase SDK caches keys internally, so subsequent requests will be faster
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		user, err := VerifyIDToken(ctx, token)
+		user, err := VerifyBearer(c)
 		if err != nil {
-			log.Printf("auth: FirebaseAuthMiddleware VerifyIDToken error on %s %s: %v (token_len=%d)", c.Method(), c.Path(), err, len(token))
-			// Include the underlying error message in the response for easier debugging in dev.
-			// Frontend will see this in the "detail" field.
-			return fiber.NewError(http.StatusUnauthorized, fmt.Sprintf("Invalid Firebase ID token: %v", err))
+			return err
 		}
 
 		// Store user in context for handlers
@@ -51,6 +54,49 @@ func FirebaseAuthMiddleware() fiber.Handler {
 	}
 }
 
+// VerifyBearer verifies the request's Bearer Firebase ID token, against the
+// tenant named by TenantHeader if sent. Errors are ready to return from a
+// handler.
+func VerifyBearer(c fiber.Ctx) (*FirebaseUser, error) {
+	authHeader := c.Get("Authorization")
+	if authHeader == "" {
+		log.Printf("auth: missing Authorization header on %s %s", c.Method(), c.Path())
+		return nil, apierror.New(http.StatusUnauthorized, apierror.MissingAuth, "Authorization header is required")
+	}
+
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		log.Printf("auth: malformed Authorization header on %s %s: %q", c.Method(), c.Path(), authHeader)
+		return nil, apierror.New(http.StatusUnauthorized, apierror.MalformedAuth, "Authorization header must be Bearer token")
+	}
+
+	token := parts[1]
+	// Use context with timeout to prevent hanging on slow Firebase calls
+	// Increased to 10s to allow Firebase SDK to fetch public keys on first request
+	// Firebase SDK caches keys internally, so subsequent requests will be faster
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := VerifyTenantIDToken(ctx, c.Get(TenantHeader), token)
+	if err != nil {
+		log.Printf("auth: FirebaseAuthMiddleware VerifyIDToken error on %s %s: %v (token_len=%d)", c.Method(), c.Path(), err, len(token))
+		return nil, TokenError(err)
+	}
+	return user, nil
+}
+
+// TokenError is the 401 for a token VerifyIDToken rejected, coded
+// EXPIRED_TOKEN when it has expired (so the frontend can refresh it silently
+// instead of signing the user out) and INVALID_TOKEN otherwise. The
+// underlying error is included in "detail" for easier debugging.
+func TokenError(err error) error {
+	code := apierror.InvalidToken
+	if errors.Is(err, ErrTokenExpired) {
+		code = apierror.ExpiredToken
+	}
+	return apierror.New(http.StatusUnauthorized, code, fmt.Sprintf("Invalid Firebase ID token: %v", err))
+}
+
 // RequireRoles returns middleware that enforces the presence of one or more roles.
 // It mimics the Python role_based_access(["whitelisted"]) behavior.
 func RequireRoles(requiredRoles ...string) fiber.Handler {
@@ -58,7 +104,7 @@ func RequireRoles(requiredRoles ...string) fiber.Handler {
 		val := c.Locals(userContextKey)
 		user, ok := val.(*FirebaseUser)
 		if !ok || user == nil {
-			return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+			return apierror.New(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 		}
 
 		// Developer role bypass
@@ -68,7 +114,7 @@ func RequireRoles(requiredRoles ...string) fiber.Handler {
 
 		for _, r := range requiredRoles {
 			if !hasRole(user.Roles, r) {
-				return fiber.NewError(http.StatusForbidden, "User does not have required role: "+r)
+				return apierror.New(http.StatusForbidden, apierror.MissingRole, "User does not have required role: "+r)
 			}
 		}
 
@@ -86,6 +132,11 @@ func GetCurrentFirebaseUser(c fiber.Ctx) (*FirebaseUser, error) {
 	return user, nil
 }
 
+// HasRole reports whether the user has the given role.
+func (u *FirebaseUser) HasRole(r string) bool {
+	return hasRole(u.Roles, r)
+}
+
 func hasRole(roles []string, r string) bool {
 	for _, role := range roles {
 		if role == r {
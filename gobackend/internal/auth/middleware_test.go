@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+)
+
+func TestRequireRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   *FirebaseUser
+		status int
+		code   apierror.Code
+	}{
+		{"no user", nil, http.StatusUnauthorized, apierror.Unauthenticated},
+		{"missing role", &FirebaseUser{UID: "u1", Roles: []string{"viewer"}}, http.StatusForbidden, apierror.MissingRole},
+		{"has role", &FirebaseUser{UID: "u2", Roles: []string{"whitelisted"}}, http.StatusOK, ""},
+		{"developer", &FirebaseUser{UID: "u3", Roles: []string{"developer"}}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+		app.Use(func(c fiber.Ctx) error {
+			if tt.user != nil {
+				c.Locals(userContextKey, tt.user)
+			}
+			return c.Next()
+		})
+		app.Get("/", RequireRoles("whitelisted"), func(c fiber.Ctx) error {
+			return c.SendStatus(http.StatusOK)
+		})
+
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body apierror.Body
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || body.Code != tt.code {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, resp.StatusCode, body.Code, tt.status, tt.code)
+		}
+		if tt.code == apierror.MissingRole && body.Detail != "User does not have required role: whitelisted" {
+			t.Errorf("%s: detail %q doesn't name the role", tt.name, body.Detail)
+		}
+	}
+}
+
+// testSessionSecret is a SESSION_SECRET of the minimum length.
+const testSessionSecret = "0123456789abcdef0123456789abcdef"
+
+// enableSessions reloads the session config with SESSION_SECRET set to secret
+// (empty disables sessions) for the rest of the test.
+func enableSessions(t *testing.T, secret string) {
+	t.Helper()
+	t.Setenv("SESSION_SECRET", secret)
+	sessionOnce, sessionSecret = sync.Once{}, nil
+	t.Cleanup(func() { sessionOnce, sessionSecret = sync.Once{}, nil })
+}
+
+func TestSessionCookieRequiresCSRFHeader(t *testing.T) {
+	enableSessions(t, testSessionSecret)
+	cookie, err := NewSessionValue(&FirebaseUser{UID: "session-user"}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	app.Post("/", FirebaseAuthMiddleware(), func(c fiber.Ctx) error {
+		return c.SendString(c.Locals(userContextKey).(*FirebaseUser).UID)
+	})
+
+	tests := []struct {
+		name   string
+		header bool
+		status int
+		code   apierror.Code
+	}{
+		{"with header", true, http.StatusOK, ""},
+		{"without header", false, http.StatusForbidden, apierror.Forbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: cookie})
+		if tt.header {
+			req.Header.Set(CSRFHeader, "XMLHttpRequest")
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body apierror.Body
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || body.Code != tt.code {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, resp.StatusCode, body.Code, tt.status, tt.code)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// SessionCookieName is the cookie POST /auth/session issues.
+const SessionCookieName = "ou_session"
+
+// CSRFHeader must be sent (with any value) on requests authenticated by the
+// session cookie. A cross-site page can make the browser attach the cookie
+// (SameSite=Lax still does on top-level navigations) but can only add a
+// custom header after a CORS preflight, which only FRONTEND_URL passes.
+const CSRFHeader = "X-Requested-With"
+
+const (
+	defaultSessionTTL = 15 * time.Minute
+	maxSessionTTL     = time.Hour
+
+	// minSessionSecretLength is the shortest SESSION_SECRET accepted, the
+	// size of the HMAC-SHA256 output.
+	minSessionSecretLength = 32
+)
+
+// sessionClaims is the signed payload of a session cookie. Roles are copied
+// from the ID token at exchange, so role changes apply once the session
+// expires.
+type sessionClaims struct {
+	UID     string   `json:"uid"`
+	Email   string   `json:"email,omitempty"`
+	Name    string   `json:"name,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	Tenant  string   `json:"tenant"`
+	Expires int64    `json:"exp"`
+}
+
+var (
+	sessionOnce   sync.Once
+	sessionSecret []byte
+	sessionTTL    time.Duration
+)
+
+// loadSessionConfig reads SESSION_SECRET and SESSION_TTL (Go duration,
+// default 15m, at most 1h). Without a secret of at least 32 bytes, session
+// cookies are disabled.
+func loadSessionConfig() {
+	sessionOnce.Do(func() {
+		sessionTTL = defaultSessionTTL
+		if v := os.Getenv("SESSION_TTL"); v != "" {
+			d, err := time.ParseDuration(v)
+			switch {
+			case err != nil || d <= 0:
+				log.Printf("auth: invalid SESSION_TTL=%q, using %s", v, defaultSessionTTL)
+			case d > maxSessionTTL:
+				log.Printf("auth: SESSION_TTL=%s is above %s, using %s", d, maxSessionTTL, maxSessionTTL)
+				sessionTTL = maxSessionTTL
+			default:
+				sessionTTL = d
+			}
+		}
+
+		secret := os.Getenv("SESSION_SECRET")
+		if secret == "" {
+			return
+		}
+		if len(secret) < minSessionSecretLength {
+			log.Printf("auth: SESSION_SECRET is shorter than %d bytes, session cookies disabled", minSessionSecretLength)
+			return
+		}
+		sessionSecret = []byte(secret)
+	})
+}
+
+// SessionsEnabled reports whether SESSION_SECRET is set, so POST /auth/session
+// can issue cookies and FirebaseAuthMiddleware accepts them.
+func SessionsEnabled() bool {
+	loadSessionConfig()
+	return sessionSecret != nil
+}
+
+// SessionTTL is how long an issued session cookie is valid.
+func SessionTTL() time.Duration {
+	loadSessionConfig()
+	return sessionTTL
+}
+
+func signSession(payload string) string {
+	mac := hmac.New(sha256.New, sessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewSessionValue signs a session for a verified user, valid until expires.
+// The value is base64url(JSON claims) "." base64url(HMAC-SHA256).
+func NewSessionValue(user *FirebaseUser, expires time.Time) (string, error) {
+	if !SessionsEnabled() {
+		return "", fmt.Errorf("session cookies are disabled")
+	}
+	data, err := json.Marshal(sessionClaims{
+		UID:     user.UID,
+		Email:   user.Email,
+		Name:    user.Name,
+		Roles:   user.Roles,
+		Tenant:  user.Tenant,
+		Expires: expires.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signSession(payload), nil
+}
+
+// VerifySessionValue checks a session cookie's signature and expiry and
+// returns its user, without calling Firebase. Expired sessions fail with
+// ErrTokenExpired and anything else with ErrTokenInvalid.
+func VerifySessionValue(value string, now time.Time) (*FirebaseUser, error) {
+	if !SessionsEnabled() {
+		return nil, fmt.Errorf("%w: session cookies are disabled", ErrTokenInvalid)
+	}
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signSession(payload))) {
+		return nil, fmt.Errorf("%w: session signature mismatch", ErrTokenInvalid)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed session", ErrTokenInvalid)
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(data, &claims); err != nil || claims.UID == "" {
+		return nil, fmt.Errorf("%w: malformed session", ErrTokenInvalid)
+	}
+	if expires := time.Unix(claims.Expires, 0); !now.Before(expires) {
+		return nil, fmt.Errorf("%w: session expired at %s", ErrTokenExpired, expires.UTC().Format(time.RFC3339))
+	}
+	return &FirebaseUser{
+		UID:    claims.UID,
+		Email:  claims.Email,
+		Name:   claims.Name,
+		Roles:  claims.Roles,
+		Tenant: claims.Tenant,
+	}, nil
+}
+
+// SetSessionCookie sets the session cookie on the response. It is HttpOnly,
+// Secure and SameSite=Lax, so the frontend and API must be served from the
+// same site (e.g. app.example.com and api.example.com). An empty value with a
+// past expiry clears it.
+func SetSessionCookie(c fiber.Ctx, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     SessionCookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
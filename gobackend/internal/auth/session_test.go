@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySessionValue(t *testing.T) {
+	enableSessions(t, testSessionSecret)
+	now := time.Unix(1_700_000_000, 0)
+	user := &FirebaseUser{UID: "session-user", Email: "s@example.com", Roles: []string{"whitelisted"}, Tenant: "tenant-a"}
+	value, err := NewSessionValue(user, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(value, ".")
+
+	// A developer's claims under the original signature
+	forged, _ := json.Marshal(sessionClaims{UID: "session-user", Roles: []string{"developer"}, Tenant: "tenant-a", Expires: now.Add(time.Hour).Unix()})
+	tamperedPayload := base64.RawURLEncoding.EncodeToString(forged) + "." + sig
+	sigBytes, _ := base64.RawURLEncoding.DecodeString(sig)
+	sigBytes[0] ^= 1
+	tamperedSig := payload + "." + base64.RawURLEncoding.EncodeToString(sigBytes)
+	// Signed correctly, but with no user
+	empty, _ := json.Marshal(sessionClaims{Expires: now.Add(time.Minute).Unix()})
+	emptyPayload := base64.RawURLEncoding.EncodeToString(empty)
+	noUID := emptyPayload + "." + signSession(emptyPayload)
+
+	tests := []struct {
+		name  string
+		value string
+		now   time.Time
+		want  error
+	}{
+		{"valid", value, now, nil},
+		{"tampered payload", tamperedPayload, now, ErrTokenInvalid},
+		{"tampered signature", tamperedSig, now, ErrTokenInvalid},
+		{"no signature", payload, now, ErrTokenInvalid},
+		{"empty", "", now, ErrTokenInvalid},
+		{"no uid", noUID, now, ErrTokenInvalid},
+		{"expired", value, now.Add(time.Minute), ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		got, err := VerifySessionValue(tt.value, tt.now)
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if got.UID != user.UID || got.Email != user.Email || got.Tenant != user.Tenant || !slices.Equal(got.Roles, user.Roles) {
+				t.Errorf("%s: user %+v, want %+v", tt.name, got, user)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Rotating SESSION_SECRET invalidates existing sessions
+	enableSessions(t, strings.Repeat("x", minSessionSecretLength))
+	if _, err := VerifySessionValue(value, now); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("wrong secret: got %v, want %v", err, ErrTokenInvalid)
+	}
+
+	// Without one, no session is accepted
+	enableSessions(t, "")
+	if _, err := VerifySessionValue(value, now); !errors.Is(err, ErrTokenInvalid) || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("sessions disabled: got %v, want %v", err, ErrTokenInvalid)
+	}
+}
@@ -1,19 +1,92 @@
 package config
 
+import (
+	"log"
+	"math"
+	"strings"
+)
+
 // AppConfig holds general application configuration.
 type AppConfig struct {
 	Port        string
 	FrontendURL string
 	DatabaseURL string
+
+	// JobWorkers is the number of background job workers (see internal/jobs).
+	JobWorkers int
+
+	// APIUsageRetentionDays is how long apiusage rows are kept before a
+	// periodic job deletes them (0 keeps them forever).
+	APIUsageRetentionDays int
+
+	// FileAccessRetentionDays is how long file_access rows are kept (0 keeps
+	// them forever).
+	FileAccessRetentionDays int
+
+	// APIKeyInactiveDays disables API keys unused for that many days (0
+	// never does).
+	APIKeyInactiveDays int
+
+	// TrustedProxies lists proxy IPs/CIDRs (or "loopback", "private",
+	// "linklocal") whose ProxyHeader is trusted for the client IP. Empty means
+	// the header is ignored and c.IP() is the direct peer.
+	TrustedProxies []string
+	ProxyHeader    string
+
+	// UploadBodyLimit is the largest request body the server accepts at all,
+	// sized for upload routes. JSONBodyLimit is the much smaller cap applied
+	// to route groups that only take JSON or query parameters.
+	UploadBodyLimit int64
+	JSONBodyLimit   int64
+
+	// OpenAPISpecFile, when set, is served at /openapi.json instead of the
+	// generated document, re-read on every request.
+	OpenAPISpecFile string
 }
 
 // GetAppConfig reads core app settings from the environment.
 // It mirrors the Python backend defaults so the frontend and DB config
 // can be reused without surprises.
 func GetAppConfig() AppConfig {
+	uploadBodyLimit := GetEnvInt64("UPLOAD_BODY_LIMIT", 100*1024*1024)
+	if uploadBodyLimit == 0 || uploadBodyLimit > math.MaxInt32 {
+		log.Printf("config: invalid UPLOAD_BODY_LIMIT=%d (1-%d), using %d", uploadBodyLimit, math.MaxInt32, 100*1024*1024)
+		uploadBodyLimit = 100 * 1024 * 1024
+	}
+	jsonBodyLimit := GetEnvInt64("JSON_BODY_LIMIT", 1024*1024)
+	if jsonBodyLimit == 0 || jsonBodyLimit > uploadBodyLimit {
+		log.Printf("config: invalid JSON_BODY_LIMIT=%d (1-%d), using %d", jsonBodyLimit, uploadBodyLimit, min(1024*1024, uploadBodyLimit))
+		jsonBodyLimit = min(1024*1024, uploadBodyLimit)
+	}
+
 	return AppConfig{
 		Port:        GetEnv("PORT", "8080"),
 		FrontendURL: GetEnv("FRONTEND_URL", ""),
 		DatabaseURL: GetEnv("DATABASE_URL", "sqlite:///./db/database.db"),
+		JobWorkers:  int(GetEnvInt64("JOB_WORKERS", 2)),
+
+		APIUsageRetentionDays: int(GetEnvInt64("APIUSAGE_RETENTION_DAYS", 365)),
+		APIKeyInactiveDays:    int(GetEnvInt64("API_KEY_INACTIVE_DAYS", 0)),
+
+		FileAccessRetentionDays: int(GetEnvInt64("FILE_ACCESS_RETENTION_DAYS", 90)),
+
+		TrustedProxies: splitList(GetEnv("TRUSTED_PROXIES", "")),
+		ProxyHeader:    GetEnv("PROXY_HEADER", "X-Forwarded-For"),
+
+		UploadBodyLimit: uploadBodyLimit,
+		JSONBodyLimit:   jsonBodyLimit,
+
+		OpenAPISpecFile: GetEnv("OPENAPI_SPEC_FILE", ""),
+	}
+}
+
+// splitList splits a comma-separated env value, dropping empty entries.
+func splitList(v string) []string {
+	out := make([]string, 0)
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
 	}
+	return out
 }
@@ -1,7 +1,13 @@
 package config
 
 import (
+	"crypto/rand"
+	"log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,8 +22,235 @@ type MinioConfig struct {
 	Region        string
 	ImgproxyURL   string
 	StoragePrefix string
+
+	// PublicPrefix enables public=true API uploads, stored under this prefix
+	// instead of StoragePrefix. A bucket policy must grant anonymous read on
+	// it; PublicBaseURL (default: the MinIO endpoint and bucket) is the base
+	// of the direct URLs returned for those objects.
+	PublicPrefix  string
+	PublicBaseURL string
+
+	// MaxObjectKeyLength is the longest object key (in bytes) an upload may
+	// produce; S3 and MinIO reject keys over 1024 bytes.
+	MaxObjectKeyLength int
+
+	// BucketStatsMaxObjects caps how many objects GET /usage/storage lists
+	// for the live bucket totals, 0 for no cap.
+	BucketStatsMaxObjects int64
+
+	// MaxUploadBytes is the largest single file an upload may be, 0 for no
+	// limit beyond the storage limit.
+	MaxUploadBytes int64
+
+	// PresignExpiry is the default lifetime of presigned download URLs and
+	// PresignMaxExpiry the longest a client may request (S3 caps this at 7 days).
+	PresignExpiry    time.Duration
+	PresignMaxExpiry time.Duration
+
+	// ImgproxyDefault* are the resize mode (fit, fill or resize), bounding box
+	// and output format (webp, avif, jpeg or png) of the imgproxy_url returned
+	// with uploads and file listings.
+	ImgproxyDefaultMode   string
+	ImgproxyDefaultWidth  int
+	ImgproxyDefaultHeight int
+	ImgproxyDefaultFormat string
+
+	// ImgproxyMaxConcurrency caps simultaneous requests from this app to
+	// imgproxy (0 = unlimited); requests beyond it wait up to
+	// ImgproxyQueueTimeout for a slot.
+	ImgproxyMaxConcurrency int
+	ImgproxyQueueTimeout   time.Duration
+
+	// ImgproxyDevMode makes /transform-url report whether its URL is signed,
+	// with the /unsafe variant alongside, so a missing IMGPROXY_KEY/SALT shows
+	// up during development.
+	ImgproxyDevMode bool
+
+	// VideoPosters enables /files/:file_id/poster, which relies on imgproxy
+	// rendering frames of video sources (imgproxy Pro's video thumbnails).
+	VideoPosters bool
+
+	// AutoOrient adds imgproxy's auto_rotate option to every transform so EXIF
+	// orientation is applied even if imgproxy's IMGPROXY_AUTO_ROTATE is disabled.
+	AutoOrient bool
+
+	// ThumbnailCacheDir enables an on-disk cache of imgproxy-generated images
+	// (thumbnail/medium/preview/full) when set. Entries expire after
+	// ThumbnailCacheTTL and the oldest are evicted above ThumbnailCacheMaxBytes.
+	ThumbnailCacheDir      string
+	ThumbnailCacheTTL      time.Duration
+	ThumbnailCacheMaxBytes int64
+
+	// GzipStorage stores compressible text uploads (text/*, JSON, XML, SVG, ...)
+	// of at least GzipMinSize bytes gzip-compressed in MinIO.
+	GzipStorage bool
+	GzipMinSize int64
+
+	// ArchiveCompression picks how project archive entries are compressed:
+	// "auto" (store already-compressed media, deflate the rest), "store" or
+	// "deflate". ArchiveDeflateLevel is the flate level (1-9) for deflate.
+	ArchiveCompression  string
+	ArchiveDeflateLevel int
+
+	// DedupScope is "per_user" (uploads only reuse the uploader's own blobs
+	// with the same content) or "global" (any user's, for single-tenant
+	// deployments).
+	DedupScope string
+
+	// FilenameCollision is what an upload does when its key already holds
+	// different content: "hash" stores it as name.<hash8>.ext instead,
+	// "overwrite" replaces the existing object.
+	FilenameCollision string
+
+	// MaxFilesPerProject is the default cap on files per project (0 = unlimited);
+	// project.max_files overrides it per project.
+	MaxFilesPerProject int64
+
+	// MaxNameLength is the longest project or API key name accepted, in
+	// characters.
+	MaxNameLength int
+
+	// TrashRetentionDays is how long deleted files stay restorable in the
+	// trash before the purge job removes them and their unreferenced blobs.
+	// 0 disables the trash: deletes are immediate.
+	TrashRetentionDays int
+
+	// ContentTypeOverrides maps lowercase file extensions (".svg") to the MIME
+	// type stored and served for them, regardless of what the client sent.
+	ContentTypeOverrides map[string]string
+
+	// AllowedExtensions, when not empty, is the only file extensions (".pdf",
+	// lowercase) uploads may have. BlockedExtensions are always rejected.
+	AllowedExtensions []string
+	BlockedExtensions []string
+
+	// AllowedMimeTypes, when not empty, is the only media types (lowercase,
+	// "image/*" for a whole family) uploads may be stored as or sniff as.
+	AllowedMimeTypes []string
+
+	// TransformPresets are the named image sizes (thumbnail, medium, ...):
+	// the built-in presets merged with TRANSFORM_PRESETS.
+	TransformPresets map[string]PresetSize
+
+	// ObjectLockMode ("GOVERNANCE" or "COMPLIANCE") also sets MinIO object
+	// retention on uploads to projects with a retention period. The bucket
+	// must have object locking enabled. Empty leaves retention to the database.
+	ObjectLockMode string
+
+	// UploadTokenSecret signs browser upload tokens; UploadTokenTTL is their
+	// default lifetime and UploadTokenMaxTTL the longest a caller may request.
+	UploadTokenSecret []byte
+	UploadTokenTTL    time.Duration
+	UploadTokenMaxTTL time.Duration
+
+	// ServeUserMetadata lists the MinIO user metadata names (lowercase,
+	// without the X-Amz-Meta- prefix) that /files/:file_id echoes back as
+	// X-Amz-Meta-* headers; "*" serves all of them. Empty serves none.
+	ServeUserMetadata []string
+
+	// InlineAllowedTypes lists the active content types (HTML, SVG, XHTML,
+	// XML) that /files/:file_id may still serve inline; the rest of them are
+	// always sent as attachments.
+	InlineAllowedTypes []string
+
+	// SanitizeSVG strips scripts, event handlers and external references
+	// from SVG uploads before they are stored (SANITIZE_SVG, default true).
+	SanitizeSVG bool
+
+	// PublicAllowedOrigins, when not empty, restricts the public /files
+	// routes to requests whose Origin or Referer host is listed (lowercase
+	// hosts, optionally with a port or a leading "*." for subdomains) or is
+	// this server. PublicAllowEmptyReferer lets requests with neither through.
+	PublicAllowedOrigins    []string
+	PublicAllowEmptyReferer bool
+
+	// WebhookAllowPrivate lets project webhooks target loopback, private and
+	// link-local addresses (WEBHOOK_ALLOW_PRIVATE=true), for self-hosted
+	// receivers next to this server. Off by default, so project owners can't
+	// make the server request internal services.
+	WebhookAllowPrivate bool
+
+	// FilenameFallback picks the Content-Disposition filename when a file has
+	// none: "key" (object key base name, then file id) or "id" (file id).
+	FilenameFallback string
+
+	// ProjectSort and FileSort are the orders used when project and file
+	// listings get no sort parameter (PROJECT_SORT, FILE_SORT).
+	ProjectSort SortOrder
+	FileSort    SortOrder
+}
+
+// ProjectSortKeys and FileSortKeys are the accepted sort values of the
+// project and file listings. Each is also the column sorted by, so only these
+// strings ever reach an ORDER BY.
+var (
+	ProjectSortKeys = []string{"created_at", "name"}
+	FileSortKeys    = []string{"created_at", "updated_at", "filename", "size"}
+)
+
+// SortOrder is a listing's sort key and direction ("asc" or "desc").
+type SortOrder struct {
+	Key   string
+	Order string
+}
+
+// parseSortOrder parses "<key>" or "<key>:<asc|desc>" (direction defaults to
+// desc) against the allowed keys, falling back to created_at desc.
+func parseSortOrder(name string, keys []string) SortOrder {
+	fallback := SortOrder{Key: "created_at", Order: "desc"}
+	v := strings.ToLower(strings.TrimSpace(os.Getenv(name)))
+	if v == "" {
+		return fallback
+	}
+	key, order, _ := strings.Cut(v, ":")
+	if order == "" {
+		order = "desc"
+	}
+	if !slices.Contains(keys, key) || (order != "asc" && order != "desc") {
+		log.Printf("config: invalid %s=%q (expected one of %s, optionally with :asc or :desc), using created_at:desc", name, v, strings.Join(keys, ", "))
+		return fallback
+	}
+	return SortOrder{Key: key, Order: order}
+}
+
+// defaultBlockedExtensions are Windows executables and scripts.
+const defaultBlockedExtensions = ".exe,.bat,.cmd,.com,.msi,.scr"
+
+// parseExtensions parses a comma-separated list of file extensions into
+// lowercase ones with a leading dot.
+func parseExtensions(v string) []string {
+	exts := splitList(v)
+	for i, ext := range exts {
+		exts[i] = "." + strings.TrimPrefix(strings.ToLower(ext), ".")
+	}
+	return exts
+}
+
+// defaultContentTypeOverrides covers types that browsers and upload tools
+// commonly misreport (e.g. SVG as text/plain, JSON as application/octet-stream).
+var defaultContentTypeOverrides = map[string]string{
+	".svg":  "image/svg+xml",
+	".json": "application/json",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".js":   "text/javascript",
+	".mjs":  "text/javascript",
+	".css":  "text/css",
+	".csv":  "text/csv",
+	".md":   "text/markdown",
+	".wasm": "application/wasm",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
 }
 
+// MaxPresignExpiry is the longest expiry S3 (and MinIO) accept for a presigned URL.
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
+// MaxObjectKeyLength is the S3/MinIO limit on object key length in bytes.
+const MaxObjectKeyLength = 1024
+
 // LoadEnv loads variables from a .env file if present (no-op on failure).
 func LoadEnv() {
 	_ = godotenv.Load()
@@ -31,6 +264,110 @@ func GetEnv(key, fallback string) string {
 	return fallback
 }
 
+// GetEnvDuration parses a Go duration (e.g. "15m", "24h") from an environment
+// variable, returning the fallback when unset or invalid.
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("config: invalid duration for %s=%q, using %s", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+// GetEnvInt64 parses an integer from an environment variable, returning the
+// fallback when unset or invalid.
+func GetEnvInt64(key string, fallback int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		log.Printf("config: invalid integer for %s=%q, using %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+// parseContentTypeOverrides merges CONTENT_TYPE_OVERRIDES, a comma-separated
+// list of ext=mime pairs (e.g. ".svg=image/svg+xml,log=text/plain"), over the
+// built-in defaults.
+func parseContentTypeOverrides(v string) map[string]string {
+	overrides := make(map[string]string, len(defaultContentTypeOverrides))
+	for ext, mimeType := range defaultContentTypeOverrides {
+		overrides[ext] = mimeType
+	}
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		ext, mimeType, ok := strings.Cut(pair, "=")
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		mimeType = strings.TrimSpace(mimeType)
+		if !ok || ext == "" || mimeType == "" {
+			log.Printf("config: ignoring invalid CONTENT_TYPE_OVERRIDES entry %q", pair)
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		overrides[ext] = mimeType
+	}
+	return overrides
+}
+
+// parseUserMetadataNames parses SERVE_USER_METADATA, a comma-separated list
+// of metadata names ("capture-date,author"), with or without the
+// X-Amz-Meta- prefix.
+func parseUserMetadataNames(v string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		name = strings.TrimPrefix(name, "x-amz-meta-")
+		if name == "" {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// parseMediaTypes parses a comma-separated list of media types, lowercased.
+func parseMediaTypes(v string) []string {
+	types := splitList(v)
+	for i, t := range types {
+		types[i] = strings.ToLower(t)
+	}
+	return types
+}
+
+// parseOriginHosts parses a comma-separated list of origins
+// ("https://example.com"), hosts ("example.com:8080") or subdomain wildcards
+// ("*.example.com") into lowercase hosts. Entries without a host are logged
+// and skipped.
+func parseOriginHosts(name, v string) []string {
+	hosts := make([]string, 0)
+	for _, entry := range splitList(v) {
+		host := strings.ToLower(entry)
+		if _, rest, ok := strings.Cut(host, "://"); ok {
+			host = rest
+		}
+		host, _, _ = strings.Cut(host, "/")
+		if host == "" || host == "*." {
+			log.Printf("config: ignoring invalid %s entry %q", name, entry)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
 // GetMinioConfig reads MinIO/S3 config from env vars with sensible defaults.
 // Uses MINIO_ROOT_USER and MINIO_ROOT_PASSWORD (with fallback to MINIO_ACCESS_KEY/MINIO_SECRET_KEY for backward compatibility).
 func GetMinioConfig() MinioConfig {
@@ -47,6 +384,101 @@ func GetMinioConfig() MinioConfig {
 		secretKey = GetEnv("MINIO_SECRET_KEY", "changeme-minio-secret")
 	}
 
+	presignMax := GetEnvDuration("PRESIGN_MAX_EXPIRY", MaxPresignExpiry)
+	if presignMax > MaxPresignExpiry {
+		presignMax = MaxPresignExpiry
+	}
+	presignExpiry := GetEnvDuration("PRESIGN_EXPIRY", 15*time.Minute)
+	if presignExpiry > presignMax {
+		presignExpiry = presignMax
+	}
+
+	// Set but empty blocks nothing
+	blockedExtensions := parseExtensions(defaultBlockedExtensions)
+	if v, ok := os.LookupEnv("UPLOAD_BLOCKED_EXTENSIONS"); ok {
+		blockedExtensions = parseExtensions(v)
+	}
+
+	maxNameLength := int(GetEnvInt64("NAME_MAX_LENGTH", 128))
+	if maxNameLength <= 0 || maxNameLength > 1024 {
+		log.Printf("config: invalid NAME_MAX_LENGTH=%d (1-1024), using 128", maxNameLength)
+		maxNameLength = 128
+	}
+
+	filenameFallback := GetEnv("FILENAME_FALLBACK", "key")
+	if filenameFallback != "key" && filenameFallback != "id" {
+		log.Printf("config: invalid FILENAME_FALLBACK=%q, using \"key\"", filenameFallback)
+		filenameFallback = "key"
+	}
+
+	uploadTokenSecret := []byte(os.Getenv("UPLOAD_TOKEN_SECRET"))
+	if len(uploadTokenSecret) == 0 {
+		// Tokens then stop validating on restart and across replicas
+		log.Printf("config: UPLOAD_TOKEN_SECRET not set, using a random per-process secret")
+		uploadTokenSecret = make([]byte, 32)
+		if _, err := rand.Read(uploadTokenSecret); err != nil {
+			log.Fatalf("config: failed to generate upload token secret: %v", err)
+		}
+	}
+	uploadTokenMaxTTL := GetEnvDuration("UPLOAD_TOKEN_MAX_TTL", 24*time.Hour)
+	uploadTokenTTL := min(GetEnvDuration("UPLOAD_TOKEN_TTL", 15*time.Minute), uploadTokenMaxTTL)
+
+	archiveCompression := strings.ToLower(GetEnv("ARCHIVE_COMPRESSION", "auto"))
+	if archiveCompression != "auto" && archiveCompression != "store" && archiveCompression != "deflate" {
+		log.Printf("config: invalid ARCHIVE_COMPRESSION=%q, using \"auto\"", archiveCompression)
+		archiveCompression = "auto"
+	}
+	archiveDeflateLevel := int(GetEnvInt64("ARCHIVE_DEFLATE_LEVEL", 6))
+	if archiveDeflateLevel < 1 || archiveDeflateLevel > 9 {
+		log.Printf("config: invalid ARCHIVE_DEFLATE_LEVEL=%d, using 6", archiveDeflateLevel)
+		archiveDeflateLevel = 6
+	}
+
+	objectLockMode := strings.ToUpper(GetEnv("OBJECT_LOCK_MODE", ""))
+	if objectLockMode != "" && objectLockMode != "GOVERNANCE" && objectLockMode != "COMPLIANCE" {
+		log.Printf("config: invalid OBJECT_LOCK_MODE=%q, object retention disabled", objectLockMode)
+		objectLockMode = ""
+	}
+
+	imgproxyMode := strings.ToLower(GetEnv("IMGPROXY_DEFAULT_MODE", "fit"))
+	if imgproxyMode != "fit" && imgproxyMode != "fill" && imgproxyMode != "resize" {
+		log.Printf("config: invalid IMGPROXY_DEFAULT_MODE=%q, using \"fit\"", imgproxyMode)
+		imgproxyMode = "fit"
+	}
+	imgproxyFormat := strings.ToLower(GetEnv("IMGPROXY_DEFAULT_FORMAT", "webp"))
+	switch imgproxyFormat {
+	case "webp", "avif", "jpeg", "png":
+	case "jpg":
+		imgproxyFormat = "jpeg"
+	default:
+		log.Printf("config: invalid IMGPROXY_DEFAULT_FORMAT=%q, using \"webp\"", imgproxyFormat)
+		imgproxyFormat = "webp"
+	}
+	imgproxyWidth := int(GetEnvInt64("IMGPROXY_DEFAULT_WIDTH", 1200))
+	imgproxyHeight := int(GetEnvInt64("IMGPROXY_DEFAULT_HEIGHT", 1200))
+	if imgproxyWidth > MaxPresetDimension || imgproxyHeight > MaxPresetDimension || (imgproxyWidth == 0 && imgproxyHeight == 0) {
+		log.Printf("config: invalid IMGPROXY_DEFAULT_WIDTH/HEIGHT=%d/%d (0-%d, not both 0), using 1200x1200", imgproxyWidth, imgproxyHeight, MaxPresetDimension)
+		imgproxyWidth, imgproxyHeight = 1200, 1200
+	}
+
+	dedupScope := strings.ToLower(GetEnv("DEDUP_SCOPE", "per_user"))
+	if dedupScope != "per_user" && dedupScope != "global" {
+		log.Printf("config: invalid DEDUP_SCOPE=%q, using \"per_user\"", dedupScope)
+		dedupScope = "per_user"
+	}
+
+	filenameCollision := strings.ToLower(GetEnv("FILENAME_COLLISION", "hash"))
+	if filenameCollision != "hash" && filenameCollision != "overwrite" {
+		log.Printf("config: invalid FILENAME_COLLISION=%q, using \"hash\"", filenameCollision)
+		filenameCollision = "hash"
+	}
+
+	maxObjectKeyLength := int(GetEnvInt64("MAX_OBJECT_KEY_LENGTH", MaxObjectKeyLength))
+	if maxObjectKeyLength <= 0 || maxObjectKeyLength > MaxObjectKeyLength {
+		log.Printf("config: invalid MAX_OBJECT_KEY_LENGTH=%d, using %d", maxObjectKeyLength, MaxObjectKeyLength)
+		maxObjectKeyLength = MaxObjectKeyLength
+	}
+
 	return MinioConfig{
 		Endpoint:      GetEnv("MINIO_ENDPOINT", "minio:9000"),
 		AccessKey:     accessKey,
@@ -56,5 +488,76 @@ func GetMinioConfig() MinioConfig {
 		Region:        GetEnv("MINIO_REGION", "us-east-1"),
 		ImgproxyURL:   GetEnv("IMGPROXY_URL", "http://imgproxy:8080"),
 		StoragePrefix: GetEnv("STORAGE_PREFIX", "uploads"),
+
+		PublicPrefix:  strings.Trim(GetEnv("PUBLIC_PREFIX", ""), "/"),
+		PublicBaseURL: GetEnv("PUBLIC_BASE_URL", ""),
+
+		MaxObjectKeyLength: maxObjectKeyLength,
+		MaxUploadBytes:     GetEnvInt64("MAX_UPLOAD_BYTES", 0),
+
+		BucketStatsMaxObjects: GetEnvInt64("BUCKET_STATS_MAX_OBJECTS", 0),
+
+		PresignExpiry:    presignExpiry,
+		PresignMaxExpiry: presignMax,
+
+		ImgproxyDefaultMode:   imgproxyMode,
+		ImgproxyDefaultWidth:  imgproxyWidth,
+		ImgproxyDefaultHeight: imgproxyHeight,
+		ImgproxyDefaultFormat: imgproxyFormat,
+
+		ImgproxyMaxConcurrency: int(GetEnvInt64("IMGPROXY_MAX_CONCURRENCY", 16)),
+		ImgproxyQueueTimeout:   GetEnvDuration("IMGPROXY_QUEUE_TIMEOUT", 10*time.Second),
+
+		ImgproxyDevMode: os.Getenv("IMGPROXY_DEV_MODE") == "true",
+		VideoPosters:    os.Getenv("VIDEO_POSTERS") == "true",
+
+		AutoOrient: os.Getenv("AUTO_ORIENT") == "true",
+
+		ThumbnailCacheDir:      GetEnv("THUMBNAIL_CACHE_DIR", ""),
+		ThumbnailCacheTTL:      GetEnvDuration("THUMBNAIL_CACHE_TTL", 24*time.Hour),
+		ThumbnailCacheMaxBytes: GetEnvInt64("THUMBNAIL_CACHE_MAX_BYTES", 512*1024*1024),
+
+		GzipStorage: os.Getenv("GZIP_STORAGE") == "true",
+		GzipMinSize: GetEnvInt64("GZIP_MIN_SIZE", 1024),
+
+		ArchiveCompression:  archiveCompression,
+		ArchiveDeflateLevel: archiveDeflateLevel,
+
+		DedupScope:        dedupScope,
+		FilenameCollision: filenameCollision,
+
+		MaxFilesPerProject: GetEnvInt64("MAX_FILES_PER_PROJECT", 0),
+		MaxNameLength:      maxNameLength,
+
+		TrashRetentionDays: int(GetEnvInt64("TRASH_RETENTION_DAYS", 0)),
+
+		ContentTypeOverrides: parseContentTypeOverrides(os.Getenv("CONTENT_TYPE_OVERRIDES")),
+
+		AllowedExtensions: parseExtensions(os.Getenv("UPLOAD_ALLOWED_EXTENSIONS")),
+		BlockedExtensions: blockedExtensions,
+		AllowedMimeTypes:  parseMediaTypes(os.Getenv("ALLOWED_MIME_TYPES")),
+
+		TransformPresets: parseTransformPresets(os.Getenv("TRANSFORM_PRESETS")),
+
+		ObjectLockMode: objectLockMode,
+
+		UploadTokenSecret: uploadTokenSecret,
+		UploadTokenTTL:    uploadTokenTTL,
+		UploadTokenMaxTTL: uploadTokenMaxTTL,
+
+		ServeUserMetadata: parseUserMetadataNames(os.Getenv("SERVE_USER_METADATA")),
+
+		InlineAllowedTypes: parseMediaTypes(os.Getenv("INLINE_ALLOWED_TYPES")),
+		SanitizeSVG:        GetEnv("SANITIZE_SVG", "true") != "false",
+
+		PublicAllowedOrigins:    parseOriginHosts("PUBLIC_FILE_ALLOWED_ORIGINS", os.Getenv("PUBLIC_FILE_ALLOWED_ORIGINS")),
+		PublicAllowEmptyReferer: GetEnv("PUBLIC_FILE_ALLOW_EMPTY_REFERER", "true") != "false",
+
+		WebhookAllowPrivate: os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true",
+
+		FilenameFallback: filenameFallback,
+
+		ProjectSort: parseSortOrder("PROJECT_SORT", ProjectSortKeys),
+		FileSort:    parseSortOrder("FILE_SORT", FileSortKeys),
 	}
 }
@@ -37,11 +37,17 @@ func EnsureMinioBucket(ctx context.Context, client *minio.Client, cfg MinioConfi
 type BucketStats struct {
 	TotalSize   int64 `json:"total_size"`   // Total size in bytes
 	ObjectCount int64 `json:"object_count"` // Number of objects
+	// Truncated is set when the scan stopped at its object cap; the totals
+	// then only cover the objects listed so far and are a lower bound.
+	Truncated bool `json:"truncated"`
 }
 
 // GetBucketStats calculates statistics for a MinIO bucket by iterating through objects.
 // This provides accurate storage usage information directly from MinIO.
-func GetBucketStats(ctx context.Context, client *minio.Client, bucket string) (BucketStats, error) {
+// A listing error, including the 30s timeout, is returned with the partial
+// totals so far. With maxObjects > 0 the scan stops after that many objects
+// and returns the totals so far marked Truncated.
+func GetBucketStats(ctx context.Context, client *minio.Client, bucket string, maxObjects int64) (BucketStats, error) {
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
@@ -57,8 +63,15 @@ func GetBucketStats(ctx context.Context, client *minio.Client, bucket string) (B
 
 	for obj := range objectCh {
 		if obj.Err != nil {
+			// A failed or timed-out listing would otherwise pass for a
+			// smaller bucket
 			log.Printf("Error listing object: %v", obj.Err)
-			continue
+			return stats, obj.Err
+		}
+		if maxObjects > 0 && stats.ObjectCount >= maxObjects {
+			// Returning cancels the listing
+			stats.Truncated = true
+			break
 		}
 		stats.TotalSize += obj.Size
 		stats.ObjectCount++
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+)
+
+// PresetSize is an image preset's imgproxy dimensions. A zero width or
+// height is computed by imgproxy from the aspect ratio.
+type PresetSize struct {
+	Width  int
+	Height int
+}
+
+// MaxPresetDimension bounds preset widths and heights.
+const MaxPresetDimension = 4000
+
+// PresetNamePattern is the allowed form of a preset name.
+var PresetNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// defaultTransformPresets are fixed-height, so imgproxy preserves the aspect
+// ratio. thumbnail, medium, preview and full also back the
+// /files/:file_id/<preset> routes.
+var defaultTransformPresets = map[string]PresetSize{
+	"thumbnail": {Width: 0, Height: 120},
+	"medium":    {Width: 0, Height: 320},
+	"preview":   {Width: 0, Height: 720},
+	"full":      {Width: 0, Height: 1080},
+}
+
+// parseTransformPresets merges TRANSFORM_PRESETS, a JSON object of name to
+// [width, height] (e.g. {"card":[0,240],"hero":[0,1440]}), over the built-in
+// presets. A null value removes a preset. Invalid entries are logged and
+// skipped; invalid JSON leaves the defaults.
+func parseTransformPresets(v string) map[string]PresetSize {
+	presets := make(map[string]PresetSize, len(defaultTransformPresets))
+	for name, size := range defaultTransformPresets {
+		presets[name] = size
+	}
+	if v == "" {
+		return presets
+	}
+
+	var entries map[string]*[2]int
+	if err := json.Unmarshal([]byte(v), &entries); err != nil {
+		log.Printf("config: invalid TRANSFORM_PRESETS, using built-in presets: %v", err)
+		return presets
+	}
+	for name, dims := range entries {
+		if !PresetNamePattern.MatchString(name) {
+			log.Printf("config: ignoring TRANSFORM_PRESETS entry with invalid name %q", name)
+			continue
+		}
+		if dims == nil {
+			delete(presets, name)
+			continue
+		}
+		width, height := dims[0], dims[1]
+		if width < 0 || height < 0 || (width == 0 && height == 0) || width > MaxPresetDimension || height > MaxPresetDimension {
+			log.Printf("config: ignoring TRANSFORM_PRESETS entry %q: dimensions must be 0-%d and not both 0", name, MaxPresetDimension)
+			continue
+		}
+		presets[name] = PresetSize{Width: width, Height: height}
+	}
+	return presets
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"strconv"
 	"strings"
 )
 
@@ -74,9 +75,154 @@ func Migrate(ctx context.Context) error {
 			user_firebase_uid TEXT NOT NULL,
 			storage_path TEXT NOT NULL,
 			content_hash TEXT,
+			updated_at TIMESTAMP,
 			FOREIGN KEY (project_id) REFERENCES project(id),
 			FOREIGN KEY (user_firebase_uid) REFERENCES user(firebase_uid)
 		);`,
+
+		// job table (background work queue, see internal/jobs)
+		`CREATE TABLE IF NOT EXISTS job (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL DEFAULT 5,
+			last_error TEXT,
+			run_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_job_status_run_at ON job(status, run_at);`,
+
+		// storage_snapshot table (daily storage per user/project, for trends)
+		`CREATE TABLE IF NOT EXISTS storage_snapshot (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			day TEXT NOT NULL,
+			user_firebase_uid TEXT NOT NULL,
+			project_id INTEGER NOT NULL,
+			total_size INTEGER NOT NULL,
+			file_count INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE (day, user_firebase_uid, project_id)
+		);`,
+
+		// audit_log table (who changed or deleted what)
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp TIMESTAMP NOT NULL,
+			actor_uid TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id TEXT NOT NULL,
+			project_id INTEGER,
+			detail TEXT
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp);`,
+
+		// file_trash table (soft-deleted file rows, purged after
+		// TRASH_RETENTION_DAYS); same columns as file plus deletion info
+		`CREATE TABLE IF NOT EXISTS file_trash (
+			id TEXT PRIMARY KEY,
+			filename TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			mime_type TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			project_id INTEGER NOT NULL,
+			user_firebase_uid TEXT NOT NULL,
+			storage_path TEXT NOT NULL,
+			content_hash TEXT,
+			updated_at TIMESTAMP,
+			content_encoding TEXT,
+			cache_control TEXT,
+			content_type_override TEXT,
+			locked_until TIMESTAMP,
+			deleted_at TIMESTAMP NOT NULL,
+			deleted_by TEXT NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_file_trash_deleted_at ON file_trash(deleted_at);`,
+
+		// usage_daily table (apiusage rolled up per user/project/day, kept
+		// after the raw rows are deleted)
+		`CREATE TABLE IF NOT EXISTS usage_daily (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			day TEXT NOT NULL,
+			user_firebase_uid TEXT NOT NULL,
+			project_id INTEGER NOT NULL,
+			api_calls INTEGER NOT NULL,
+			total_response_time REAL NOT NULL,
+			success_count INTEGER NOT NULL,
+			UNIQUE (day, user_firebase_uid, project_id)
+		);`,
+
+		// share_link table (public links to one file; password_hash is set
+		// for password-protected links)
+		`CREATE TABLE IF NOT EXISTS share_link (
+			token TEXT PRIMARY KEY,
+			file_id TEXT NOT NULL,
+			user_firebase_uid TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP,
+			password_hash TEXT
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_share_link_file_id ON share_link(file_id);`,
+		// project_webhook table (endpoints notified of a project's file
+		// events; events is a comma-separated list)
+		`CREATE TABLE IF NOT EXISTS project_webhook (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			project_id INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_project_webhook_project_id ON project_webhook(project_id);`,
+		// webhook_delivery table (one row per event sent to a webhook,
+		// updated after each attempt until delivered or dead)
+		`CREATE TABLE IF NOT EXISTS webhook_delivery (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			project_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			body TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			response_code INTEGER,
+			last_error TEXT,
+			next_retry_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_delivery_webhook_id ON webhook_delivery(webhook_id, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_delivery_due ON webhook_delivery(status, next_retry_at);`,
+		// file_derivative table (preset renderings stored in MinIO; width,
+		// height and content_hash tell whether one is still current)
+		`CREATE TABLE IF NOT EXISTS file_derivative (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			file_id TEXT NOT NULL,
+			project_id INTEGER NOT NULL,
+			preset TEXT NOT NULL,
+			format TEXT NOT NULL,
+			width INTEGER NOT NULL,
+			height INTEGER NOT NULL,
+			content_hash TEXT NOT NULL,
+			storage_path TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE (file_id, preset, format)
+		);`,
+		// file_access table (downloads through the public /files routes)
+		`CREATE TABLE IF NOT EXISTS file_access (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			file_id TEXT NOT NULL,
+			timestamp TIMESTAMP NOT NULL,
+			ip TEXT NOT NULL,
+			user_agent TEXT NOT NULL,
+			bytes INTEGER NOT NULL,
+			referrer TEXT NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_file_access_file_id ON file_access(file_id, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_file_access_timestamp ON file_access(timestamp);`,
 	}
 
 	for _, stmt := range stmts {
@@ -85,45 +231,61 @@ func Migrate(ctx context.Context) error {
 		}
 	}
 
-	// Add content_hash column to existing file tables (SQLite doesn't support IF NOT EXISTS for ALTER TABLE)
-	// Check if column exists by querying pragma
-	var columnExists bool
-	rows, err := conn.QueryContext(ctx, `PRAGMA table_info(file)`)
-	if err != nil {
-		log.Printf("warning: failed to query table_info for file table: %v", err)
-		// If we can't check, try to add the column anyway (it will fail gracefully if it exists)
-		columnExists = false
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var cid int
-			var name string
-			var dataType string
-			var notNull int
-			var defaultValue sql.NullString
-			var pk int
-			if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err == nil {
-				if name == "content_hash" {
-					columnExists = true
-					break
-				}
-			}
-		}
-		if err := rows.Err(); err != nil {
-			log.Printf("warning: error iterating table_info rows: %v", err)
-		}
+	// Columns added after the initial schema; CREATE TABLE IF NOT EXISTS won't
+	// add them to existing databases.
+	if err := ensureColumn(ctx, conn, "file", "content_hash", "TEXT"); err != nil {
+		log.Printf("warning: failed to add content_hash column: %v", err)
+	}
+	if err := ensureColumn(ctx, conn, "file", "updated_at", "TIMESTAMP"); err != nil {
+		log.Printf("warning: failed to add updated_at column: %v", err)
+	} else if _, err := conn.ExecContext(ctx, `UPDATE file SET updated_at = created_at WHERE updated_at IS NULL`); err != nil {
+		log.Printf("warning: failed to backfill file.updated_at: %v", err)
+	}
+
+	// Existing users get the default through the column default
+	if err := ensureColumn(ctx, conn, "user", "storage_limit", "INTEGER NOT NULL DEFAULT "+strconv.FormatInt(DefaultStorageLimit, 10)); err != nil {
+		log.Printf("warning: failed to add user.storage_limit column: %v", err)
 	}
 
-	if !columnExists {
-		if _, err := conn.ExecContext(ctx, `ALTER TABLE file ADD COLUMN content_hash TEXT`); err != nil {
-			// Check if error is because column already exists (SQLite error code 1)
-			if strings.Contains(err.Error(), "duplicate column") || strings.Contains(err.Error(), "already exists") {
-				log.Printf("content_hash column already exists, skipping")
-			} else {
-				log.Printf("warning: failed to add content_hash column: %v", err)
+	if err := ensureColumn(ctx, conn, "project", "presets", "TEXT"); err != nil {
+		log.Printf("warning: failed to add project.presets column: %v", err)
+	}
+
+	if err := ensureColumn(ctx, conn, "apikey", "allowed_ips", "TEXT"); err != nil {
+		log.Printf("warning: failed to add apikey.allowed_ips column: %v", err)
+	}
+	if err := ensureColumn(ctx, conn, "apikey", "reactivated_at", "TIMESTAMP"); err != nil {
+		log.Printf("warning: failed to add apikey.reactivated_at column: %v", err)
+	}
+
+	if err := ensureColumn(ctx, conn, "project", "max_files", "INTEGER"); err != nil {
+		log.Printf("warning: failed to add project.max_files column: %v", err)
+	}
+
+	if err := ensureColumn(ctx, conn, "file", "content_encoding", "TEXT"); err != nil {
+		log.Printf("warning: failed to add file.content_encoding column: %v", err)
+	}
+
+	if err := ensureColumn(ctx, conn, "file", "cache_control", "TEXT"); err != nil {
+		log.Printf("warning: failed to add file.cache_control column: %v", err)
+	}
+	if err := ensureColumn(ctx, conn, "file", "content_type_override", "TEXT"); err != nil {
+		log.Printf("warning: failed to add file.content_type_override column: %v", err)
+	}
+
+	if err := ensureColumn(ctx, conn, "file", "locked_until", "TIMESTAMP"); err != nil {
+		log.Printf("warning: failed to add file.locked_until column: %v", err)
+	}
+	if err := ensureColumn(ctx, conn, "project", "retention_days", "INTEGER"); err != nil {
+		log.Printf("warning: failed to add project.retention_days column: %v", err)
+	}
+
+	// Image dimensions; file_trash mirrors file's columns
+	for _, table := range []string{"file", "file_trash"} {
+		for _, column := range []string{"width", "height"} {
+			if err := ensureColumn(ctx, conn, table, column, "INTEGER"); err != nil {
+				log.Printf("warning: failed to add %s.%s column: %v", table, column, err)
 			}
-		} else {
-			log.Printf("added content_hash column to file table")
 		}
 	}
 
@@ -131,7 +293,49 @@ func Migrate(ctx context.Context) error {
 	if _, err := conn.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS idx_file_content_hash ON file(content_hash)`); err != nil {
 		log.Printf("warning: failed to create index on content_hash: %v", err)
 	}
+	// Used by the apiusage retention cleanup
+	if _, err := conn.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS idx_apiusage_timestamp ON apiusage(timestamp)`); err != nil {
+		log.Printf("warning: failed to create index on apiusage.timestamp: %v", err)
+	}
+
+	log.Printf("database migrations applied (tables ensured: user, project, apikey, apiusage, file, job, storage_snapshot, audit_log, file_trash, usage_daily, share_link, project_webhook, webhook_delivery, file_derivative, file_access)")
+	return nil
+}
+
+// ensureColumn adds a column to an existing table if it is missing. SQLite
+// doesn't support ADD COLUMN IF NOT EXISTS, so we check PRAGMA table_info first.
+func ensureColumn(ctx context.Context, conn *sql.DB, table, column, definition string) error {
+	rows, err := conn.QueryContext(ctx, `PRAGMA table_info(`+table+`)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-	log.Printf("database migrations applied (tables ensured: user, project, apikey, apiusage, file)")
+	for rows.Next() {
+		var cid int
+		var name string
+		var dataType string
+		var notNull int
+		var defaultValue sql.NullString
+		var pk int
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+column+` `+definition); err != nil {
+		// Another process may have added it between the check and the ALTER
+		if strings.Contains(err.Error(), "duplicate column") {
+			return nil
+		}
+		return err
+	}
+	log.Printf("added %s column to %s table", column, table)
 	return nil
 }
@@ -6,10 +6,16 @@ import "time"
 // We'll start with plain structs to be used with database/sql and add
 // helpers/queries as we port each route.
 
+// DefaultStorageLimit is the storage quota of a user in bytes (50GB, like
+// Python) unless user.storage_limit was changed.
+const DefaultStorageLimit int64 = 50 * 1024 * 1024 * 1024
+
 type User struct {
 	FirebaseUID string    `db:"firebase_uid" json:"firebase_uid"`
 	Email       string    `db:"email" json:"email"`
 	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	// StorageLimit is the user's storage quota in bytes.
+	StorageLimit int64 `db:"storage_limit" json:"storage_limit"`
 }
 
 type Project struct {
@@ -29,6 +35,8 @@ type ApiKey struct {
 	LastUsedAt      *time.Time `db:"last_used_at" json:"last_used_at"`
 	UserFirebaseUID string     `db:"user_firebase_uid" json:"user_firebase_uid"`
 	ProjectID       int64      `db:"project_id" json:"project_id"`
+	// AllowedIPs restricts use of the key to these IPs/CIDRs; empty means any.
+	AllowedIPs []string `db:"allowed_ips" json:"allowed_ips"`
 }
 
 type ApiUsage struct {
@@ -42,6 +50,18 @@ type ApiUsage struct {
 	ApiKeyID        int64     `db:"api_key_id" json:"api_key_id"`
 }
 
+// AuditEntry is one audit_log row.
+type AuditEntry struct {
+	ID         int64     `db:"id" json:"id"`
+	Timestamp  time.Time `db:"timestamp" json:"timestamp"`
+	ActorUID   string    `db:"actor_uid" json:"actor_uid"`
+	Action     string    `db:"action" json:"action"`
+	TargetType string    `db:"target_type" json:"target_type"`
+	TargetID   string    `db:"target_id" json:"target_id"`
+	ProjectID  *int64    `db:"project_id" json:"project_id"`
+	Detail     string    `db:"detail" json:"detail,omitempty"`
+}
+
 type File struct {
 	ID              string    `db:"id" json:"id"`
 	Filename        string    `db:"filename" json:"filename"`
@@ -52,4 +72,26 @@ type File struct {
 	UserFirebaseUID string    `db:"user_firebase_uid" json:"user_firebase_uid"`
 	StoragePath     string    `db:"storage_path" json:"storage_path"`
 	ContentHash     string    `db:"content_hash" json:"content_hash"`
+	UpdatedAt       time.Time `db:"updated_at" json:"updated_at"`
+	// ContentEncoding is "gzip" when the object is stored compressed in MinIO.
+	ContentEncoding string `db:"content_encoding" json:"content_encoding,omitempty"`
+	// CacheControl and ContentTypeOverride replace the default Cache-Control
+	// and Content-Type when the file is served; empty means the default.
+	CacheControl        string `db:"cache_control" json:"cache_control,omitempty"`
+	ContentTypeOverride string `db:"content_type_override" json:"content_type_override,omitempty"`
+	// LockedUntil blocks deletion of the file until that time (retention).
+	LockedUntil *time.Time `db:"locked_until" json:"locked_until,omitempty"`
+	// Width and Height are the pixel dimensions of PNG, JPEG and GIF
+	// uploads, nil when unknown.
+	Width  *int `db:"width" json:"width,omitempty"`
+	Height *int `db:"height" json:"height,omitempty"`
+}
+
+// TrashedFile is a soft-deleted file (file_trash row). PurgeAt is when the
+// purge job removes it for good.
+type TrashedFile struct {
+	File
+	DeletedAt time.Time `db:"deleted_at" json:"deleted_at"`
+	DeletedBy string    `db:"deleted_by" json:"deleted_by"`
+	PurgeAt   time.Time `json:"purge_at"`
 }
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	lockedRetryAttempts = 5
+	lockedRetryBaseWait = 20 * time.Millisecond
+)
+
+// IsLocked reports whether err is SQLite's SQLITE_BUSY / SQLITE_LOCKED
+// ("database is locked"), which can still happen under bursty concurrent
+// writes despite WAL and the busy timeout.
+func IsLocked(err error) bool {
+	if err == nil {
+		return false
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// Extended codes keep the primary code in the low byte
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+			return true
+		}
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "SQLITE_BUSY")
+}
+
+// ExecWithRetry runs a write statement, retrying a few times with jittered
+// backoff when the database is locked. Other errors are returned immediately.
+func ExecWithRetry(ctx context.Context, conn *sql.DB, query string, args ...any) (sql.Result, error) {
+	var res sql.Result
+	var err error
+	for attempt := 0; attempt < lockedRetryAttempts; attempt++ {
+		res, err = conn.ExecContext(ctx, query, args...)
+		if !IsLocked(err) {
+			return res, err
+		}
+
+		// 20ms, 40ms, 80ms, ... plus up to 100% jitter so writers spread out
+		wait := lockedRetryBaseWait << attempt
+		wait += time.Duration(rand.Int64N(int64(wait)))
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+	}
+	return res, err
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// openTestDB opens a WAL database in a temp file without a busy timeout, so
+// lock contention surfaces as SQLITE_BUSY right away.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "retry.db") + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(0)"
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := conn.Exec(`CREATE TABLE counter (id INTEGER PRIMARY KEY AUTOINCREMENT, writer INTEGER NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestExecWithRetryUnderWriteLock(t *testing.T) {
+	conn := openTestDB(t)
+	ctx := context.Background()
+
+	// Hold the write lock in an open transaction for a while
+	holder, err := conn.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	if _, err := holder.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := holder.ExecContext(ctx, `INSERT INTO counter (writer) VALUES (-1)`); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = conn.ExecContext(ctx, `INSERT INTO counter (writer) VALUES (0)`)
+	if !IsLocked(err) {
+		t.Fatalf("plain ExecContext under a held write lock: err = %v, want SQLITE_BUSY", err)
+	}
+
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = ExecWithRetry(ctx, conn, `INSERT INTO counter (writer) VALUES (?)`, i+1)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := holder.ExecContext(ctx, `COMMIT`); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("writer %d: ExecWithRetry = %v", i+1, err)
+		}
+	}
+	var n int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM counter WHERE writer > 0`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != writers {
+		t.Fatalf("%d rows written, want %d", n, writers)
+	}
+}
+
+func TestIsLockedExtendedCode(t *testing.T) {
+	conn := openTestDB(t)
+	ctx := context.Background()
+	if _, err := conn.Exec(`INSERT INTO counter (writer) VALUES (0)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// A read transaction whose snapshot goes stale when another connection
+	// commits can't be upgraded to a write: SQLITE_BUSY_SNAPSHOT
+	reader, err := conn.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err := reader.ExecContext(ctx, `BEGIN`); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := reader.QueryRowContext(ctx, `SELECT COUNT(*) FROM counter`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`INSERT INTO counter (writer) VALUES (1)`); err != nil {
+		t.Fatal(err)
+	}
+	_, err = reader.ExecContext(ctx, `INSERT INTO counter (writer) VALUES (2)`)
+	reader.ExecContext(ctx, `ROLLBACK`)
+
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code() != sqlite3.SQLITE_BUSY_SNAPSHOT {
+		t.Fatalf("err = %v, want SQLITE_BUSY_SNAPSHOT", err)
+	}
+	if !IsLocked(err) {
+		t.Fatal("IsLocked(SQLITE_BUSY_SNAPSHOT) = false")
+	}
+	if IsLocked(errors.New("constraint failed")) || IsLocked(nil) {
+		t.Fatal("IsLocked matched an unrelated error")
+	}
+}
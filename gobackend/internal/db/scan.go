@@ -0,0 +1,109 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// RowScanner is implemented by both *sql.Row and *sql.Rows.
+type RowScanner interface {
+	Scan(dest ...any) error
+}
+
+// FileColumns is the file column list expected by ScanFile, for use in
+// SELECT statements: "SELECT " + FileColumns + " FROM file WHERE ...".
+const FileColumns = `id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path, content_hash, updated_at, content_encoding, cache_control, content_type_override, locked_until, width, height`
+
+// ScanFile scans a row selected with FileColumns into f. Nullable columns
+// added by later migrations fall back to sensible defaults for old rows.
+func ScanFile(row RowScanner, f *File) error {
+	var contentHash sql.NullString
+	var updatedAt sql.NullTime
+	var contentEncoding, cacheControl, contentTypeOverride sql.NullString
+	var lockedUntil sql.NullTime
+	var width, height sql.NullInt64
+	if err := row.Scan(
+		&f.ID,
+		&f.Filename,
+		&f.Size,
+		&f.MimeType,
+		&f.CreatedAt,
+		&f.ProjectID,
+		&f.UserFirebaseUID,
+		&f.StoragePath,
+		&contentHash,
+		&updatedAt,
+		&contentEncoding,
+		&cacheControl,
+		&contentTypeOverride,
+		&lockedUntil,
+		&width,
+		&height,
+	); err != nil {
+		return err
+	}
+	f.ContentHash = contentHash.String
+	f.ContentEncoding = contentEncoding.String
+	f.CacheControl = cacheControl.String
+	f.ContentTypeOverride = contentTypeOverride.String
+	if lockedUntil.Valid {
+		t := lockedUntil.Time
+		f.LockedUntil = &t
+	}
+	if width.Valid && height.Valid {
+		w, h := int(width.Int64), int(height.Int64)
+		f.Width, f.Height = &w, &h
+	}
+	f.UpdatedAt = f.CreatedAt
+	if updatedAt.Valid {
+		f.UpdatedAt = updatedAt.Time
+	}
+	return nil
+}
+
+// ScanTrashedFile scans a file_trash row selected with
+// FileColumns + ", deleted_at, deleted_by" into t.
+func ScanTrashedFile(row RowScanner, t *TrashedFile) error {
+	return ScanFile(trailingScanner{row, []any{&t.DeletedAt, &t.DeletedBy}}, &t.File)
+}
+
+// trailingScanner adds destinations for columns selected after FileColumns.
+type trailingScanner struct {
+	row   RowScanner
+	extra []any
+}
+
+func (s trailingScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// APIKeyColumns is the apikey column list expected by ScanAPIKey.
+const APIKeyColumns = `id, key, name, is_active, created_at, last_used_at, user_firebase_uid, project_id, allowed_ips`
+
+// ScanAPIKey scans a row selected with APIKeyColumns into k.
+func ScanAPIKey(row RowScanner, k *ApiKey) error {
+	var lastUsed sql.NullTime
+	var allowedIPs sql.NullString
+	if err := row.Scan(
+		&k.ID,
+		&k.Key,
+		&k.Name,
+		&k.IsActive,
+		&k.CreatedAt,
+		&lastUsed,
+		&k.UserFirebaseUID,
+		&k.ProjectID,
+		&allowedIPs,
+	); err != nil {
+		return err
+	}
+	if lastUsed.Valid {
+		t := lastUsed.Time
+		k.LastUsedAt = &t
+	}
+	k.AllowedIPs = make([]string, 0)
+	if allowedIPs.String != "" {
+		k.AllowedIPs = strings.Split(allowedIPs.String, ",")
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+// Package jobs is a small DB-backed queue for work that should run after a
+// request returns (e.g. thumbnail pre-generation). Jobs are rows in the job
+// table; a pool of workers claims pending rows, runs the handler registered
+// for the job type, and retries failures with backoff.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// Job statuses stored in job.status.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+const defaultMaxAttempts = 5
+
+// Handler processes one job. Returning an error schedules a retry until the
+// job's max_attempts is reached.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = make(map[string]Handler)
+)
+
+// Register sets the handler for a job type. It is meant to be called during
+// startup, before Start.
+func Register(jobType string, h Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[jobType] = h
+}
+
+// Enqueue adds a job of the given type with a JSON-encoded payload.
+func Enqueue(ctx context.Context, jobType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode job payload: %w", err)
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = conn.ExecContext(ctx, `
+		INSERT INTO job (type, payload, status, attempts, max_attempts, run_at, created_at, updated_at)
+		VALUES (?, ?, ?, 0, ?, ?, ?, ?)
+	`, jobType, string(data), StatusPending, defaultMaxAttempts, now, now, now)
+	return err
+}
+
+// Pool is a set of worker goroutines processing the job table.
+type Pool struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start launches n workers that poll for pending jobs every pollInterval.
+// Jobs left "running" by a previous process (e.g. after a crash) are put back
+// to pending first.
+func Start(n int, pollInterval time.Duration) (*Pool, error) {
+	conn, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Exec(`UPDATE job SET status = ?, updated_at = ? WHERE status = ?`,
+		StatusPending, time.Now().UTC(), StatusRunning); err != nil {
+		return nil, fmt.Errorf("reset running jobs: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{ctx: ctx, cancel: cancel}
+	for i := 0; i < n; i++ {
+		p.wg.Add(1)
+		go p.work(ctx, conn, pollInterval)
+	}
+	log.Printf("jobs: started %d workers (poll=%s)", n, pollInterval)
+	return p, nil
+}
+
+// Stop stops claiming new jobs and waits up to timeout for running jobs to
+// finish. Jobs interrupted by the timeout are retried on the next start.
+func (p *Pool) Stop(timeout time.Duration) {
+	p.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Printf("jobs: workers stopped")
+	case <-time.After(timeout):
+		log.Printf("jobs: timed out waiting for workers after %s", timeout)
+	}
+}
+
+// Every runs fn immediately and then every interval until the pool is stopped.
+// It is for periodic maintenance (snapshots, cleanup) rather than queued work;
+// errors are logged and the next run happens on schedule.
+func (p *Pool) Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			if err := fn(p.ctx); err != nil && p.ctx.Err() == nil {
+				log.Printf("jobs: periodic %s failed: %v", name, err)
+			}
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+func (p *Pool) work(ctx context.Context, conn *sql.DB, pollInterval time.Duration) {
+	defer p.wg.Done()
+
+	for {
+		// Drain the queue before sleeping
+		for ctx.Err() == nil {
+			ran, err := runNext(ctx, conn)
+			if err != nil {
+				log.Printf("jobs: claim error: %v", err)
+				break
+			}
+			if !ran {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// runNext claims and runs the oldest due job. It reports whether a job was run.
+func runNext(ctx context.Context, conn *sql.DB) (bool, error) {
+	now := time.Now().UTC()
+
+	var (
+		id          int64
+		jobType     string
+		payload     string
+		attempts    int
+		maxAttempts int
+	)
+	// Claiming with a single UPDATE keeps two workers from taking the same job
+	err := conn.QueryRowContext(ctx, `
+		UPDATE job
+		SET status = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id = (
+			SELECT id FROM job
+			WHERE status = ? AND run_at <= ?
+			ORDER BY run_at, id
+			LIMIT 1
+		) AND status = ?
+		RETURNING id, type, payload, attempts, max_attempts
+	`, StatusRunning, now, StatusPending, now, StatusPending).Scan(&id, &jobType, &payload, &attempts, &maxAttempts)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	handlersMu.RLock()
+	h, ok := handlers[jobType]
+	handlersMu.RUnlock()
+
+	var runErr error
+	if !ok {
+		runErr = fmt.Errorf("no handler registered for job type %q", jobType)
+		attempts = maxAttempts // retrying won't help
+	} else {
+		runErr = runHandler(ctx, h, json.RawMessage(payload))
+	}
+
+	// Record the outcome even if we're shutting down
+	finishCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if runErr == nil {
+		_, err = conn.ExecContext(finishCtx, `
+			UPDATE job SET status = ?, last_error = NULL, updated_at = ? WHERE id = ?
+		`, StatusDone, time.Now().UTC(), id)
+		return true, err
+	}
+
+	if attempts >= maxAttempts {
+		log.Printf("jobs: job %d (%s) failed permanently after %d attempts: %v", id, jobType, attempts, runErr)
+		_, err = conn.ExecContext(finishCtx, `
+			UPDATE job SET status = ?, last_error = ?, updated_at = ? WHERE id = ?
+		`, StatusFailed, runErr.Error(), time.Now().UTC(), id)
+		return true, err
+	}
+
+	delay := retryDelay(attempts)
+	log.Printf("jobs: job %d (%s) attempt %d failed, retrying in %s: %v", id, jobType, attempts, delay, runErr)
+	_, err = conn.ExecContext(finishCtx, `
+		UPDATE job SET status = ?, last_error = ?, run_at = ?, updated_at = ? WHERE id = ?
+	`, StatusPending, runErr.Error(), time.Now().UTC().Add(delay), time.Now().UTC(), id)
+	return true, err
+}
+
+// runHandler runs h, turning a panic into an error so one bad job can't take
+// down the worker.
+func runHandler(ctx context.Context, h Handler, payload json.RawMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, payload)
+}
+
+// retryDelay is an exponential backoff: 10s, 20s, 40s, ... capped at 10m.
+func retryDelay(attempts int) time.Duration {
+	d := 10 * time.Second << (attempts - 1)
+	if d <= 0 || d > 10*time.Minute {
+		return 10 * time.Minute
+	}
+	return d
+}
@@ -0,0 +1,373 @@
+// Package openapi builds the OpenAPI 3 document served at /openapi.json from
+// the routes registered on the Fiber app, with JSON schemas reflected from
+// the Go types handlers bind and return, so the spec can't drift from the
+// code.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// Spec is an OpenAPI 3.0 document.
+type Spec struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+// Info is the document's info object.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Components holds the reflected schemas and the security schemes.
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes one way requests authenticate.
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// Operation is one method on a path.
+type Operation struct {
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId"`
+	Tags        []string              `json:"tags,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema the generator emits.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Doc describes one route beyond what the router knows. Request and
+// Response are values of the types the handler binds and returns (e.g.
+// db.Project{} or []db.File{}); nil means none. Status defaults to 200, or
+// 204 without a Response. A Multipart request is documented as
+// multipart/form-data instead of JSON.
+type Doc struct {
+	Summary   string
+	Tag       string
+	Request   any
+	Multipart bool
+	Response  any
+	Status    int
+}
+
+// Route is a registered route with its security scheme names (empty for
+// public routes) and documentation, if any.
+type Route struct {
+	Method   string
+	Path     string
+	Params   []string
+	Security []string
+	Doc      *Doc
+}
+
+// FromFiber lists the app's handler routes (not middleware), leaving out the
+// HEAD routes Fiber adds for every GET.
+func FromFiber(routes []fiber.Route) []Route {
+	out := make([]Route, 0, len(routes))
+	seen := make(map[string]bool)
+	for _, r := range routes {
+		if r.Method == fiber.MethodHead || r.Method == fiber.MethodConnect || r.Method == fiber.MethodTrace {
+			continue
+		}
+		// Groups can register the same route twice (e.g. "/" and "")
+		path := strings.TrimSuffix(r.Path, "/")
+		if path == "" {
+			path = "/"
+		}
+		if key := r.Method + " " + path; !seen[key] {
+			seen[key] = true
+			out = append(out, Route{Method: r.Method, Path: path, Params: r.Params})
+		}
+	}
+	return out
+}
+
+// Build generates the document. errorBody is the JSON error body every
+// operation can return.
+func Build(info Info, schemes map[string]SecurityScheme, routes []Route, errorBody any) *Spec {
+	g := &generator{schemas: make(map[string]*Schema)}
+	spec := &Spec{
+		OpenAPI:    "3.0.3",
+		Info:       info,
+		Paths:      make(map[string]map[string]Operation),
+		Components: Components{Schemas: g.schemas, SecuritySchemes: schemes},
+	}
+	g.schemas["Error"] = g.structSchema(reflect.TypeOf(errorBody))
+	errorRef := &Schema{Ref: "#/components/schemas/Error"}
+
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+	for _, r := range routes {
+		path, params := openAPIPath(r.Path, r.Params)
+		op := Operation{
+			OperationID: operationID(r.Method, path),
+			Tags:        []string{defaultTag(path)},
+			Parameters:  params,
+			Responses:   make(map[string]Response),
+		}
+		for _, name := range r.Security {
+			op.Security = append(op.Security, map[string][]string{name: {}})
+		}
+
+		status := http.StatusOK
+		var response any
+		if r.Doc != nil {
+			op.Summary = r.Doc.Summary
+			if r.Doc.Tag != "" {
+				op.Tags = []string{r.Doc.Tag}
+			}
+			if r.Doc.Request != nil {
+				mediaType := fiber.MIMEApplicationJSON
+				if r.Doc.Multipart {
+					mediaType = fiber.MIMEMultipartForm
+				}
+				op.RequestBody = &RequestBody{
+					Required: true,
+					Content:  map[string]MediaType{mediaType: {Schema: g.schemaOf(reflect.TypeOf(r.Doc.Request))}},
+				}
+			}
+			response = r.Doc.Response
+			switch {
+			case r.Doc.Status != 0:
+				status = r.Doc.Status
+			case response == nil:
+				status = http.StatusNoContent
+			}
+		}
+
+		ok := Response{Description: http.StatusText(status)}
+		if response != nil {
+			ok.Content = map[string]MediaType{fiber.MIMEApplicationJSON: {Schema: g.schemaOf(reflect.TypeOf(response))}}
+		}
+		op.Responses[strconv.Itoa(status)] = ok
+		op.Responses["default"] = Response{
+			Description: "Error",
+			Content:     map[string]MediaType{fiber.MIMEApplicationJSON: {Schema: errorRef}},
+		}
+
+		if spec.Paths[path] == nil {
+			spec.Paths[path] = make(map[string]Operation)
+		}
+		spec.Paths[path][strings.ToLower(r.Method)] = op
+	}
+	return spec
+}
+
+// Stub is a valid document with no paths.
+func Stub(info Info) *Spec {
+	return &Spec{
+		OpenAPI:    "3.0.3",
+		Info:       info,
+		Paths:      make(map[string]map[string]Operation),
+		Components: Components{Schemas: make(map[string]*Schema)},
+	}
+}
+
+// openAPIPath turns /projects/:project_id into /projects/{project_id} with
+// its path parameters. Wildcards (*, +) become a "path" parameter.
+func openAPIPath(path string, params []string) (string, []Parameter) {
+	out := make([]Parameter, 0, len(params))
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		var name string
+		switch {
+		case strings.HasPrefix(s, ":"):
+			name = strings.TrimSuffix(strings.TrimPrefix(s, ":"), "?")
+		case s == "*" || s == "+":
+			name = "path"
+		default:
+			continue
+		}
+		segments[i] = "{" + name + "}"
+		out = append(out, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	return strings.Join(segments, "/"), out
+}
+
+// operationID is e.g. get_projects_project_id_stats.
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		b.WriteString("_" + strings.ToLower(part))
+	}
+	return b.String()
+}
+
+// defaultTag groups a route by its first path segment after /api/v1 or
+// /frontend.
+func defaultTag(path string) string {
+	path = strings.TrimPrefix(path, "/api/v1")
+	path = strings.TrimPrefix(path, "/frontend")
+	first, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if first == "" {
+		return "default"
+	}
+	return first
+}
+
+// generator reflects Go types into schemas, registering named structs as
+// components so each is described once.
+type generator struct {
+	schemas map[string]*Schema
+}
+
+// Binary is a multipart file field in a Doc request type.
+type Binary []byte
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	binaryType = reflect.TypeOf(Binary{})
+)
+
+func (g *generator) schemaOf(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == binaryType:
+		return &Schema{Type: "string", Format: "binary"}
+	case t.Kind() == reflect.Pointer:
+		s := *g.schemaOf(t.Elem())
+		if s.Ref != "" {
+			return &s
+		}
+		s.Nullable = true
+		return &s
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		// json.RawMessage and []byte are opaque
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{}
+		}
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			// Placeholder first so recursive types terminate
+			g.schemas[name] = &Schema{}
+			*g.schemas[name] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.addFields(s, t)
+	return s
+}
+
+// addFields adds a struct's JSON fields to s, flattening embedded structs
+// like encoding/json does.
+func (g *generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.addFields(s, f.Type)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schemaOf(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// schemaName is a type's name with the first letter upper-cased; generic
+// instances like pageResponse[db.File] become PageResponseFile.
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if i := strings.Index(name, "["); i >= 0 {
+		arg := strings.TrimSuffix(name[i+1:], "]")
+		name = name[:i] + arg[strings.LastIndex(arg, ".")+1:]
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
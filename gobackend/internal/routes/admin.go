@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// RegisterAdminRoutes registers operator endpoints. They require the
+// developer role.
+func RegisterAdminRoutes(router fiber.Router) {
+	router.Use(auth.FirebaseAuthMiddleware())
+	router.Use(auth.RequireRoles("developer"))
+
+	router.Get("/audit", getAuditLog)
+	router.Get("/stats", getAdminStats)
+}
+
+// getAuditLog lists audit entries newest first (GET /admin/audit), filtered
+// by actor, action, target_type, project_id and a start_date/end_date range.
+func getAuditLog(c fiber.Ctx) error {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	where := make([]string, 0)
+	args := make([]any, 0)
+
+	if actor := strings.TrimSpace(c.Query("actor")); actor != "" {
+		where = append(where, "actor_uid = ?")
+		args = append(args, actor)
+	}
+	if action := c.Query("action"); action != "" {
+		if !audit.IsAction(action) {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid action: must be one of create, update, delete, import, restore")
+		}
+		where = append(where, "action = ?")
+		args = append(args, action)
+	}
+	if targetType := c.Query("target_type"); targetType != "" {
+		where = append(where, "target_type = ?")
+		args = append(args, targetType)
+	}
+	if projectIDStr := c.Query("project_id"); projectIDStr != "" {
+		projectID, err := strconv.ParseInt(projectIDStr, 10, 64)
+		if err != nil || projectID <= 0 {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project_id")
+		}
+		where = append(where, "project_id = ?")
+		args = append(args, projectID)
+	}
+	if startDateStr := c.Query("start_date"); startDateStr != "" {
+		start, err := time.Parse("2006-01-02", startDateStr)
+		if err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid start_date")
+		}
+		where = append(where, "timestamp >= ?")
+		args = append(args, start)
+	}
+	if endDateStr := c.Query("end_date"); endDateStr != "" {
+		end, err := time.Parse("2006-01-02", endDateStr)
+		if err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid end_date")
+		}
+		// include full end day
+		where = append(where, "timestamp < ?")
+		args = append(args, end.AddDate(0, 0, 1))
+	}
+
+	whereClause := ""
+	if len(where) > 0 {
+		whereClause = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page := pageResponse[db.AuditEntry]{Items: make([]db.AuditEntry, 0), Limit: limit, Offset: offset}
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log `+whereClause, args...).Scan(&page.Total); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to count audit entries")
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT id, timestamp, actor_uid, action, target_type, target_id, project_id, detail
+		FROM audit_log
+		`+whereClause+`
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query audit log")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e db.AuditEntry
+		var projectID sql.NullInt64
+		var detail sql.NullString
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.ActorUID, &e.Action, &e.TargetType, &e.TargetID, &projectID, &detail); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan audit entry")
+		}
+		if projectID.Valid {
+			e.ProjectID = &projectID.Int64
+		}
+		e.Detail = detail.String
+		page.Items = append(page.Items, e)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate audit log")
+	}
+
+	return c.JSON(page)
+}
@@ -0,0 +1,88 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// adminStatsTTL is how long GET /admin/stats serves the same aggregates; each
+// computation scans the file and apiusage tables.
+const adminStatsTTL = time.Minute
+
+// adminStats is the service-wide overview returned by GET /admin/stats.
+type adminStats struct {
+	Users      int64 `json:"users"`
+	Projects   int64 `json:"projects"`
+	Files      int64 `json:"files"`
+	TrashFiles int64 `json:"trash_files"`
+	// StorageBytes is the total size of all files; StoredBytes counts
+	// deduplicated content once, as it is held in MinIO.
+	StorageBytes  int64     `json:"storage_bytes"`
+	StoredBytes   int64     `json:"stored_bytes"`
+	APIKeys       int64     `json:"api_keys"`
+	ActiveAPIKeys int64     `json:"active_api_keys"`
+	Requests24h   int64     `json:"requests_24h"`
+	Requests30d   int64     `json:"requests_30d"`
+	ComputedAt    time.Time `json:"computed_at"`
+}
+
+var adminStatsCache struct {
+	mu    sync.Mutex
+	stats *adminStats
+}
+
+// getAdminStats handles GET /admin/stats. Results are cached for
+// adminStatsTTL; concurrent requests on a stale cache compute them once.
+func getAdminStats(c fiber.Ctx) error {
+	adminStatsCache.mu.Lock()
+	defer adminStatsCache.mu.Unlock()
+
+	if s := adminStatsCache.stats; s != nil && time.Since(s.ComputedAt) < adminStatsTTL {
+		c.Set("X-Cache", "HIT")
+		return c.JSON(s)
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	s := &adminStats{ComputedAt: now}
+	if err := conn.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM user),
+			(SELECT COUNT(*) FROM project),
+			(SELECT COUNT(*) FROM file),
+			(SELECT COUNT(*) FROM file_trash),
+			(SELECT COALESCE(SUM(size), 0) FROM file),
+			(SELECT COALESCE(SUM(size), 0) FROM (SELECT MAX(size) AS size FROM file GROUP BY storage_path)),
+			(SELECT COUNT(*) FROM apikey),
+			(SELECT COUNT(*) FROM apikey WHERE is_active = 1)
+	`).Scan(&s.Users, &s.Projects, &s.Files, &s.TrashFiles, &s.StorageBytes, &s.StoredBytes, &s.APIKeys, &s.ActiveAPIKeys); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to compute totals")
+	}
+	if err := conn.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(CASE WHEN timestamp >= ? THEN 1 ELSE 0 END), 0)
+		FROM apiusage
+		WHERE timestamp >= ?
+	`, now.Add(-24*time.Hour), now.AddDate(0, 0, -30)).Scan(&s.Requests30d, &s.Requests24h); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to count requests")
+	}
+
+	adminStatsCache.stats = s
+	c.Set("X-Cache", "MISS")
+	return c.JSON(s)
+}
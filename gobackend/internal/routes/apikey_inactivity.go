@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// disableInactiveAPIKeys deactivates active API keys not used for
+// inactiveDays: their last use, or creation for keys never used, is older,
+// and so is their last re-enabling through PUT /api-keys/:api_key_id/active.
+// Each is recorded in the audit log and sent to the project's webhooks as
+// api_key.disabled.
+func disableInactiveAPIKeys(ctx context.Context, inactiveDays int) error {
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+
+	// Same format as CURRENT_TIMESTAMP, which sets these columns
+	cutoff := time.Now().UTC().AddDate(0, 0, -inactiveDays).Format("2006-01-02 15:04:05")
+	const inactive = `is_active = 1
+		AND COALESCE(last_used_at, created_at) < ?
+		AND (reactivated_at IS NULL OR reactivated_at < ?)`
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT `+db.APIKeyColumns+`
+		FROM apikey
+		WHERE `+inactive, cutoff, cutoff)
+	if err != nil {
+		return err
+	}
+	keys := make([]db.ApiKey, 0)
+	for rows.Next() {
+		var k db.ApiKey
+		if err := db.ScanAPIKey(rows, &k); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	detail := "disabled after " + strconv.Itoa(inactiveDays) + " days unused"
+	for _, k := range keys {
+		// Re-check, the key may have been used since
+		res, err := db.ExecWithRetry(ctx, conn, `
+			UPDATE apikey SET is_active = 0
+			WHERE id = ? AND `+inactive, k.ID, cutoff, cutoff)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		log.Printf("apikeys: disabled key %d of project %d, %s", k.ID, k.ProjectID, detail)
+		audit.Record(ctx, k.UserFirebaseUID, audit.ActionUpdate, audit.TargetAPIKey, strconv.FormatInt(k.ID, 10), k.ProjectID, detail)
+		dispatchWebhookEvent(ctx, webhookEvent{
+			Event:     webhookEventAPIKeyDisabled,
+			ProjectID: k.ProjectID,
+			APIKey:    &webhookAPIKey{ID: k.ID, Name: k.Name, CreatedAt: k.CreatedAt, LastUsedAt: k.LastUsedAt},
+		})
+	}
+	return nil
+}
@@ -3,54 +3,113 @@ package routes
 import (
 	"context"
 	"database/sql"
+	"log"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
 	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
 	"github.com/gabriel/open_upload_gobackend/internal/db"
 	"github.com/gofiber/fiber/v3"
 	"github.com/google/uuid"
 )
 
 type apiKeyPayload struct {
-	ProjectID int64  `json:"project_id"`
-	Name      string `json:"name"`
+	ProjectID  int64    `json:"project_id"`
+	Name       string   `json:"name"`
+	AllowedIPs []string `json:"allowed_ips"`
 }
 
+type allowedIPsPayload struct {
+	AllowedIPs []string `json:"allowed_ips"`
+}
+
+type apiKeyActivePayload struct {
+	IsActive *bool `json:"is_active"`
+}
+
+// maxAllowedIPs bounds the per-key IP allowlist.
+const maxAllowedIPs = 50
+
+type verifyBatchPayload struct {
+	APIKeys []string `json:"api_keys"`
+}
+
+// apiKeyStatus is the per-key result of a batch verification.
+type apiKeyStatus struct {
+	APIKey string `json:"api_key"`
+	Status string `json:"status"` // "active", "inactive" or "not_found"
+}
+
+// maxVerifyBatchSize bounds how many keys can be checked in one request.
+const maxVerifyBatchSize = 100
+
 // RegisterAPIKeyRoutes registers /api-keys routes (Firebase-authenticated).
-func RegisterAPIKeyRoutes(router fiber.Router) {
+func RegisterAPIKeyRoutes(router fiber.Router, cfg config.MinioConfig) {
 	router.Use(auth.FirebaseAuthMiddleware())
 
-	router.Post("/", createAPIKey)
+	router.Post("/", func(c fiber.Ctx) error {
+		return createAPIKey(c, cfg)
+	})
 	router.Get("/", listAPIKeys)
 	router.Delete("/:api_key_id", deleteAPIKey)
+	router.Put("/:api_key_id/allowed-ips", updateAPIKeyAllowedIPs)
+	router.Put("/:api_key_id/active", updateAPIKeyActive)
 }
 
 // RegisterFrontendAPIKeyRoutes registers /frontend/api-keys routes (Firebase-authenticated).
 func RegisterFrontendAPIKeyRoutes(router fiber.Router) {
 	router.Use(auth.FirebaseAuthMiddleware())
 	router.Get("/api/verify", verifyAPIKey)
+	router.Post("/api/verify-batch", verifyAPIKeysBatch)
 }
 
 func generateAPIKey() string {
 	return "openupload_sk_" + uuid.New().String()
 }
 
-func createAPIKey(c fiber.Ctx) error {
+// normalizeAllowedIPs validates an IP allowlist and returns it in its stored
+// form (comma-separated canonical CIDRs), or nil for an empty list.
+func normalizeAllowedIPs(entries []string) (any, error) {
+	if len(entries) > maxAllowedIPs {
+		return nil, apiError(http.StatusBadRequest, apierror.InvalidRequest, "too many allowed_ips (max "+strconv.Itoa(maxAllowedIPs)+")")
+	}
+	prefixes := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := auth.ParseAllowedIP(entry)
+		if err != nil {
+			return nil, apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid allowed_ips entry: "+entry)
+		}
+		prefixes = append(prefixes, prefix.String())
+	}
+	if len(prefixes) == 0 {
+		return nil, nil
+	}
+	return strings.Join(prefixes, ","), nil
+}
+
+func createAPIKey(c fiber.Ctx, cfg config.MinioConfig) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	var body apiKeyPayload
 	if err := c.Bind().Body(&body); err != nil {
-		return fiber.NewError(http.StatusBadRequest, "invalid API key payload")
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid API key payload")
+	}
+	if body.Name, err = validateName("name", body.Name, cfg.MaxNameLength); err != nil {
+		return err
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -64,51 +123,43 @@ func createAPIKey(c fiber.Ctx) error {
 		WHERE id = ?
 	`, body.ProjectID).Scan(&ownerUID); err != nil {
 		if err == sql.ErrNoRows {
-			return fiber.NewError(http.StatusNotFound, "Project not found")
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
 		}
-		return fiber.NewError(http.StatusInternalServerError, "failed to load project")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
 	}
 	if ownerUID != user.UID {
-		return fiber.NewError(http.StatusForbidden, "Not authorized to create API key for this project")
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to create API key for this project")
+	}
+
+	allowedIPs, err := normalizeAllowedIPs(body.AllowedIPs)
+	if err != nil {
+		return err
 	}
 
 	keyValue := generateAPIKey()
 
-	res, err := conn.ExecContext(ctx, `
-		INSERT INTO apikey (key, name, is_active, created_at, last_used_at, user_firebase_uid, project_id)
-		VALUES (?, ?, 1, CURRENT_TIMESTAMP, NULL, ?, ?)
-	`, keyValue, body.Name, user.UID, body.ProjectID)
+	res, err := db.ExecWithRetry(ctx, conn, `
+		INSERT INTO apikey (key, name, is_active, created_at, last_used_at, user_firebase_uid, project_id, allowed_ips)
+		VALUES (?, ?, 1, CURRENT_TIMESTAMP, NULL, ?, ?, ?)
+	`, keyValue, body.Name, user.UID, body.ProjectID, allowedIPs)
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to create API key")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to create API key")
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to get new API key id")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to get new API key id")
 	}
 
 	var apiKey db.ApiKey
-	var lastUsed sql.NullTime
-	if err := conn.QueryRowContext(ctx, `
-		SELECT id, key, name, is_active, created_at, last_used_at, user_firebase_uid, project_id
+	if err := db.ScanAPIKey(conn.QueryRowContext(ctx, `
+		SELECT `+db.APIKeyColumns+`
 		FROM apikey
 		WHERE id = ?
-	`, id).Scan(
-		&apiKey.ID,
-		&apiKey.Key,
-		&apiKey.Name,
-		&apiKey.IsActive,
-		&apiKey.CreatedAt,
-		&lastUsed,
-		&apiKey.UserFirebaseUID,
-		&apiKey.ProjectID,
-	); err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to load created API key")
-	}
-	if lastUsed.Valid {
-		t := lastUsed.Time
-		apiKey.LastUsedAt = &t
+	`, id), &apiKey); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load created API key")
 	}
+	audit.Record(ctx, user.UID, audit.ActionCreate, audit.TargetAPIKey, strconv.FormatInt(apiKey.ID, 10), apiKey.ProjectID, apiKey.Name)
 
 	return c.Status(http.StatusCreated).JSON(apiKey)
 }
@@ -116,12 +167,12 @@ func createAPIKey(c fiber.Ctx) error {
 func listAPIKeys(c fiber.Ctx) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -130,7 +181,7 @@ func listAPIKeys(c fiber.Ctx) error {
 	projectIDStr := c.Query("project_id", "")
 
 	query := `
-		SELECT id, key, name, is_active, created_at, last_used_at, user_firebase_uid, project_id
+		SELECT ` + db.APIKeyColumns + `
 		FROM apikey
 		WHERE user_firebase_uid = ?
 	`
@@ -139,7 +190,7 @@ func listAPIKeys(c fiber.Ctx) error {
 	if projectIDStr != "" {
 		projectID, err := strconv.ParseInt(projectIDStr, 10, 64)
 		if err != nil || projectID <= 0 {
-			return fiber.NewError(http.StatusBadRequest, "invalid project_id")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project_id")
 		}
 
 		// Verify project belongs to user
@@ -150,12 +201,12 @@ func listAPIKeys(c fiber.Ctx) error {
 			WHERE id = ?
 		`, projectID).Scan(&ownerUID); err != nil {
 			if err == sql.ErrNoRows {
-				return fiber.NewError(http.StatusNotFound, "Project not found or not owned by user")
+				return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found or not owned by user")
 			}
-			return fiber.NewError(http.StatusInternalServerError, "failed to load project")
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
 		}
 		if ownerUID != user.UID {
-			return fiber.NewError(http.StatusNotFound, "Project not found or not owned by user")
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found or not owned by user")
 		}
 
 		query += " AND project_id = ?"
@@ -174,24 +225,10 @@ func listAPIKeys(c fiber.Ctx) error {
 
 	for rows.Next() {
 		var k db.ApiKey
-		var lastUsed sql.NullTime
-		if err := rows.Scan(
-			&k.ID,
-			&k.Key,
-			&k.Name,
-			&k.IsActive,
-			&k.CreatedAt,
-			&lastUsed,
-			&k.UserFirebaseUID,
-			&k.ProjectID,
-		); err != nil {
+		if err := db.ScanAPIKey(rows, &k); err != nil {
 			// Continue to next row instead of failing completely
 			continue
 		}
-		if lastUsed.Valid {
-			t := lastUsed.Time
-			k.LastUsedAt = &t
-		}
 		keys = append(keys, k)
 	}
 
@@ -201,23 +238,68 @@ func listAPIKeys(c fiber.Ctx) error {
 		return c.JSON(keys)
 	}
 
+	// include=usage adds per-key request counts; opt-in since it scans apiusage
+	if slices.Contains(strings.Split(c.Query("include"), ","), "usage") {
+		withUsage, err := apiKeysWithUsage(ctx, conn, keys)
+		if err != nil {
+			log.Printf("listAPIKeys usage query error: %v", err)
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load API key usage")
+		}
+		return c.JSON(withUsage)
+	}
+
 	return c.JSON(keys)
 }
 
+// apiKeyUsageDays is the window request_count and last_request_at cover.
+const apiKeyUsageDays = 30
+
+// apiKeyWithUsage is an API key with its requests over the last
+// apiKeyUsageDays days, for GET /api-keys?include=usage.
+type apiKeyWithUsage struct {
+	db.ApiKey
+	RequestCount  int64      `json:"request_count"`
+	LastRequestAt *time.Time `json:"last_request_at"`
+}
+
+// apiKeysWithUsage adds each key's recent request count and latest request
+// time from apiusage.
+func apiKeysWithUsage(ctx context.Context, conn *sql.DB, keys []db.ApiKey) ([]apiKeyWithUsage, error) {
+	since := time.Now().UTC().AddDate(0, 0, -apiKeyUsageDays)
+	out := make([]apiKeyWithUsage, 0, len(keys))
+	for _, k := range keys {
+		entry := apiKeyWithUsage{ApiKey: k}
+		// A scalar subquery keeps the timestamp's column type, unlike MAX()
+		var last sql.NullTime
+		if err := conn.QueryRowContext(ctx, `
+			SELECT
+				(SELECT COUNT(*) FROM apiusage WHERE api_key_id = ? AND timestamp >= ?),
+				(SELECT timestamp FROM apiusage WHERE api_key_id = ? AND timestamp >= ? ORDER BY timestamp DESC LIMIT 1)
+		`, k.ID, since, k.ID, since).Scan(&entry.RequestCount, &last); err != nil {
+			return nil, err
+		}
+		if last.Valid {
+			entry.LastRequestAt = &last.Time
+		}
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
 func deleteAPIKey(c fiber.Ctx) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	apiKeyID, err := strconv.ParseInt(c.Params("api_key_id"), 10, 64)
 	if err != nil || apiKeyID <= 0 {
-		return fiber.NewError(http.StatusBadRequest, "invalid api_key_id")
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid api_key_id")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -230,65 +312,233 @@ func deleteAPIKey(c fiber.Ctx) error {
 		WHERE id = ?
 	`, apiKeyID).Scan(&ownerUID); err != nil {
 		if err == sql.ErrNoRows {
-			return fiber.NewError(http.StatusNotFound, "API key not found")
+			return apiError(http.StatusNotFound, apierror.APIKeyNotFound, "API key not found")
 		}
-		return fiber.NewError(http.StatusInternalServerError, "failed to load API key")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load API key")
 	}
 	if ownerUID != user.UID {
-		return fiber.NewError(http.StatusForbidden, "Not authorized to delete this API key")
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to delete this API key")
 	}
 
 	if _, err := conn.ExecContext(ctx, `DELETE FROM apikey WHERE id = ?`, apiKeyID); err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to delete API key")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to delete API key")
 	}
+	audit.Record(ctx, user.UID, audit.ActionDelete, audit.TargetAPIKey, strconv.FormatInt(apiKeyID, 10), 0, "")
 
 	return c.SendStatus(http.StatusNoContent)
 }
 
+// updateAPIKeyAllowedIPs replaces a key's IP allowlist
+// (PUT /api-keys/:api_key_id/allowed-ips); an empty list removes the restriction.
+func updateAPIKeyAllowedIPs(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	apiKeyID, err := strconv.ParseInt(c.Params("api_key_id"), 10, 64)
+	if err != nil || apiKeyID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid api_key_id")
+	}
+
+	var body allowedIPsPayload
+	if err := c.Bind().Body(&body); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid allowed_ips payload")
+	}
+	allowedIPs, err := normalizeAllowedIPs(body.AllowedIPs)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid
+		FROM apikey
+		WHERE id = ?
+	`, apiKeyID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.APIKeyNotFound, "API key not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load API key")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to modify this API key")
+	}
+
+	if _, err := conn.ExecContext(ctx, `UPDATE apikey SET allowed_ips = ? WHERE id = ?`, allowedIPs, apiKeyID); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to update API key")
+	}
+
+	var apiKey db.ApiKey
+	if err := db.ScanAPIKey(conn.QueryRowContext(ctx, `
+		SELECT `+db.APIKeyColumns+`
+		FROM apikey
+		WHERE id = ?
+	`, apiKeyID), &apiKey); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load updated API key")
+	}
+	audit.Record(ctx, user.UID, audit.ActionUpdate, audit.TargetAPIKey, strconv.FormatInt(apiKeyID, 10), apiKey.ProjectID, "allowed_ips")
+
+	return c.JSON(apiKey)
+}
+
+// updateAPIKeyActive enables or disables a key (PUT /api-keys/:api_key_id/active).
+// Re-enabling a disabled key restarts its API_KEY_INACTIVE_DAYS period.
+func updateAPIKeyActive(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	apiKeyID, err := strconv.ParseInt(c.Params("api_key_id"), 10, 64)
+	if err != nil || apiKeyID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid api_key_id")
+	}
+
+	var body apiKeyActivePayload
+	if err := c.Bind().Body(&body); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid is_active payload")
+	}
+	if body.IsActive == nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "is_active is required")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid
+		FROM apikey
+		WHERE id = ?
+	`, apiKeyID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.APIKeyNotFound, "API key not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load API key")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to modify this API key")
+	}
+
+	if _, err := db.ExecWithRetry(ctx, conn, `
+		UPDATE apikey
+		SET is_active = ?,
+			reactivated_at = CASE WHEN ? AND is_active = 0 THEN CURRENT_TIMESTAMP ELSE reactivated_at END
+		WHERE id = ?
+	`, *body.IsActive, *body.IsActive, apiKeyID); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to update API key")
+	}
+
+	var apiKey db.ApiKey
+	if err := db.ScanAPIKey(conn.QueryRowContext(ctx, `
+		SELECT `+db.APIKeyColumns+`
+		FROM apikey
+		WHERE id = ?
+	`, apiKeyID), &apiKey); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load updated API key")
+	}
+	audit.Record(ctx, user.UID, audit.ActionUpdate, audit.TargetAPIKey, strconv.FormatInt(apiKeyID, 10), apiKey.ProjectID, "is_active="+strconv.FormatBool(apiKey.IsActive))
+
+	return c.JSON(apiKey)
+}
+
 func verifyAPIKey(c fiber.Ctx) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	apiKeyVal := c.Query("api_key", "")
 	if apiKeyVal == "" {
-		return fiber.NewError(http.StatusBadRequest, "api_key query param is required")
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "api_key query param is required")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var key db.ApiKey
-	var lastUsed sql.NullTime
-	if err := conn.QueryRowContext(ctx, `
-		SELECT id, key, name, is_active, created_at, last_used_at, user_firebase_uid, project_id
+	if err := db.ScanAPIKey(conn.QueryRowContext(ctx, `
+		SELECT `+db.APIKeyColumns+`
 		FROM apikey
 		WHERE key = ? AND user_firebase_uid = ? AND is_active = 1
-	`, apiKeyVal, user.UID).Scan(
-		&key.ID,
-		&key.Key,
-		&key.Name,
-		&key.IsActive,
-		&key.CreatedAt,
-		&lastUsed,
-		&key.UserFirebaseUID,
-		&key.ProjectID,
-	); err != nil {
+	`, apiKeyVal, user.UID), &key); err != nil {
 		if err == sql.ErrNoRows {
-			return fiber.NewError(http.StatusNotFound, "API key not found or not owned by user")
+			return apiError(http.StatusNotFound, apierror.APIKeyNotFound, "API key not found or not owned by user")
 		}
-		return fiber.NewError(http.StatusInternalServerError, "failed to verify API key")
-	}
-	if lastUsed.Valid {
-		t := lastUsed.Time
-		key.LastUsedAt = &t
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to verify API key")
 	}
 
 	return c.JSON(key)
 }
+
+// verifyAPIKeysBatch reports the status of several API keys at once. Keys that
+// don't exist or belong to another user are both reported as "not_found" so
+// the endpoint can't be used to probe other users' keys.
+func verifyAPIKeysBatch(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	var body verifyBatchPayload
+	if err := c.Bind().Body(&body); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid verify payload")
+	}
+	if len(body.APIKeys) == 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "api_keys is required")
+	}
+	if len(body.APIKeys) > maxVerifyBatchSize {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "too many api_keys (max "+strconv.Itoa(maxVerifyBatchSize)+")")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results := make([]apiKeyStatus, 0, len(body.APIKeys))
+	for _, keyValue := range body.APIKeys {
+		status := "not_found"
+
+		var isActive bool
+		err := conn.QueryRowContext(ctx, `
+			SELECT is_active
+			FROM apikey
+			WHERE key = ? AND user_firebase_uid = ?
+		`, keyValue, user.UID).Scan(&isActive)
+		switch {
+		case err == nil && isActive:
+			status = "active"
+		case err == nil:
+			status = "inactive"
+		case err != sql.ErrNoRows:
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to verify API keys")
+		}
+
+		results = append(results, apiKeyStatus{APIKey: keyValue, Status: status})
+	}
+
+	return c.JSON(fiber.Map{"results": results})
+}
@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+)
+
+// LimitBody rejects requests whose body is larger than limit bytes with 413,
+// except on the listed paths (e.g. the upload route of a group that otherwise
+// only takes JSON). The check goes by Content-Length and leaves the body
+// unread, so a streamed upload isn't pulled into memory just to be measured.
+// Chunked bodies have no length up front: they are read up to limit+1 bytes
+// here, which never exceeds what the route would read anyway.
+func LimitBody(limit int64, except ...string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		path := strings.TrimSuffix(c.Path(), "/")
+		for _, p := range except {
+			if path == p {
+				return c.Next()
+			}
+		}
+		tooLarge := apiError(http.StatusRequestEntityTooLarge, apierror.BodyTooLarge, "request body larger than "+strconv.FormatInt(limit, 10)+" bytes")
+		req := c.Request()
+		if n := req.Header.ContentLength(); n >= 0 {
+			if int64(n) > limit {
+				return tooLarge
+			}
+			return c.Next()
+		}
+		if !req.IsBodyStream() {
+			if int64(len(req.Body())) > limit {
+				return tooLarge
+			}
+			return c.Next()
+		}
+		body, err := io.ReadAll(io.LimitReader(req.BodyStream(), limit+1))
+		if err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "failed to read request body")
+		}
+		if int64(len(body)) > limit {
+			return tooLarge
+		}
+		req.SetBody(body)
+		return c.Next()
+	}
+}
+
+// CloseStreamedBody closes the connection after a request whose body was
+// streamed (larger than the server's BodyLimit) and not read to the end by the
+// handler, e.g. one LimitBody or an auth check rejected. fasthttp would
+// otherwise parse the unread rest of the body as the next request.
+func CloseStreamedBody() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		err := c.Next()
+		if c.Request().IsBodyStream() {
+			c.Response().SetConnectionClose()
+		}
+		return err
+	}
+}
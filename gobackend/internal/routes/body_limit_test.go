@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+)
+
+func TestLimitBody(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		ErrorHandler:      apierror.Handler,
+		BodyLimit:         16,
+		StreamRequestBody: true,
+	})
+	app.Use(CloseStreamedBody())
+	app.Use(LimitBody(64, "/upload"))
+	app.Post("/*", func(c fiber.Ctx) error {
+		return c.SendString(strconv.Itoa(len(c.Body())))
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln, fiber.ListenConfig{DisableStartupMessage: true})
+	defer app.Shutdown()
+	baseURL := "http://" + ln.Addr().String()
+
+	tests := []struct {
+		name    string
+		path    string
+		size    int
+		chunked bool
+		status  int
+	}{
+		{"under the limit", "/json", 64, false, http.StatusOK},
+		{"over the limit", "/json", 65, false, http.StatusRequestEntityTooLarge},
+		{"chunked under the limit", "/json", 64, true, http.StatusOK},
+		{"chunked over the limit", "/json", 65, true, http.StatusRequestEntityTooLarge},
+		{"excepted path", "/upload", 1000, false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(strings.Repeat("a", tt.size))
+			if tt.chunked {
+				// Hide the length so the request goes out chunked
+				body = io.MultiReader(body)
+			}
+			resp, err := http.Post(baseURL+tt.path, "text/plain", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d (%s), want %d", resp.StatusCode, got, tt.status)
+			}
+			if tt.status == http.StatusOK && string(got) != strconv.Itoa(tt.size) {
+				t.Errorf("handler read %s bytes, want %d", got, tt.size)
+			}
+			// A rejected body was left unread; the connection must not be reused
+			if streamed := tt.size > 16 || tt.chunked; streamed && tt.status != http.StatusOK && !resp.Close {
+				t.Error("connection kept open after an unread body")
+			}
+		})
+	}
+}
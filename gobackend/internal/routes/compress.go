@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+)
+
+// storeObject uploads an object to MinIO, gzip-compressing it first when
+// at-rest compression applies (see shouldCompress). It returns the stored
+// content encoding ("gzip" or "") to record on the file row.
+func storeObject(ctx context.Context, client *minio.Client, cfg config.MinioConfig, key string, src io.Reader, size int64, contentType string) (string, error) {
+	if !shouldCompress(cfg, contentType, size) {
+		_, err := client.PutObject(ctx, cfg.Bucket, key, src, size, minio.PutObjectOptions{
+			ContentType: contentType,
+		})
+		return "", err
+	}
+
+	// Compress while uploading; the compressed size isn't known up front, so
+	// MinIO uploads it as a multipart stream.
+	pr, pw := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, src)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	// Content-Encoding is stored as object metadata so presigned MinIO URLs
+	// are decoded transparently by browsers too.
+	_, err := client.PutObject(ctx, cfg.Bucket, key, pr, -1, minio.PutObjectOptions{
+		ContentType:     contentType,
+		ContentEncoding: "gzip",
+	})
+	// Unblock the compressor if the upload stopped reading early
+	pr.CloseWithError(err)
+	if err != nil {
+		return "", err
+	}
+	return "gzip", nil
+}
+
+// shouldCompress reports whether an upload is stored gzip-compressed: at-rest
+// compression must be enabled, the file large enough to be worth it, and the
+// type compressible text. Images (other than SVG), video, archives and other
+// already-compressed formats are never compressed.
+func shouldCompress(cfg config.MinioConfig, contentType string, size int64) bool {
+	if !cfg.GzipStorage || size < cfg.GzipMinSize {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-ndjson", "application/yaml", "application/x-yaml",
+		"application/sql", "application/wasm":
+		return true
+	}
+	return false
+}
+
+// serveGzipObject streams a gzip-stored object. size is the compressed size
+// reported by MinIO; the decompressed length isn't known up front.
+func serveGzipObject(c fiber.Ctx, cfg config.MinioConfig, obj *minio.Object, key string, size int64) error {
+	c.Set("Accept-Ranges", "none")
+	c.Set("Vary", "Accept-Encoding")
+	c.Append("Access-Control-Expose-Headers", "Accept-Ranges", "Content-Encoding", "Content-Length")
+
+	if acceptsGzip(c.Get("Accept-Encoding")) {
+		c.Set("Content-Encoding", "gzip")
+		if size > 0 {
+			c.Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		if _, err := io.Copy(c.Response().BodyWriter(), obj); err != nil {
+			log.Printf("serveGzipObject: Copy error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+			return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to stream file from storage")
+		}
+		return nil
+	}
+
+	gz, err := gzip.NewReader(obj)
+	if err != nil {
+		log.Printf("serveGzipObject: gzip error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to decode file from storage")
+	}
+	defer gz.Close()
+	if _, err := io.Copy(c.Response().BodyWriter(), gz); err != nil {
+		log.Printf("serveGzipObject: Copy error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to stream file from storage")
+	}
+	return nil
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip (an
+// explicit "gzip;q=0" opts out).
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := strings.ReplaceAll(strings.ToLower(params), " ", "")
+		if q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// isPrecompressedType reports whether content of this type is already
+// compressed (images other than SVG, audio, video, archives, fonts, PDF), so
+// compressing it again costs CPU for little gain.
+func isPrecompressedType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	switch {
+	case mediaType == "image/svg+xml", mediaType == "image/bmp", mediaType == "image/x-ms-bmp":
+		return false
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "font/woff"):
+		return true
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip",
+		"application/x-7z-compressed", "application/x-rar-compressed", "application/vnd.rar",
+		"application/x-bzip2", "application/x-xz", "application/zstd",
+		"application/pdf", "application/epub+zip",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+	"github.com/gabriel/open_upload_gobackend/internal/jobs"
+)
+
+// maxDerivativeSize bounds a stored derivative read back into memory to be
+// served.
+const maxDerivativeSize = 32 * 1024 * 1024
+
+type generateDerivativesResponse struct {
+	Preset string `json:"preset"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Images is how many of the project's files imgproxy can render,
+	// UpToDate how many of those already have this derivative stored at the
+	// preset's current size, and Queued how many were scheduled.
+	Images   int `json:"images"`
+	UpToDate int `json:"up_to_date"`
+	Queued   int `json:"queued"`
+}
+
+// derivativeKey is the object key of a stored rendering. The variant carries
+// the preset dimensions, so resizing a preset writes a new object.
+func derivativeKey(cfg config.MinioConfig, fileID, variant, format string) string {
+	return cfg.StoragePrefix + "/derivatives/" + fileID + "/" + variant + "." + format
+}
+
+// generateProjectDerivatives schedules storing a preset rendering of every
+// image in a project (POST
+// /projects/:project_id/generate-derivatives?preset=medium&format=webp), so
+// the size routes serve it from MinIO instead of asking imgproxy. Images with
+// a derivative for the current content and preset size are skipped. Renders
+// run on the job pool, so JOB_WORKERS and IMGPROXY_MAX_CONCURRENCY bound the
+// load on imgproxy.
+func generateProjectDerivatives(c fiber.Ctx, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+
+	format := c.Query("format", "webp")
+	if !isAllowedFormat(format) {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "format must be webp, jpeg or png")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := requireProjectOwner(ctx, conn, projectID, user.UID); err != nil {
+		return err
+	}
+
+	resp := generateDerivativesResponse{Preset: c.Query("preset", "medium"), Format: format}
+	var ok bool
+	resp.Width, resp.Height, ok = resolvePreset(ctx, conn, cfg, projectID, resp.Preset)
+	if !ok {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid preset")
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT f.id, f.filename, f.mime_type, f.storage_path, COALESCE(f.content_encoding, ''), f.size,
+			EXISTS (
+				SELECT 1 FROM file_derivative d
+				WHERE d.file_id = f.id AND d.preset = ? AND d.format = ?
+					AND d.width = ? AND d.height = ? AND d.content_hash = COALESCE(f.content_hash, '')
+			)
+		FROM file f
+		WHERE f.project_id = ?
+		ORDER BY f.created_at DESC
+	`, resp.Preset, format, resp.Width, resp.Height, projectID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project files")
+	}
+	fileIDs := make([]string, 0)
+	for rows.Next() {
+		var f db.File
+		var stored bool
+		if err := rows.Scan(&f.ID, &f.Filename, &f.MimeType, &f.StoragePath, &f.ContentEncoding, &f.Size, &stored); err != nil {
+			rows.Close()
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan file")
+		}
+		if !rendersThroughImgproxy(cfg, f) {
+			continue
+		}
+		resp.Images++
+		if stored {
+			resp.UpToDate++
+			continue
+		}
+		fileIDs = append(fileIDs, f.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate project files")
+	}
+
+	for _, id := range fileIDs {
+		if err := jobs.Enqueue(ctx, jobStoreDerivative, warmPayload{FileID: id, Preset: resp.Preset, Format: format}); err != nil {
+			log.Printf("generate-derivatives: project %d: failed to enqueue file %s: %v", projectID, id, err)
+			continue
+		}
+		resp.Queued++
+	}
+
+	return c.Status(http.StatusAccepted).JSON(resp)
+}
+
+// storeDerivative renders a file's preset through imgproxy and stores the
+// result in MinIO, replacing an earlier derivative of the same preset and
+// format. Files that are served without imgproxy are skipped.
+func storeDerivative(ctx context.Context, client *minio.Client, cfg config.MinioConfig, fileID, preset, format string) error {
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &f); err != nil {
+		if err == sql.ErrNoRows {
+			return nil // deleted before the job ran
+		}
+		return err
+	}
+	if !rendersThroughImgproxy(cfg, f) {
+		return nil
+	}
+	key, err := extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
+	if err != nil {
+		return err
+	}
+
+	width, height, ok := resolvePreset(ctx, conn, cfg, f.ProjectID, preset)
+	if !ok {
+		// preset removed with TRANSFORM_PRESETS or from the project
+		return nil
+	}
+	body, contentType, err := fetchImgproxyImage(ctx, cfg, key, width, height, format, preset)
+	if err != nil {
+		return err
+	}
+	if contentType != "" && contentType != formatContentType(format) {
+		return nil
+	}
+
+	objectKey := derivativeKey(cfg, f.ID, presetCacheVariant(preset, width, height), format)
+	if _, err := client.PutObject(ctx, cfg.Bucket, objectKey, bytes.NewReader(body), int64(len(body)), minio.PutObjectOptions{
+		ContentType: formatContentType(format),
+	}); err != nil {
+		return err
+	}
+
+	var previous string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT storage_path
+		FROM file_derivative
+		WHERE file_id = ? AND preset = ? AND format = ?
+	`, f.ID, preset, format).Scan(&previous); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	storagePath := "s3://" + cfg.Bucket + "/" + objectKey
+	if _, err := db.ExecWithRetry(ctx, conn, `
+		INSERT INTO file_derivative (file_id, project_id, preset, format, width, height, content_hash, storage_path, size, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (file_id, preset, format) DO UPDATE SET
+			width = excluded.width,
+			height = excluded.height,
+			content_hash = excluded.content_hash,
+			storage_path = excluded.storage_path,
+			size = excluded.size,
+			created_at = excluded.created_at
+	`, f.ID, f.ProjectID, preset, format, width, height, f.ContentHash, storagePath, len(body), time.Now().UTC()); err != nil {
+		return err
+	}
+
+	// The old preset size was stored under another key
+	if previous != "" && previous != storagePath {
+		if oldKey, err := extractKeyFromStoragePath(previous, cfg.Bucket); err == nil {
+			if err := client.RemoveObject(ctx, cfg.Bucket, oldKey, minio.RemoveObjectOptions{}); err != nil {
+				log.Printf("derivatives: failed to delete replaced object %s: %v", oldKey, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadDerivative returns a file's stored rendering of a preset, if there is
+// one for its current content and the preset's current size.
+func loadDerivative(ctx context.Context, conn *sql.DB, client *minio.Client, cfg config.MinioConfig, f db.File, preset, format string, width, height int) ([]byte, bool) {
+	var storagePath string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT storage_path
+		FROM file_derivative
+		WHERE file_id = ? AND preset = ? AND format = ? AND width = ? AND height = ? AND content_hash = ?
+	`, f.ID, preset, format, width, height, f.ContentHash).Scan(&storagePath); err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("derivatives: failed to look up %s/%s of file %s: %v", preset, format, f.ID, err)
+		}
+		return nil, false
+	}
+	key, err := extractKeyFromStoragePath(storagePath, cfg.Bucket)
+	if err != nil {
+		return nil, false
+	}
+
+	obj, err := client.GetObject(ctx, cfg.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		log.Printf("derivatives: failed to get %s: %v", key, err)
+		return nil, false
+	}
+	defer obj.Close()
+	body, err := io.ReadAll(io.LimitReader(obj, maxDerivativeSize+1))
+	if err != nil || len(body) > maxDerivativeSize {
+		log.Printf("derivatives: failed to read %s: %v", key, err)
+		return nil, false
+	}
+	return body, true
+}
+
+// removeFileDerivatives deletes a file's stored derivatives and their rows.
+// Failures are logged.
+func removeFileDerivatives(ctx context.Context, conn *sql.DB, client *minio.Client, cfg config.MinioConfig, fileID string) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT storage_path
+		FROM file_derivative
+		WHERE file_id = ?
+	`, fileID)
+	if err != nil {
+		log.Printf("derivatives: failed to list derivatives of file %s: %v", fileID, err)
+		return
+	}
+	keys := make([]string, 0)
+	for rows.Next() {
+		var storagePath string
+		if err := rows.Scan(&storagePath); err != nil {
+			continue
+		}
+		if key, err := extractKeyFromStoragePath(storagePath, cfg.Bucket); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	rows.Close()
+	if len(keys) == 0 {
+		return
+	}
+
+	for _, key := range keys {
+		if err := client.RemoveObject(ctx, cfg.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("derivatives: failed to delete object %s: %v", key, err)
+		}
+	}
+	if _, err := db.ExecWithRetry(ctx, conn, `DELETE FROM file_derivative WHERE file_id = ?`, fileID); err != nil {
+		log.Printf("derivatives: failed to delete rows of file %s: %v", fileID, err)
+	}
+}
@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+)
+
+// apiError builds an error response with a machine-readable code; it is
+// rendered as {"detail": msg, "code": code} by apierror.Handler.
+func apiError(status int, code apierror.Code, msg string) error {
+	return apierror.New(status, code, msg)
+}
+
+// errorStatus returns the HTTP status an error will be rendered with, e.g.
+// for usage tracking of errors returned by helpers.
+func errorStatus(err error) int {
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// mapMinioError turns an error from MinIO into an API error with a status
+// that says what went wrong: 404 for missing objects, 503 when MinIO is busy
+// or unreachable, 504 on timeouts, 507 when it is out of space and 502 when
+// it rejects our credentials or the bucket is missing (a server-side
+// misconfiguration, not the client's fault). Anything else is a 500 with msg.
+func mapMinioError(err error, msg string) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchVersion":
+		return apiError(http.StatusNotFound, apierror.FileNotFound, "object not found")
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "NoSuchBucket":
+		return apiError(http.StatusBadGateway, apierror.StorageError, msg+": storage rejected the request")
+	case "SlowDown", "SlowDownRead", "SlowDownWrite", "ServiceUnavailable", "XMinioServerNotInitialized", "InternalError":
+		return apiError(http.StatusServiceUnavailable, apierror.StorageUnavailable, msg+": storage temporarily unavailable")
+	case "RequestTimeout":
+		return apiError(http.StatusGatewayTimeout, apierror.StorageUnavailable, msg+": storage timed out")
+	case "XMinioStorageFull":
+		return apiError(http.StatusInsufficientStorage, apierror.StorageError, msg+": storage is full")
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return apiError(http.StatusGatewayTimeout, apierror.StorageUnavailable, msg+": storage timed out")
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return apiError(http.StatusGatewayTimeout, apierror.StorageUnavailable, msg+": storage timed out")
+		}
+		return apiError(http.StatusServiceUnavailable, apierror.StorageUnavailable, msg+": storage unreachable")
+	}
+	return apiError(http.StatusInternalServerError, apierror.StorageError, msg)
+}
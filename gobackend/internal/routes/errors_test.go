@@ -0,0 +1,47 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+)
+
+func TestMapMinioError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   apierror.Code
+	}{
+		{"NoSuchKey", minio.ErrorResponse{Code: "NoSuchKey"}, http.StatusNotFound, apierror.FileNotFound},
+		{"NoSuchVersion", minio.ErrorResponse{Code: "NoSuchVersion"}, http.StatusNotFound, apierror.FileNotFound},
+		{"AccessDenied", minio.ErrorResponse{Code: "AccessDenied"}, http.StatusBadGateway, apierror.StorageError},
+		{"NoSuchBucket", minio.ErrorResponse{Code: "NoSuchBucket"}, http.StatusBadGateway, apierror.StorageError},
+		{"SlowDown", minio.ErrorResponse{Code: "SlowDown"}, http.StatusServiceUnavailable, apierror.StorageUnavailable},
+		{"ServiceUnavailable", minio.ErrorResponse{Code: "ServiceUnavailable"}, http.StatusServiceUnavailable, apierror.StorageUnavailable},
+		{"RequestTimeout", minio.ErrorResponse{Code: "RequestTimeout"}, http.StatusGatewayTimeout, apierror.StorageUnavailable},
+		{"XMinioStorageFull", minio.ErrorResponse{Code: "XMinioStorageFull"}, http.StatusInsufficientStorage, apierror.StorageError},
+		{"unknown code", minio.ErrorResponse{Code: "MalformedXML"}, http.StatusInternalServerError, apierror.StorageError},
+		{"wrapped deadline", fmt.Errorf("stat object: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, apierror.StorageUnavailable},
+		{"net timeout", fmt.Errorf("put object: %w", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}), http.StatusGatewayTimeout, apierror.StorageUnavailable},
+		{"net refused", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}, http.StatusServiceUnavailable, apierror.StorageUnavailable},
+		{"other error", errors.New("boom"), http.StatusInternalServerError, apierror.StorageError},
+	}
+	for _, tt := range tests {
+		var apiErr *apierror.Error
+		if !errors.As(mapMinioError(tt.err, "failed"), &apiErr) {
+			t.Errorf("%s: not an *apierror.Error", tt.name)
+			continue
+		}
+		if apiErr.Status != tt.status || apiErr.Code != tt.code {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, apiErr.Status, apiErr.Code, tt.status, tt.code)
+		}
+	}
+}
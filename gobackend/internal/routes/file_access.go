@@ -0,0 +1,225 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+const (
+	// fileAccessQueue is how many access records may wait for the writer;
+	// beyond that they are dropped rather than slowing down downloads.
+	fileAccessQueue = 1024
+
+	// maxAccessHeader bounds the stored user agent and referrer.
+	maxAccessHeader = 512
+
+	// fileAccessCleanupBatch is how many file_access rows one DELETE removes.
+	fileAccessCleanupBatch = 5000
+)
+
+// fileAccess is a row of file_access: one successful download of a file
+// through the public /files routes.
+type fileAccess struct {
+	ID        int64     `json:"id"`
+	FileID    string    `json:"file_id"`
+	Timestamp time.Time `json:"timestamp"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	// Bytes is the size of the response body, 0 when it wasn't known.
+	Bytes    int64  `json:"bytes"`
+	Referrer string `json:"referrer"`
+}
+
+var fileAccessLog struct {
+	once    sync.Once
+	entries chan fileAccess
+}
+
+// withFileAccessLog wraps a public file handler so each successful response
+// is recorded in file_access. Records are written by a background goroutine.
+func withFileAccessLog(h fiber.Handler) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		err := h(c)
+		if status := c.Response().StatusCode(); err == nil && status >= 200 && status < 300 {
+			recordFileAccess(c)
+		}
+		return err
+	}
+}
+
+// recordFileAccess queues an access record for the request's file. Values are
+// copied since Fiber reuses the request's buffers.
+func recordFileAccess(c fiber.Ctx) {
+	fileAccessLog.once.Do(func() {
+		fileAccessLog.entries = make(chan fileAccess, fileAccessQueue)
+		go writeFileAccess(fileAccessLog.entries)
+	})
+
+	e := fileAccess{
+		FileID:    strings.Clone(c.Params("file_id")),
+		Timestamp: time.Now().UTC(),
+		IP:        strings.Clone(c.IP()),
+		UserAgent: truncateHeader(c.Get(fiber.HeaderUserAgent)),
+		Bytes:     responseBytes(c),
+		Referrer:  truncateHeader(c.Get(fiber.HeaderReferer)),
+	}
+	select {
+	case fileAccessLog.entries <- e:
+	default:
+		log.Printf("file access: queue full, dropping record for file_id=%s", e.FileID)
+	}
+}
+
+// responseBytes is the size of the response body: the length given for a
+// streamed body, which Content-Length is only set from on writing otherwise.
+func responseBytes(c fiber.Ctx) int64 {
+	resp := c.Response()
+	if resp.IsBodyStream() {
+		return max(int64(resp.Header.ContentLength()), 0)
+	}
+	return int64(len(resp.Body()))
+}
+
+func truncateHeader(v string) string {
+	if len(v) > maxAccessHeader {
+		v = v[:maxAccessHeader]
+	}
+	return strings.Clone(v)
+}
+
+// writeFileAccess inserts queued access records one at a time.
+func writeFileAccess(entries <-chan fileAccess) {
+	for e := range entries {
+		conn, err := db.GetDB()
+		if err != nil {
+			log.Printf("file access: db error: %v", err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if _, err := db.ExecWithRetry(ctx, conn, `
+			INSERT INTO file_access (file_id, timestamp, ip, user_agent, bytes, referrer)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, e.FileID, e.Timestamp, e.IP, e.UserAgent, e.Bytes, e.Referrer); err != nil {
+			log.Printf("file access: insert error: %v, file_id=%s", err, e.FileID)
+		}
+		cancel()
+	}
+}
+
+// getFileAccessLog handles GET /frontend/files/:file_id/access-log: the
+// downloads of one of the user's files through the public /files routes,
+// newest first, as {items, total, limit, offset}.
+func getFileAccessLog(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	fileID := c.Params("file_id")
+	if fileID == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
+	}
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `SELECT user_firebase_uid FROM file WHERE id = ?`, fileID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this file")
+	}
+
+	resp := pageResponse[fileAccess]{Items: make([]fileAccess, 0), Limit: limit, Offset: offset}
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM file_access WHERE file_id = ?`, fileID).Scan(&resp.Total); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to count accesses")
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT id, file_id, timestamp, ip, user_agent, bytes, referrer
+		FROM file_access
+		WHERE file_id = ?
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, fileID, limit, offset)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query accesses")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a fileAccess
+		if err := rows.Scan(&a.ID, &a.FileID, &a.Timestamp, &a.IP, &a.UserAgent, &a.Bytes, &a.Referrer); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan access")
+		}
+		resp.Items = append(resp.Items, a)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate accesses")
+	}
+
+	return c.JSON(resp)
+}
+
+// cleanupFileAccess deletes file_access rows older than retentionDays, in
+// batches, along with the rows of files that were deleted (trashed files keep
+// theirs until they are purged).
+func cleanupFileAccess(ctx context.Context, retentionDays int) error {
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -retentionDays)
+	var total int64
+	for {
+		res, err := db.ExecWithRetry(ctx, conn, `
+			DELETE FROM file_access
+			WHERE id IN (
+				SELECT id FROM file_access
+				WHERE timestamp < ?
+				   OR (NOT EXISTS (SELECT 1 FROM file WHERE file.id = file_access.file_id)
+				       AND NOT EXISTS (SELECT 1 FROM file_trash WHERE file_trash.id = file_access.file_id))
+				LIMIT ?
+			)
+		`, cutoff, fileAccessCleanupBatch)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		total += n
+		if n < fileAccessCleanupBatch || ctx.Err() != nil {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("file access: deleted %d rows", total)
+	}
+	return nil
+}
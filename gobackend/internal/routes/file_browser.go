@@ -0,0 +1,175 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+const maxFilterProjects = 100
+
+// parseSort reads the sort and order query parameters. Without sort, the
+// listing's configured default applies; with sort but no order, desc. The
+// returned key is taken from keys rather than the query, so it is safe to
+// use as an ORDER BY column.
+func parseSort(c fiber.Ctx, keys []string, def config.SortOrder) (string, string, error) {
+	key, order := def.Key, def.Order
+	if v := c.Query("sort"); v != "" {
+		i := slices.Index(keys, v)
+		if i < 0 {
+			return "", "", apiError(http.StatusBadRequest, apierror.InvalidRequest, "sort must be one of "+strings.Join(keys, ", "))
+		}
+		key, order = keys[i], "desc"
+	}
+	if v := c.Query("order"); v != "" {
+		order = strings.ToLower(v)
+		if order != "asc" && order != "desc" {
+			return "", "", apiError(http.StatusBadRequest, apierror.InvalidRequest, "order must be asc or desc")
+		}
+	}
+	return key, order, nil
+}
+
+// listUserFiles handles GET /frontend/files: the user's files across several
+// projects (?project_ids=1,2,3, default all of them), filtered by filename
+// (q) and MIME type prefix (mime_type), sorted and paginated.
+func listUserFiles(c fiber.Ctx, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectIDs, err := parseProjectIDs(c.Query("project_ids"))
+	if err != nil {
+		return err
+	}
+
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	sortColumn, order, err := parseSort(c, config.FileSortKeys, cfg.FileSort)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Every requested project must exist and belong to the user
+	for _, projectID := range projectIDs {
+		var ownerUID string
+		if err := conn.QueryRowContext(ctx, `
+			SELECT user_firebase_uid
+			FROM project
+			WHERE id = ?
+		`, projectID).Scan(&ownerUID); err != nil {
+			if err == sql.ErrNoRows {
+				return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project "+strconv.FormatInt(projectID, 10)+" not found")
+			}
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+		}
+		if ownerUID != user.UID {
+			return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access project "+strconv.FormatInt(projectID, 10))
+		}
+	}
+
+	where := []string{"user_firebase_uid = ?"}
+	args := []any{user.UID}
+	if len(projectIDs) > 0 {
+		where = append(where, "project_id IN (?"+strings.Repeat(", ?", len(projectIDs)-1)+")")
+		for _, id := range projectIDs {
+			args = append(args, id)
+		}
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		where = append(where, `filename LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(q)+"%")
+	}
+	if mimeType := strings.TrimSpace(c.Query("mime_type")); mimeType != "" {
+		where = append(where, `mime_type LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(strings.ToLower(mimeType))+"%")
+	}
+	whereClause := strings.Join(where, " AND ")
+
+	page := pageResponse[db.File]{Items: make([]db.File, 0), Limit: limit, Offset: offset}
+	if err := conn.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM file
+		WHERE `+whereClause, args...).Scan(&page.Total); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to count files")
+	}
+
+	// id breaks ties so pages are stable
+	rows, err := conn.QueryContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE `+whereClause+`
+		ORDER BY `+sortColumn+` `+order+`, id `+order+`
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to list files")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f db.File
+		if err := db.ScanFile(rows, &f); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to list files")
+		}
+		page.Items = append(page.Items, f)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to list files")
+	}
+
+	return c.JSON(page)
+}
+
+// parseProjectIDs parses a comma-separated list of project ids, dropping
+// duplicates. An empty value yields no ids.
+func parseProjectIDs(value string) ([]int64, error) {
+	ids := make([]int64, 0)
+	if strings.TrimSpace(value) == "" {
+		return ids, nil
+	}
+	seen := make(map[int64]bool)
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id: "+strconv.Quote(strings.TrimSpace(part)))
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxFilterProjects {
+		return nil, apiError(http.StatusBadRequest, apierror.InvalidRequest, "too many project_ids (max "+strconv.Itoa(maxFilterProjects)+")")
+	}
+	return ids, nil
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally (used
+// with ESCAPE '\').
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,197 @@
+package routes
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+	"github.com/gabriel/open_upload_gobackend/internal/thumbcache"
+)
+
+const (
+	defaultTextPreviewBytes = 4096
+	maxTextPreviewBytes     = 64 * 1024
+)
+
+// textPreviewTypes are the non-text/* types of source and data files that
+// can be shown as text.
+var textPreviewTypes = []string{
+	"application/json",
+	"application/ld+json",
+	"application/xml",
+	"application/javascript",
+	"application/x-javascript",
+	"application/ecmascript",
+	"application/typescript",
+	"application/x-typescript",
+	"application/yaml",
+	"application/x-yaml",
+	"application/toml",
+	"application/sql",
+	"application/graphql",
+	"application/x-sh",
+	"application/x-shellscript",
+	"application/x-python",
+	"application/x-httpd-php",
+	"application/x-perl",
+	"application/x-ruby",
+	"application/x-tex",
+	"application/x-ndjson",
+}
+
+func isTextPreviewType(contentType string) bool {
+	t := mediaType(contentType)
+	return strings.HasPrefix(t, "text/") || slices.Contains(textPreviewTypes, t) ||
+		strings.HasSuffix(t, "+json") || strings.HasSuffix(t, "+xml")
+}
+
+// serveFilePreview handles GET /files/:file_id/preview. Images get the
+// preview preset from imgproxy; text and code files get their first bytes
+// (?bytes=, default 4096, at most 64 KiB) as text/plain, fetched with a
+// range GET so large files aren't read. A preview cut short of the file
+// ends on a whole UTF-8 character and has X-Preview-Truncated: true. Other
+// files get 400.
+func serveFilePreview(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig, cache *thumbcache.Cache) error {
+	c.Set("Access-Control-Allow-Origin", "*")
+	c.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Set("Access-Control-Allow-Headers", "*")
+
+	if client == nil {
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "storage service unavailable")
+	}
+	fileID := c.Params("file_id")
+	if fileID == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
+	}
+
+	limit := int64(defaultTextPreviewBytes)
+	if v := c.Query("bytes"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "bytes must be a positive integer")
+		}
+		limit = min(n, maxTextPreviewBytes)
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	dbCtx, dbCancel := context.WithTimeout(c.Context(), 5*time.Second)
+	defer dbCancel()
+
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(dbCtx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &f); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
+	}
+
+	contentType := normalizeContentType(cfg, f.Filename, f.MimeType)
+	if strings.HasPrefix(contentType, "image/") {
+		return serveImageSize(c, cfg, client, cache, fileID, "preview")
+	}
+	if !isTextPreviewType(contentType) {
+		return apiError(http.StatusBadRequest, apierror.NotText, "Text previews are only available for text files")
+	}
+
+	limit = min(limit, f.Size)
+	body := make([]byte, 0)
+	if limit > 0 {
+		src, closeSrc, err := openTextPreview(client, cfg, f, limit)
+		if err != nil {
+			return err
+		}
+		body, err = io.ReadAll(io.LimitReader(src, limit))
+		closeSrc()
+		if err != nil {
+			log.Printf("text preview: read error: %v, file_id=%s", err, f.ID)
+			return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to read file from storage")
+		}
+	}
+
+	truncated := int64(len(body)) < f.Size
+	if truncated && len(body) > 0 {
+		// Don't end on part of a multi-byte character
+		start := len(body) - 1
+		for start > 0 && len(body)-start < utf8.UTFMax && !utf8.RuneStart(body[start]) {
+			start--
+		}
+		if !utf8.FullRune(body[start:]) {
+			body = body[:start]
+		}
+	}
+
+	c.Set("Content-Type", "text/plain; charset=utf-8")
+	c.Set("X-Content-Type-Options", "nosniff")
+	c.Set("Cache-Control", "public, max-age=3600")
+	c.Set("X-Preview-Truncated", strconv.FormatBool(truncated))
+	c.Set("Access-Control-Expose-Headers", "X-Preview-Truncated")
+	return c.Send(body)
+}
+
+// openTextPreview opens a file for reading its first limit bytes: a range
+// GET for plain objects, a decompressing stream (stopped after limit bytes
+// by the caller) for gzip-stored ones, or the legacy local file.
+func openTextPreview(client *minio.Client, cfg config.MinioConfig, f db.File, limit int64) (io.Reader, func(), error) {
+	if !strings.HasPrefix(f.StoragePath, "s3://") {
+		local, err := os.Open(f.StoragePath)
+		if err != nil {
+			return nil, nil, apiError(http.StatusNotFound, apierror.FileNotFound, "File not found on storage")
+		}
+		return local, func() { local.Close() }, nil
+	}
+	key, err := extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
+	if err != nil {
+		return nil, nil, apiError(http.StatusInternalServerError, apierror.StorageError, "invalid storage path")
+	}
+
+	opts := minio.GetObjectOptions{}
+	if f.ContentEncoding == "" {
+		if err := opts.SetRange(0, limit-1); err != nil {
+			return nil, nil, apiError(http.StatusInternalServerError, apierror.InternalError, "invalid range")
+		}
+	}
+	obj, err := client.GetObject(context.Background(), cfg.Bucket, key, opts)
+	if err != nil {
+		log.Printf("text preview: GetObject error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+		return nil, nil, mapMinioError(err, "failed to fetch file from storage")
+	}
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		log.Printf("text preview: Stat error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+		return nil, nil, mapMinioError(err, "failed to fetch file from storage")
+	}
+	if f.ContentEncoding != "gzip" {
+		return obj, func() { obj.Close() }, nil
+	}
+
+	gz, err := gzip.NewReader(obj)
+	if err != nil {
+		obj.Close()
+		log.Printf("text preview: gzip error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+		return nil, nil, apiError(http.StatusInternalServerError, apierror.StorageError, "failed to decode stored file")
+	}
+	return gz, func() { gz.Close(); obj.Close() }, nil
+}
@@ -0,0 +1,116 @@
+package routes
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// serveRawFile handles GET /files/:file_id/raw: the exact bytes that were
+// uploaded, always as an application/octet-stream attachment and never
+// transformed, with X-Content-SHA256 set to the stored hash for verification.
+// Files stored gzip-compressed are decompressed back to the original bytes.
+func serveRawFile(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig) error {
+	c.Set("Access-Control-Allow-Origin", "*")
+	c.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Set("Access-Control-Allow-Headers", "*")
+
+	if client == nil {
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "storage service unavailable")
+	}
+	fileID := c.Params("file_id")
+	if fileID == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	dbCtx, dbCancel := context.WithTimeout(c.Context(), 5*time.Second)
+	defer dbCancel()
+
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(dbCtx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &f); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
+	}
+
+	var key string
+	if strings.HasPrefix(f.StoragePath, "s3://") {
+		key, err = extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.StorageError, "invalid storage path")
+		}
+	}
+
+	c.Set("Content-Type", "application/octet-stream")
+	c.Set("Content-Disposition", `attachment; filename="`+downloadFilename(cfg, f, key)+`"`)
+	// no-transform keeps proxies and CDNs from recompressing or converting
+	c.Set("Cache-Control", "public, max-age=3600, no-transform")
+	c.Set("X-Content-Type-Options", "nosniff")
+	c.Set("Accept-Ranges", "none")
+	c.Set("Access-Control-Expose-Headers", "Content-Length, X-Content-SHA256")
+	if f.ContentHash != "" {
+		c.Set("X-Content-SHA256", f.ContentHash)
+	}
+
+	if key == "" {
+		// Legacy local path
+		if _, err := os.Stat(f.StoragePath); err != nil {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found on storage")
+		}
+		return c.SendFile(f.StoragePath)
+	}
+
+	obj, err := client.GetObject(context.Background(), cfg.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		log.Printf("raw file: GetObject error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+		return mapMinioError(err, "failed to fetch file from storage")
+	}
+	defer obj.Close()
+	if _, err := obj.Stat(); err != nil {
+		log.Printf("raw file: Stat error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+		return mapMinioError(err, "failed to fetch file from storage")
+	}
+
+	var src io.Reader = obj
+	if f.ContentEncoding == "gzip" {
+		gz, err := gzip.NewReader(obj)
+		if err != nil {
+			log.Printf("raw file: gzip error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+			return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to decode stored file")
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	// The recorded size is always that of the original bytes
+	c.Set("Content-Length", strconv.FormatInt(f.Size, 10))
+	if _, err := io.Copy(c.Response().BodyWriter(), src); err != nil {
+		log.Printf("raw file: Copy error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to stream file from storage")
+	}
+	return nil
+}
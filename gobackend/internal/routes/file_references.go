@@ -0,0 +1,121 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// fileReferencesResponse lists the caller's other files sharing a file's
+// stored blob. Trashed files keep the blob until they are purged, so they are
+// listed separately.
+type fileReferencesResponse struct {
+	FileID      string           `json:"file_id"`
+	ContentHash string           `json:"content_hash"`
+	StoragePath string           `json:"storage_path"`
+	References  []db.File        `json:"references"`
+	Trashed     []db.TrashedFile `json:"trashed_references"`
+}
+
+// getFileReferences handles GET /frontend/files/:file_id/references: which of
+// the user's other files share this file's blob, through deduplication by
+// content hash (which reuses the blob's storage path) or the same object key.
+// Deleting any one of them frees no storage while the others remain. Other
+// users' files are never listed.
+func getFileReferences(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	fileID := c.Params("file_id")
+	if fileID == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &f); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
+	}
+	if f.UserFirebaseUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this file")
+	}
+
+	resp := fileReferencesResponse{
+		FileID:      f.ID,
+		ContentHash: f.ContentHash,
+		StoragePath: f.StoragePath,
+		References:  make([]db.File, 0),
+		Trashed:     make([]db.TrashedFile, 0),
+	}
+
+	// Same reference rule as removeUnreferencedBlob
+	rows, err := conn.QueryContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE user_firebase_uid = ?
+		  AND id != ?
+		  AND storage_path = ?
+		ORDER BY created_at DESC
+	`, user.UID, f.ID, f.StoragePath)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query references")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ref db.File
+		if err := db.ScanFile(rows, &ref); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan file")
+		}
+		resp.References = append(resp.References, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate references")
+	}
+
+	trashRows, err := conn.QueryContext(ctx, `
+		SELECT `+db.FileColumns+`, deleted_at, deleted_by
+		FROM file_trash
+		WHERE user_firebase_uid = ?
+		  AND storage_path = ?
+		ORDER BY deleted_at DESC
+	`, user.UID, f.StoragePath)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query trashed references")
+	}
+	defer trashRows.Close()
+	for trashRows.Next() {
+		var t db.TrashedFile
+		if err := db.ScanTrashedFile(trashRows, &t); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan trashed file")
+		}
+		resp.Trashed = append(resp.Trashed, t)
+	}
+	if err := trashRows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate trashed references")
+	}
+
+	return c.JSON(resp)
+}
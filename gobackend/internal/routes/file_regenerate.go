@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+	"github.com/gabriel/open_upload_gobackend/internal/jobs"
+	"github.com/gabriel/open_upload_gobackend/internal/thumbcache"
+)
+
+type regenerateResponse struct {
+	FileID string `json:"file_id"`
+	Preset string `json:"preset"`
+	Format string `json:"format"`
+}
+
+// regenerateThumbnail handles POST /frontend/files/:file_id/regenerate-thumbnail
+// (?preset=thumbnail&format=webp): it drops the file's cached renderings and
+// schedules the preset to be rendered again, e.g. after a failed render at
+// upload or a preset change. Only files served through imgproxy qualify.
+func regenerateThumbnail(c fiber.Ctx, cfg config.MinioConfig, cache *thumbcache.Cache) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	fileID := c.Params("file_id")
+	if fileID == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
+	}
+	format := c.Query("format", "webp")
+	if !isAllowedFormat(format) {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "format must be webp, jpeg or png")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &f); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
+	}
+	if f.UserFirebaseUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this file")
+	}
+
+	if !rendersThroughImgproxy(cfg, f) {
+		return apiError(http.StatusBadRequest, apierror.NotAnImage, "file has no generated thumbnails")
+	}
+
+	resp := regenerateResponse{FileID: f.ID, Preset: c.Query("preset", "thumbnail"), Format: format}
+	if _, _, ok := resolvePreset(ctx, conn, cfg, f.ProjectID, resp.Preset); !ok {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid preset")
+	}
+
+	// Every cached size goes, so other presets are re-rendered on next view
+	cache.DeleteFile(f.ID)
+	if err := jobs.Enqueue(ctx, jobWarmPreset, warmPayload{FileID: f.ID, Preset: resp.Preset, Format: format}); err != nil {
+		log.Printf("regenerate-thumbnail: failed to enqueue file %s: %v", f.ID, err)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to schedule thumbnail generation")
+	}
+
+	return c.Status(http.StatusAccepted).JSON(resp)
+}
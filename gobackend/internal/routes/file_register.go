@@ -0,0 +1,248 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// maxRegisterBatchKeys bounds how many keys one register-batch call may list.
+const maxRegisterBatchKeys = 500
+
+type registerBatchRequest struct {
+	ProjectID int64    `json:"project_id"`
+	Keys      []string `json:"keys"`
+}
+
+// registerResult is the outcome for one key: "registered" with the new
+// file_id, "exists" when a file row already points at the object, or
+// "skipped" with a reason.
+type registerResult struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	FileID string `json:"file_id,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type registerBatchResponse struct {
+	Registered int              `json:"registered"`
+	Results    []registerResult `json:"results"`
+}
+
+// registerFiles creates file rows for objects that were copied into the
+// bucket outside the API (POST /frontend/files/register-batch), e.g. with
+// mc cp. Keys must lie under the project's prefix (<STORAGE_PREFIX>/<project_id>/).
+// Each object is read once to compute its content hash and image
+// dimensions. The type, content, size, storage and file limits apply as for
+// complete-upload.
+func registerFiles(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	var req registerBatchRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid JSON body")
+	}
+	if req.ProjectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project_id")
+	}
+	if len(req.Keys) == 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "keys is required")
+	}
+	if len(req.Keys) > maxRegisterBatchKeys {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "at most "+strconv.Itoa(maxRegisterBatchKeys)+" keys per request")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, req.ProjectID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
+	}
+
+	totalStorage, err := UserStorageUsage(ctx, conn, user.UID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to compute storage usage")
+	}
+	quota, err := userStorageLimit(ctx, conn, user.UID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to load storage limit")
+	}
+
+	prefix := projectListPrefix(cfg.StoragePrefix, req.ProjectID)
+	resp := registerBatchResponse{Results: make([]registerResult, 0, len(req.Keys))}
+	seen := make(map[string]bool)
+	for _, key := range req.Keys {
+		key = strings.TrimPrefix(key, "/")
+		if seen[key] {
+			resp.Results = append(resp.Results, registerResult{Key: key, Status: "skipped", Reason: "duplicate key in request"})
+			continue
+		}
+		seen[key] = true
+
+		res := registerObject(ctx, conn, client, cfg, user.UID, req.ProjectID, prefix, key, &totalStorage, quota)
+		if res.Status == "registered" {
+			resp.Registered++
+		}
+		resp.Results = append(resp.Results, res)
+	}
+
+	if resp.Registered > 0 {
+		audit.Record(ctx, user.UID, audit.ActionImport, audit.TargetProject, strconv.FormatInt(req.ProjectID, 10), req.ProjectID,
+			fmt.Sprintf("%d objects registered, %d not", resp.Registered, len(resp.Results)-resp.Registered))
+	}
+	return c.JSON(resp)
+}
+
+// registerObject creates the file row for one existing object, adding its
+// size to totalStorage on success. quota is the user's storage limit.
+func registerObject(ctx context.Context, conn *sql.DB, client *minio.Client, cfg config.MinioConfig, uid string, projectID int64, prefix, key string, totalStorage *int64, quota int64) registerResult {
+	res := registerResult{Key: key, Status: "skipped"}
+	if path.Clean(key) != key || !strings.HasPrefix(key, prefix) || len(key) == len(prefix) {
+		res.Reason = "key must be under " + prefix
+		return res
+	}
+	if len(key) > cfg.MaxObjectKeyLength {
+		res.Reason = "key longer than " + strconv.Itoa(cfg.MaxObjectKeyLength) + " bytes"
+		return res
+	}
+
+	storagePath := "s3://" + cfg.Bucket + "/" + key
+	var existingID string
+	err := conn.QueryRowContext(ctx, `
+		SELECT id
+		FROM file
+		WHERE storage_path = ?
+		LIMIT 1
+	`, storagePath).Scan(&existingID)
+	if err == nil {
+		res.Status = "exists"
+		res.FileID = existingID
+		return res
+	}
+	if err != sql.ErrNoRows {
+		res.Reason = "failed to check existing files"
+		return res
+	}
+
+	info, err := client.StatObject(ctx, cfg.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			res.Reason = "object not found"
+		} else {
+			log.Printf("register: stat error for %s: %v", key, err)
+			res.Reason = "failed to stat object"
+		}
+		return res
+	}
+	// The same rules as complete-upload, but a skipped object is left alone:
+	// it was put in the bucket outside the API
+	if err := checkUploadSize(cfg, info.Size); err != nil {
+		res.Reason = err.Error()
+		return res
+	}
+	filename := path.Base(key)
+	contentType := normalizeContentType(cfg, filename, info.ContentType)
+	if err := checkPresignedType(cfg, filename, contentType); err != nil {
+		res.Reason = err.Error()
+		return res
+	}
+	if *totalStorage+info.Size > quota {
+		res.Reason = "storage limit reached"
+		return res
+	}
+	if err := checkProjectFileLimit(ctx, conn, cfg, projectID, 1); err != nil {
+		res.Reason = "project file limit reached"
+		return res
+	}
+
+	obj, err := client.GetObject(ctx, cfg.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		log.Printf("register: GetObject error for %s: %v", key, err)
+		res.Reason = "failed to read object"
+		return res
+	}
+	defer obj.Close()
+	head, err := readUploadHead(obj)
+	if err != nil {
+		log.Printf("register: read error for %s: %v", key, err)
+		res.Reason = "failed to read object"
+		return res
+	}
+	if err := checkUploadContent(cfg, contentType, head); err != nil {
+		res.Reason = err.Error()
+		return res
+	}
+
+	// Everything read for the dimensions passes through the hash too
+	hash := sha256.New()
+	body := io.MultiReader(bytes.NewReader(head), obj)
+	width, height := readDimensions(io.TeeReader(body, hash), contentType)
+	if _, err := io.Copy(hash, body); err != nil {
+		log.Printf("register: read error for %s: %v", key, err)
+		res.Reason = "failed to read object"
+		return res
+	}
+	contentHash := hex.EncodeToString(hash.Sum(nil))
+
+	lockedUntil, err := lockUntilForUpload(ctx, conn, client, cfg, projectID, key)
+	if err != nil {
+		res.Reason = "failed to load project retention"
+		return res
+	}
+
+	now := time.Now().UTC()
+	id := uuid.NewString()
+	if _, err := db.ExecWithRetry(ctx, conn, `
+		INSERT INTO file (id, filename, size, mime_type, created_at, updated_at, project_id, user_firebase_uid, storage_path, content_hash, content_encoding, locked_until, width, height)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, '', ?, ?, ?)
+	`, id, filename, info.Size, contentType, now, now, projectID, uid, storagePath, contentHash, lockedUntil, width, height); err != nil {
+		log.Printf("register: db insert file error for %s: %v", key, err)
+		res.Reason = "failed to save file record"
+		return res
+	}
+	enqueueUploadJobs(ctx, id, contentType, info.Size)
+	*totalStorage += info.Size
+
+	res.Status = "registered"
+	res.FileID = id
+	return res
+}
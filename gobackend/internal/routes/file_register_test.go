@@ -0,0 +1,70 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+func TestRegisterObjectChecks(t *testing.T) {
+	projectID := createTestProject(t, "register-user")
+	conn, err := db.GetDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.MinioConfig{
+		Bucket:             "uploads",
+		StoragePrefix:      "p",
+		MaxObjectKeyLength: 1024,
+		MaxUploadBytes:     1024,
+		SanitizeSVG:        true,
+		BlockedExtensions:  []string{".exe"},
+		AllowedMimeTypes:   []string{"image/*", "text/*"},
+	}
+	prefix := projectListPrefix(cfg.StoragePrefix, projectID)
+	client := newFakeMinio(t, map[string][]byte{
+		"uploads/" + prefix + "image.png": img.Bytes(),
+		"uploads/" + prefix + "elf.txt":   []byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x3e\x00"),
+		"uploads/" + prefix + "big.txt":   bytes.Repeat([]byte("a"), 2048),
+		"uploads/" + prefix + "logo.svg":  []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`),
+		"uploads/" + prefix + "tool.exe":  []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff\x00\x00"),
+		"uploads/" + prefix + "notes.txt": []byte("plain notes\n"),
+	})
+
+	tests := []struct {
+		name       string
+		registered bool
+	}{
+		{"image.png", true},
+		{"notes.txt", true},
+		{"elf.txt", false},
+		{"big.txt", false},
+		{"logo.svg", false},
+		{"tool.exe", false},
+	}
+	totalStorage := int64(0)
+	for _, tt := range tests {
+		res := registerObject(context.Background(), conn, client, cfg, "register-user", projectID, prefix, prefix+tt.name, &totalStorage, 1<<30)
+		if got := res.Status == "registered"; got != tt.registered {
+			t.Errorf("%s: status %q (%s), want registered = %v", tt.name, res.Status, res.Reason, tt.registered)
+		}
+	}
+
+	var width, height int
+	if err := conn.QueryRow(`SELECT width, height FROM file WHERE storage_path = ?`, "s3://uploads/"+prefix+"image.png").Scan(&width, &height); err != nil {
+		t.Fatal(err)
+	}
+	if width != 3 || height != 2 {
+		t.Errorf("registered image is %dx%d, want 3x2", width, height)
+	}
+}
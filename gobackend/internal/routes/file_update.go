@@ -0,0 +1,198 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+const (
+	maxFilenameLength     = 255
+	maxCacheControlLength = 256
+)
+
+// fileUpdatePayload is the PATCH body; omitted fields are left unchanged and
+// an empty cache_control / content_type_override restores the default.
+type fileUpdatePayload struct {
+	Filename            *string `json:"filename"`
+	CacheControl        *string `json:"cache_control"`
+	ContentTypeOverride *string `json:"content_type_override"`
+	// LockedUntil is an RFC 3339 time; locks can be extended but only
+	// shortened or cleared ("") by developers while active.
+	LockedUntil *string `json:"locked_until"`
+}
+
+// updateFile handles PATCH /frontend/files/:file_id: rename a file and set
+// its per-file Cache-Control and Content-Type used when it is served, or
+// change its deletion lock.
+func updateFile(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	fileID := c.Params("file_id")
+	if fileID == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
+	}
+
+	var payload fileUpdatePayload
+	if err := c.Bind().Body(&payload); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid request body")
+	}
+	if payload.Filename == nil && payload.CacheControl == nil && payload.ContentTypeOverride == nil && payload.LockedUntil == nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "nothing to update")
+	}
+
+	sets := make([]string, 0)
+	args := make([]any, 0)
+	// changed lists the updated fields for the audit log
+	changed := make([]string, 0)
+	if payload.Filename != nil {
+		name := strings.TrimSpace(*payload.Filename)
+		if err := validateFilename(name); err != nil {
+			return err
+		}
+		sets = append(sets, "filename = ?")
+		changed = append(changed, "filename")
+		args = append(args, name)
+	}
+	if payload.CacheControl != nil {
+		value := strings.TrimSpace(*payload.CacheControl)
+		if len(value) > maxCacheControlLength || !isHeaderSafe(value) {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid cache_control")
+		}
+		sets = append(sets, "cache_control = ?")
+		changed = append(changed, "cache_control")
+		args = append(args, nullIfEmpty(value))
+	}
+	if payload.ContentTypeOverride != nil {
+		value := strings.TrimSpace(*payload.ContentTypeOverride)
+		if value != "" {
+			mediaType, params, err := mime.ParseMediaType(value)
+			if err != nil || !strings.Contains(mediaType, "/") {
+				return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid content_type_override")
+			}
+			value = mime.FormatMediaType(mediaType, params)
+		}
+		sets = append(sets, "content_type_override = ?")
+		changed = append(changed, "content_type_override")
+		args = append(args, nullIfEmpty(value))
+	}
+
+	var lockedUntil *time.Time
+	if payload.LockedUntil != nil && strings.TrimSpace(*payload.LockedUntil) != "" {
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(*payload.LockedUntil))
+		if err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "locked_until must be an RFC 3339 time")
+		}
+		t = t.UTC()
+		lockedUntil = &t
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var current db.File
+	if err := db.ScanFile(conn.QueryRowContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &current); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
+	}
+	if current.UserFirebaseUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to update this file")
+	}
+
+	if payload.LockedUntil != nil {
+		// Shortening or clearing an active lock is the same as unlocking it
+		if lockedUntil == nil || (current.LockedUntil != nil && lockedUntil.Before(*current.LockedUntil)) {
+			if err := checkFileUnlocked(current, user); err != nil {
+				return err
+			}
+		}
+		sets = append(sets, "locked_until = ?")
+		changed = append(changed, "locked_until")
+		if lockedUntil != nil {
+			args = append(args, *lockedUntil)
+		} else {
+			args = append(args, nil)
+		}
+	}
+
+	sets = append(sets, "updated_at = ?")
+	args = append(args, time.Now().UTC(), fileID)
+	if _, err := db.ExecWithRetry(ctx, conn, `
+		UPDATE file
+		SET `+strings.Join(sets, ", ")+`
+		WHERE id = ?
+	`, args...); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to update file")
+	}
+
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &f); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load updated file")
+	}
+	audit.Record(ctx, user.UID, audit.ActionUpdate, audit.TargetFile, f.ID, f.ProjectID, strings.Join(changed, ","))
+
+	return c.JSON(f)
+}
+
+// validateFilename checks a display filename: it ends up in the
+// Content-Disposition header, so quotes, path separators and control
+// characters are rejected.
+func validateFilename(name string) error {
+	if name == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "filename cannot be empty")
+	}
+	if len(name) > maxFilenameLength {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "filename is too long")
+	}
+	if strings.ContainsAny(name, `/\"`) || !isHeaderSafe(name) {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "filename contains invalid characters")
+	}
+	return nil
+}
+
+// isHeaderSafe reports whether s has no control characters (CR/LF in
+// particular) and can be used in a response header value.
+func isHeaderSafe(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// nullIfEmpty stores empty strings as NULL.
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
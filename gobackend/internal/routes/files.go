@@ -1,39 +1,50 @@
 package routes
 
 import (
+	"bytes"
 	"context"
-	"crypto/hmac"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v3"
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
 	"github.com/gabriel/open_upload_gobackend/internal/auth"
 	"github.com/gabriel/open_upload_gobackend/internal/config"
 	"github.com/gabriel/open_upload_gobackend/internal/db"
+	"github.com/gabriel/open_upload_gobackend/internal/thumbcache"
 )
 
 type uploadResponse struct {
-	ID          string `json:"id"`
-	Key         string `json:"key"`
-	Bucket      string `json:"bucket"`
-	Size        int64  `json:"size"`
-	ContentType string `json:"content_type"`
-	URL         string `json:"url"`
-	ImgproxyURL string `json:"imgproxy_url"`
+	ID           string `json:"id"`
+	Key          string `json:"key"`
+	Bucket       string `json:"bucket"`
+	Size         int64  `json:"size"`
+	ContentType  string `json:"content_type"`
+	URL          string `json:"url"`
+	ImgproxyURL  string `json:"imgproxy_url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	// PublicURL is the object's direct URL for public=true uploads.
+	PublicURL string `json:"public_url,omitempty"`
 }
 
 type fileInfo struct {
@@ -42,6 +53,25 @@ type fileInfo struct {
 	ETag         string    `json:"etag"`
 	LastModified time.Time `json:"last_modified"`
 	ImgproxyURL  string    `json:"imgproxy_url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	// PresignedURL is a direct download link, set with ?presign=true.
+	PresignedURL string `json:"presigned_url,omitempty"`
+}
+
+const (
+	// listPresignExpiry is the lifetime of the download links /list signs.
+	listPresignExpiry = 15 * time.Minute
+
+	// maxListPresigns caps how many objects one /list request signs.
+	maxListPresigns = 500
+)
+
+// listResponse is the /list envelope: the objects plus totals aggregated
+// during the same listing pass, scoped to the requested prefix.
+type listResponse struct {
+	Files       []fileInfo `json:"files"`
+	TotalSize   int64      `json:"total_size"`
+	ObjectCount int64      `json:"object_count"`
 }
 
 // RegisterFileRoutes registers file-related routes on the given router.
@@ -55,14 +85,15 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 		}
 		start := time.Now()
 
-		key := c.Query("key")
-		if key == "" {
+		if err := checkTransformQuery(c); err != nil {
 			trackAPIUsage(context.Background(), "/api/v1/files/transform-url", http.StatusBadRequest, start, apiCtx)
-			return fiber.NewError(fiber.StatusBadRequest, "key is required")
+			return err
 		}
-		if len(key) > 2048 {
+
+		key := c.Query("key")
+		if key == "" {
 			trackAPIUsage(context.Background(), "/api/v1/files/transform-url", http.StatusBadRequest, start, apiCtx)
-			return fiber.NewError(fiber.StatusBadRequest, "key is too long")
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "key is required")
 		}
 
 		mode := c.Query("mode", "fit")
@@ -78,31 +109,44 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 		// - full: large but bounded
 		preset := c.Query("preset", "")
 
+		// A preset fixes both dimensions, so w/h alongside it are ambiguous
+		if preset != "" && (c.Query("w") != "" || c.Query("h") != "") {
+			trackAPIUsage(context.Background(), "/api/v1/files/transform-url", http.StatusBadRequest, start, apiCtx)
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "preset cannot be combined with w or h")
+		}
+
 		var width, height int
 		if preset != "" {
 			var ok bool
-			width, height, ok = getPresetDimensions(preset)
+			presetCtx, presetCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer presetCancel()
+			if conn, err := db.GetDB(); err == nil {
+				width, height, ok = resolvePreset(presetCtx, conn, cfg, apiCtx.Project.ID, preset)
+			} else {
+				width, height, ok = getPresetDimensions(cfg, preset)
+			}
 			if !ok {
 				trackAPIUsage(context.Background(), "/api/v1/files/transform-url", http.StatusBadRequest, start, apiCtx)
-				return fiber.NewError(fiber.StatusBadRequest, "invalid preset")
+				return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "invalid preset")
 			}
 		} else {
+			// w and h must be positive; 0 is rejected rather than meaning "auto"
 			width, err = strconv.Atoi(c.Query("w", "1200"))
 			if err != nil || width <= 0 {
 				trackAPIUsage(context.Background(), "/api/v1/files/transform-url", http.StatusBadRequest, start, apiCtx)
-				return fiber.NewError(fiber.StatusBadRequest, "invalid width")
+				return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "invalid width: must be a positive integer")
 			}
 			height, err = strconv.Atoi(c.Query("h", "1200"))
 			if err != nil || height <= 0 {
 				trackAPIUsage(context.Background(), "/api/v1/files/transform-url", http.StatusBadRequest, start, apiCtx)
-				return fiber.NewError(fiber.StatusBadRequest, "invalid height")
+				return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "invalid height: must be a positive integer")
 			}
 		}
 
 		const maxDim = 4000
 		if width > maxDim || height > maxDim {
 			trackAPIUsage(context.Background(), "/api/v1/files/transform-url", http.StatusBadRequest, start, apiCtx)
-			return fiber.NewError(fiber.StatusBadRequest, "dimensions too large")
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "dimensions too large")
 		}
 
 		format := c.Query("format", "webp")
@@ -110,18 +154,54 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 			format = "webp"
 		}
 
+		// The API-key flow has no file record, so check the object itself is an
+		// image imgproxy can read. skip_validation=true is for sources imgproxy
+		// handles that aren't image/* (e.g. PDF rasterization) and saves a round trip.
+		if c.Query("skip_validation") != "true" {
+			statCtx, statCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer statCancel()
+			info, err := client.StatObject(statCtx, cfg.Bucket, key, minio.StatObjectOptions{})
+			if err != nil {
+				log.Printf("transform-url stat error: %v", err)
+				err = mapMinioError(err, "failed to check object")
+				trackAPIUsage(context.Background(), "/api/v1/files/transform-url", errorStatus(err), start, apiCtx)
+				return err
+			}
+			contentType := normalizeContentType(cfg, key, info.ContentType)
+			if !strings.HasPrefix(contentType, "image/") {
+				trackAPIUsage(context.Background(), "/api/v1/files/transform-url", http.StatusBadRequest, start, apiCtx)
+				return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "object is not an image (content type "+contentType+")")
+			}
+			if info.Metadata.Get("Content-Encoding") == "gzip" {
+				trackAPIUsage(context.Background(), "/api/v1/files/transform-url", http.StatusBadRequest, start, apiCtx)
+				return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "object is stored compressed and can't be transformed")
+			}
+		}
+
 		transformURL := buildImgproxyURLWithOptions(cfg, key, mode, width, height, format)
 
 		trackAPIUsage(context.Background(), "/api/v1/files/transform-url", http.StatusOK, start, apiCtx)
 
-		return c.JSON(fiber.Map{
+		resp := fiber.Map{
 			"url":    transformURL,
 			"mode":   mode,
 			"width":  width,
 			"height": height,
 			"format": format,
 			"preset": preset,
-		})
+		}
+		// In dev mode, make a missing signing setup visible instead of silently
+		// handing out /unsafe URLs that a signed-only imgproxy rejects
+		if cfg.ImgproxyDevMode {
+			path := imgproxyPath(cfg, key, mode, width, height, format)
+			signed := signImgproxyPath(path) != ""
+			resp["signed"] = signed
+			resp["unsafe_url"] = cfg.ImgproxyURL + "/unsafe" + path
+			if !signed {
+				resp["warning"] = "imgproxy signing is not configured (IMGPROXY_KEY/IMGPROXY_SALT missing or invalid); url is an /unsafe URL that only works if imgproxy accepts unsigned requests"
+			}
+		}
+		return c.JSON(resp)
 	})
 
 	// POST /upload
@@ -132,48 +212,118 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 		}
 		start := time.Now()
 
+		if err := checkUploadRequestSize(c, cfg); err != nil {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusRequestEntityTooLarge, start, apiCtx)
+			return err
+		}
 		fileHeader, err := c.FormFile("file")
 		if err != nil {
 			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusBadRequest, start, apiCtx)
-			return fiber.NewError(fiber.StatusBadRequest, "file is required")
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "file is required")
+		}
+		if err := checkUploadSize(cfg, fileHeader.Size); err != nil {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusRequestEntityTooLarge, start, apiCtx)
+			return err
 		}
 
 		conn, err := db.GetDB()
 		if err != nil {
 			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusInternalServerError, start, apiCtx)
-			return fiber.NewError(http.StatusInternalServerError, "database not available")
+			return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
+		// API-key uploads count toward the key owner's storage limit like
+		// frontend uploads do
+		totalStorage, err := UserStorageUsage(ctx, conn, apiCtx.User.FirebaseUID)
+		if err != nil {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusInternalServerError, start, apiCtx)
+			return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to compute storage usage")
+		}
+		if totalStorage+fileHeader.Size > apiCtx.User.StorageLimit {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusRequestEntityTooLarge, start, apiCtx)
+			return apiError(http.StatusRequestEntityTooLarge, apierror.StorageLimitExceeded, "Upload would exceed storage limit")
+		}
+
+		if err := checkProjectFileLimit(ctx, conn, cfg, apiCtx.Project.ID, 1); err != nil {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", errorStatus(err), start, apiCtx)
+			return err
+		}
+
+		// public=true stores the object under PUBLIC_PREFIX, which a bucket
+		// policy makes anonymously readable
+		public := c.FormValue("public") == "true"
+		if public && cfg.PublicPrefix == "" {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusBadRequest, start, apiCtx)
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "public uploads are not enabled (PUBLIC_PREFIX is not set)")
+		}
+		prefix := cfg.StoragePrefix
+		if public {
+			prefix = cfg.PublicPrefix
+		}
+		newKey := objectKeyUnder(prefix, apiCtx.Project.ID, fileHeader.Filename, time.Now().UTC())
+		if err := checkObjectKeyLength(cfg, newKey); err != nil {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusBadRequest, start, apiCtx)
+			return err
+		}
+
+		if err := checkIfNoneMatch(ctx, client, cfg.Bucket, newKey, c.Get("If-None-Match")); err != nil {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", errorStatus(err), start, apiCtx)
+			return err
+		}
+
 		src, err := fileHeader.Open()
 		if err != nil {
 			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusInternalServerError, start, apiCtx)
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to open uploaded file")
+			return apiError(fiber.StatusInternalServerError, apierror.StorageError, "failed to open uploaded file")
 		}
 		defer src.Close()
 
 		// Compute SHA256 hash of file content for deduplication
-		hash := sha256.New()
-		if _, err := io.Copy(hash, src); err != nil {
+		contentHash, head, err := hashUpload(src)
+		if err != nil {
 			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusInternalServerError, start, apiCtx)
-			return fiber.NewError(http.StatusInternalServerError, "failed to compute file hash")
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to compute file hash")
+		}
+
+		// Correct commonly misreported or missing types (e.g. .svg sent as text/plain)
+		contentType := uploadContentType(cfg, fileHeader.Filename, fileHeader.Header.Get("Content-Type"), head)
+		if err := checkUploadType(cfg, fileHeader.Filename, fileHeader.Header.Get("Content-Type"), contentType); err != nil {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", errorStatus(err), start, apiCtx)
+			return err
+		}
+		if err := checkUploadContent(cfg, contentType, head); err != nil {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", errorStatus(err), start, apiCtx)
+			return err
+		}
+
+		// SVGs are stored sanitized, so the hash is of the sanitized bytes
+		var sanitized []byte
+		if cfg.SanitizeSVG && mediaType(contentType) == "image/svg+xml" {
+			if sanitized, contentHash, err = sanitizeSVGUpload(fileHeader); err != nil {
+				trackAPIUsage(context.Background(), "/api/v1/files/upload", errorStatus(err), start, apiCtx)
+				return err
+			}
 		}
-		contentHash := hex.EncodeToString(hash.Sum(nil))
 
-		// Check if a file with this hash already exists
+		width, height := uploadDimensions(fileHeader, contentType)
+
+		// Check if a file with this hash already exists. Empty files all share
+		// one hash, so they always get their own object. Public uploads need
+		// their own object under the public prefix.
 		var existingStoragePath string
 		var existingSize int64
-		err = conn.QueryRowContext(ctx, `
-			SELECT storage_path, size
-			FROM file
-			WHERE content_hash = ?
-			LIMIT 1
-		`, contentHash).Scan(&existingStoragePath, &existingSize)
+		var existingEncoding string
+		err = sql.ErrNoRows
+		if !public {
+			existingStoragePath, existingSize, existingEncoding, err = findDedupBlob(ctx, conn, cfg, apiCtx.User.FirebaseUID, contentHash)
+		}
 
 		var storagePath string
 		var fileSize int64
+		var contentEncoding string
 		var key string
 
 		if err == nil && existingStoragePath != "" {
@@ -181,6 +331,7 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 			log.Printf("upload: reusing existing file with hash %s, storage_path=%s", contentHash, existingStoragePath)
 			storagePath = existingStoragePath
 			fileSize = existingSize
+			contentEncoding = existingEncoding
 			// Extract key from storage path
 			key = strings.TrimPrefix(storagePath, "s3://"+cfg.Bucket+"/")
 		} else {
@@ -190,53 +341,56 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 			src, err = fileHeader.Open()
 			if err != nil {
 				trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusInternalServerError, start, apiCtx)
-				return fiber.NewError(http.StatusInternalServerError, "failed to reopen uploaded file")
+				return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to reopen uploaded file")
 			}
 			defer src.Close()
 
-			// Construct object key: prefix/project_id/yyyy/mm/dd/filename
-			now := time.Now().UTC()
-			datePath := filepath.Join(
-				now.Format("2006"),
-				now.Format("01"),
-				now.Format("02"),
-			)
-			key = filepath.ToSlash(filepath.Join(cfg.StoragePrefix, strconv.FormatInt(apiCtx.Project.ID, 10), datePath, fileHeader.Filename))
-
-			opts := minio.PutObjectOptions{
-				ContentType: fileHeader.Header.Get("Content-Type"),
+			key, err = collisionFreeKey(ctx, conn, client, cfg, newKey, contentHash)
+			if err == nil {
+				err = checkObjectKeyLength(cfg, key)
+			}
+			if err != nil {
+				trackAPIUsage(context.Background(), "/api/v1/files/upload", errorStatus(err), start, apiCtx)
+				return err
 			}
 
-			info, err := client.PutObject(
-				ctx,
-				cfg.Bucket,
-				key,
-				src,
-				fileHeader.Size,
-				opts,
-			)
+			var body io.Reader = src
+			size := fileHeader.Size
+			if sanitized != nil {
+				body, size = bytes.NewReader(sanitized), int64(len(sanitized))
+			}
+			contentEncoding, err = storeObject(ctx, client, cfg, key, body, size, contentType)
 			if err != nil {
 				log.Printf("upload error: %v", err)
-				trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusInternalServerError, start, apiCtx)
-				return fiber.NewError(fiber.StatusInternalServerError, "failed to upload file")
+				err = mapMinioError(err, "failed to upload file")
+				trackAPIUsage(context.Background(), "/api/v1/files/upload", errorStatus(err), start, apiCtx)
+				return err
 			}
 
-			storagePath = "s3://" + cfg.Bucket + "/" + info.Key
-			fileSize = info.Size
-			key = info.Key
+			storagePath = "s3://" + cfg.Bucket + "/" + key
+			// Record the original size, not the compressed size stored in MinIO
+			fileSize = size
+		}
+
+		// Projects with a retention period lock new files against deletion
+		lockedUntil, err := lockUntilForUpload(ctx, conn, client, cfg, apiCtx.Project.ID, key)
+		if err != nil {
+			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusInternalServerError, start, apiCtx)
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project retention")
 		}
 
 		// Insert DB record
 		nowStr := time.Now().UTC()
 		id := uuid.NewString()
-		if _, err := conn.ExecContext(ctx, `
-				INSERT INTO file (id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path, content_hash)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-			`, id, fileHeader.Filename, fileSize, defaultContentType(fileHeader.Header.Get("Content-Type")), nowStr, apiCtx.Project.ID, apiCtx.User.FirebaseUID, storagePath, contentHash); err != nil {
+		if _, err := db.ExecWithRetry(ctx, conn, `
+				INSERT INTO file (id, filename, size, mime_type, created_at, updated_at, project_id, user_firebase_uid, storage_path, content_hash, content_encoding, locked_until, width, height)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, id, fileHeader.Filename, fileSize, contentType, nowStr, nowStr, apiCtx.Project.ID, apiCtx.User.FirebaseUID, storagePath, contentHash, contentEncoding, lockedUntil, width, height); err != nil {
 			log.Printf("db insert file error: %v", err)
 			trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusInternalServerError, start, apiCtx)
-			return fiber.NewError(http.StatusInternalServerError, "failed to save file record")
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to save file record")
 		}
+		enqueueUploadJobs(ctx, id, contentType, fileSize)
 
 		imgproxyURL := buildImgproxyURL(cfg, key)
 
@@ -245,15 +399,30 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 
 		trackAPIUsage(context.Background(), "/api/v1/files/upload", http.StatusCreated, start, apiCtx)
 
-		return c.Status(fiber.StatusCreated).JSON(uploadResponse{
-			ID:          id,
-			Key:         key,
-			Bucket:      cfg.Bucket,
-			Size:        fileSize,
-			ContentType: defaultContentType(fileHeader.Header.Get("Content-Type")),
-			URL:         publicURL,
-			ImgproxyURL: imgproxyURL,
-		})
+		resp := uploadResponse{
+			ID:           id,
+			Key:          key,
+			Bucket:       cfg.Bucket,
+			Size:         fileSize,
+			ContentType:  contentType,
+			URL:          publicURL,
+			ImgproxyURL:  imgproxyURL,
+			ThumbnailURL: buildThumbnailURL(cfg, key, "webp"),
+		}
+		if public {
+			resp.PublicURL = publicObjectURL(cfg, key)
+		}
+		return c.Status(fiber.StatusCreated).JSON(resp)
+	})
+
+	// POST /presign-upload - upload URL for a direct PUT to MinIO
+	router.Post("/presign-upload", func(c fiber.Ctx) error {
+		return presignUpload(c, client, cfg)
+	})
+
+	// POST /complete-upload - record a file uploaded with a presign-upload URL
+	router.Post("/complete-upload", func(c fiber.Ctx) error {
+		return completeUpload(c, client, cfg)
 	})
 
 	// GET /list
@@ -264,8 +433,32 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 		}
 		start := time.Now()
 
-		// Simple list-by-prefix API, not paginated for now
-		prefix := c.Query("prefix", cfg.StoragePrefix)
+		// Simple list-by-prefix API, not paginated for now. Keys are scoped to
+		// the key's project, so other tenants' objects can't be enumerated.
+		prefix, ok := scopedListPrefix(cfg, apiCtx.Project.ID, c.Query("prefix"))
+		if !ok {
+			trackAPIUsage(context.Background(), "/api/v1/files/list", http.StatusForbidden, start, apiCtx)
+			return apiError(fiber.StatusForbidden, apierror.Forbidden, "prefix must be under "+projectListPrefix(cfg.StoragePrefix, apiCtx.Project.ID))
+		}
+
+		// Optional sorting. MinIO always lists in lexical key order, so sorting by
+		// last_modified is applied to the collected results (the current page only).
+		sortBy := c.Query("sort", "")
+		if sortBy != "" && sortBy != "key" && sortBy != "last_modified" {
+			trackAPIUsage(context.Background(), "/api/v1/files/list", http.StatusBadRequest, start, apiCtx)
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "invalid sort (expected key or last_modified)")
+		}
+		order := c.Query("order", "asc")
+		if order != "asc" && order != "desc" {
+			trackAPIUsage(context.Background(), "/api/v1/files/list", http.StatusBadRequest, start, apiCtx)
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "invalid order (expected asc or desc)")
+		}
+		thumbnailFormat := c.Query("thumbnail_format", "webp")
+		if thumbnailFormat != "webp" && thumbnailFormat != "avif" {
+			trackAPIUsage(context.Background(), "/api/v1/files/list", http.StatusBadRequest, start, apiCtx)
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "invalid thumbnail_format (expected webp or avif)")
+		}
+		presign := c.Query("presign") == "true"
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -277,23 +470,54 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 
 		// Initialize as empty slice (not nil) to ensure JSON returns []
 		files := make([]fileInfo, 0)
+		var totalSize, objectCount int64
 		for obj := range objectCh {
 			if obj.Err != nil {
 				log.Printf("list error: %v", obj.Err)
 				continue
 			}
+			// Same accumulation as config.GetBucketStats, limited to this prefix
+			totalSize += obj.Size
+			objectCount++
 			files = append(files, fileInfo{
 				Key:          obj.Key,
 				Size:         obj.Size,
 				ETag:         obj.ETag,
 				LastModified: obj.LastModified,
 				ImgproxyURL:  buildImgproxyURL(cfg, obj.Key),
+				ThumbnailURL: buildThumbnailURL(cfg, obj.Key, thumbnailFormat),
 			})
 		}
 
-		trackAPIUsage(context.Background(), "/api/v1/files/list", http.StatusOK, start, apiCtx)
+		sortFileInfos(files, sortBy, order == "desc")
 
-		return c.JSON(files)
+		// Signing is local but not free, so only the first maxListPresigns
+		// objects in the returned order get a link
+		endpoint := "/api/v1/files/list"
+		if presign {
+			endpoint = "/api/v1/files/list?presign=true"
+			for i := range files[:min(len(files), maxListPresigns)] {
+				u, err := client.PresignedGetObject(ctx, cfg.Bucket, files[i].Key, min(listPresignExpiry, cfg.PresignMaxExpiry), url.Values{})
+				if err != nil {
+					log.Printf("list presign error: %v", err)
+					continue
+				}
+				files[i].PresignedURL = u.String()
+			}
+		}
+
+		trackAPIUsage(context.Background(), endpoint, http.StatusOK, start, apiCtx)
+
+		// format=array keeps the original bare-array response for older clients
+		if c.Query("format") == "array" {
+			return c.JSON(files)
+		}
+
+		return c.JSON(listResponse{
+			Files:       files,
+			TotalSize:   totalSize,
+			ObjectCount: objectCount,
+		})
 	})
 
 	// DELETE /:key
@@ -304,22 +528,54 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 		}
 		start := time.Now()
 
-		key := c.Params("key")
+		key, err := objectKeyParam(c)
+		if err != nil {
+			trackAPIUsage(context.Background(), "/api/v1/files/"+c.Params("key"), http.StatusBadRequest, start, apiCtx)
+			return err
+		}
 		if key == "" {
 			trackAPIUsage(context.Background(), "/api/v1/files/"+key, http.StatusBadRequest, start, apiCtx)
-			return fiber.NewError(fiber.StatusBadRequest, "key is required")
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "key is required")
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
+		// RemoveObject succeeds for missing keys; strict=true reports them as 404
+		if c.Query("strict") == "true" {
+			if _, err := client.StatObject(ctx, cfg.Bucket, key, minio.StatObjectOptions{}); err != nil {
+				log.Printf("delete stat error: %v", err)
+				err = mapMinioError(err, "failed to check object")
+				trackAPIUsage(context.Background(), "/api/v1/files/"+key, errorStatus(err), start, apiCtx)
+				return err
+			}
+		}
+
+		// Objects backing a locked file can't be removed through the API
+		var lockedFiles int
+		if conn, err := db.GetDB(); err == nil {
+			if err := conn.QueryRowContext(ctx, `
+				SELECT COUNT(*)
+				FROM file
+				WHERE storage_path = ? AND locked_until > ?
+			`, "s3://"+cfg.Bucket+"/"+key, time.Now().UTC()).Scan(&lockedFiles); err != nil {
+				log.Printf("delete lock check error: %v", err)
+			}
+		}
+		if lockedFiles > 0 {
+			trackAPIUsage(context.Background(), "/api/v1/files/"+key, http.StatusForbidden, start, apiCtx)
+			return apiError(http.StatusForbidden, apierror.FileLocked, "object belongs to a locked file")
+		}
+
 		err = client.RemoveObject(ctx, cfg.Bucket, key, minio.RemoveObjectOptions{})
 		if err != nil {
 			log.Printf("delete error: %v", err)
-			trackAPIUsage(context.Background(), "/api/v1/files/"+key, http.StatusInternalServerError, start, apiCtx)
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to delete object")
+			err = mapMinioError(err, "failed to delete object")
+			trackAPIUsage(context.Background(), "/api/v1/files/"+key, errorStatus(err), start, apiCtx)
+			return err
 		}
 
+		audit.Record(ctx, apiCtx.User.FirebaseUID, audit.ActionDelete, audit.TargetObject, key, apiCtx.Project.ID, "api key "+strconv.FormatInt(apiCtx.APIKey.ID, 10))
 		trackAPIUsage(context.Background(), "/api/v1/files/"+key, http.StatusNoContent, start, apiCtx)
 
 		return c.SendStatus(fiber.StatusNoContent)
@@ -327,20 +583,37 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 
 	// GET /:key (public presigned redirect)
 	router.Get("/:key", func(c fiber.Ctx) error {
-		key := c.Params("key")
+		key, err := objectKeyParam(c)
+		if err != nil {
+			return err
+		}
 		if key == "" {
-			return fiber.NewError(fiber.StatusBadRequest, "key is required")
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "key is required")
+		}
+
+		// Clients may ask for a longer (e.g. links in emails) or shorter lived URL
+		// via ?expiry=<seconds>, bounded by PRESIGN_MAX_EXPIRY (at most 7 days).
+		expiry := cfg.PresignExpiry
+		if v := c.Query("expiry"); v != "" {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "invalid expiry (expected positive number of seconds)")
+			}
+			expiry = time.Duration(seconds) * time.Second
+			if expiry > cfg.PresignMaxExpiry {
+				return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "expiry exceeds maximum of "+strconv.Itoa(int(cfg.PresignMaxExpiry/time.Second))+" seconds")
+			}
 		}
 
-		// Generate a short-lived presigned URL from MinIO
+		// Generate a presigned URL from MinIO
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
 		reqParams := url.Values{}
-		u, err := client.PresignedGetObject(ctx, cfg.Bucket, key, 15*time.Minute, reqParams)
+		u, err := client.PresignedGetObject(ctx, cfg.Bucket, key, expiry, reqParams)
 		if err != nil {
 			log.Printf("presign error: %v", err)
-			return fiber.NewError(fiber.StatusInternalServerError, "failed to generate download URL")
+			return mapMinioError(err, "failed to generate download URL")
 		}
 
 		return c.Redirect().Status(fiber.StatusTemporaryRedirect).To(u.String())
@@ -349,32 +622,42 @@ func RegisterFileRoutes(router fiber.Router, client *minio.Client, cfg config.Mi
 
 // RegisterFrontendFileRoutes registers /frontend/files routes that mirror the Python
 // frontend file routes and use Firebase auth + DB records.
-func RegisterFrontendFileRoutes(router fiber.Router, client *minio.Client, cfg config.MinioConfig) {
+func RegisterFrontendFileRoutes(router fiber.Router, client *minio.Client, cfg config.MinioConfig, cache *thumbcache.Cache) {
 	router.Use(auth.FirebaseAuthMiddleware())
 	router.Use(auth.RequireRoles("whitelisted"))
 
-	const storageLimit = 50 * 1024 * 1024 * 1024 // 50GB
-
 	// POST /frontend/files/upload
 	router.Post("/upload", func(c fiber.Ctx) error {
 		user, err := auth.GetCurrentFirebaseUser(c)
 		if err != nil {
-			return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+			return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 		}
 
 		projectID, err := strconv.ParseInt(c.FormValue("project_id"), 10, 64)
 		if err != nil || projectID <= 0 {
-			return fiber.NewError(http.StatusBadRequest, "invalid project_id")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project_id")
 		}
 
+		if err := checkUploadRequestSize(c, cfg); err != nil {
+			return err
+		}
 		fileHeader, err := c.FormFile("file")
 		if err != nil {
-			return fiber.NewError(http.StatusBadRequest, "file is required")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file is required")
+		}
+		if err := checkUploadSize(cfg, fileHeader.Size); err != nil {
+			return err
+		}
+
+		// Optional id to follow the upload on /upload-progress/:upload_id
+		uploadID := c.FormValue("upload_id")
+		if len(uploadID) > maxUploadIDLength {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "upload_id longer than "+strconv.Itoa(maxUploadIDLength)+" bytes")
 		}
 
 		conn, err := db.GetDB()
 		if err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "database not available")
+			return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -388,147 +671,91 @@ func RegisterFrontendFileRoutes(router fiber.Router, client *minio.Client, cfg c
 			WHERE id = ?
 		`, projectID).Scan(&ownerUID); err != nil {
 			if err == sql.ErrNoRows {
-				return fiber.NewError(http.StatusForbidden, "Not authorized to upload to this project")
+				return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to upload to this project")
 			}
-			return fiber.NewError(http.StatusInternalServerError, "failed to load project")
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
 		}
 		if ownerUID != user.UID {
-			return fiber.NewError(http.StatusForbidden, "Not authorized to upload to this project")
-		}
-
-		// Check storage usage
-		var totalStorage int64
-		if err := conn.QueryRowContext(ctx, `
-			SELECT COALESCE(SUM(size), 0)
-			FROM file
-			WHERE user_firebase_uid = ?
-		`, user.UID).Scan(&totalStorage); err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "failed to compute storage usage")
-		}
-		if totalStorage+fileHeader.Size > storageLimit {
-			return fiber.NewError(http.StatusRequestEntityTooLarge, "Upload would exceed storage limit")
+			return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to upload to this project")
 		}
 
-		src, err := fileHeader.Open()
+		progress := startUploadProgress(user.UID, uploadID, fileHeader.Size)
+		f, err := saveUpload(ctx, conn, client, cfg, user.UID, projectID, fileHeader, progress)
+		progress.finish(user.UID, f.ID, err)
 		if err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "failed to open uploaded file")
+			return err
 		}
-		defer src.Close()
 
-		// Compute SHA256 hash of file content for deduplication
-		hash := sha256.New()
-		if _, err := io.Copy(hash, src); err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "failed to compute file hash")
-		}
-		contentHash := hex.EncodeToString(hash.Sum(nil))
+		return c.Status(http.StatusCreated).JSON(f)
+	})
 
-		// Check if a file with this hash already exists
-		var existingStoragePath string
-		var existingSize int64
-		err = conn.QueryRowContext(ctx, `
-			SELECT storage_path, size
-			FROM file
-			WHERE content_hash = ?
-			LIMIT 1
-		`, contentHash).Scan(&existingStoragePath, &existingSize)
+	// POST /frontend/files/:file_id/share - public link, optionally password-protected
+	router.Post("/:file_id/share", createShare)
 
-		var storagePath string
-		var fileSize int64
+	// GET /frontend/files/upload-progress/:upload_id - SSE progress of an upload
+	router.Get("/upload-progress/:upload_id", streamUploadProgress)
 
-		if err == nil && existingStoragePath != "" {
-			// File with same hash exists, reuse the storage path
-			log.Printf("upload: reusing existing file with hash %s, storage_path=%s", contentHash, existingStoragePath)
-			storagePath = existingStoragePath
-			fileSize = existingSize
-			// Don't count storage again since we're reusing an existing file
-		} else {
-			// New file, upload to MinIO
-			// Reset file reader for upload
-			src.Close()
-			src, err = fileHeader.Open()
-			if err != nil {
-				return fiber.NewError(http.StatusInternalServerError, "failed to reopen uploaded file")
-			}
-			defer src.Close()
+	// POST /frontend/files/:file_id/regenerate-thumbnail - drop cached renderings and re-render
+	router.Post("/:file_id/regenerate-thumbnail", func(c fiber.Ctx) error {
+		return regenerateThumbnail(c, cfg, cache)
+	})
 
-			now := time.Now().UTC()
-			datePath := filepath.Join(
-				now.Format("2006"),
-				now.Format("01"),
-				now.Format("02"),
-			)
-			key := filepath.ToSlash(filepath.Join(cfg.StoragePrefix, strconv.FormatInt(projectID, 10), datePath, fileHeader.Filename))
+	// GET /frontend/files/:file_id/references - the user's files sharing its blob
+	router.Get("/:file_id/references", getFileReferences)
 
-			opts := minio.PutObjectOptions{
-				ContentType: fileHeader.Header.Get("Content-Type"),
-			}
+	// GET /frontend/files/:file_id/access-log - downloads through the public routes
+	router.Get("/:file_id/access-log", getFileAccessLog)
 
-			info, err := client.PutObject(
-				ctx,
-				cfg.Bucket,
-				key,
-				src,
-				fileHeader.Size,
-				opts,
-			)
-			if err != nil {
-				log.Printf("upload error: %v", err)
-				return fiber.NewError(fiber.StatusInternalServerError, "failed to upload file")
-			}
+	// PATCH /frontend/files/:file_id - rename and per-file serving overrides
+	router.Patch("/:file_id", updateFile)
 
-			storagePath = "s3://" + cfg.Bucket + "/" + key
-			fileSize = info.Size
-		}
+	// POST /frontend/files/upload-token - short-lived token for browser uploads
+	router.Post("/upload-token", func(c fiber.Ctx) error {
+		return createUploadToken(c, cfg)
+	})
 
-		nowStr := time.Now().UTC()
+	// POST /frontend/files/register-batch - file rows for objects copied in externally
+	router.Post("/register-batch", func(c fiber.Ctx) error {
+		return registerFiles(c, client, cfg)
+	})
 
-		// Insert DB record with hash
-		id := uuid.NewString()
-		if _, err := conn.ExecContext(ctx, `
-			INSERT INTO file (id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path, content_hash)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, id, fileHeader.Filename, fileSize, defaultContentType(fileHeader.Header.Get("Content-Type")), nowStr, projectID, user.UID, storagePath, contentHash); err != nil {
-			log.Printf("db insert file error: %v", err)
-			return fiber.NewError(http.StatusInternalServerError, "failed to save file record")
-		}
+	// POST /frontend/files/archive - ZIP of selected files
+	router.Post("/archive", func(c fiber.Ctx) error {
+		return archiveFiles(c, client, cfg)
+	})
 
-		var f db.File
-		if err := conn.QueryRowContext(ctx, `
-			SELECT id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path, content_hash
-			FROM file
-			WHERE id = ?
-		`, id).Scan(
-			&f.ID,
-			&f.Filename,
-			&f.Size,
-			&f.MimeType,
-			&f.CreatedAt,
-			&f.ProjectID,
-			&f.UserFirebaseUID,
-			&f.StoragePath,
-			&f.ContentHash,
-		); err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "failed to load created file")
-		}
+	// GET /frontend/files - files across projects, paginated
+	router.Get("/", func(c fiber.Ctx) error {
+		return listUserFiles(c, cfg)
+	})
 
-		return c.Status(http.StatusCreated).JSON(f)
+	// GET /frontend/files/trash and POST /frontend/files/trash/:file_id/restore
+	router.Get("/trash", func(c fiber.Ctx) error {
+		return listTrash(c, cfg)
+	})
+	router.Post("/trash/:file_id/restore", func(c fiber.Ctx) error {
+		return restoreFile(c, cfg)
 	})
 
 	// GET /frontend/files/list
 	router.Get("/list", func(c fiber.Ctx) error {
 		user, err := auth.GetCurrentFirebaseUser(c)
 		if err != nil {
-			return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+			return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 		}
 
 		projectID, err := strconv.ParseInt(c.Query("project_id"), 10, 64)
 		if err != nil || projectID <= 0 {
-			return fiber.NewError(http.StatusBadRequest, "invalid project_id")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project_id")
+		}
+		sortColumn, order, err := parseSort(c, config.FileSortKeys, cfg.FileSort)
+		if err != nil {
+			return err
 		}
 
 		conn, err := db.GetDB()
 		if err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "database not available")
+			return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -542,22 +769,22 @@ func RegisterFrontendFileRoutes(router fiber.Router, client *minio.Client, cfg c
 			WHERE id = ?
 		`, projectID).Scan(&ownerUID); err != nil {
 			if err == sql.ErrNoRows {
-				return fiber.NewError(http.StatusForbidden, "Not authorized to access this project")
+				return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
 			}
-			return fiber.NewError(http.StatusInternalServerError, "failed to load project")
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
 		}
 		if ownerUID != user.UID {
-			return fiber.NewError(http.StatusForbidden, "Not authorized to access this project")
+			return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
 		}
 
 		// Initialize as empty slice (not nil) to ensure JSON returns []
 		files := make([]db.File, 0)
 
 		rows, err := conn.QueryContext(ctx, `
-			SELECT id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path, content_hash
+			SELECT `+db.FileColumns+`
 			FROM file
 			WHERE project_id = ?
-			ORDER BY created_at DESC
+			ORDER BY `+sortColumn+` `+order+`, id `+order+`
 		`, projectID)
 		if err != nil {
 			// Return empty array instead of error - query failures might be due to empty table
@@ -567,17 +794,7 @@ func RegisterFrontendFileRoutes(router fiber.Router, client *minio.Client, cfg c
 
 		for rows.Next() {
 			var f db.File
-			if err := rows.Scan(
-				&f.ID,
-				&f.Filename,
-				&f.Size,
-				&f.MimeType,
-				&f.CreatedAt,
-				&f.ProjectID,
-				&f.UserFirebaseUID,
-				&f.StoragePath,
-				&f.ContentHash,
-			); err != nil {
+			if err := db.ScanFile(rows, &f); err != nil {
 				// Continue to next row instead of failing completely
 				continue
 			}
@@ -597,203 +814,696 @@ func RegisterFrontendFileRoutes(router fiber.Router, client *minio.Client, cfg c
 	router.Delete("/:file_id", func(c fiber.Ctx) error {
 		user, err := auth.GetCurrentFirebaseUser(c)
 		if err != nil {
-			return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+			return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 		}
 
 		fileID := c.Params("file_id")
 		if fileID == "" {
-			return fiber.NewError(http.StatusBadRequest, "file_id is required")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
 		}
 
 		conn, err := db.GetDB()
 		if err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "database not available")
+			return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
 		var f db.File
-		if err := conn.QueryRowContext(ctx, `
-			SELECT id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path, content_hash
+		if err := db.ScanFile(conn.QueryRowContext(ctx, `
+			SELECT `+db.FileColumns+`
 			FROM file
 			WHERE id = ?
-		`, fileID).Scan(
-			&f.ID,
-			&f.Filename,
-			&f.Size,
-			&f.MimeType,
-			&f.CreatedAt,
-			&f.ProjectID,
-			&f.UserFirebaseUID,
-			&f.StoragePath,
-			&f.ContentHash,
-		); err != nil {
+		`, fileID), &f); err != nil {
 			if err == sql.ErrNoRows {
-				return fiber.NewError(http.StatusNotFound, "File not found")
+				return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
 			}
-			return fiber.NewError(http.StatusInternalServerError, "failed to load file")
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
 		}
 
 		if f.UserFirebaseUID != user.UID {
-			return fiber.NewError(http.StatusForbidden, "Not authorized to delete this file")
+			return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to delete this file")
 		}
-
-		// Check how many files reference the same storage_path (for deduplication)
-		var referenceCount int
-		if f.ContentHash != "" {
-			err = conn.QueryRowContext(ctx, `
-				SELECT COUNT(*)
-				FROM file
-				WHERE content_hash = ?
-			`, f.ContentHash).Scan(&referenceCount)
-		} else {
-			// Fallback: count by storage_path if hash is not available
-			err = conn.QueryRowContext(ctx, `
-				SELECT COUNT(*)
-				FROM file
-				WHERE storage_path = ?
-			`, f.StoragePath).Scan(&referenceCount)
-		}
-		if err != nil {
-			log.Printf("failed to count file references: %v", err)
-			referenceCount = 1 // Assume it's the only reference if we can't check
+		if err := checkFileUnlocked(f, user); err != nil {
+			return err
 		}
 
-		// Only delete from MinIO if this is the last reference
-		if referenceCount <= 1 {
-			if strings.HasPrefix(f.StoragePath, "s3://") {
-				key, err := extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
-				if err != nil {
-					log.Printf("failed to extract key from storage path for deletion: %v", err)
-					// Continue with DB deletion even if key extraction fails
-				} else {
-					ctxDel, cancelDel := context.WithTimeout(context.Background(), 5*time.Second)
-					defer cancelDel()
-					if err := client.RemoveObject(ctxDel, cfg.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
-						log.Printf("delete object error: %v", err)
-					} else {
-						log.Printf("deleted MinIO object: %s (last reference)", key)
-					}
-				}
-			} else {
-				// Legacy local path - best-effort delete from disk
-				_ = os.Remove(f.StoragePath)
+		// With the trash enabled the file stays restorable until it is purged
+		if cfg.TrashRetentionDays > 0 {
+			if err := trashFile(ctx, conn, fileID, user.UID); err != nil {
+				log.Printf("trash file error: %v", err)
+				return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to delete file record")
 			}
-		} else {
-			log.Printf("skipping MinIO deletion: %d files still reference storage_path=%s", referenceCount-1, f.StoragePath)
+			audit.Record(ctx, user.UID, audit.ActionDelete, audit.TargetFile, fileID, f.ProjectID, f.Filename+" (trash)")
+			dispatchFileEvent(ctx, webhookEventFileDeleted, f)
+			return c.SendStatus(http.StatusNoContent)
 		}
 
 		if _, err := conn.ExecContext(ctx, `DELETE FROM file WHERE id = ?`, fileID); err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "failed to delete file record")
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to delete file record")
 		}
+		// Only delete from MinIO if this was the last reference (deduplication)
+		removeUnreferencedBlob(ctx, conn, client, cfg, f)
+		audit.Record(ctx, user.UID, audit.ActionDelete, audit.TargetFile, fileID, f.ProjectID, f.Filename)
+		dispatchFileEvent(ctx, webhookEventFileDeleted, f)
 
 		return c.SendStatus(http.StatusNoContent)
 	})
 }
 
-// extractKeyFromStoragePath extracts the MinIO object key from an s3:// storage path.
-// It handles cases where the bucket name might not match the config by parsing the URL directly.
-func extractKeyFromStoragePath(storagePath string, expectedBucket string) (string, error) {
-	if !strings.HasPrefix(storagePath, "s3://") {
-		return "", fiber.NewError(http.StatusBadRequest, "storage path is not an s3:// URL")
+// saveUpload stores an uploaded file in a project the caller is allowed to
+// upload to: it enforces the storage and file limits, deduplicates by content
+// hash, uploads to MinIO and records the file. The file is read once:
+// hashed while streamed to a temporary object, which is then copied to its
+// key or, for duplicate content, dropped. Errors are API errors. progress
+// (may be nil) follows the storing.
+func saveUpload(ctx context.Context, conn *sql.DB, client *minio.Client, cfg config.MinioConfig, uid string, projectID int64, fileHeader *multipart.FileHeader, progress *uploadProgress) (db.File, error) {
+	// Check storage usage
+	totalStorage, err := UserStorageUsage(ctx, conn, uid)
+	if err != nil {
+		return db.File{}, apiError(http.StatusInternalServerError, apierror.StorageError, "failed to compute storage usage")
 	}
-
-	// Remove s3:// prefix
-	path := strings.TrimPrefix(storagePath, "s3://")
-
-	// Split by / to get bucket and key parts
-	parts := strings.SplitN(path, "/", 2)
-	if len(parts) < 2 {
-		return "", fiber.NewError(http.StatusBadRequest, "invalid s3:// URL format")
+	quota, err := userStorageLimit(ctx, conn, uid)
+	if err != nil {
+		return db.File{}, apiError(http.StatusInternalServerError, apierror.StorageError, "failed to load storage limit")
 	}
-
-	bucket := parts[0]
-	key := parts[1]
-
-	// Log if bucket doesn't match (for debugging) but still return the key
-	if bucket != expectedBucket {
-		log.Printf("warning: storage path bucket (%s) doesn't match config bucket (%s), using extracted key anyway", bucket, expectedBucket)
+	if totalStorage+fileHeader.Size > quota {
+		return db.File{}, apiError(http.StatusRequestEntityTooLarge, apierror.StorageLimitExceeded, "Upload would exceed storage limit")
 	}
 
-	// Remove any leading/trailing slashes from key
-	key = strings.Trim(key, "/")
-
-	if key == "" {
-		return "", fiber.NewError(http.StatusBadRequest, "empty key extracted from storage path")
+	if err := checkProjectFileLimit(ctx, conn, cfg, projectID, 1); err != nil {
+		return db.File{}, err
 	}
 
-	return key, nil
-}
-
-// serveFileFromMinIO is a helper function to serve a file directly from MinIO
-func serveFileFromMinIO(c fiber.Ctx, ctx context.Context, client *minio.Client, cfg config.MinioConfig, f db.File, key string) error {
-	// Ensure CORS headers are set even if errors occur
-	c.Set("Access-Control-Allow-Origin", "*")
-	c.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	c.Set("Access-Control-Allow-Headers", "*")
-
-	log.Printf("serveFileFromMinIO: bucket=%s, key=%s, file_id=%s", cfg.Bucket, key, f.ID)
-
-	// Create a context with longer timeout for MinIO operations (30 seconds)
-	minioCtx, minioCancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer minioCancel()
+	key := objectKey(cfg, projectID, fileHeader.Filename, time.Now().UTC())
+	if err := checkObjectKeyLength(cfg, key); err != nil {
+		return db.File{}, err
+	}
 
-	// Get object from MinIO
-	obj, err := client.GetObject(minioCtx, cfg.Bucket, key, minio.GetObjectOptions{})
+	src, err := fileHeader.Open()
 	if err != nil {
-		log.Printf("serveFileFromMinIO: GetObject error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
-		return fiber.NewError(http.StatusInternalServerError, "failed to fetch file from storage")
+		return db.File{}, apiError(http.StatusInternalServerError, apierror.StorageError, "failed to open uploaded file")
 	}
-	defer obj.Close()
+	defer src.Close()
 
-	// Get object info for content type
-	objInfo, err := obj.Stat()
+	// The first bytes decide the content type before anything is stored
+	head, err := readUploadHead(src)
 	if err != nil {
-		log.Printf("serveFileFromMinIO: Stat error: %v, using DB metadata, bucket=%s, key=%s", err, cfg.Bucket, key)
-		// Continue anyway - we can use file metadata from DB
+		return db.File{}, apiError(http.StatusInternalServerError, apierror.StorageError, "failed to read uploaded file")
 	}
 
-	// Set headers before streaming
-	contentType := f.MimeType
-	if contentType == "" && err == nil {
-		contentType = objInfo.ContentType
+	// Correct commonly misreported or missing types (e.g. .svg sent as text/plain)
+	contentType := uploadContentType(cfg, fileHeader.Filename, fileHeader.Header.Get("Content-Type"), head)
+	if err := checkUploadType(cfg, fileHeader.Filename, fileHeader.Header.Get("Content-Type"), contentType); err != nil {
+		return db.File{}, err
 	}
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	if err := checkUploadContent(cfg, contentType, head); err != nil {
+		return db.File{}, err
 	}
 
-	c.Set("Content-Type", contentType)
-	c.Set("Content-Disposition", `inline; filename="`+f.Filename+`"`)
-	if f.Size > 0 {
-		c.Set("Content-Length", strconv.FormatInt(f.Size, 10))
+	// SVGs are stored sanitized, so the hash is of the sanitized bytes
+	var sanitized []byte
+	var contentHash string
+	if cfg.SanitizeSVG && mediaType(contentType) == "image/svg+xml" {
+		if sanitized, contentHash, err = sanitizeSVGUpload(fileHeader); err != nil {
+			return db.File{}, err
+		}
 	}
-	c.Set("Cache-Control", "public, max-age=3600")
 
-	log.Printf("serveFileFromMinIO: streaming file, contentType=%s, size=%d, bucket=%s, key=%s", contentType, f.Size, cfg.Bucket, key)
+	width, height := uploadDimensions(fileHeader, contentType)
 
-	// Stream the file directly instead of reading into memory
-	// This is more efficient and handles large files better
-	_, err = io.Copy(c.Response().BodyWriter(), obj)
-	if err != nil {
-		log.Printf("serveFileFromMinIO: Copy error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
-		return fiber.NewError(http.StatusInternalServerError, "failed to stream file from storage")
-	}
+	// Anything else is hashed while it is stored under a temporary key, in a
+	// single pass over the file; the hash then decides whether it is kept.
+	var tmpKey, contentEncoding string
+	fileSize := fileHeader.Size
+	if sanitized == nil {
+		body := io.MultiReader(bytes.NewReader(head), src)
+		tmpKey, contentHash, contentEncoding, err = streamUploadToTemp(ctx, client, cfg, progress.reader(body), fileHeader.Size, contentType)
+		if err != nil {
+			log.Printf("upload error: %v", err)
+			return db.File{}, mapMinioError(err, "failed to upload file")
+		}
+	}
+
+	// Check if a file with this hash already exists. Empty files all share
+	// one hash, so they always get their own object.
+	existingStoragePath, existingSize, existingEncoding, err := findDedupBlob(ctx, conn, cfg, uid, contentHash)
+
+	var storagePath string
+
+	if err == nil && existingStoragePath != "" {
+		// File with same hash exists, reuse the storage path
+		log.Printf("upload: reusing existing file with hash %s, storage_path=%s", contentHash, existingStoragePath)
+		if tmpKey != "" {
+			removeTempUpload(client, cfg, tmpKey)
+		}
+		storagePath = existingStoragePath
+		fileSize = existingSize
+		contentEncoding = existingEncoding
+		// Don't count storage again since we're reusing an existing file
+	} else {
+		key, err = collisionFreeKey(ctx, conn, client, cfg, key, contentHash)
+		if err == nil {
+			err = checkObjectKeyLength(cfg, key)
+		}
+		if err != nil {
+			if tmpKey != "" {
+				removeTempUpload(client, cfg, tmpKey)
+			}
+			return db.File{}, err
+		}
+
+		if tmpKey != "" {
+			if err := promoteTempUpload(ctx, client, cfg, tmpKey, key, fileSize, contentType, contentEncoding); err != nil {
+				log.Printf("upload error: %v", err)
+				removeTempUpload(client, cfg, tmpKey)
+				return db.File{}, mapMinioError(err, "failed to upload file")
+			}
+		} else {
+			progress.setPhase(uploadPhaseStoring)
+			contentEncoding, err = storeObject(ctx, client, cfg, key, progress.reader(bytes.NewReader(sanitized)), int64(len(sanitized)), contentType)
+			if err != nil {
+				log.Printf("upload error: %v", err)
+				return db.File{}, mapMinioError(err, "failed to upload file")
+			}
+			fileSize = int64(len(sanitized))
+		}
+
+		// The original size is recorded, not the compressed size stored in MinIO
+		storagePath = "s3://" + cfg.Bucket + "/" + key
+	}
+
+	// Projects with a retention period lock new files against deletion
+	lockedUntil, err := lockUntilForUpload(ctx, conn, client, cfg, projectID, strings.TrimPrefix(storagePath, "s3://"+cfg.Bucket+"/"))
+	if err != nil {
+		return db.File{}, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project retention")
+	}
+
+	nowStr := time.Now().UTC()
+
+	// Insert DB record with hash
+	id := uuid.NewString()
+	if _, err := db.ExecWithRetry(ctx, conn, `
+		INSERT INTO file (id, filename, size, mime_type, created_at, updated_at, project_id, user_firebase_uid, storage_path, content_hash, content_encoding, locked_until, width, height)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, fileHeader.Filename, fileSize, contentType, nowStr, nowStr, projectID, uid, storagePath, contentHash, contentEncoding, lockedUntil, width, height); err != nil {
+		log.Printf("db insert file error: %v", err)
+		return db.File{}, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to save file record")
+	}
+	enqueueUploadJobs(ctx, id, contentType, fileSize)
+
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, id), &f); err != nil {
+		return db.File{}, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load created file")
+	}
+	return f, nil
+}
+
+// objectKey constructs the MinIO object key for an upload:
+// prefix/project_id/yyyy/mm/dd/filename.
+func objectKey(cfg config.MinioConfig, projectID int64, filename string, now time.Time) string {
+	return objectKeyUnder(cfg.StoragePrefix, projectID, filename, now)
+}
+
+// objectKeyUnder is objectKey with an explicit prefix, e.g. PUBLIC_PREFIX.
+func objectKeyUnder(prefix string, projectID int64, filename string, now time.Time) string {
+	datePath := filepath.Join(
+		now.Format("2006"),
+		now.Format("01"),
+		now.Format("02"),
+	)
+	return filepath.ToSlash(filepath.Join(prefix, strconv.FormatInt(projectID, 10), datePath, filename))
+}
+
+// objectStatter is the part of *minio.Client checkIfNoneMatch uses.
+type objectStatter interface {
+	StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+}
+
+// checkIfNoneMatch applies an upload's If-None-Match header: "*" only lets
+// the upload create key while no object is stored there (412 otherwise), no
+// header means no condition, and any other value is a 400.
+func checkIfNoneMatch(ctx context.Context, client objectStatter, bucket, key, ifNoneMatch string) error {
+	ifNoneMatch = strings.TrimSpace(ifNoneMatch)
+	if ifNoneMatch == "" {
+		return nil
+	}
+	if ifNoneMatch != "*" {
+		return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "only If-None-Match: * is supported")
+	}
+	_, err := client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return apiError(http.StatusPreconditionFailed, apierror.PreconditionFailed, "object already exists: "+key)
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		log.Printf("upload stat error: %v", err)
+		return mapMinioError(err, "failed to check object")
+	}
+	return nil
+}
+
+// collisionFreeKey applies FILENAME_COLLISION=hash to a new upload's key:
+// when an object already exists there and no file with contentHash is stored
+// under it, the key gets the first 8 hex digits of the hash before its
+// extension (photo.jpg becomes photo.1a2b3c4d.jpg), so both files survive.
+// The file's filename stays the original.
+func collisionFreeKey(ctx context.Context, conn *sql.DB, client *minio.Client, cfg config.MinioConfig, key, contentHash string) (string, error) {
+	if cfg.FilenameCollision != "hash" || len(contentHash) < 8 {
+		return key, nil
+	}
+	if _, err := client.StatObject(ctx, cfg.Bucket, key, minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return key, nil
+		}
+		log.Printf("upload collision stat error: %v", err)
+		return "", mapMinioError(err, "failed to check object")
+	}
+
+	// Replacing an object with the same content loses nothing
+	var sameContent int
+	if err := conn.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM file
+		WHERE storage_path = ? AND content_hash = ?
+	`, "s3://"+cfg.Bucket+"/"+key, contentHash).Scan(&sameContent); err != nil {
+		return "", apiError(http.StatusInternalServerError, apierror.InternalError, "failed to check existing object")
+	}
+	if sameContent > 0 {
+		return key, nil
+	}
+
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext) + "." + contentHash[:8] + ext, nil
+}
+
+// projectListPrefix is the key prefix of a project's objects under prefix
+// (see objectKeyUnder), with a trailing slash.
+func projectListPrefix(prefix string, projectID int64) string {
+	return path.Join(prefix, strconv.FormatInt(projectID, 10)) + "/"
+}
+
+// scopedListPrefix normalizes a /list prefix (trimmed, no leading slash,
+// repeated slashes collapsed) and reports whether it lies within the
+// project's objects under STORAGE_PREFIX or PUBLIC_PREFIX. An empty prefix
+// lists the project's STORAGE_PREFIX objects; "." and ".." segments are
+// never allowed.
+func scopedListPrefix(cfg config.MinioConfig, projectID int64, raw string) (string, bool) {
+	raw = strings.TrimLeft(strings.TrimSpace(raw), "/")
+	if raw == "" {
+		return projectListPrefix(cfg.StoragePrefix, projectID), true
+	}
+	for strings.Contains(raw, "//") {
+		raw = strings.ReplaceAll(raw, "//", "/")
+	}
+	for _, seg := range strings.Split(raw, "/") {
+		if seg == "." || seg == ".." {
+			return "", false
+		}
+	}
+
+	scopes := []string{projectListPrefix(cfg.StoragePrefix, projectID)}
+	if cfg.PublicPrefix != "" {
+		scopes = append(scopes, projectListPrefix(cfg.PublicPrefix, projectID))
+	}
+	for _, scope := range scopes {
+		// "uploads/1" means the whole project, not also "uploads/10/..."
+		if raw+"/" == scope {
+			return scope, true
+		}
+		if strings.HasPrefix(raw, scope) {
+			return raw, true
+		}
+	}
+	return "", false
+}
+
+// publicObjectURL is the direct URL of an object under PUBLIC_PREFIX:
+// PUBLIC_BASE_URL (e.g. a CDN in front of the bucket) or else the MinIO
+// endpoint and bucket, followed by the escaped key.
+func publicObjectURL(cfg config.MinioConfig, key string) string {
+	base := cfg.PublicBaseURL
+	if base == "" {
+		scheme := "http"
+		if cfg.UseSSL {
+			scheme = "https"
+		}
+		base = scheme + "://" + cfg.Endpoint + "/" + cfg.Bucket
+	}
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = escapeKeySegment(seg)
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.Join(segments, "/")
+}
+
+// userStorageLimit is the user's storage quota in bytes (user.storage_limit),
+// or db.DefaultStorageLimit when the user has no row yet.
+func userStorageLimit(ctx context.Context, conn *sql.DB, uid string) (int64, error) {
+	var limit int64
+	err := conn.QueryRowContext(ctx, `SELECT storage_limit FROM user WHERE firebase_uid = ?`, uid).Scan(&limit)
+	if err == sql.ErrNoRows {
+		return db.DefaultStorageLimit, nil
+	}
+	return limit, err
+}
+
+// UserStorageUsage is the storage the user's quota is charged for: their
+// files, plus trashed blobs no file references any more, which stay in the
+// bucket until the trash is purged. A blob trashed more than once is counted
+// once. Every quota check, and /me's storage_used, goes through here.
+func UserStorageUsage(ctx context.Context, conn *sql.DB, uid string) (int64, error) {
+	var usage int64
+	err := conn.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COALESCE(SUM(size), 0) FROM file WHERE user_firebase_uid = ?)
+			+ (SELECT COALESCE(SUM(size), 0) FROM (
+				SELECT MAX(t.size) AS size
+				FROM file_trash t
+				WHERE t.user_firebase_uid = ?
+				AND NOT EXISTS (SELECT 1 FROM file f WHERE f.storage_path = t.storage_path)
+				GROUP BY t.storage_path
+			))
+	`, uid, uid).Scan(&usage)
+	return usage, err
+}
+
+// checkObjectKeyLength rejects keys over MAX_OBJECT_KEY_LENGTH before they
+// reach MinIO, which would otherwise fail the upload with an opaque error.
+func checkObjectKeyLength(cfg config.MinioConfig, key string) error {
+	if len(key) <= cfg.MaxObjectKeyLength {
+		return nil
+	}
+	return apiError(http.StatusBadRequest, apierror.InvalidRequest,
+		"object key would be "+strconv.Itoa(len(key))+" bytes, over the "+strconv.Itoa(cfg.MaxObjectKeyLength)+"-byte limit; use a shorter filename")
+}
+
+// uploadFormOverhead is how far a multipart upload request may exceed
+// MAX_UPLOAD_BYTES: boundaries, part headers and the other form fields.
+const uploadFormOverhead = 64 * 1024
+
+// checkUploadRequestSize rejects an upload request whose Content-Length
+// already shows the file is over MAX_UPLOAD_BYTES, before the form is parsed.
+func checkUploadRequestSize(c fiber.Ctx, cfg config.MinioConfig) error {
+	if cfg.MaxUploadBytes == 0 {
+		return nil
+	}
+	if n := int64(c.Request().Header.ContentLength()); n > cfg.MaxUploadBytes+uploadFormOverhead {
+		return apiError(http.StatusRequestEntityTooLarge, apierror.FileTooLarge,
+			"request body is "+strconv.FormatInt(n, 10)+" bytes, over the "+strconv.FormatInt(cfg.MaxUploadBytes, 10)+"-byte upload limit")
+	}
+	return nil
+}
+
+// checkUploadSize rejects a file of size bytes when it is over
+// MAX_UPLOAD_BYTES.
+func checkUploadSize(cfg config.MinioConfig, size int64) error {
+	if cfg.MaxUploadBytes == 0 || size <= cfg.MaxUploadBytes {
+		return nil
+	}
+	return apiError(http.StatusRequestEntityTooLarge, apierror.FileTooLarge,
+		"file is "+strconv.FormatInt(size, 10)+" bytes, over the "+strconv.FormatInt(cfg.MaxUploadBytes, 10)+"-byte upload limit")
+}
+
+// extractKeyFromStoragePath extracts the MinIO object key from an s3:// storage path.
+// It handles cases where the bucket name might not match the config by parsing the URL directly.
+func extractKeyFromStoragePath(storagePath string, expectedBucket string) (string, error) {
+	if !strings.HasPrefix(storagePath, "s3://") {
+		return "", apiError(http.StatusBadRequest, apierror.InvalidRequest, "storage path is not an s3:// URL")
+	}
+
+	// Remove s3:// prefix
+	path := strings.TrimPrefix(storagePath, "s3://")
+
+	// Split by / to get bucket and key parts
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) < 2 {
+		return "", apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid s3:// URL format")
+	}
+
+	bucket := parts[0]
+	key := parts[1]
+
+	// Log if bucket doesn't match (for debugging) but still return the key
+	if bucket != expectedBucket {
+		log.Printf("warning: storage path bucket (%s) doesn't match config bucket (%s), using extracted key anyway", bucket, expectedBucket)
+	}
+
+	// Remove any leading/trailing slashes from key
+	key = strings.Trim(key, "/")
+
+	if key == "" {
+		return "", apiError(http.StatusBadRequest, apierror.InvalidRequest, "empty key extracted from storage path")
+	}
+
+	return key, nil
+}
+
+// setUserMetadataHeaders echoes the object's user metadata named in
+// SERVE_USER_METADATA as X-Amz-Meta-* headers, exposed to cross-origin
+// scripts too.
+func setUserMetadataHeaders(c fiber.Ctx, cfg config.MinioConfig, metadata map[string]string) {
+	if len(cfg.ServeUserMetadata) == 0 || len(metadata) == 0 {
+		return
+	}
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		if slices.Contains(cfg.ServeUserMetadata, "*") || slices.Contains(cfg.ServeUserMetadata, strings.ToLower(name)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		header := "X-Amz-Meta-" + name
+		c.Set(header, metadata[name])
+		c.Append("Access-Control-Expose-Headers", header)
+	}
+}
+
+// activeContentTypes can run script when a browser renders them, so an
+// uploaded one served inline from this origin is stored XSS.
+var activeContentTypes = []string{
+	"text/html",
+	"application/xhtml+xml",
+	"image/svg+xml",
+	"text/xml",
+	"application/xml",
+}
+
+// mediaType is a Content-Type without parameters, lowercased.
+func mediaType(contentType string) string {
+	t, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+func isActiveContentType(contentType string) bool {
+	return slices.Contains(activeContentTypes, mediaType(contentType))
+}
+
+// serveFileFromMinIO is a helper function to serve a file directly from MinIO
+func serveFileFromMinIO(c fiber.Ctx, ctx context.Context, client *minio.Client, cfg config.MinioConfig, f db.File, key string) error {
+	// Ensure CORS headers are set even if errors occur
+	c.Set("Access-Control-Allow-Origin", "*")
+	c.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Set("Access-Control-Allow-Headers", "*")
+
+	log.Printf("serveFileFromMinIO: bucket=%s, key=%s, file_id=%s", cfg.Bucket, key, f.ID)
+
+	// Create a context with longer timeout for MinIO operations (30 seconds)
+	minioCtx, minioCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer minioCancel()
+
+	// Get object from MinIO
+	obj, err := client.GetObject(minioCtx, cfg.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		log.Printf("serveFileFromMinIO: GetObject error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+		return mapMinioError(err, "failed to fetch file from storage")
+	}
+	defer obj.Close()
+
+	// Get object info for content type
+	objInfo, err := obj.Stat()
+	if err != nil {
+		log.Printf("serveFileFromMinIO: Stat error: %v, using DB metadata, bucket=%s, key=%s", err, cfg.Bucket, key)
+		// A missing object can't be streamed either; otherwise continue
+		// with the file metadata from the DB
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found on storage")
+		}
+	}
+
+	// Set headers before streaming
+	contentType := f.MimeType
+	if contentType == "" && err == nil {
+		contentType = objInfo.ContentType
+	}
+	// Records stored before the override table existed may carry a wrong type
+	contentType = normalizeContentType(cfg, f.Filename, contentType)
+	// Per-file overrides set via PATCH /frontend/files/:file_id win
+	disposition := "inline"
+	if f.ContentTypeOverride != "" {
+		contentType = f.ContentTypeOverride
+		if contentType == "application/octet-stream" {
+			disposition = "attachment"
+		}
+	}
+	cacheControl := "public, max-age=3600"
+	if f.CacheControl != "" {
+		cacheControl = f.CacheControl
+	}
+
+	size := f.Size
+	if err == nil {
+		size = objInfo.Size
+	}
+
+	// Uploads are untrusted: never let browsers sniff another type, and
+	// download active content instead of rendering it on this origin
+	if isActiveContentType(contentType) && !slices.Contains(cfg.InlineAllowedTypes, mediaType(contentType)) {
+		disposition = "attachment"
+	}
+
+	c.Set("Content-Type", contentType)
+	c.Set("Content-Disposition", disposition+`; filename="`+downloadFilename(cfg, f, key)+`"`)
+	c.Set("X-Content-Type-Options", "nosniff")
+	c.Set("Cache-Control", cacheControl)
+	if err == nil {
+		setUserMetadataHeaders(c, cfg, objInfo.UserMetadata)
+	}
+
+	// Objects stored gzip-compressed are sent as-is to clients that accept
+	// gzip and decompressed on the fly otherwise. Byte ranges of the original
+	// content can't be served from the compressed object.
+	if f.ContentEncoding == "gzip" {
+		var storedSize int64
+		if err == nil {
+			storedSize = objInfo.Size
+		}
+		return serveGzipObject(c, cfg, obj, key, storedSize)
+	}
+
+	// Advertise range support on every response so media players know they can seek
+	c.Set("Accept-Ranges", "bytes")
+	c.Append("Access-Control-Expose-Headers", "Accept-Ranges", "Content-Range", "Content-Length")
+
+	// Single byte ranges (bytes=a-b, bytes=a-, bytes=-n); multi-range requests
+	// get the full body.
+	if rangeHeader := c.Get("Range"); rangeHeader != "" {
+		start, end, ok, satisfiable := parseByteRange(rangeHeader, size)
+		if ok && !satisfiable {
+			c.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+			return apiError(http.StatusRequestedRangeNotSatisfiable, apierror.InvalidRequest, "requested range not satisfiable")
+		}
+		if ok {
+			if _, err := obj.Seek(start, io.SeekStart); err != nil {
+				log.Printf("serveFileFromMinIO: Seek error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+				return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to fetch file from storage")
+			}
+			length := end - start + 1
+			c.Status(http.StatusPartialContent)
+			c.Set("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10)+"/"+strconv.FormatInt(size, 10))
+			c.Set("Content-Length", strconv.FormatInt(length, 10))
+
+			log.Printf("serveFileFromMinIO: streaming range %d-%d/%d, bucket=%s, key=%s", start, end, size, cfg.Bucket, key)
+			if _, err := io.CopyN(c.Response().BodyWriter(), obj, length); err != nil {
+				log.Printf("serveFileFromMinIO: Copy error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+				return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to stream file from storage")
+			}
+			return nil
+		}
+	}
+
+	// Always sent, so empty files get an explicit Content-Length: 0
+	c.Set("Content-Length", strconv.FormatInt(size, 10))
+
+	log.Printf("serveFileFromMinIO: streaming file, contentType=%s, size=%d, bucket=%s, key=%s", contentType, size, cfg.Bucket, key)
+
+	// Stream the file directly instead of reading into memory
+	// This is more efficient and handles large files better
+	_, err = io.Copy(c.Response().BodyWriter(), obj)
+	if err != nil {
+		log.Printf("serveFileFromMinIO: Copy error: %v, bucket=%s, key=%s", err, cfg.Bucket, key)
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to stream file from storage")
+	}
 
 	log.Printf("serveFileFromMinIO: successfully streamed file, bucket=%s, key=%s", cfg.Bucket, key)
 	return nil
 }
 
+// parseByteRange parses a single-range Range header against a resource of the
+// given size and returns the inclusive byte offsets. ok is false when the
+// header should be ignored (malformed or multi-range); satisfiable is false
+// when it is well-formed but lies outside the resource (416).
+func parseByteRange(header string, size int64) (start, end int64, ok, satisfiable bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, false
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, false
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, true, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, false
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, true, false
+	}
+	return start, end, true, true
+}
+
 // serveImageSize is a helper function that serves an image at a specific size using imgproxy.
 // It loads the file from the database, validates it's an image, and proxies the request to imgproxy.
-func serveImageSize(c fiber.Ctx, cfg config.MinioConfig, client *minio.Client, fileID string, height int, sizeName string) error {
+// Generated images are kept in cache (if enabled) so repeat requests skip imgproxy.
+func serveImageSize(c fiber.Ctx, cfg config.MinioConfig, client *minio.Client, cache *thumbcache.Cache, fileID string, sizeName string) error {
+	return serveImageTransform(c, cfg, client, cache, fileID, sizeName, "webp", true)
+}
+
+// serveImageTransform proxies a file through imgproxy at a preset size and
+// output format. Presets are resolved in the file's project, so a project's
+// custom presets override the global ones. With originalIfSmaller, images
+// known to already fit the preset are served as stored instead, since
+// imgproxy would only re-encode them; the size routes allow this, but
+// /transform promises the requested format.
+func serveImageTransform(c fiber.Ctx, cfg config.MinioConfig, client *minio.Client, cache *thumbcache.Cache, fileID string, sizeName, format string, originalIfSmaller bool) error {
 	if fileID == "" {
-		return fiber.NewError(http.StatusBadRequest, "file_id is required")
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	// Use a short timeout for DB query
@@ -801,31 +1511,22 @@ func serveImageSize(c fiber.Ctx, cfg config.MinioConfig, client *minio.Client, f
 	defer dbCancel()
 
 	var f db.File
-	if err := conn.QueryRowContext(dbCtx, `
-		SELECT id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path, content_hash
+	if err := db.ScanFile(conn.QueryRowContext(dbCtx, `
+		SELECT `+db.FileColumns+`
 		FROM file
 		WHERE id = ?
-	`, fileID).Scan(
-		&f.ID,
-		&f.Filename,
-		&f.Size,
-		&f.MimeType,
-		&f.CreatedAt,
-		&f.ProjectID,
-		&f.UserFirebaseUID,
-		&f.StoragePath,
-		&f.ContentHash,
-	); err != nil {
+	`, fileID), &f); err != nil {
 		if err == sql.ErrNoRows {
-			return fiber.NewError(http.StatusNotFound, "File not found")
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
 		}
-		return fiber.NewError(http.StatusInternalServerError, "failed to load file")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
 	}
 
 	// Only generate images for image files
+	f.MimeType = normalizeContentType(cfg, f.Filename, f.MimeType)
 	if !strings.HasPrefix(f.MimeType, "image/") {
 		log.Printf("%s: skipping non-image file: id=%s, mime_type=%s, storage_path=%s", sizeName, f.ID, f.MimeType, f.StoragePath)
-		return fiber.NewError(http.StatusBadRequest, "Image sizes are only available for image files")
+		return apiError(http.StatusBadRequest, apierror.NotAnImage, "Image sizes are only available for image files")
 	}
 
 	// If it's an S3 path, proxy image from imgproxy
@@ -835,64 +1536,74 @@ func serveImageSize(c fiber.Ctx, cfg config.MinioConfig, client *minio.Client, f
 			log.Printf("%s: failed to extract key from storage path: %v", sizeName, err)
 			return err
 		}
-		log.Printf("%s: start: fileID=%s, mime_type=%s, storagePath=%s, bucket=%s, extracted key=%s, imgproxy_base=%s",
-			sizeName, f.ID, f.MimeType, f.StoragePath, cfg.Bucket, key, cfg.ImgproxyURL)
-		imageURL := buildImgproxyURLWithOptions(cfg, key, "fit", 0, height, "webp")
-		log.Printf("%s: requesting imgproxy URL=%s", sizeName, imageURL)
 
-		// Create a context tied to the request context with longer timeout
-		imgproxyCtx, imgproxyCancel := context.WithTimeout(c.Context(), 30*time.Second)
-		defer imgproxyCancel()
-
-		// Proxy request to imgproxy (internal service)
-		req, err := http.NewRequestWithContext(imgproxyCtx, "GET", imageURL, nil)
-		if err != nil {
-			log.Printf("%s proxy request error: %v", sizeName, err)
-			return fiber.NewError(http.StatusInternalServerError, "failed to create image request")
+		// imgproxy can't read gzip-stored objects (e.g. compressed SVGs);
+		// vector images are resolution-independent, so serve the original.
+		// Empty files have nothing to resize and are served as they are too.
+		if f.ContentEncoding != "" || f.Size == 0 {
+			return serveFileFromMinIO(c, context.Background(), client, cfg, f, key)
 		}
 
-		httpClient := &http.Client{
-			Timeout: 30 * time.Second,
+		width, height, ok := resolvePreset(dbCtx, conn, cfg, f.ProjectID, sizeName)
+		if !ok {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid preset")
 		}
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			log.Printf("%s proxy error: %v", sizeName, err)
-			return fiber.NewError(http.StatusServiceUnavailable, "Image service unavailable")
+		if originalIfSmaller && fitsPreset(f, width, height) {
+			c.Set("X-Image-Source", "original")
+			return serveFileFromMinIO(c, context.Background(), client, cfg, f, key)
 		}
-		defer resp.Body.Close()
 
-		log.Printf("%s: imgproxy response status=%d", sizeName, resp.StatusCode)
+		// The file was just looked up, so a cached image is never served for a deleted file.
+		// Dimensions are part of the cache key so changing a project preset takes effect immediately.
+		expectedType := formatContentType(format)
+		cacheVariant := presetCacheVariant(sizeName, width, height)
 
-		// If imgproxy fails, log details and propagate an error
-		if resp.StatusCode != http.StatusOK {
-			bodyPreview, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-
-			log.Printf("%s: imgproxy error: status=%d, fileID=%s, key=%s, bucket=%s, body_preview=%q",
-				sizeName, resp.StatusCode, fileID, key, cfg.Bucket, string(bodyPreview))
+		// The rendering only depends on the content and the imgproxy options,
+		// so a matching ETag saves the imgproxy round trip entirely
+		etag := imageETag(cfg, f.ContentHash, cacheVariant, format)
+		if etag != "" && etagMatches(c.Get("If-None-Match"), etag) {
+			c.Set("ETag", etag)
+			c.Set("Cache-Control", "public, max-age=3600")
+			return c.SendStatus(http.StatusNotModified)
+		}
 
-			if resp.StatusCode == http.StatusNotFound {
-				return fiber.NewError(http.StatusNotFound, "Image not found")
+		body, ok := cache.Get(f.ID, cacheVariant, format)
+		if !ok {
+			// A derivative stored by generate-derivatives also skips imgproxy
+			if body, ok = loadDerivative(dbCtx, conn, client, cfg, f, sizeName, format, width, height); ok {
+				cache.Put(f.ID, cacheVariant, format, body)
 			}
-
-			return fiber.NewError(http.StatusBadGateway, "Image service error")
 		}
-
-		// Set headers from imgproxy response
-		contentType := resp.Header.Get("Content-Type")
+		if ok {
+			c.Set("Content-Type", expectedType)
+			c.Set("Cache-Control", "public, max-age=3600")
+			c.Set("Content-Disposition", `inline; filename="`+sizeName+`_`+downloadFilename(cfg, f, key)+`"`)
+			c.Set("X-Cache", "HIT")
+			if etag != "" {
+				c.Set("ETag", etag)
+			}
+			return c.Send(body)
+		}
+		log.Printf("%s: start: fileID=%s, mime_type=%s, storagePath=%s, bucket=%s, extracted key=%s, imgproxy_base=%s",
+			sizeName, f.ID, f.MimeType, f.StoragePath, cfg.Bucket, key, cfg.ImgproxyURL)
+		body, contentType, err := fetchImgproxyImage(c.Context(), cfg, key, width, height, format, sizeName)
+		if err != nil {
+			return err
+		}
 		if contentType == "" {
-			contentType = "image/webp"
+			contentType = expectedType
 		}
 		c.Set("Content-Type", contentType)
 		c.Set("Cache-Control", "public, max-age=3600")
-		c.Set("Content-Disposition", `inline; filename="`+sizeName+`_`+f.Filename+`"`)
+		c.Set("Content-Disposition", `inline; filename="`+sizeName+`_`+downloadFilename(cfg, f, key)+`"`)
 
-		// Read the entire body and send it - SendStream might have issues with http.Response.Body
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("%s: failed to read imgproxy response body: %v", sizeName, err)
-			return fiber.NewError(http.StatusInternalServerError, "failed to read image")
+		if contentType == expectedType {
+			cache.Put(f.ID, cacheVariant, format, body)
+		}
+		c.Set("X-Cache", "MISS")
+		if etag != "" && contentType == expectedType {
+			c.Set("ETag", etag)
 		}
-
 		return c.Send(body)
 	}
 
@@ -901,14 +1612,112 @@ func serveImageSize(c fiber.Ctx, cfg config.MinioConfig, client *minio.Client, f
 		return c.SendFile(f.StoragePath)
 	}
 
-	return fiber.NewError(http.StatusNotFound, "File not found on storage")
+	return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found on storage")
+}
+
+// imgproxySlots caps concurrent imgproxy requests at IMGPROXY_MAX_CONCURRENCY
+// (nil when unlimited). It is sized on first use.
+var (
+	imgproxySlotsOnce sync.Once
+	imgproxySlots     chan struct{}
+)
+
+// acquireImgproxySlot waits up to IMGPROXY_QUEUE_TIMEOUT for a free imgproxy
+// request slot and returns the function releasing it, or a 503 when the wait
+// times out.
+func acquireImgproxySlot(ctx context.Context, cfg config.MinioConfig, sizeName string) (func(), error) {
+	imgproxySlotsOnce.Do(func() {
+		if cfg.ImgproxyMaxConcurrency > 0 {
+			imgproxySlots = make(chan struct{}, cfg.ImgproxyMaxConcurrency)
+		}
+	})
+	if imgproxySlots == nil {
+		return func() {}, nil
+	}
+
+	timer := time.NewTimer(cfg.ImgproxyQueueTimeout)
+	defer timer.Stop()
+	select {
+	case imgproxySlots <- struct{}{}:
+		return func() { <-imgproxySlots }, nil
+	case <-timer.C:
+		log.Printf("%s: no imgproxy slot free after %s (%d in flight)", sizeName, cfg.ImgproxyQueueTimeout, cap(imgproxySlots))
+		return nil, apiError(http.StatusServiceUnavailable, apierror.ImageServiceError, "Image service busy, try again later")
+	case <-ctx.Done():
+		return nil, apiError(http.StatusServiceUnavailable, apierror.ImageServiceError, "Image service busy, try again later")
+	}
+}
+
+// fetchImgproxyImage renders an object through imgproxy (internal service) and
+// returns the image bytes and imgproxy's Content-Type. At most
+// IMGPROXY_MAX_CONCURRENCY requests run at once; the rest queue.
+func fetchImgproxyImage(ctx context.Context, cfg config.MinioConfig, key string, width, height int, format, sizeName string) ([]byte, string, error) {
+	return fetchImgproxyURL(ctx, cfg, buildImgproxyURLWithOptions(cfg, key, "fit", width, height, format), key, sizeName)
+}
+
+// fetchImgproxyURL is fetchImgproxyImage for an already built imgproxy URL
+// rendering key.
+func fetchImgproxyURL(ctx context.Context, cfg config.MinioConfig, imageURL, key, sizeName string) ([]byte, string, error) {
+	release, err := acquireImgproxySlot(ctx, cfg, sizeName)
+	if err != nil {
+		return nil, "", err
+	}
+	defer release()
+
+	log.Printf("%s: requesting imgproxy URL=%s", sizeName, imageURL)
+
+	// Create a context tied to the caller's context with longer timeout
+	imgproxyCtx, imgproxyCancel := context.WithTimeout(ctx, 30*time.Second)
+	defer imgproxyCancel()
+
+	req, err := http.NewRequestWithContext(imgproxyCtx, "GET", imageURL, nil)
+	if err != nil {
+		log.Printf("%s proxy request error: %v", sizeName, err)
+		return nil, "", apiError(http.StatusInternalServerError, apierror.InternalError, "failed to create image request")
+	}
+
+	httpClient := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("%s proxy error: %v", sizeName, err)
+		return nil, "", apiError(http.StatusServiceUnavailable, apierror.ImageServiceError, "Image service unavailable")
+	}
+	defer resp.Body.Close()
+
+	log.Printf("%s: imgproxy response status=%d", sizeName, resp.StatusCode)
+
+	// If imgproxy fails, log details and propagate an error
+	if resp.StatusCode != http.StatusOK {
+		bodyPreview, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		log.Printf("%s: imgproxy error: status=%d, key=%s, bucket=%s, body_preview=%q",
+			sizeName, resp.StatusCode, key, cfg.Bucket, string(bodyPreview))
+
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, "", apiError(http.StatusNotFound, apierror.FileNotFound, "Image not found")
+		}
+
+		return nil, "", apiError(http.StatusBadGateway, apierror.ImageServiceError, "Image service error")
+	}
+
+	// Read the entire body - SendStream might have issues with http.Response.Body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("%s: failed to read imgproxy response body: %v", sizeName, err)
+		return nil, "", apiError(http.StatusInternalServerError, apierror.InternalError, "failed to read image")
+	}
+
+	return body, resp.Header.Get("Content-Type"), nil
 }
 
 // RegisterPublicFileRoutes registers /files/:file_id to serve downloads by DB ID.
 // Files are proxied from MinIO instead of redirecting, so the frontend never accesses MinIO directly.
-func RegisterPublicFileRoutes(router fiber.Router, client *minio.Client, cfg config.MinioConfig) {
+// Generated images are cached in cache (nil disables caching).
+func RegisterPublicFileRoutes(router fiber.Router, client *minio.Client, cfg config.MinioConfig, cache *thumbcache.Cache) {
 	// GET /files/:file_id - serve file (proxied from MinIO)
-	router.Get("/:file_id", func(c fiber.Ctx) error {
+	router.Get("/:file_id", withFileAccessLog(func(c fiber.Ctx) error {
 		// Set CORS headers explicitly for all responses (including errors)
 		c.Set("Access-Control-Allow-Origin", "*")
 		c.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
@@ -916,12 +1725,12 @@ func RegisterPublicFileRoutes(router fiber.Router, client *minio.Client, cfg con
 
 		if client == nil {
 			log.Printf("public file: MinIO client is nil")
-			return fiber.NewError(http.StatusInternalServerError, "storage service unavailable")
+			return apiError(http.StatusInternalServerError, apierror.StorageError, "storage service unavailable")
 		}
 		fileID := c.Params("file_id")
 		if fileID == "" {
 			log.Printf("public file: empty file_id")
-			return fiber.NewError(http.StatusBadRequest, "file_id is required")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
 		}
 
 		log.Printf("public file: request for file_id=%s", fileID)
@@ -929,7 +1738,7 @@ func RegisterPublicFileRoutes(router fiber.Router, client *minio.Client, cfg con
 		conn, err := db.GetDB()
 		if err != nil {
 			log.Printf("public file: database connection error: %v", err)
-			return fiber.NewError(http.StatusInternalServerError, "database not available")
+			return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 		}
 
 		// Use request context for DB query (short timeout)
@@ -937,27 +1746,17 @@ func RegisterPublicFileRoutes(router fiber.Router, client *minio.Client, cfg con
 		defer dbCancel()
 
 		var f db.File
-		if err := conn.QueryRowContext(dbCtx, `
-			SELECT id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path, content_hash
+		if err := db.ScanFile(conn.QueryRowContext(dbCtx, `
+			SELECT `+db.FileColumns+`
 			FROM file
 			WHERE id = ?
-		`, fileID).Scan(
-			&f.ID,
-			&f.Filename,
-			&f.Size,
-			&f.MimeType,
-			&f.CreatedAt,
-			&f.ProjectID,
-			&f.UserFirebaseUID,
-			&f.StoragePath,
-			&f.ContentHash,
-		); err != nil {
+		`, fileID), &f); err != nil {
 			if err == sql.ErrNoRows {
 				log.Printf("public file: file not found in database: file_id=%s", fileID)
-				return fiber.NewError(http.StatusNotFound, "File not found")
+				return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
 			}
 			log.Printf("public file: database query error: %v, file_id=%s", err, fileID)
-			return fiber.NewError(http.StatusInternalServerError, "failed to load file")
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
 		}
 
 		log.Printf("public file: loaded file from DB: id=%s, storage_path=%s", f.ID, f.StoragePath)
@@ -968,7 +1767,7 @@ func RegisterPublicFileRoutes(router fiber.Router, client *minio.Client, cfg con
 			key, err := extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
 			if err != nil {
 				log.Printf("public file: failed to extract key from storage path: %v, storage_path=%s", err, f.StoragePath)
-				return fiber.NewError(http.StatusInternalServerError, "invalid storage path")
+				return apiError(http.StatusInternalServerError, apierror.StorageError, "invalid storage path")
 			}
 			log.Printf("public file: serving from MinIO: storage_path=%s, extracted_key=%s", f.StoragePath, key)
 			if err := serveFileFromMinIO(c, context.Background(), client, cfg, f, key); err != nil {
@@ -986,64 +1785,238 @@ func RegisterPublicFileRoutes(router fiber.Router, client *minio.Client, cfg con
 		}
 
 		log.Printf("public file: file not found on storage: storage_path=%s", f.StoragePath)
-		return fiber.NewError(http.StatusNotFound, "File not found on storage")
-	})
+		return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found on storage")
+	}))
+
+	// GET /files/:file_id/raw - original bytes as an attachment, never transformed
+	router.Get("/:file_id/raw", withFileAccessLog(func(c fiber.Ctx) error {
+		return serveRawFile(c, client, cfg)
+	}))
 
 	// GET /files/:file_id/thumbnail - serve thumbnail using imgproxy
 	router.Get("/:file_id/thumbnail", func(c fiber.Ctx) error {
-		return serveImageSize(c, cfg, client, c.Params("file_id"), 120, "thumbnail")
+		return serveImageSize(c, cfg, client, cache, c.Params("file_id"), "thumbnail")
 	})
 
 	// GET /files/:file_id/medium - serve medium-sized image using imgproxy
 	router.Get("/:file_id/medium", func(c fiber.Ctx) error {
-		return serveImageSize(c, cfg, client, c.Params("file_id"), 320, "medium")
+		return serveImageSize(c, cfg, client, cache, c.Params("file_id"), "medium")
 	})
 
-	// GET /files/:file_id/preview - serve preview-sized image using imgproxy
+	// GET /files/:file_id/preview - preview-sized image using imgproxy, or the
+	// first ?bytes= of a text file
 	router.Get("/:file_id/preview", func(c fiber.Ctx) error {
-		return serveImageSize(c, cfg, client, c.Params("file_id"), 720, "preview")
+		return serveFilePreview(c, client, cfg, cache)
 	})
 
 	// GET /files/:file_id/full - serve full-sized (but bounded) image using imgproxy
 	router.Get("/:file_id/full", func(c fiber.Ctx) error {
-		return serveImageSize(c, cfg, client, c.Params("file_id"), 1080, "full")
+		return serveImageSize(c, cfg, client, cache, c.Params("file_id"), "full")
+	})
+
+	// GET /files/:file_id/poster?preset=medium&t=0 - frame of a video file
+	router.Get("/:file_id/poster", func(c fiber.Ctx) error {
+		return serveVideoPoster(c, cfg, client, cache)
+	})
+
+	// GET /files/:file_id/transform?preset=medium&format=webp - any preset/format,
+	// proxied through imgproxy so it never needs to be exposed publicly
+	router.Get("/:file_id/transform", func(c fiber.Ctx) error {
+		if err := checkTransformQuery(c); err != nil {
+			return err
+		}
+		preset := c.Query("preset", "medium")
+		if !presetNamePattern.MatchString(preset) {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid preset")
+		}
+		format := c.Query("format", "webp")
+		if !isAllowedFormat(format) {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid format")
+		}
+		return serveImageTransform(c, cfg, client, cache, c.Params("file_id"), preset, format, false)
+	})
+}
+
+// RegisterBlobRoutes registers /blob/:hash, a content-addressed URL for a
+// stored blob. The URL never changes for the same content, so responses are
+// marked immutable. The caller must own at least one file with that hash.
+func RegisterBlobRoutes(router fiber.Router, client *minio.Client, cfg config.MinioConfig) {
+	router.Use(auth.FirebaseAuthMiddleware())
+
+	router.Get("/:hash", func(c fiber.Ctx) error {
+		user, err := auth.GetCurrentFirebaseUser(c)
+		if err != nil {
+			return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+		}
+
+		hash := strings.ToLower(c.Params("hash"))
+		if !isSHA256Hex(hash) {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "hash must be a hex-encoded SHA-256")
+		}
+
+		// Content never changes for a hash, so a matching ETag is always current
+		etag := `"` + hash + `"`
+		if c.Get("If-None-Match") == etag {
+			return c.SendStatus(http.StatusNotModified)
+		}
+
+		conn, err := db.GetDB()
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+		}
+
+		dbCtx, dbCancel := context.WithTimeout(c.Context(), 5*time.Second)
+		defer dbCancel()
+
+		// Other users' files are reported as not found so hashes can't be probed
+		var f db.File
+		if err := db.ScanFile(conn.QueryRowContext(dbCtx, `
+			SELECT `+db.FileColumns+`
+			FROM file
+			WHERE content_hash = ? AND user_firebase_uid = ?
+			ORDER BY created_at
+			LIMIT 1
+		`, hash, user.UID), &f); err != nil {
+			if err == sql.ErrNoRows {
+				return apiError(http.StatusNotFound, apierror.FileNotFound, "Blob not found")
+			}
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load blob")
+		}
+
+		if !strings.HasPrefix(f.StoragePath, "s3://") {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "Blob not found on storage")
+		}
+		key, err := extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.StorageError, "invalid storage path")
+		}
+
+		if err := serveFileFromMinIO(c, context.Background(), client, cfg, f, key); err != nil {
+			return err
+		}
+		// Responses are per-user (auth required), so shared caches must not store them
+		c.Set("Cache-Control", "private, max-age=31536000, immutable")
+		c.Set("ETag", etag)
+		return nil
 	})
 }
 
+// isSHA256Hex reports whether s is a lowercase hex-encoded SHA-256 digest.
+func isSHA256Hex(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
 // buildImgproxyURL creates a signed imgproxy URL using the s3:// scheme.
 // It uses IMGPROXY_KEY and IMGPROXY_SALT (hex-encoded) as described in the
 // imgproxy documentation. If key/salt are not set or invalid, it falls back
-// to an /unsafe URL so that development still works.
+// to an /unsafe URL so that development still works. Mode, size and format
+// come from the IMGPROXY_DEFAULT_* settings.
 func buildImgproxyURL(cfg config.MinioConfig, key string) string {
-	return buildImgproxyURLWithOptions(cfg, key, "fit", 1200, 1200, "webp")
+	return buildImgproxyURLWithOptions(cfg, key, cfg.ImgproxyDefaultMode, cfg.ImgproxyDefaultWidth, cfg.ImgproxyDefaultHeight, cfg.ImgproxyDefaultFormat)
+}
+
+// buildThumbnailURL creates a signed imgproxy URL for the thumbnail preset,
+// for list views that would otherwise load the 1200px imgproxy_url.
+func buildThumbnailURL(cfg config.MinioConfig, key, format string) string {
+	width, height, ok := getPresetDimensions(cfg, "thumbnail")
+	if !ok {
+		return ""
+	}
+	return buildImgproxyURLWithOptions(cfg, key, "fit", width, height, format)
 }
 
 // buildImgproxyURLWithOptions builds a signed imgproxy URL with the provided
 // transform options, after they have been validated.
 func buildImgproxyURLWithOptions(cfg config.MinioConfig, key, mode string, width, height int, format string) string {
+	return signedImgproxyURL(cfg, imgproxyPath(cfg, key, mode, width, height, format))
+}
+
+// signedImgproxyURL is the imgproxy URL of an unsigned path (see imgproxyPath).
+func signedImgproxyURL(cfg config.MinioConfig, path string) string {
+	sig := signImgproxyPath(path)
+	if sig == "" {
+		// Fallback to unsafe mode for development if signing is not configured
+		log.Printf("imgproxy: using unsafe mode (signing not configured), path=%s", path)
+		return cfg.ImgproxyURL + "/unsafe" + path
+	}
+
+	fullURL := cfg.ImgproxyURL + "/" + sig + path
+	log.Printf("imgproxy: built URL: path=%s", path)
+	return fullURL
+}
+
+// imgproxyPath is the unsigned imgproxy path for an object and transform.
+func imgproxyPath(cfg config.MinioConfig, key, mode string, width, height int, format string) string {
 	// Ensure key doesn't have leading slash
 	key = strings.TrimPrefix(key, "/")
 
 	// Source URL in s3:// scheme - when IMGPROXY_USE_S3 is enabled, imgproxy accesses MinIO directly
-	src := "s3://" + cfg.Bucket + "/" + key
+	src := imgproxyPlainSource(cfg.Bucket, key)
 
 	// imgproxy format: when IMGPROXY_USE_S3 is enabled, use plain s3:// URL (not base64-encoded)
 	// Format: /rs:mode:width:height/plain/s3://bucket/key@format
 	// Note: When width is 0, imgproxy auto-calculates width preserving aspect ratio
 	// The /plain/ prefix allows plain text URLs - use the s3:// URL directly
 	resizePart := "/rs:" + mode + ":" + strconv.Itoa(width) + ":" + strconv.Itoa(height)
-	path := resizePart + "/plain/" + src + "@" + format
+	// imgproxy rotates by EXIF orientation by default (IMGPROXY_AUTO_ROTATE) and
+	// nothing here strips that; AUTO_ORIENT makes it explicit per URL.
+	if cfg.AutoOrient {
+		resizePart += "/ar:1"
+	}
+	return resizePart + "/plain/" + src + "@" + format
+}
 
-	sig := signImgproxyPath(path)
-	if sig == "" {
-		// Fallback to unsafe mode for development if signing is not configured
-		log.Printf("imgproxy: using unsafe mode (signing not configured), source=%s", src)
-		return cfg.ImgproxyURL + "/unsafe" + path
+// imgproxyPlainSource is the s3:// source URL of an object for a /plain/
+// imgproxy path. imgproxy percent-decodes the plain source once and then
+// parses it as a URL, taking the object key from its decoded path, so the
+// key is escaped twice: each segment to make a valid URL (spaces, +, #, ?,
+// % and non-ASCII would otherwise be mangled or cut off) and then every %
+// of that, which also keeps an @ in the key from being read as the format
+// separator. Keys of only letters, digits and -._~/ are unchanged.
+func imgproxyPlainSource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = escapeKeySegment(seg)
 	}
+	return strings.ReplaceAll("s3://"+bucket+"/"+strings.Join(segments, "/"), "%", "%25")
+}
 
-	fullURL := cfg.ImgproxyURL + "/" + sig + path
-	log.Printf("imgproxy: built URL: source=%s, path=%s", src, path)
-	return fullURL
+// escapeKeySegment percent-encodes every byte of an object key segment but
+// the RFC 3986 unreserved characters. url.PathEscape leaves @, +, : and
+// others as is.
+func escapeKeySegment(seg string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(seg); i++ {
+		ch := seg[i]
+		if 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '-' || ch == '.' || ch == '_' || ch == '~' {
+			b.WriteByte(ch)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[ch>>4])
+		b.WriteByte(hexDigits[ch&0x0f])
+	}
+	return b.String()
+}
+
+// objectKeyParam returns the :key route parameter decoded. Fiber matches
+// routes on the raw path, so keys with spaces, +, # or non-ASCII characters
+// (and / as %2F) arrive percent-encoded.
+func objectKeyParam(c fiber.Ctx) (string, error) {
+	key, err := url.PathUnescape(c.Params("key"))
+	if err != nil {
+		return "", apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "key is not validly percent-encoded")
+	}
+	return key, nil
 }
 
 // signImgproxyPath computes the HMAC-SHA256 signature for an imgproxy path
@@ -1067,15 +2040,42 @@ func signImgproxyPath(path string) string {
 		return ""
 	}
 
-	mac := hmac.New(sha256.New, key)
 	// Per imgproxy docs, the message is salt + path
-	mac.Write(salt)
-	mac.Write([]byte(path))
-	signature := mac.Sum(nil)
+	signature := hmacSHA256(key, salt, []byte(path))
 
 	return base64.RawURLEncoding.EncodeToString(signature)
 }
 
+// Query limits for the transform endpoints: key is an object key, every
+// other parameter a short token (mode, format, preset, w, h, ...).
+const (
+	maxTransformQueryBytes  = 4096
+	maxTransformKeyLength   = 2048
+	maxTransformParamLength = 64
+)
+
+// checkTransformQuery rejects oversized transform query strings with a 400
+// before any parameter is parsed.
+func checkTransformQuery(c fiber.Ctx) error {
+	uri := c.Request().URI()
+	if len(uri.QueryString()) > maxTransformQueryBytes {
+		return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "query string is too long")
+	}
+	for name, value := range uri.QueryArgs().All() {
+		if len(name) > maxTransformParamLength {
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, "query parameter name is too long")
+		}
+		limit := maxTransformParamLength
+		if string(name) == "key" {
+			limit = maxTransformKeyLength
+		}
+		if len(value) > limit {
+			return apiError(fiber.StatusBadRequest, apierror.InvalidRequest, string(name)+" is too long (max "+strconv.Itoa(limit)+" bytes)")
+		}
+	}
+	return nil
+}
+
 func isAllowedMode(mode string) bool {
 	switch mode {
 	case "fit", "fill", "resize":
@@ -1094,6 +2094,70 @@ func isAllowedFormat(format string) bool {
 	}
 }
 
+// presetCacheVariant names a cached rendering, e.g. "thumbnail-0x120".
+func presetCacheVariant(preset string, width, height int) string {
+	return preset + "-" + strconv.Itoa(width) + "x" + strconv.Itoa(height)
+}
+
+// imageETag is the weak ETag of a preset rendering: a digest of the source
+// content hash, the preset variant (name and dimensions), the output format
+// and the options applied to every transform. Weak because imgproxy output
+// isn't guaranteed byte-identical across versions. Files without a content
+// hash get none.
+func imageETag(cfg config.MinioConfig, contentHash, variant, format string) string {
+	if contentHash == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(contentHash + "\x00" + variant + "\x00" + format + "\x00" + strconv.FormatBool(cfg.AutoOrient)))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// weak comparison as If-None-Match requires.
+func etagMatches(ifNoneMatch, etag string) bool {
+	ifNoneMatch = strings.TrimSpace(ifNoneMatch)
+	if ifNoneMatch == "" {
+		return false
+	}
+	if ifNoneMatch == "*" {
+		return true
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// formatContentType maps an imgproxy output format to its MIME type.
+func formatContentType(format string) string {
+	if format == "jpg" {
+		return "image/jpeg"
+	}
+	return "image/" + format
+}
+
+// sortFileInfos orders a listing by key (MinIO's native order) or by
+// last_modified, optionally reversed. The sort is stable so objects with the
+// same timestamp keep their lexical order.
+func sortFileInfos(files []fileInfo, sortBy string, desc bool) {
+	if sortBy == "" && !desc {
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if desc {
+			a, b = b, a
+		}
+		if sortBy == "last_modified" {
+			return a.LastModified.Before(b.LastModified)
+		}
+		return a.Key < b.Key
+	})
+}
+
 func defaultContentType(ct string) string {
 	ct = strings.TrimSpace(ct)
 	if ct == "" {
@@ -1102,21 +2166,114 @@ func defaultContentType(ct string) string {
 	return ct
 }
 
-// getPresetDimensions maps logical size presets to concrete imgproxy dimensions.
-// Heights are fixed, width=0 so imgproxy computes it and preserves aspect ratio.
-func getPresetDimensions(preset string) (width, height int, ok bool) {
-	switch preset {
-	case "thumbnail":
-		return 0, 120, true
-	case "medium":
-		return 0, 320, true
-	case "preview":
-		return 0, 720, true
-	case "full":
-		return 0, 1080, true
-	default:
-		return 0, 0, false
+// downloadFilename returns the name to put in Content-Disposition, falling
+// back (per cfg.FilenameFallback) to the object key's base name or the file id
+// so downloads are never unnamed.
+func downloadFilename(cfg config.MinioConfig, f db.File, key string) string {
+	if name := strings.TrimSpace(f.Filename); name != "" {
+		return name
+	}
+	if cfg.FilenameFallback != "id" && key != "" {
+		if base := path.Base(key); base != "." && base != "/" {
+			return base
+		}
 	}
+	return f.ID
+}
+
+// normalizeContentType returns the canonical MIME type for a file, preferring
+// the configured extension override table over the type the client reported.
+func normalizeContentType(cfg config.MinioConfig, filename, ct string) string {
+	if override, ok := cfg.ContentTypeOverrides[strings.ToLower(filepath.Ext(filename))]; ok {
+		return override
+	}
+	return defaultContentType(ct)
+}
+
+// sniffLength is how much of an upload http.DetectContentType looks at.
+const sniffLength = 512
+
+// findDedupBlob looks up an existing blob with the given content hash that an
+// upload by uid may reuse: the user's own under DEDUP_SCOPE=per_user, anyone's
+// under global. Empty files all share one hash, so they are never matched.
+// It returns sql.ErrNoRows when there is none.
+func findDedupBlob(ctx context.Context, conn *sql.DB, cfg config.MinioConfig, uid, contentHash string) (storagePath string, size int64, contentEncoding string, err error) {
+	query := `
+		SELECT storage_path, size, COALESCE(content_encoding, '')
+		FROM file
+		WHERE content_hash = ? AND size > 0`
+	args := []any{contentHash}
+	if cfg.DedupScope != "global" {
+		query += ` AND user_firebase_uid = ?`
+		args = append(args, uid)
+	}
+	err = conn.QueryRowContext(ctx, query+`
+		LIMIT 1
+	`, args...).Scan(&storagePath, &size, &contentEncoding)
+	return storagePath, size, contentEncoding, err
+}
+
+// readUploadHead reads an upload's first sniffLength bytes (fewer for
+// smaller files) for content type detection.
+func readUploadHead(src io.Reader) ([]byte, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// hashUpload returns the hex SHA-256 of an upload along with its first
+// sniffLength bytes for content type detection.
+func hashUpload(src io.Reader) (contentHash string, head []byte, err error) {
+	hash := sha256.New()
+	head, err = readUploadHead(src)
+	if err != nil {
+		return "", nil, err
+	}
+	hash.Write(head)
+	if _, err := io.Copy(hash, src); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), head, nil
+}
+
+// uploadContentType is normalizeContentType for uploads, with the type
+// sniffed from the content's first bytes taking over when the client's type
+// is missing or generic (application/octet-stream), or claims an image the
+// content clearly isn't (e.g. HTML or a PDF sent as image/png).
+func uploadContentType(cfg config.MinioConfig, filename, clientType string, head []byte) string {
+	if override, ok := cfg.ContentTypeOverrides[strings.ToLower(filepath.Ext(filename))]; ok {
+		return override
+	}
+	detected := http.DetectContentType(head)
+	detectedBase, _, _ := strings.Cut(detected, ";")
+
+	clientType = strings.TrimSpace(clientType)
+	clientBase, _, _ := strings.Cut(strings.ToLower(clientType), ";")
+	clientBase = strings.TrimSpace(clientBase)
+	switch {
+	case clientBase == "" || clientBase == "application/octet-stream" || clientBase == "binary/octet-stream":
+		if len(head) == 0 {
+			return defaultContentType(clientType)
+		}
+		return detected
+	case strings.HasPrefix(clientBase, "image/") && !strings.HasPrefix(detectedBase, "image/") &&
+		detectedBase != "application/octet-stream" && detectedBase != "text/plain" && detectedBase != "text/xml":
+		// text/plain and text/xml cover SVG; octet-stream covers image
+		// formats the sniffer doesn't know (AVIF, HEIC, ...)
+		log.Printf("upload: %q sent as %s but looks like %s, using the detected type", filename, clientType, detected)
+		return detected
+	}
+	return clientType
+}
+
+// getPresetDimensions maps logical size presets to concrete imgproxy dimensions,
+// from the built-in presets and TRANSFORM_PRESETS.
+func getPresetDimensions(cfg config.MinioConfig, preset string) (width, height int, ok bool) {
+	size, ok := cfg.TransformPresets[preset]
+	return size.Width, size.Height, ok
 }
 
 // trackAPIUsage logs API usage to the apiusage table, mirroring the Python
@@ -1131,7 +2288,7 @@ func trackAPIUsage(ctx context.Context, endpoint string, status int, start time.
 
 	responseTimeMs := float64(time.Since(start)) / float64(time.Millisecond)
 
-	_, err = conn.ExecContext(ctx, `
+	_, err = db.ExecWithRetry(ctx, conn, `
 		INSERT INTO apiusage (timestamp, endpoint, response_time, status_code, user_firebase_uid, project_id, api_key_id)
 		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`, time.Now().UTC(), endpoint, responseTimeMs, status, apiCtx.User.FirebaseUID, apiCtx.Project.ID, apiCtx.APIKey.ID)
@@ -0,0 +1,401 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+func TestUserStorageUsageCountsUnreferencedTrash(t *testing.T) {
+	projectID := createTestProject(t, "usage-user")
+	conn, err := db.GetDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert := func(table, id, path string, size int64) {
+		t.Helper()
+		query := `INSERT INTO ` + table + ` (id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path`
+		if table == "file_trash" {
+			query += `, deleted_at, deleted_by) VALUES (?, 'f', ?, 'text/plain', CURRENT_TIMESTAMP, ?, 'usage-user', ?, CURRENT_TIMESTAMP, 'usage-user')`
+		} else {
+			query += `) VALUES (?, 'f', ?, 'text/plain', CURRENT_TIMESTAMP, ?, 'usage-user', ?)`
+		}
+		if _, err := conn.Exec(query, id, size, projectID, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	insert("file", "live", "s3://bucket/live", 100)
+	// Shares its blob with a live file: already counted
+	insert("file_trash", "dup-of-live", "s3://bucket/live", 100)
+	// Only trashed references left: counted once
+	insert("file_trash", "gone-1", "s3://bucket/gone", 40)
+	insert("file_trash", "gone-2", "s3://bucket/gone", 40)
+
+	usage, err := UserStorageUsage(context.Background(), conn, "usage-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 140 {
+		t.Fatalf("UserStorageUsage = %d, want 140", usage)
+	}
+}
+
+func TestEscapeKeySegment(t *testing.T) {
+	tests := []struct {
+		seg, want string
+	}{
+		{"photo-1_a.b~c.png", "photo-1_a.b~c.png"},
+		{"my photo.png", "my%20photo.png"},
+		{"a+b.png", "a%2Bb.png"},
+		{"#1?.png", "%231%3F.png"},
+		{"100%.png", "100%25.png"},
+		{"me@2x.png", "me%402x.png"},
+		{"café.png", "caf%C3%A9.png"},
+		{"日本.png", "%E6%97%A5%E6%9C%AC.png"},
+	}
+	for _, tt := range tests {
+		if got := escapeKeySegment(tt.seg); got != tt.want {
+			t.Errorf("escapeKeySegment(%q) = %q, want %q", tt.seg, got, tt.want)
+		}
+	}
+}
+
+func TestImgproxyPathPlainSource(t *testing.T) {
+	cfg := config.MinioConfig{Bucket: "uploads"}
+	tests := []struct {
+		key, want string
+	}{
+		{"uploads/7/2024/05/01/photo.png", "/rs:fit:300:0/plain/s3://uploads/uploads/7/2024/05/01/photo.png@webp"},
+		{"7/my photo.png", "/rs:fit:300:0/plain/s3://uploads/7/my%2520photo.png@webp"},
+		{"7/a+b.png", "/rs:fit:300:0/plain/s3://uploads/7/a%252Bb.png@webp"},
+		{"7/#1.png", "/rs:fit:300:0/plain/s3://uploads/7/%25231.png@webp"},
+		{"7/café.png", "/rs:fit:300:0/plain/s3://uploads/7/caf%25C3%25A9.png@webp"},
+		{"7/me@2x.png", "/rs:fit:300:0/plain/s3://uploads/7/me%25402x.png@webp"},
+	}
+	for _, tt := range tests {
+		if got := imgproxyPath(cfg, tt.key, "fit", 300, 0, "webp"); got != tt.want {
+			t.Errorf("imgproxyPath(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestObjectKeyParam(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	app.Get("/:key", func(c fiber.Ctx) error {
+		key, err := objectKeyParam(c)
+		if err != nil {
+			return err
+		}
+		return c.SendString(key)
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		key    string
+	}{
+		{"/7%2F2024%2Fphoto.png", http.StatusOK, "7/2024/photo.png"},
+		{"/my%20photo%2B1.png", http.StatusOK, "my photo+1.png"},
+		{"/a+b.png", http.StatusOK, "a+b.png"},
+		{"/%231.png", http.StatusOK, "#1.png"},
+		{"/caf%C3%A9.png", http.StatusOK, "café.png"},
+		{"/bad%zz.png", http.StatusBadRequest, ""},
+		{"/trailing%2", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		// Set the raw request URI: httptest.NewRequest rejects invalid escapes
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RequestURI = tt.path
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d (%s)", tt.path, resp.StatusCode, tt.status, body)
+			continue
+		}
+		if tt.status == http.StatusOK && string(body) != tt.key {
+			t.Errorf("%s: key %q, want %q", tt.path, body, tt.key)
+		}
+	}
+
+	// Every key escaped segment by segment, / included, decodes back to itself
+	for _, key := range []string{"7/2024/05/01/my photo+1#2 café.png", "a/b/c", "100%/x@y.png"} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/"+escapeKeySegment(key), nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != key {
+			t.Errorf("round trip of %q: %d %q", key, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestCheckObjectKeyLength(t *testing.T) {
+	cfg := config.MinioConfig{
+		StoragePrefix:      strings.Repeat("p", 900),
+		MaxObjectKeyLength: config.MaxObjectKeyLength,
+	}
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	// prefix + "/7/2024/05/01/" is 914 bytes
+	fits := objectKey(cfg, 7, strings.Repeat("a", 106)+".png", now)
+	if len(fits) != config.MaxObjectKeyLength {
+		t.Fatalf("test key is %d bytes, want %d", len(fits), config.MaxObjectKeyLength)
+	}
+	if err := checkObjectKeyLength(cfg, fits); err != nil {
+		t.Fatalf("key of exactly the limit rejected: %v", err)
+	}
+
+	tests := []struct {
+		name, filename string
+	}{
+		{"ascii", strings.Repeat("a", 107) + ".png"},
+		// 40 runes but 120 bytes: the limit is in bytes
+		{"multi-byte", strings.Repeat("日", 40) + ".png"},
+	}
+	for _, tt := range tests {
+		key := objectKey(cfg, 7, tt.filename, now)
+		err := checkObjectKeyLength(cfg, key)
+		if err == nil {
+			t.Errorf("%s: %d-byte key accepted", tt.name, len(key))
+			continue
+		}
+		if got := errorStatus(err); got != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", tt.name, got)
+		}
+		if !strings.Contains(err.Error(), "1024-byte limit") {
+			t.Errorf("%s: message %q doesn't name the limit", tt.name, err.Error())
+		}
+	}
+}
+
+// fakeStatter is an objectStatter over a fixed set of keys.
+type fakeStatter struct {
+	keys  map[string]bool
+	err   error
+	calls int
+}
+
+func (f *fakeStatter) StatObject(_ context.Context, _, key string, _ minio.StatObjectOptions) (minio.ObjectInfo, error) {
+	f.calls++
+	if f.err != nil {
+		return minio.ObjectInfo{}, f.err
+	}
+	if !f.keys[key] {
+		return minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchKey"}
+	}
+	return minio.ObjectInfo{Key: key}, nil
+}
+
+func TestCheckIfNoneMatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		key         string
+		statErr     error
+		want        int
+		wantStat    bool
+	}{
+		{"no header", "", "7/taken.png", nil, 0, false},
+		{"key exists", "*", "7/taken.png", nil, http.StatusPreconditionFailed, true},
+		{"key is free", " * ", "7/free.png", nil, 0, true},
+		{"etag value", `"abc123"`, "7/free.png", nil, http.StatusBadRequest, false},
+		{"weak etag", `W/"abc123"`, "7/free.png", nil, http.StatusBadRequest, false},
+		{"stat fails", "*", "7/free.png", minio.ErrorResponse{Code: "AccessDenied"}, http.StatusBadGateway, true},
+	}
+	for _, tt := range tests {
+		client := &fakeStatter{keys: map[string]bool{"7/taken.png": true}, err: tt.statErr}
+		err := checkIfNoneMatch(context.Background(), client, "uploads", tt.key, tt.ifNoneMatch)
+		if tt.want == 0 && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		} else if tt.want != 0 && (err == nil || errorStatus(err) != tt.want) {
+			t.Errorf("%s: err = %v, want status %d", tt.name, err, tt.want)
+		}
+		if (client.calls > 0) != tt.wantStat {
+			t.Errorf("%s: %d StatObject calls, want stat %v", tt.name, client.calls, tt.wantStat)
+		}
+	}
+}
+
+// newFakeMinio serves objects (keyed by "bucket/key") over the S3 GET and
+// HEAD object calls, enough for serving files.
+func newFakeMinio(t *testing.T, objects map[string][]byte) *minio.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := objects[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		contentType := mime.TypeByExtension(path.Ext(r.URL.Path))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	client, err := minio.New(strings.TrimPrefix(srv.URL, "http://"), &minio.Options{
+		Creds:  credentials.NewStaticV4("test", "testsecret", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestServeEmptyFileContentLength(t *testing.T) {
+	cfg := config.MinioConfig{Bucket: "uploads"}
+	client := newFakeMinio(t, map[string][]byte{"uploads/7/empty.txt": {}})
+	f := db.File{ID: "empty", Filename: "empty.txt", MimeType: "text/plain", StoragePath: "s3://uploads/7/empty.txt"}
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	app.Get("/", func(c fiber.Ctx) error {
+		return serveFileFromMinIO(c, context.Background(), client, cfg, f, "7/empty.txt")
+	})
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Length"); got != "0" {
+		t.Fatalf("Content-Length = %q, want \"0\"", got)
+	}
+	if len(body) != 0 {
+		t.Fatalf("body = %q, want empty", body)
+	}
+}
+
+func TestFindDedupBlobSkipsEmptyFiles(t *testing.T) {
+	projectID := createTestProject(t, "dedup-user")
+	conn, err := db.GetDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	for _, row := range []struct {
+		id, hash string
+		size     int64
+	}{
+		{"empty-1", emptyHash, 0},
+		{"empty-2", emptyHash, 0},
+		{"full", "5f0b6ebc4a1f6d8d6bd5cd9e2bd9ab4f0e7a1ad1b16d4a4c1d0f8c1c3b4a5d6e", 12},
+	} {
+		if _, err := conn.Exec(`
+			INSERT INTO file (id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path, content_hash)
+			VALUES (?, ?, ?, 'text/plain', CURRENT_TIMESTAMP, ?, 'dedup-user', ?, ?)
+		`, row.id, row.id+".txt", row.size, projectID, "s3://uploads/7/"+row.id+".txt", row.hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, scope := range []string{"per_user", "global"} {
+		cfg := config.MinioConfig{DedupScope: scope}
+		if path, _, _, err := findDedupBlob(context.Background(), conn, cfg, "dedup-user", emptyHash); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("%s scope: empty upload deduplicated to %q (err %v)", scope, path, err)
+		}
+		path, _, _, err := findDedupBlob(context.Background(), conn, cfg, "dedup-user", "5f0b6ebc4a1f6d8d6bd5cd9e2bd9ab4f0e7a1ad1b16d4a4c1d0f8c1c3b4a5d6e")
+		if err != nil || path != "s3://uploads/7/full.txt" {
+			t.Errorf("%s scope: non-empty upload not deduplicated: %q, %v", scope, path, err)
+		}
+	}
+}
+
+func TestTransformURL(t *testing.T) {
+	projectID := createTestProject(t, "transform-user")
+	cfg := config.MinioConfig{
+		Bucket:           "uploads",
+		ImgproxyURL:      "http://imgproxy.test",
+		TransformPresets: map[string]config.PresetSize{"thumbnail": {Width: 0, Height: 150}},
+	}
+	client := newFakeMinio(t, map[string][]byte{"uploads/7/photo.png": []byte("\x89PNG\r\n\x1a\n")})
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	app.Use(func(c fiber.Ctx) error {
+		// What auth.APIKeyMiddleware stores for a valid key
+		c.Locals("api_key_ctx", &auth.APIKeyContext{
+			User:    db.User{FirebaseUID: "transform-user"},
+			Project: db.Project{ID: projectID},
+		})
+		return c.Next()
+	})
+	RegisterFileRoutes(app, client, cfg)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"preset and width", "key=7/photo.png&preset=thumbnail&w=300", http.StatusBadRequest},
+		{"preset and height", "key=7/photo.png&preset=thumbnail&h=300", http.StatusBadRequest},
+		{"zero width", "key=7/photo.png&w=0&h=300", http.StatusBadRequest},
+		{"zero height", "key=7/photo.png&w=300&h=0", http.StatusBadRequest},
+		{"negative width", "key=7/photo.png&w=-5", http.StatusBadRequest},
+		{"unknown preset", "key=7/photo.png&preset=huge", http.StatusBadRequest},
+		{"preset alone", "key=7/photo.png&preset=thumbnail", http.StatusOK},
+		{"explicit size", "key=7/photo.png&w=300&h=200", http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/transform-url?"+tt.query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, resp.StatusCode, tt.status, body)
+			continue
+		}
+		if tt.name != "preset alone" {
+			continue
+		}
+		var got struct {
+			URL    string `json:"url"`
+			Width  int    `json:"width"`
+			Height int    `json:"height"`
+			Preset string `json:"preset"`
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Preset != "thumbnail" || got.Width != 0 || got.Height != 150 || !strings.Contains(got.URL, "/rs:fit:0:150/plain/s3://uploads/7/photo.png@webp") {
+			t.Errorf("preset alone: %+v", got)
+		}
+	}
+}
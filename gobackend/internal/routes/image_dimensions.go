@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// maxDimensionsHeader bounds how much of an upload is read to find its
+// dimensions; JPEG metadata (EXIF, ICC profiles) can precede the frame
+// header by tens of kilobytes.
+const maxDimensionsHeader = 1024 * 1024
+
+// dimensionTypes are the image types whose dimensions are recorded.
+var dimensionTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true}
+
+// uploadDimensions returns the width and height of a PNG, JPEG or GIF
+// upload, or nils when the type is another or the header can't be decoded.
+func uploadDimensions(fileHeader *multipart.FileHeader, contentType string) (*int, *int) {
+	if !dimensionTypes[mediaType(contentType)] {
+		return nil, nil
+	}
+	src, err := fileHeader.Open()
+	if err != nil {
+		return nil, nil
+	}
+	defer src.Close()
+	return readDimensions(src, contentType)
+}
+
+// readDimensions is uploadDimensions for content read from r, which is read
+// no further than maxDimensionsHeader.
+func readDimensions(r io.Reader, contentType string) (*int, *int) {
+	if !dimensionTypes[mediaType(contentType)] {
+		return nil, nil
+	}
+	cfg, _, err := image.DecodeConfig(io.LimitReader(r, maxDimensionsHeader))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, nil
+	}
+	return &cfg.Width, &cfg.Height
+}
+
+// fitsPreset reports whether an image of known dimensions already fits in a
+// preset's box (0 leaves that side unbounded), so resizing it would at most
+// re-encode it.
+func fitsPreset(f db.File, width, height int) bool {
+	if f.Width == nil || f.Height == nil {
+		return false
+	}
+	return (width == 0 || *f.Width <= width) && (height == 0 || *f.Height <= height)
+}
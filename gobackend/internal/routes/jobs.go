@@ -0,0 +1,288 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+	"github.com/gabriel/open_upload_gobackend/internal/jobs"
+	"github.com/gabriel/open_upload_gobackend/internal/thumbcache"
+)
+
+// Job types enqueued by the file routes.
+const (
+	jobPregenerateThumbnail = "thumbnail.pregenerate"
+	jobWarmPreset           = "image.warm"
+	jobStoreDerivative      = "image.derivative"
+)
+
+// filePayload is the payload for jobs that act on a single file.
+type filePayload struct {
+	FileID string `json:"file_id"`
+}
+
+// warmPayload is the payload for jobWarmPreset and jobStoreDerivative.
+type warmPayload struct {
+	FileID string `json:"file_id"`
+	Preset string `json:"preset"`
+	Format string `json:"format"`
+}
+
+// RegisterJobHandlers registers handlers for the background jobs enqueued by
+// this package. Call it before jobs.Start.
+func RegisterJobHandlers(client *minio.Client, cfg config.MinioConfig, cache *thumbcache.Cache) {
+	jobs.Register(jobPregenerateThumbnail, func(ctx context.Context, payload json.RawMessage) error {
+		return pregenerateThumbnail(ctx, cfg, cache, payload)
+	})
+	jobs.Register(jobWarmPreset, func(ctx context.Context, payload json.RawMessage) error {
+		var p warmPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		return renderPreset(ctx, cfg, cache, p.FileID, p.Preset, p.Format)
+	})
+	jobs.Register(jobStoreDerivative, func(ctx context.Context, payload json.RawMessage) error {
+		var p warmPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		return storeDerivative(ctx, client, cfg, p.FileID, p.Preset, p.Format)
+	})
+}
+
+// apiUsageCleanupBatch is how many apiusage rows one DELETE removes, so the
+// cleanup never holds the write lock for long.
+const apiUsageCleanupBatch = 5000
+
+// RegisterPeriodicJobs schedules recurring maintenance on the job pool.
+func RegisterPeriodicJobs(pool *jobs.Pool, appCfg config.AppConfig, client *minio.Client, cfg config.MinioConfig) {
+	// Re-run hourly so today's snapshot stays current and a missed day
+	// (e.g. downtime at midnight) is still recorded once the server is up.
+	pool.Every("storage-snapshot", time.Hour, snapshotStorage)
+	pool.Every("trash-purge", time.Hour, func(ctx context.Context) error {
+		return purgeTrash(ctx, client, cfg)
+	})
+	pool.Every("upload-temp-cleanup", time.Hour, func(ctx context.Context) error {
+		return cleanupTempUploads(ctx, client, cfg)
+	})
+	pool.Every("usage-rollup", time.Hour, rollupAPIUsage)
+	webhookClient := newWebhookClient(cfg.WebhookAllowPrivate)
+	pool.Every("webhook-deliveries", webhookPollInterval, func(ctx context.Context) error {
+		return deliverDueWebhooks(ctx, webhookClient)
+	})
+	pool.Every("webhook-delivery-cleanup", 6*time.Hour, cleanupWebhookDeliveries)
+	if appCfg.APIKeyInactiveDays > 0 {
+		pool.Every("apikey-inactivity", time.Hour, func(ctx context.Context) error {
+			return disableInactiveAPIKeys(ctx, appCfg.APIKeyInactiveDays)
+		})
+	}
+	if appCfg.APIUsageRetentionDays > 0 {
+		pool.Every("apiusage-cleanup", 6*time.Hour, func(ctx context.Context) error {
+			return cleanupAPIUsage(ctx, appCfg.APIUsageRetentionDays)
+		})
+	}
+	if appCfg.FileAccessRetentionDays > 0 {
+		pool.Every("file-access-cleanup", 6*time.Hour, func(ctx context.Context) error {
+			return cleanupFileAccess(ctx, appCfg.FileAccessRetentionDays)
+		})
+	}
+}
+
+// cleanupAPIUsage deletes apiusage rows older than retentionDays, in batches,
+// after making sure they are rolled up into usage_daily.
+func cleanupAPIUsage(ctx context.Context, retentionDays int) error {
+	if err := rollupAPIUsage(ctx); err != nil {
+		return fmt.Errorf("rollup before cleanup: %w", err)
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -retentionDays)
+	var total int64
+	for {
+		res, err := db.ExecWithRetry(ctx, conn, `
+			DELETE FROM apiusage
+			WHERE id IN (
+				SELECT id FROM apiusage
+				WHERE timestamp < ?
+				LIMIT ?
+			)
+		`, cutoff, apiUsageCleanupBatch)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		total += n
+		if n < apiUsageCleanupBatch || ctx.Err() != nil {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("apiusage: deleted %d rows older than %s", total, cutoff.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// snapshotStorage records today's storage usage per user and project in
+// storage_snapshot, replacing any earlier snapshot for the same day.
+func snapshotStorage(ctx context.Context) error {
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err = db.ExecWithRetry(ctx, conn, `
+		INSERT INTO storage_snapshot (day, user_firebase_uid, project_id, total_size, file_count, created_at)
+		SELECT ?, user_firebase_uid, project_id, COALESCE(SUM(size), 0), COUNT(*), ?
+		FROM file
+		GROUP BY user_firebase_uid, project_id
+		ON CONFLICT (day, user_firebase_uid, project_id) DO UPDATE SET
+			total_size = excluded.total_size,
+			file_count = excluded.file_count,
+			created_at = excluded.created_at
+	`, now.Format("2006-01-02"), now)
+	return err
+}
+
+// rollupAPIUsage adds every complete day of apiusage that isn't in
+// usage_daily yet. Days are rolled up once, in order, so usage_daily covers
+// everything up to its latest day and apiusage holds the rest. The day is the
+// timestamp's date prefix: SQLite's DATE() can't parse the zone suffix of
+// timestamps written by the Go driver.
+func rollupAPIUsage(ctx context.Context) error {
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, err := usageRolledUpThrough(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if !from.Before(today) {
+		return nil
+	}
+
+	_, err = db.ExecWithRetry(ctx, conn, `
+		INSERT INTO usage_daily (day, user_firebase_uid, project_id, api_calls, total_response_time, success_count)
+		SELECT substr(timestamp, 1, 10), user_firebase_uid, project_id, COUNT(*), COALESCE(SUM(response_time), 0),
+			SUM(CASE WHEN status_code < 400 THEN 1 ELSE 0 END)
+		FROM apiusage
+		WHERE timestamp >= ? AND timestamp < ?
+		GROUP BY substr(timestamp, 1, 10), user_firebase_uid, project_id
+		ON CONFLICT (day, user_firebase_uid, project_id) DO NOTHING
+	`, from, today)
+	return err
+}
+
+// usageRolledUpThrough returns the start of the first day not in usage_daily
+// (the zero time when nothing is rolled up yet).
+func usageRolledUpThrough(ctx context.Context, conn *sql.DB) (time.Time, error) {
+	var last sql.NullString
+	if err := conn.QueryRowContext(ctx, `SELECT MAX(day) FROM usage_daily`).Scan(&last); err != nil {
+		return time.Time{}, err
+	}
+	if !last.Valid {
+		return time.Time{}, nil
+	}
+	day, err := time.Parse("2006-01-02", last.String)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.AddDate(0, 0, 1), nil
+}
+
+// pregenerateThumbnail renders an uploaded image's thumbnail into the cache so
+// the first view doesn't wait on imgproxy.
+func pregenerateThumbnail(ctx context.Context, cfg config.MinioConfig, cache *thumbcache.Cache, payload json.RawMessage) error {
+	if cache == nil {
+		return nil
+	}
+
+	var p filePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	return renderPreset(ctx, cfg, cache, p.FileID, "thumbnail", "webp")
+}
+
+// renderPreset requests a file's preset rendering from imgproxy, which warms
+// imgproxy and anything caching in front of it, and stores the result in
+// cache when enabled. Files that are served without imgproxy are skipped.
+func renderPreset(ctx context.Context, cfg config.MinioConfig, cache *thumbcache.Cache, fileID, preset, format string) error {
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &f); err != nil {
+		if err == sql.ErrNoRows {
+			return nil // deleted before the job ran
+		}
+		return err
+	}
+
+	if !rendersThroughImgproxy(cfg, f) {
+		return nil
+	}
+	key, err := extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
+	if err != nil {
+		return err
+	}
+
+	width, height, ok := resolvePreset(ctx, conn, cfg, f.ProjectID, preset)
+	if !ok {
+		// preset removed with TRANSFORM_PRESETS or from the project
+		return nil
+	}
+	body, contentType, err := fetchImgproxyImage(ctx, cfg, key, width, height, format, preset)
+	if err != nil {
+		return err
+	}
+	if contentType == "" || contentType == formatContentType(format) {
+		cache.Put(f.ID, presetCacheVariant(preset, width, height), format, body)
+	}
+	return nil
+}
+
+// rendersThroughImgproxy reports whether the size routes send f through
+// imgproxy, and so whether it has thumbnails and derivatives to generate.
+// gzip-stored images (SVGs) and empty files are served as they are.
+func rendersThroughImgproxy(cfg config.MinioConfig, f db.File) bool {
+	return strings.HasPrefix(normalizeContentType(cfg, f.Filename, f.MimeType), "image/") &&
+		strings.HasPrefix(f.StoragePath, "s3://") && f.ContentEncoding == "" && f.Size > 0
+}
+
+// enqueueUploadJobs schedules post-upload processing for a new file, including
+// its project's file.uploaded webhooks. Failures are logged only; the upload
+// itself has already succeeded.
+func enqueueUploadJobs(ctx context.Context, fileID, contentType string, size int64) {
+	// Empty files have no thumbnail to render
+	if strings.HasPrefix(contentType, "image/") && size > 0 {
+		if err := jobs.Enqueue(ctx, jobPregenerateThumbnail, filePayload{FileID: fileID}); err != nil {
+			log.Printf("jobs: failed to enqueue %s for file %s: %v", jobPregenerateThumbnail, fileID, err)
+		}
+	}
+	dispatchFileUploaded(ctx, fileID)
+}
@@ -0,0 +1,65 @@
+package routes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+func TestRendersThroughImgproxy(t *testing.T) {
+	cfg := config.MinioConfig{Bucket: "uploads"}
+	image := db.File{Filename: "photo.png", MimeType: "image/png", StoragePath: "s3://uploads/7/photo.png", Size: 2048}
+
+	empty := image
+	empty.Size = 0
+	gzipped := image
+	gzipped.Filename, gzipped.MimeType, gzipped.ContentEncoding = "logo.svg", "image/svg+xml", "gzip"
+	text := image
+	text.Filename, text.MimeType = "notes.txt", "text/plain"
+	external := image
+	external.StoragePath = "https://example.com/photo.png"
+
+	tests := []struct {
+		name string
+		f    db.File
+		want bool
+	}{
+		{"image", image, true},
+		{"empty image", empty, false},
+		{"gzip-stored svg", gzipped, false},
+		{"not an image", text, false},
+		{"not in the bucket", external, false},
+	}
+	for _, tt := range tests {
+		if got := rendersThroughImgproxy(cfg, tt.f); got != tt.want {
+			t.Errorf("%s: rendersThroughImgproxy = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEnqueueUploadJobsSkipsEmptyFiles(t *testing.T) {
+	conn, err := db.GetDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	enqueueUploadJobs(ctx, "empty-image", "image/png", 0)
+	enqueueUploadJobs(ctx, "full-image", "image/png", 2048)
+
+	count := func(fileID string) int {
+		t.Helper()
+		var n int
+		if err := conn.QueryRow(`SELECT COUNT(*) FROM job WHERE type = ? AND payload LIKE ?`, jobPregenerateThumbnail, `%"`+fileID+`"%`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count("empty-image"); n != 0 {
+		t.Errorf("%d thumbnail jobs enqueued for an empty file, want 0", n)
+	}
+	if n := count("full-image"); n != 1 {
+		t.Errorf("%d thumbnail jobs enqueued for a non-empty image, want 1", n)
+	}
+}
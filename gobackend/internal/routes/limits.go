@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+)
+
+// projectFileLimit returns the maximum number of files for a project: the
+// project's max_files override if set, otherwise cfg.MaxFilesPerProject.
+// Zero means unlimited.
+func projectFileLimit(ctx context.Context, conn *sql.DB, cfg config.MinioConfig, projectID int64) (int64, error) {
+	var override sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `
+		SELECT max_files
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(&override); err != nil {
+		return 0, err
+	}
+	if override.Valid && override.Int64 >= 0 {
+		return override.Int64, nil
+	}
+	return cfg.MaxFilesPerProject, nil
+}
+
+// checkProjectFileLimit returns a 409 error when adding n files would take the
+// project over its file count limit.
+func checkProjectFileLimit(ctx context.Context, conn *sql.DB, cfg config.MinioConfig, projectID int64, n int64) error {
+	limit, err := projectFileLimit(ctx, conn, cfg, projectID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project file limit")
+	}
+	if limit == 0 {
+		return nil
+	}
+
+	var count int64
+	if err := conn.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM file
+		WHERE project_id = ?
+	`, projectID).Scan(&count); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to count project files")
+	}
+	if count+n > limit {
+		return apiError(http.StatusConflict, apierror.FileLimitExceeded, "Project file limit reached ("+strconv.FormatInt(limit, 10)+" files)")
+	}
+	return nil
+}
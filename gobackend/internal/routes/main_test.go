@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// TestMain points the database at a fresh SQLite file, so handlers that
+// call db.GetDB run against the real schema.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "routes-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("DATABASE_URL", "sqlite:///"+filepath.Join(dir, "test.db"))
+	if err := db.Migrate(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// createTestProject inserts a user (if new) and a project owned by them,
+// returning the project ID.
+func createTestProject(t *testing.T, uid string) int64 {
+	t.Helper()
+	conn, err := db.GetDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`INSERT OR IGNORE INTO user (firebase_uid, email, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)`, uid, uid+"@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := conn.Exec(`INSERT INTO project (name, created_at, user_firebase_uid) VALUES (?, CURRENT_TIMESTAMP, ?)`, "test", uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+)
+
+// validateName checks a project or API key name and returns it with
+// surrounding whitespace trimmed. Names must be valid UTF-8, not blank, at
+// most maxLength characters and free of control and other non-printable
+// characters (including tabs and newlines). Errors are 400s naming field.
+func validateName(field, name string, maxLength int) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", apierror.NewField(http.StatusBadRequest, apierror.InvalidRequest, field, field+" must be valid UTF-8")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", apierror.NewField(http.StatusBadRequest, apierror.InvalidRequest, field, field+" is required")
+	}
+	if utf8.RuneCountInString(name) > maxLength {
+		return "", apierror.NewField(http.StatusBadRequest, apierror.InvalidRequest, field, field+" must be at most "+strconv.Itoa(maxLength)+" characters")
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return "", apierror.NewField(http.StatusBadRequest, apierror.InvalidRequest, field, field+" contains a non-printable character ("+strconv.QuoteRuneToASCII(r)+")")
+		}
+	}
+	return name, nil
+}
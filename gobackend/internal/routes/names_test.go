@@ -0,0 +1,58 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+)
+
+func TestValidateName(t *testing.T) {
+	const max = 128
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		ok    bool
+	}{
+		{"plain", "Marketing site", "Marketing site", true},
+		{"trimmed", "  assets\t", "assets", true},
+		{"exactly max bytes", strings.Repeat("a", max), strings.Repeat("a", max), true},
+		{"max+1 bytes", strings.Repeat("a", max+1), "", false},
+		// The limit is in characters: 128 of them is 384 bytes here
+		{"max multi-byte characters", strings.Repeat("日", max), strings.Repeat("日", max), true},
+		{"max+1 multi-byte characters", strings.Repeat("日", max+1), "", false},
+		{"unicode letters", "Café ☕ 写真", "Café ☕ 写真", true},
+		{"empty", "", "", false},
+		{"only spaces", "   ", "", false},
+		{"NUL", "key\x00name", "", false},
+		{"newline", "key\nname", "", false},
+		{"tab inside", "key\tname", "", false},
+		{"escape", "\x1b[31mred", "", false},
+		{"right-to-left override", "invoice\u202etxt.exe", "", false},
+		{"zero-width space", "admin\u200b", "", false},
+		{"invalid UTF-8", "bad\xff\xfename", "", false},
+		{"truncated UTF-8", "name\xe6\x97", "", false},
+	}
+	for _, field := range []string{"name", "project.name"} {
+		for _, tt := range tests {
+			got, err := validateName(field, tt.input, max)
+			if tt.ok {
+				if err != nil || got != tt.want {
+					t.Errorf("%s: %s: validateName = %q, %v, want %q", field, tt.name, got, err, tt.want)
+				}
+				continue
+			}
+			var apiErr *apierror.Error
+			if !errors.As(err, &apiErr) {
+				t.Errorf("%s: %s: accepted as %q", field, tt.name, got)
+				continue
+			}
+			if apiErr.Status != http.StatusBadRequest || apiErr.Field != field {
+				t.Errorf("%s: %s: got %d field %q, want 400 field %q", field, tt.name, apiErr.Status, apiErr.Field, field)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package routes
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+	"github.com/gabriel/open_upload_gobackend/internal/openapi"
+)
+
+// uploadForm documents the multipart body of the upload routes.
+type uploadForm struct {
+	File   openapi.Binary `json:"file"`
+	Public bool           `json:"public,omitempty"`
+}
+
+// openAPIDocs adds summaries and request/response types to the file,
+// project and API key routes, keyed by method and registered path. Routes
+// missing here are still listed, with their path parameters and security.
+var openAPIDocs = map[string]openapi.Doc{
+	"POST /api/v1/files/upload":       {Summary: "Upload a file to the API key's project", Tag: "Files", Request: uploadForm{}, Multipart: true, Response: uploadResponse{}, Status: fiber.StatusCreated},
+	"GET /api/v1/files/list":          {Summary: "List the API key's project objects", Tag: "Files", Response: listResponse{}},
+	"GET /api/v1/files/transform-url": {Summary: "Sign an imgproxy URL for an object", Tag: "Files", Response: map[string]string{}},
+	"DELETE /api/v1/files/:key":       {Summary: "Delete an object by key", Tag: "Files"},
+	"GET /api/v1/files/:key":          {Summary: "Redirect to a presigned download URL", Tag: "Files", Status: fiber.StatusTemporaryRedirect},
+
+	"POST /api/v1/files/presign-upload":  {Summary: "Get a presigned URL to upload a file to MinIO directly", Tag: "Files", Request: presignUploadRequest{}, Response: presignUploadResponse{}},
+	"POST /api/v1/files/complete-upload": {Summary: "Record a file uploaded with a presigned URL", Tag: "Files", Request: completeUploadRequest{}, Response: uploadResponse{}, Status: fiber.StatusCreated},
+
+	"POST /frontend/files/upload":         {Summary: "Upload a file to a project", Tag: "Files", Request: uploadForm{}, Multipart: true, Response: db.File{}, Status: fiber.StatusCreated},
+	"GET /frontend/files":                 {Summary: "List the user's files across projects", Tag: "Files", Response: pageResponse[db.File]{}},
+	"GET /frontend/files/list":            {Summary: "List a project's files", Tag: "Files", Response: []db.File{}},
+	"PATCH /frontend/files/:file_id":      {Summary: "Rename a file or change how it is served", Tag: "Files", Request: fileUpdatePayload{}, Response: db.File{}},
+	"DELETE /frontend/files/:file_id":     {Summary: "Delete a file (to the trash when enabled)", Tag: "Files"},
+	"GET /frontend/files/trash":           {Summary: "List the user's trashed files", Tag: "Files", Response: pageResponse[db.TrashedFile]{}},
+	"POST /frontend/files/upload-token":   {Summary: "Create a browser upload token", Tag: "Files", Request: uploadTokenRequest{}, Response: uploadTokenResponse{}},
+	"POST /frontend/files/register-batch": {Summary: "Register existing objects as files", Tag: "Files", Request: registerBatchRequest{}, Response: registerBatchResponse{}},
+	"GET /files/:file_id":                 {Summary: "Download a file", Tag: "Files", Status: fiber.StatusOK},
+	"GET /files/:file_id/preview":         {Summary: "Preview an image, or the first bytes of a text file", Tag: "Files", Status: fiber.StatusOK},
+
+	"GET /frontend/files/:file_id/access-log": {Summary: "List downloads of a file through the public routes", Tag: "Files", Response: pageResponse[fileAccess]{}},
+
+	"GET /files/:file_id/poster": {Summary: "Render a frame of a video file", Tag: "Files", Status: fiber.StatusOK},
+
+	"GET /projects":                                   {Summary: "List projects", Tag: "Projects", Response: []db.Project{}},
+	"POST /projects":                                  {Summary: "Create a project", Tag: "Projects", Request: projectCreatePayload{}, Response: db.Project{}, Status: fiber.StatusCreated},
+	"GET /projects/overview":                          {Summary: "List projects with their totals", Tag: "Projects", Response: []projectOverview{}},
+	"GET /projects/:project_id":                       {Summary: "Get a project with its API keys", Tag: "Projects", Response: ProjectWithKeys{}},
+	"DELETE /projects/:project_id":                    {Summary: "Delete a project (dry_run=true returns the impact instead)", Tag: "Projects"},
+	"GET /projects/:project_id/stats":                 {Summary: "Get a project's storage and file totals", Tag: "Projects", Response: ProjectStats{}},
+	"GET /projects/:project_id/export":                {Summary: "Export a project manifest", Tag: "Projects", Response: ProjectManifest{}},
+	"POST /projects/import":                           {Summary: "Import a project from a manifest", Tag: "Projects", Response: importResponse{}, Status: fiber.StatusCreated},
+	"POST /projects/:project_id/warm-cache":           {Summary: "Render a preset for every image", Tag: "Projects", Response: warmCacheResponse{}, Status: fiber.StatusAccepted},
+	"POST /projects/:project_id/generate-derivatives": {Summary: "Store a preset rendering of every image", Tag: "Projects", Response: generateDerivativesResponse{}, Status: fiber.StatusAccepted},
+	"GET /projects/:project_id/webhooks":              {Summary: "List a project's webhooks", Tag: "Projects", Response: []projectWebhook{}},
+	"POST /projects/:project_id/webhooks":             {Summary: "Create a webhook", Tag: "Projects", Request: webhookRequest{}, Response: projectWebhook{}, Status: fiber.StatusCreated},
+
+	"GET /api-keys":                         {Summary: "List API keys", Tag: "API keys", Response: []db.ApiKey{}},
+	"POST /api-keys":                        {Summary: "Create an API key", Tag: "API keys", Request: apiKeyPayload{}, Response: db.ApiKey{}, Status: fiber.StatusCreated},
+	"DELETE /api-keys/:api_key_id":          {Summary: "Delete an API key", Tag: "API keys"},
+	"PUT /api-keys/:api_key_id/allowed-ips": {Summary: "Restrict an API key to IPs or CIDRs", Tag: "API keys", Request: allowedIPsPayload{}, Response: db.ApiKey{}},
+	"PUT /api-keys/:api_key_id/active":      {Summary: "Enable or disable an API key", Tag: "API keys", Request: apiKeyActivePayload{}, Response: db.ApiKey{}},
+
+	"POST /frontend/api-keys/api/verify-batch": {Summary: "Check several API keys", Tag: "API keys", Request: verifyBatchPayload{}, Response: map[string][]apiKeyStatus{}},
+
+	"GET /admin/stats": {Summary: "Service-wide totals for operators", Tag: "Admin", Response: adminStats{}},
+}
+
+var openAPISecuritySchemes = map[string]openapi.SecurityScheme{
+	"bearerAuth":    {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "Firebase ID token"},
+	"sessionCookie": {Type: "apiKey", In: "cookie", Name: auth.SessionCookieName, Description: "Session cookie from POST /auth/session, sent with an " + auth.CSRFHeader + " header"},
+	"apiKeyAuth":    {Type: "apiKey", In: "header", Name: "X-API-Key", Description: "API key for programmatic access"},
+	"uploadToken":   {Type: "http", Scheme: "bearer", Description: "Upload token from POST /frontend/files/upload-token"},
+}
+
+// openAPISecurity returns the security schemes (alternatives) of a path.
+func openAPISecurity(path string) []string {
+	switch {
+	case strings.HasPrefix(path, "/api/v1/"):
+		return []string{"apiKeyAuth"}
+	case path == "/upload":
+		return []string{"uploadToken"}
+	case path == "/health" || path == "/openapi.json" || strings.HasPrefix(path, "/files/") || strings.HasPrefix(path, "/share/"):
+		return nil
+	}
+	return []string{"bearerAuth", "sessionCookie"}
+}
+
+var openAPIInfo = openapi.Info{
+	Title:       "OpenUpload API",
+	Description: "API for managing file uploads, projects, and API keys",
+	Version:     "1.0.0",
+}
+
+// OpenAPIHandler serves the OpenAPI document generated from app's routes. It
+// is built on the first request, once every route is registered. Should
+// generation fail, a valid document without paths is served instead so
+// documentation UIs still load. A specFile (OPENAPI_SPEC_FILE) is served
+// instead while it can be read, for iterating on a hand-edited spec.
+func OpenAPIHandler(app *fiber.App, specFile string) fiber.Handler {
+	var (
+		once sync.Once
+		spec *openapi.Spec
+	)
+	return func(c fiber.Ctx) error {
+		if specFile != "" {
+			data, err := os.ReadFile(specFile)
+			if err == nil {
+				c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+				return c.Send(data)
+			}
+			log.Printf("warning: openapi: failed to read OPENAPI_SPEC_FILE, serving the generated spec: %v", err)
+		}
+		once.Do(func() {
+			spec = buildOpenAPISpec(app)
+		})
+		return c.JSON(spec)
+	}
+}
+
+func buildOpenAPISpec(app *fiber.App) (spec *openapi.Spec) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("warning: openapi: failed to generate the spec, serving one without paths: %v", r)
+			spec = openapi.Stub(openAPIInfo)
+		}
+	}()
+
+	routes := openapi.FromFiber(app.GetRoutes(true))
+	for i := range routes {
+		routes[i].Security = openAPISecurity(routes[i].Path)
+		if doc, ok := openAPIDocs[routes[i].Method+" "+routes[i].Path]; ok {
+			routes[i].Doc = &doc
+		}
+	}
+	return openapi.Build(openAPIInfo, openAPISecuritySchemes, routes, apierror.Body{})
+}
@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// pageResponse is the envelope for offset-paginated listings.
+type pageResponse[T any] struct {
+	Items  []T   `json:"items"`
+	Total  int64 `json:"total"`
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+}
+
+// parsePagination reads ?limit= (default 50, capped at 500) and ?offset=.
+func parsePagination(c fiber.Ctx) (limit, offset int, err error) {
+	limit, err = strconv.Atoi(c.Query("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit <= 0 {
+		return 0, 0, apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid limit")
+	}
+	offset, err = strconv.Atoi(c.Query("offset", "0"))
+	if err != nil || offset < 0 {
+		return 0, 0, apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid offset")
+	}
+	return min(limit, maxPageSize), offset, nil
+}
@@ -0,0 +1,179 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+	"github.com/gofiber/fiber/v3"
+)
+
+// PresetDimensions is a custom image size. A zero width or height lets
+// imgproxy compute it from the aspect ratio.
+type PresetDimensions struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// maxPresets caps how many custom presets a project can define.
+const maxPresets = 20
+
+var presetNamePattern = config.PresetNamePattern
+
+// resolvePreset returns the dimensions for a preset in a project's context:
+// the project's custom presets (project.presets) take precedence over the
+// global defaults from getPresetDimensions.
+func resolvePreset(ctx context.Context, conn *sql.DB, cfg config.MinioConfig, projectID int64, preset string) (width, height int, ok bool) {
+	presets, err := loadProjectPresets(ctx, conn, projectID)
+	if err != nil {
+		log.Printf("presets: failed to load presets for project %d: %v", projectID, err)
+	}
+	if p, found := presets[preset]; found {
+		return p.Width, p.Height, true
+	}
+	return getPresetDimensions(cfg, preset)
+}
+
+func loadProjectPresets(ctx context.Context, conn *sql.DB, projectID int64) (map[string]PresetDimensions, error) {
+	var raw sql.NullString
+	if err := conn.QueryRowContext(ctx, `
+		SELECT presets
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(&raw); err != nil {
+		return nil, err
+	}
+
+	presets := make(map[string]PresetDimensions)
+	if !raw.Valid || raw.String == "" {
+		return presets, nil
+	}
+	if err := json.Unmarshal([]byte(raw.String), &presets); err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+// getProjectPresets returns the project's custom presets (GET /projects/:project_id/presets).
+func getProjectPresets(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
+	}
+
+	presets, err := loadProjectPresets(ctx, conn, projectID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load presets")
+	}
+	return c.JSON(presets)
+}
+
+// updateProjectPresets replaces the project's custom presets
+// (PUT /projects/:project_id/presets). The body maps preset names to
+// {width, height}; an empty object clears them.
+func updateProjectPresets(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+
+	var presets map[string]PresetDimensions
+	if err := json.Unmarshal(c.Body(), &presets); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid JSON body")
+	}
+	if len(presets) > maxPresets {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "too many presets (max 20)")
+	}
+	const maxDim = config.MaxPresetDimension
+	for name, p := range presets {
+		if !presetNamePattern.MatchString(name) {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid preset name: "+name)
+		}
+		if p.Width < 0 || p.Height < 0 || p.Width > maxDim || p.Height > maxDim || (p.Width == 0 && p.Height == 0) {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid dimensions for preset: "+name)
+		}
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to modify this project")
+	}
+
+	var stored any
+	if len(presets) > 0 {
+		data, err := json.Marshal(presets)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to encode presets")
+		}
+		stored = string(data)
+	}
+	if _, err := conn.ExecContext(ctx, `UPDATE project SET presets = ? WHERE id = ?`, stored, projectID); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to save presets")
+	}
+	audit.Record(ctx, user.UID, audit.ActionUpdate, audit.TargetProject, strconv.FormatInt(projectID, 10), projectID, "presets")
+
+	if presets == nil {
+		presets = make(map[string]PresetDimensions)
+	}
+	return c.JSON(presets)
+}
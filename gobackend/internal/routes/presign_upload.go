@@ -0,0 +1,326 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// presignUploadExpiry is the lifetime of the upload URLs presign-upload signs.
+const presignUploadExpiry = 15 * time.Minute
+
+type presignUploadRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+}
+
+type presignUploadResponse struct {
+	UploadURL string `json:"upload_url"`
+	Key       string `json:"key"`
+	// ContentType is what the PUT should send as Content-Type; complete-upload
+	// records the type the object was stored with.
+	ContentType string    `json:"content_type"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type completeUploadRequest struct {
+	Key string `json:"key"`
+	// Filename defaults to the key's base name.
+	Filename string `json:"filename"`
+}
+
+// presignUpload handles POST /api/v1/files/presign-upload: a presigned PUT
+// URL for the key /upload would store the file under, so large files go to
+// MinIO directly instead of through this server. Type, storage and file
+// limits are checked as far as they can be before the upload, and again by
+// complete-upload.
+func presignUpload(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig) error {
+	apiCtx, err := auth.GetAPIKeyContext(c)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+
+	var req presignUploadRequest
+	if err := c.Bind().Body(&req); err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", http.StatusBadRequest, start, apiCtx)
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid JSON body")
+	}
+	if err := validateFilename(req.Filename); err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", errorStatus(err), start, apiCtx)
+		return err
+	}
+	if err := checkPresignedType(cfg, req.Filename, req.ContentType); err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", errorStatus(err), start, apiCtx)
+		return err
+	}
+	contentType := normalizeContentType(cfg, req.Filename, req.ContentType)
+
+	conn, err := db.GetDB()
+	if err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", http.StatusInternalServerError, start, apiCtx)
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The size isn't known yet; complete-upload checks the object itself
+	totalStorage, err := UserStorageUsage(ctx, conn, apiCtx.User.FirebaseUID)
+	if err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", http.StatusInternalServerError, start, apiCtx)
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to compute storage usage")
+	}
+	if totalStorage >= apiCtx.User.StorageLimit {
+		trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", http.StatusRequestEntityTooLarge, start, apiCtx)
+		return apiError(http.StatusRequestEntityTooLarge, apierror.StorageLimitExceeded, "Storage limit reached")
+	}
+	if err := checkProjectFileLimit(ctx, conn, cfg, apiCtx.Project.ID, 1); err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", errorStatus(err), start, apiCtx)
+		return err
+	}
+
+	key := objectKey(cfg, apiCtx.Project.ID, req.Filename, time.Now().UTC())
+	// The content hash that FILENAME_COLLISION=hash uses isn't known yet, so
+	// a taken key gets a random suffix instead
+	if cfg.FilenameCollision == "hash" {
+		if _, err := client.StatObject(ctx, cfg.Bucket, key, minio.StatObjectOptions{}); err == nil {
+			ext := path.Ext(key)
+			key = strings.TrimSuffix(key, ext) + "." + strings.ReplaceAll(uuid.NewString(), "-", "")[:8] + ext
+		} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			log.Printf("presign upload stat error: %v", err)
+			err = mapMinioError(err, "failed to check object")
+			trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", errorStatus(err), start, apiCtx)
+			return err
+		}
+	}
+	if err := checkObjectKeyLength(cfg, key); err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", errorStatus(err), start, apiCtx)
+		return err
+	}
+
+	expiry := min(presignUploadExpiry, cfg.PresignMaxExpiry)
+	u, err := client.PresignedPutObject(ctx, cfg.Bucket, key, expiry)
+	if err != nil {
+		log.Printf("presign upload error: %v", err)
+		err = mapMinioError(err, "failed to generate upload URL")
+		trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", errorStatus(err), start, apiCtx)
+		return err
+	}
+
+	trackAPIUsage(context.Background(), "/api/v1/files/presign-upload", http.StatusOK, start, apiCtx)
+	return c.JSON(presignUploadResponse{
+		UploadURL:   u.String(),
+		Key:         key,
+		ContentType: contentType,
+		ExpiresAt:   time.Now().UTC().Add(expiry),
+	})
+}
+
+// checkPresignedType is checkUploadType for uploads that bypass this server,
+// with ALLOWED_MIME_TYPES applied to the type alone; complete-upload checks
+// the content. SVGs can't be sanitized on the way while SANITIZE_SVG is on,
+// so they have to use /upload.
+func checkPresignedType(cfg config.MinioConfig, filename, contentType string) error {
+	resolved := normalizeContentType(cfg, filename, contentType)
+	if err := checkUploadType(cfg, filename, contentType, resolved); err != nil {
+		return err
+	}
+	if err := checkUploadContent(cfg, resolved, nil); err != nil {
+		return err
+	}
+	if cfg.SanitizeSVG && mediaType(resolved) == "image/svg+xml" {
+		return apiError(http.StatusUnsupportedMediaType, apierror.UnsupportedFileType, "SVGs are sanitized on upload; use /api/v1/files/upload")
+	}
+	return nil
+}
+
+// completeUpload handles POST /api/v1/files/complete-upload: it records the
+// file row for an object uploaded with a presign-upload URL. The object is
+// read once to hash it and find image dimensions. An object that breaks the
+// type, storage or file limits is deleted.
+func completeUpload(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig) error {
+	apiCtx, err := auth.GetAPIKeyContext(c)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+
+	var req completeUploadRequest
+	if err := c.Bind().Body(&req); err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusBadRequest, start, apiCtx)
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid JSON body")
+	}
+	prefix := projectListPrefix(cfg.StoragePrefix, apiCtx.Project.ID)
+	key := strings.TrimPrefix(req.Key, "/")
+	if path.Clean(key) != key || !strings.HasPrefix(key, prefix) || len(key) == len(prefix) {
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusBadRequest, start, apiCtx)
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "key must be under "+prefix)
+	}
+	filename := req.Filename
+	if filename == "" {
+		filename = path.Base(key)
+	}
+	if err := validateFilename(filename); err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", errorStatus(err), start, apiCtx)
+		return err
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusInternalServerError, start, apiCtx)
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	// Hashing reads the whole object
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	// A trashed file still owns its blob until it is purged or restored
+	storagePath := "s3://" + cfg.Bucket + "/" + key
+	var existingID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT id FROM file WHERE storage_path = ?
+		UNION ALL
+		SELECT id FROM file_trash WHERE storage_path = ?
+		LIMIT 1
+	`, storagePath, storagePath).Scan(&existingID); err == nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusConflict, start, apiCtx)
+		return apiError(http.StatusConflict, apierror.InvalidRequest, "key is already recorded as file "+existingID)
+	} else if err != sql.ErrNoRows {
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusInternalServerError, start, apiCtx)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to check existing files")
+	}
+
+	info, err := client.StatObject(ctx, cfg.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusNotFound, start, apiCtx)
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "nothing has been uploaded to "+key)
+		}
+		log.Printf("complete upload stat error: %v", err)
+		err = mapMinioError(err, "failed to check object")
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", errorStatus(err), start, apiCtx)
+		return err
+	}
+
+	// reject deletes the object along with refusing it, unless a file or
+	// trashed file references it by now (a concurrent upload to the key)
+	reject := func(err error) error {
+		var references int
+		if qErr := conn.QueryRowContext(ctx, `
+			SELECT (SELECT COUNT(*) FROM file WHERE storage_path = ?)
+				+ (SELECT COUNT(*) FROM file_trash WHERE storage_path = ?)
+		`, storagePath, storagePath).Scan(&references); qErr != nil {
+			log.Printf("complete upload: failed to count references, keeping rejected object %s: %v", key, qErr)
+		} else if references > 0 {
+			log.Printf("complete upload: keeping rejected object %s, %d files reference it", key, references)
+		} else if rmErr := client.RemoveObject(ctx, cfg.Bucket, key, minio.RemoveObjectOptions{}); rmErr != nil {
+			log.Printf("complete upload: failed to remove rejected object %s: %v", key, rmErr)
+		}
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", errorStatus(err), start, apiCtx)
+		return err
+	}
+
+	// A presigned PUT can't be capped, so oversized files are refused here
+	if err := checkUploadSize(cfg, info.Size); err != nil {
+		return reject(err)
+	}
+	contentType := normalizeContentType(cfg, filename, info.ContentType)
+	if err := checkPresignedType(cfg, filename, contentType); err != nil {
+		return reject(err)
+	}
+
+	totalStorage, err := UserStorageUsage(ctx, conn, apiCtx.User.FirebaseUID)
+	if err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusInternalServerError, start, apiCtx)
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to compute storage usage")
+	}
+	if totalStorage+info.Size > apiCtx.User.StorageLimit {
+		return reject(apiError(http.StatusRequestEntityTooLarge, apierror.StorageLimitExceeded, "Upload would exceed storage limit"))
+	}
+	if err := checkProjectFileLimit(ctx, conn, cfg, apiCtx.Project.ID, 1); err != nil {
+		if errorStatus(err) >= 500 {
+			trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", errorStatus(err), start, apiCtx)
+			return err
+		}
+		return reject(err)
+	}
+
+	obj, err := client.GetObject(ctx, cfg.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		log.Printf("complete upload GetObject error: %v", err)
+		err = mapMinioError(err, "failed to read uploaded object")
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", errorStatus(err), start, apiCtx)
+		return err
+	}
+	defer obj.Close()
+	head, err := readUploadHead(obj)
+	if err != nil {
+		log.Printf("complete upload read error: %v, key=%s", err, key)
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusInternalServerError, start, apiCtx)
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to read uploaded object")
+	}
+	if err := checkUploadContent(cfg, contentType, head); err != nil {
+		return reject(err)
+	}
+
+	// Everything read for the dimensions passes through the hash too
+	hash := sha256.New()
+	body := io.MultiReader(bytes.NewReader(head), obj)
+	width, height := readDimensions(io.TeeReader(body, hash), contentType)
+	_, err = io.Copy(hash, body)
+	if err != nil {
+		log.Printf("complete upload read error: %v, key=%s", err, key)
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusInternalServerError, start, apiCtx)
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to read uploaded object")
+	}
+	contentHash := hex.EncodeToString(hash.Sum(nil))
+
+	lockedUntil, err := lockUntilForUpload(ctx, conn, client, cfg, apiCtx.Project.ID, key)
+	if err != nil {
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusInternalServerError, start, apiCtx)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project retention")
+	}
+
+	now := time.Now().UTC()
+	id := uuid.NewString()
+	if _, err := db.ExecWithRetry(ctx, conn, `
+		INSERT INTO file (id, filename, size, mime_type, created_at, updated_at, project_id, user_firebase_uid, storage_path, content_hash, content_encoding, locked_until, width, height)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, '', ?, ?, ?)
+	`, id, filename, info.Size, contentType, now, now, apiCtx.Project.ID, apiCtx.User.FirebaseUID, storagePath, contentHash, lockedUntil, width, height); err != nil {
+		log.Printf("complete upload db insert error: %v", err)
+		trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusInternalServerError, start, apiCtx)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to save file record")
+	}
+	enqueueUploadJobs(ctx, id, contentType, info.Size)
+
+	trackAPIUsage(context.Background(), "/api/v1/files/complete-upload", http.StatusCreated, start, apiCtx)
+	return c.Status(http.StatusCreated).JSON(uploadResponse{
+		ID:           id,
+		Key:          key,
+		Bucket:       cfg.Bucket,
+		Size:         info.Size,
+		ContentType:  contentType,
+		URL:          c.Scheme() + "://" + c.Host() + "/files/" + id,
+		ImgproxyURL:  buildImgproxyURL(cfg, key),
+		ThumbnailURL: buildThumbnailURL(cfg, key, "webp"),
+	})
+}
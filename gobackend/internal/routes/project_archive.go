@@ -0,0 +1,330 @@
+package routes
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// archiveEntryTimeout bounds fetching one object while streaming an archive.
+const archiveEntryTimeout = 5 * time.Minute
+
+var errNotInStorage = errors.New("file is not stored in MinIO")
+
+// archiveProject streams a ZIP of a project's files plus its manifest.json
+// (GET /projects/:project_id/archive). Entries are named after the files so
+// the archive can be fed back to /projects/import. ?compression=auto|store|deflate
+// overrides ARCHIVE_COMPRESSION.
+func archiveProject(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+
+	compression := c.Query("compression", cfg.ArchiveCompression)
+	if compression != "auto" && compression != "store" && compression != "deflate" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "compression must be auto, store or deflate")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var project db.Project
+	var desc sql.NullString
+	if err := conn.QueryRowContext(ctx, `
+		SELECT id, name, description, created_at, user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(
+		&project.ID,
+		&project.Name,
+		&desc,
+		&project.CreatedAt,
+		&project.UserFirebaseUID,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if desc.Valid {
+		project.Description = &desc.String
+	}
+	if project.UserFirebaseUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
+	}
+
+	manifest, err := loadProjectManifest(ctx, conn, project)
+	if err != nil {
+		return err
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to encode manifest")
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE project_id = ?
+		ORDER BY created_at
+	`, projectID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project files")
+	}
+	defer rows.Close()
+
+	files := make([]db.File, 0)
+	for rows.Next() {
+		var f db.File
+		if err := db.ScanFile(rows, &f); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan file")
+		}
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate project files")
+	}
+
+	return streamArchive(c, client, cfg, "project-"+strconv.FormatInt(projectID, 10), files, compression, manifest.ExportedAt, manifestJSON)
+}
+
+// maxArchiveFileIDs bounds how many files one POST /frontend/files/archive
+// may select.
+const maxArchiveFileIDs = 1000
+
+type archiveFilesRequest struct {
+	FileIDs []string `json:"file_ids"`
+}
+
+// archiveFiles streams a ZIP of specific files picked by id
+// (POST /frontend/files/archive). Every file must belong to the user; entries
+// are named after the files, with repeated names suffixed. ?compression
+// works as for project archives.
+func archiveFiles(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	var req archiveFilesRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid JSON body")
+	}
+	ids := make([]string, 0, len(req.FileIDs))
+	seen := make(map[string]bool)
+	for _, id := range req.FileIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_ids is required")
+	}
+	if len(ids) > maxArchiveFileIDs {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "at most "+strconv.Itoa(maxArchiveFileIDs)+" files per archive")
+	}
+
+	compression := c.Query("compression", cfg.ArchiveCompression)
+	if compression != "auto" && compression != "store" && compression != "deflate" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "compression must be auto, store or deflate")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := conn.QueryContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)
+	`, args...)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load files")
+	}
+	defer rows.Close()
+
+	byID := make(map[string]db.File, len(ids))
+	for rows.Next() {
+		var f db.File
+		if err := db.ScanFile(rows, &f); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan file")
+		}
+		byID[f.ID] = f
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate files")
+	}
+
+	// Keep the requested order so entry names are suffixed predictably
+	files := make([]db.File, 0, len(ids))
+	for _, id := range ids {
+		f, ok := byID[id]
+		if !ok {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found: "+id)
+		}
+		if f.UserFirebaseUID != user.UID {
+			return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access file "+id)
+		}
+		files = append(files, f)
+	}
+
+	return streamArchive(c, client, cfg, "files-"+time.Now().UTC().Format("20060102-150405"), files, compression, time.Now().UTC(), nil)
+}
+
+// streamArchive sends files as a ZIP named <name>.zip, with manifestJSON as
+// manifest.json first when given. Headers are sent before the body, so
+// failures while streaming can only be logged; a broken entry is skipped and
+// the archive stays readable.
+func streamArchive(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig, name string, files []db.File, compression string, modified time.Time, manifestJSON []byte) error {
+	c.Set("Content-Type", "application/zip")
+	c.Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		zw := zip.NewWriter(w)
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, cfg.ArchiveDeflateLevel)
+		})
+
+		if manifestJSON != nil {
+			if mw, err := zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: modified}); err == nil {
+				_, _ = mw.Write(manifestJSON)
+			}
+		}
+
+		names := make(map[string]int)
+		for _, f := range files {
+			entryName := uniqueArchiveName(names, archiveEntryName(f))
+			if err := writeArchiveEntry(zw, client, cfg, f, entryName, archiveMethod(compression, normalizeContentType(cfg, f.Filename, f.MimeType))); err != nil {
+				log.Printf("archive: %s: skipping file %s: %v", name, f.ID, err)
+			}
+		}
+
+		if err := zw.Close(); err != nil {
+			log.Printf("archive: %s: failed to finish archive: %v", name, err)
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("archive: %s: client write error: %v", name, err)
+		}
+	})
+}
+
+// writeArchiveEntry copies one file from MinIO into the archive, decoding
+// gzip-stored objects so entries hold the original bytes.
+func writeArchiveEntry(zw *zip.Writer, client *minio.Client, cfg config.MinioConfig, f db.File, name string, method uint16) error {
+	if !strings.HasPrefix(f.StoragePath, "s3://") {
+		return errNotInStorage
+	}
+	key, err := extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), archiveEntryTimeout)
+	defer cancel()
+
+	obj, err := client.GetObject(ctx, cfg.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	// GetObject is lazy; surface a missing object before adding the entry
+	if _, err := obj.Stat(); err != nil {
+		return err
+	}
+
+	var src io.Reader = obj
+	if f.ContentEncoding == "gzip" {
+		gz, err := gzip.NewReader(obj)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: f.UpdatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, src)
+	return err
+}
+
+// archiveMethod picks the ZIP method for an entry. In auto mode media and
+// archives, which barely shrink, are stored to save CPU; everything else is
+// deflated.
+func archiveMethod(compression, contentType string) uint16 {
+	switch compression {
+	case "store":
+		return zip.Store
+	case "deflate":
+		return zip.Deflate
+	}
+	if isPrecompressedType(contentType) {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// archiveEntryName is the archive path for a file: its filename, without any
+// directory components.
+func archiveEntryName(f db.File) string {
+	name := path.Base(strings.ReplaceAll(f.Filename, `\`, "/"))
+	if name == "" || name == "." || name == "/" || name == ".." || name == "manifest.json" {
+		return f.ID
+	}
+	return name
+}
+
+// uniqueArchiveName suffixes repeated names ("a.txt", "a (1).txt", ...).
+func uniqueArchiveName(seen map[string]int, name string) string {
+	n := seen[name]
+	seen[name] = n + 1
+	if n == 0 {
+		return name
+	}
+	ext := path.Ext(name)
+	candidate := strings.TrimSuffix(name, ext) + " (" + strconv.Itoa(n) + ")" + ext
+	return uniqueArchiveName(seen, candidate)
+}
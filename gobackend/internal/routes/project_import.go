@@ -0,0 +1,369 @@
+package routes
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// importSkipped reports a manifest file that was not imported and why.
+type importSkipped struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Reason   string `json:"reason"`
+}
+
+type importResponse struct {
+	Project       db.Project      `json:"project"`
+	FilesImported int             `json:"files_imported"`
+	FilesSkipped  []importSkipped `json:"files_skipped"`
+	APIKeys       []db.ApiKey     `json:"api_keys"`
+}
+
+// importProject recreates a project from an export manifest (see exportProject)
+// and a ZIP archive of the file contents, as multipart fields "manifest" and
+// "archive". Archive entries are matched to manifest files by file id, then by
+// filename. Files missing from the archive are restored from existing blobs
+// with the same content hash when possible. Imported files belong to the
+// importing user, and API keys are recreated with fresh secrets.
+func importProject(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	manifestJSON := []byte(c.FormValue("manifest"))
+	if len(manifestJSON) == 0 {
+		manifestHeader, err := c.FormFile("manifest")
+		if err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "manifest is required")
+		}
+		mf, err := manifestHeader.Open()
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to open manifest")
+		}
+		manifestJSON, err = io.ReadAll(mf)
+		mf.Close()
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to read manifest")
+		}
+	}
+
+	var manifest ProjectManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid manifest JSON")
+	}
+	if manifest.Version != manifestVersion {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "unsupported manifest version")
+	}
+	if manifest.Project.Name, err = validateName("project.name", manifest.Project.Name, cfg.MaxNameLength); err != nil {
+		return err
+	}
+
+	// Index archive entries by base name; the archive is optional when every
+	// file can be restored from an existing blob.
+	entries := make(map[string]*zip.File)
+	if archiveHeader, err := c.FormFile("archive"); err == nil {
+		archiveFile, err := archiveHeader.Open()
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to open archive")
+		}
+		defer archiveFile.Close()
+
+		zr, err := zip.NewReader(archiveFile, archiveHeader.Size)
+		if err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "archive is not a valid ZIP file")
+		}
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			name := path.Base(zf.Name)
+			if _, exists := entries[name]; !exists {
+				entries[name] = zf
+			}
+		}
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	// Refuse imports whose manifest alone is over the limit up front; each
+	// file is charged its real size as it is stored
+	var importSize int64
+	for _, mf := range manifest.Files {
+		importSize += mf.Size
+	}
+	totalStorage, err := UserStorageUsage(ctx, conn, user.UID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to compute storage usage")
+	}
+	quota, err := userStorageLimit(ctx, conn, user.UID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load storage limit")
+	}
+	if totalStorage+importSize > quota {
+		return apiError(http.StatusRequestEntityTooLarge, apierror.StorageLimitExceeded, "Import would exceed storage limit")
+	}
+
+	res, err := conn.ExecContext(ctx, `
+		INSERT INTO project (name, description, created_at, user_firebase_uid)
+		VALUES (?, ?, CURRENT_TIMESTAMP, ?)
+	`, manifest.Project.Name, manifest.Project.Description, user.UID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to create project")
+	}
+	projectID, err := res.LastInsertId()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to get new project id")
+	}
+
+	resp := importResponse{
+		FilesSkipped: make([]importSkipped, 0),
+		APIKeys:      make([]db.ApiKey, 0),
+	}
+
+	fileLimit, err := projectFileLimit(ctx, conn, cfg, projectID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project file limit")
+	}
+
+	used := make(map[*zip.File]bool)
+	for _, mf := range manifest.Files {
+		if fileLimit > 0 && int64(resp.FilesImported) >= fileLimit {
+			resp.FilesSkipped = append(resp.FilesSkipped, importSkipped{ID: mf.ID, Filename: mf.Filename, Reason: "project file limit reached"})
+			continue
+		}
+
+		entry := entries[mf.ID]
+		if entry == nil {
+			entry = entries[path.Base(mf.Filename)]
+		}
+		if entry != nil && used[entry] {
+			entry = nil
+		}
+
+		content, reason := importFileContents(ctx, conn, client, cfg, user.UID, projectID, mf, entry, quota-totalStorage)
+		if reason != "" {
+			resp.FilesSkipped = append(resp.FilesSkipped, importSkipped{ID: mf.ID, Filename: mf.Filename, Reason: reason})
+			continue
+		}
+		if entry != nil {
+			used[entry] = true
+		}
+
+		createdAt := mf.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now().UTC()
+		}
+		if _, err := conn.ExecContext(ctx, `
+			INSERT INTO file (id, filename, size, mime_type, created_at, updated_at, project_id, user_firebase_uid, storage_path, content_hash, content_encoding)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, uuid.NewString(), mf.Filename, content.size, content.contentType, createdAt, time.Now().UTC(), projectID, user.UID, content.storagePath, content.contentHash, content.contentEncoding); err != nil {
+			log.Printf("import: db insert file error: %v", err)
+			resp.FilesSkipped = append(resp.FilesSkipped, importSkipped{ID: mf.ID, Filename: mf.Filename, Reason: "failed to save file record"})
+			continue
+		}
+		totalStorage += content.size
+		resp.FilesImported++
+	}
+
+	// New keys with fresh secrets; old secrets are never part of a manifest.
+	for _, mk := range manifest.APIKeys {
+		keyValue := generateAPIKey()
+		res, err := conn.ExecContext(ctx, `
+			INSERT INTO apikey (key, name, is_active, created_at, last_used_at, user_firebase_uid, project_id)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP, NULL, ?, ?)
+		`, keyValue, mk.Name, mk.IsActive, user.UID, projectID)
+		if err != nil {
+			log.Printf("import: failed to create API key %q: %v", mk.Name, err)
+			continue
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			continue
+		}
+		resp.APIKeys = append(resp.APIKeys, db.ApiKey{
+			ID:              id,
+			Key:             keyValue,
+			Name:            mk.Name,
+			IsActive:        mk.IsActive,
+			CreatedAt:       time.Now().UTC(),
+			UserFirebaseUID: user.UID,
+			ProjectID:       projectID,
+			AllowedIPs:      make([]string, 0),
+		})
+	}
+
+	var desc sql.NullString
+	if err := conn.QueryRowContext(ctx, `
+		SELECT id, name, description, created_at, user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(
+		&resp.Project.ID,
+		&resp.Project.Name,
+		&desc,
+		&resp.Project.CreatedAt,
+		&resp.Project.UserFirebaseUID,
+	); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load created project")
+	}
+	if desc.Valid {
+		resp.Project.Description = &desc.String
+	}
+	audit.Record(ctx, user.UID, audit.ActionImport, audit.TargetProject, strconv.FormatInt(projectID, 10), projectID,
+		fmt.Sprintf("%d files imported, %d skipped", resp.FilesImported, len(resp.FilesSkipped)))
+
+	return c.Status(http.StatusCreated).JSON(resp)
+}
+
+// importedContent is where one imported file's contents are stored.
+type importedContent struct {
+	storagePath     string
+	size            int64
+	contentType     string
+	contentHash     string
+	contentEncoding string
+}
+
+// importFileContents makes the contents of one manifest file available in
+// storage, uploading the archive entry unless a blob with the same hash already
+// exists. Archive entries go through the type, content, SVG and size checks of
+// uploads, and whatever the file is stored as must fit in remaining (the
+// user's storage left): the manifest's sizes aren't trusted. It returns a
+// non-empty reason when the file has to be skipped.
+func importFileContents(ctx context.Context, conn *sql.DB, client *minio.Client, cfg config.MinioConfig, uid string, projectID int64, mf ManifestFile, entry *zip.File, remaining int64) (content importedContent, reason string) {
+	content.contentType = normalizeContentType(cfg, mf.Filename, mf.MimeType)
+	content.contentHash = mf.ContentHash
+
+	// SVGs are stored sanitized, like uploads
+	var sanitized []byte
+	if entry == nil {
+		if err := checkUploadType(cfg, mf.Filename, mf.MimeType, content.contentType); err != nil {
+			return importedContent{}, err.Error()
+		}
+	} else {
+		if remaining < 0 || entry.UncompressedSize64 > uint64(remaining) {
+			return importedContent{}, "storage limit reached"
+		}
+		if err := checkUploadSize(cfg, int64(entry.UncompressedSize64)); err != nil {
+			return importedContent{}, err.Error()
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return importedContent{}, "failed to read archive entry"
+		}
+		head, err := readUploadHead(rc)
+		if err != nil {
+			rc.Close()
+			return importedContent{}, "failed to read archive entry"
+		}
+		content.contentType = uploadContentType(cfg, mf.Filename, mf.MimeType, head)
+		if err := checkUploadType(cfg, mf.Filename, mf.MimeType, content.contentType); err != nil {
+			rc.Close()
+			return importedContent{}, err.Error()
+		}
+		if err := checkUploadContent(cfg, content.contentType, head); err != nil {
+			rc.Close()
+			return importedContent{}, err.Error()
+		}
+
+		// The manifest's hash is of the exported bytes
+		hash := sha256.New()
+		body := io.TeeReader(io.MultiReader(bytes.NewReader(head), rc), hash)
+		var svgHash string
+		if cfg.SanitizeSVG && mediaType(content.contentType) == "image/svg+xml" {
+			sanitized, svgHash, err = sanitizeSVGReader(body)
+		} else {
+			_, err = io.Copy(io.Discard, body)
+		}
+		rc.Close()
+		if err != nil {
+			if errorStatus(err) < 500 {
+				return importedContent{}, err.Error()
+			}
+			return importedContent{}, "failed to read archive entry"
+		}
+		content.contentHash = hex.EncodeToString(hash.Sum(nil))
+		if mf.ContentHash != "" && mf.ContentHash != content.contentHash {
+			return importedContent{}, "content hash does not match manifest"
+		}
+		if sanitized != nil {
+			content.contentHash = svgHash
+		}
+	}
+
+	// Reuse an existing blob with the same content (never for empty files,
+	// which all share one hash). Under DEDUP_SCOPE=per_user a manifest can
+	// only point at the importing user's own blobs.
+	if content.contentHash != "" {
+		existingStoragePath, existingSize, existingEncoding, err := findDedupBlob(ctx, conn, cfg, uid, content.contentHash)
+		if err == nil && existingStoragePath != "" {
+			if existingSize > remaining {
+				return importedContent{}, "storage limit reached"
+			}
+			content.storagePath, content.size, content.contentEncoding = existingStoragePath, existingSize, existingEncoding
+			return content, ""
+		}
+	}
+
+	if entry == nil {
+		return importedContent{}, "missing from archive"
+	}
+
+	key, err := collisionFreeKey(ctx, conn, client, cfg, objectKey(cfg, projectID, mf.Filename, time.Now().UTC()), content.contentHash)
+	if err != nil {
+		return importedContent{}, "failed to check existing object"
+	}
+	if len(key) > cfg.MaxObjectKeyLength {
+		return importedContent{}, "filename too long for object key"
+	}
+
+	if sanitized != nil {
+		content.size = int64(len(sanitized))
+		content.contentEncoding, err = storeObject(ctx, client, cfg, key, bytes.NewReader(sanitized), content.size, content.contentType)
+	} else {
+		var rc io.ReadCloser
+		if rc, err = entry.Open(); err != nil {
+			return importedContent{}, "failed to read archive entry"
+		}
+		content.size = int64(entry.UncompressedSize64)
+		content.contentEncoding, err = storeObject(ctx, client, cfg, key, rc, content.size, content.contentType)
+		rc.Close()
+	}
+	if err != nil {
+		log.Printf("import: upload error for %s: %v", key, err)
+		return importedContent{}, "failed to upload file"
+	}
+
+	content.storagePath = "s3://" + cfg.Bucket + "/" + key
+	return content, ""
+}
@@ -0,0 +1,174 @@
+package routes
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// TestImportLyingManifest imports a manifest that understates every file's
+// size and mislabels its types: the archive entries decide.
+func TestImportLyingManifest(t *testing.T) {
+	const uid = "import-user"
+	createTestProject(t, uid)
+	conn, err := db.GetDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`UPDATE user SET storage_limit = 1000 WHERE firebase_uid = ?`, uid); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := map[string][]byte{
+		"big.txt":   bytes.Repeat([]byte("a"), 2000),
+		"small.txt": []byte("ten bytes\n"),
+		"tool.exe":  []byte("MZ\x90\x00\x03\x00\x00\x00"),
+		"logo.svg":  []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect width="1" height="1"/></svg>`),
+		"fake.png":  []byte("not a png at all"),
+	}
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	manifest := ProjectManifest{Version: manifestVersion, Project: db.Project{Name: "imported"}}
+	for name, data := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		mimeType := "text/plain"
+		switch name {
+		case "fake.png":
+			mimeType = "image/png"
+		case "logo.svg":
+			mimeType = "image/svg+xml"
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{ID: name, Filename: name, Size: 1, MimeType: mimeType})
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("manifest", string(manifestJSON))
+	fw, err := mw.CreateFormFile("archive", "archive.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(archive.Bytes())
+	mw.Close()
+
+	// Bodies arrive in signed chunks; the header has the real length
+	var mu sync.Mutex
+	stored := make(map[string][]byte)
+	storedSize := make(map[string]int64)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		size, _ := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		mu.Lock()
+		stored[r.URL.Path] = data
+		storedSize[r.URL.Path] = size
+		mu.Unlock()
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+	}))
+	defer srv.Close()
+	client, err := minio.New(strings.TrimPrefix(srv.URL, "http://"), &minio.Options{
+		Creds:  credentials.NewStaticV4("test", "testsecret", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.MinioConfig{
+		Bucket:             "uploads",
+		StoragePrefix:      "uploads",
+		MaxObjectKeyLength: 1024,
+		MaxNameLength:      128,
+		SanitizeSVG:        true,
+		BlockedExtensions:  []string{".exe"},
+		AllowedMimeTypes:   []string{"text/*", "image/*"},
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	app.Post("/import", func(c fiber.Ctx) error {
+		c.Locals("firebase_user", &auth.FirebaseUser{UID: uid})
+		return importProject(c, client, cfg)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/import", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		data, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status %d: %s", resp.StatusCode, data)
+	}
+	var out importResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	skipped := make(map[string]string)
+	for _, s := range out.FilesSkipped {
+		skipped[s.Filename] = s.Reason
+	}
+	for _, name := range []string{"big.txt", "tool.exe", "fake.png"} {
+		if _, ok := skipped[name]; !ok {
+			t.Errorf("%s was imported", name)
+		}
+	}
+	if reason := skipped["big.txt"]; reason != "storage limit reached" {
+		t.Errorf("big.txt skipped with %q, want the storage limit", reason)
+	}
+	if out.FilesImported != 2 {
+		t.Fatalf("%d files imported (skipped %v), want small.txt and logo.svg", out.FilesImported, skipped)
+	}
+
+	rows, err := conn.Query(`SELECT filename, size, mime_type, storage_path FROM file WHERE project_id = ?`, out.Project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var filename, mimeType, storagePath string
+		var size int64
+		if err := rows.Scan(&filename, &size, &mimeType, &storagePath); err != nil {
+			t.Fatal(err)
+		}
+		key := "/" + strings.TrimPrefix(storagePath, "s3://")
+		if size != storedSize[key] {
+			t.Errorf("%s recorded as %d bytes, stored %d", filename, size, storedSize[key])
+		}
+		data := stored[key]
+		if filename == "logo.svg" && (mimeType != "image/svg+xml" || bytes.Contains(data, []byte("script"))) {
+			t.Errorf("logo.svg stored as %s, unsanitized: %s", mimeType, data)
+		}
+	}
+}
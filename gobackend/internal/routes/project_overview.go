@@ -0,0 +1,94 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// projectOverview is one project with the totals the projects page shows.
+type projectOverview struct {
+	db.Project
+	FileCount    int64      `json:"file_count"`
+	TotalSize    int64      `json:"total_size"`
+	LastUploadAt *time.Time `json:"last_upload_at"`
+	APIKeyCount  int64      `json:"api_key_count"`
+}
+
+// getProjectsOverview handles GET /projects/overview: every project of the
+// user with its file count, storage, last upload time and API key count, in
+// one query instead of a stats request per project.
+func getProjectsOverview(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Scalar subqueries rather than MAX(created_at), which the driver returns
+	// as a string instead of a time
+	rows, err := conn.QueryContext(ctx, `
+		SELECT p.id, p.name, p.description, p.created_at, p.user_firebase_uid,
+			(SELECT COUNT(*) FROM file WHERE project_id = p.id),
+			(SELECT COALESCE(SUM(size), 0) FROM file WHERE project_id = p.id),
+			(SELECT created_at FROM file WHERE project_id = p.id ORDER BY created_at DESC LIMIT 1),
+			(SELECT COUNT(*) FROM apikey WHERE project_id = p.id)
+		FROM project p
+		WHERE p.user_firebase_uid = ?
+		ORDER BY p.created_at DESC
+	`, user.UID)
+	if err != nil {
+		log.Printf("projects overview query error: %v", err)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query projects")
+	}
+	defer rows.Close()
+
+	// Initialize as empty slice (not nil) to ensure JSON returns []
+	overview := make([]projectOverview, 0)
+	for rows.Next() {
+		var p projectOverview
+		var desc sql.NullString
+		var lastUpload sql.NullTime
+		if err := rows.Scan(
+			&p.ID,
+			&p.Name,
+			&desc,
+			&p.CreatedAt,
+			&p.UserFirebaseUID,
+			&p.FileCount,
+			&p.TotalSize,
+			&lastUpload,
+			&p.APIKeyCount,
+		); err != nil {
+			log.Printf("projects overview scan error: %v", err)
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan project")
+		}
+		if desc.Valid {
+			p.Description = &desc.String
+		}
+		if lastUpload.Valid {
+			p.LastUploadAt = &lastUpload.Time
+		}
+		overview = append(overview, p)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate projects")
+	}
+
+	return c.JSON(overview)
+}
@@ -0,0 +1,114 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+	"github.com/gabriel/open_upload_gobackend/internal/jobs"
+)
+
+type warmCacheResponse struct {
+	Preset string `json:"preset"`
+	Format string `json:"format"`
+	// Images is how many of the project's files imgproxy can render, Queued
+	// how many of those were scheduled.
+	Images int `json:"images"`
+	Queued int `json:"queued"`
+}
+
+// warmProjectCache schedules a preset rendering of every image in a project
+// (POST /projects/:project_id/warm-cache?preset=thumbnail&format=webp), so
+// the first real request after a bulk upload is served from cache. Renders
+// run on the job pool, so JOB_WORKERS and IMGPROXY_MAX_CONCURRENCY bound the
+// load on imgproxy; the response only reports what was queued.
+func warmProjectCache(c fiber.Ctx, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+
+	format := c.Query("format", "webp")
+	if !isAllowedFormat(format) {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "format must be webp, jpeg or png")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
+	}
+
+	resp := warmCacheResponse{Preset: c.Query("preset", "thumbnail"), Format: format}
+	if _, _, ok := resolvePreset(ctx, conn, cfg, projectID, resp.Preset); !ok {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid preset")
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT id, filename, mime_type, storage_path, COALESCE(content_encoding, ''), size
+		FROM file
+		WHERE project_id = ?
+		ORDER BY created_at DESC
+	`, projectID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project files")
+	}
+	fileIDs := make([]string, 0)
+	for rows.Next() {
+		var f db.File
+		if err := rows.Scan(&f.ID, &f.Filename, &f.MimeType, &f.StoragePath, &f.ContentEncoding, &f.Size); err != nil {
+			rows.Close()
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan file")
+		}
+		if !rendersThroughImgproxy(cfg, f) {
+			continue
+		}
+		fileIDs = append(fileIDs, f.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate project files")
+	}
+
+	resp.Images = len(fileIDs)
+	for _, id := range fileIDs {
+		if err := jobs.Enqueue(ctx, jobWarmPreset, warmPayload{FileID: id, Preset: resp.Preset, Format: format}); err != nil {
+			log.Printf("warm-cache: project %d: failed to enqueue file %s: %v", projectID, id, err)
+			continue
+		}
+		resp.Queued++
+	}
+
+	return c.Status(http.StatusAccepted).JSON(resp)
+}
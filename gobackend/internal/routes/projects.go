@@ -8,14 +8,39 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
 	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
 	"github.com/gabriel/open_upload_gobackend/internal/db"
 	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
 )
 
 type ProjectStats struct {
 	TotalStorage int64 `json:"total_storage"`
 	TotalFiles   int64 `json:"total_files"`
+	// FileLimit is the project's max file count (0 = unlimited); RemainingFiles
+	// is null when unlimited.
+	FileLimit      int64  `json:"file_limit"`
+	RemainingFiles *int64 `json:"remaining_files"`
+}
+
+// projectDeleteImpact is what DELETE /projects/:project_id?dry_run=true
+// reports.
+type projectDeleteImpact struct {
+	FileCount      int64 `json:"file_count"`
+	TrashFileCount int64 `json:"trash_file_count"`
+	TotalStorage   int64 `json:"total_storage"`
+	APIKeyCount    int64 `json:"api_key_count"`
+	// ObjectsToDelete counts the stored objects only this project's files
+	// and trash reference, and StorageFreed their size: deduplicated content
+	// also referenced from other projects stays.
+	ObjectsToDelete int64 `json:"objects_to_delete"`
+	StorageFreed    int64 `json:"storage_freed"`
+	// LockedFiles under retention make the delete fail with 403 (except for
+	// developers).
+	LockedFiles int64 `json:"locked_files"`
 }
 
 // ProjectWithKeys matches the Python ProjectReadWithKeys model and the
@@ -25,34 +50,119 @@ type ProjectWithKeys struct {
 	APIKeys    []db.ApiKey `json:"api_keys"`
 }
 
+// ProjectManifest is a portable description of a project used for backup and
+// migration. It deliberately leaves out API key secrets and storage paths.
+type ProjectManifest struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Project    db.Project       `json:"project"`
+	Files      []ManifestFile   `json:"files"`
+	APIKeys    []ManifestAPIKey `json:"api_keys"`
+}
+
+type ManifestFile struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size"`
+	MimeType    string    `json:"mime_type"`
+	ContentHash string    `json:"content_hash"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ManifestAPIKey struct {
+	Name      string    `json:"name"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// manifestVersion is bumped when the manifest format changes incompatibly.
+const manifestVersion = 1
+
 // RegisterProjectRoutes wires project-related routes that mirror backend/routes/projects.py.
 // Prefixes are expected to be added by the caller (e.g. app.Group("/projects")).
-func RegisterProjectRoutes(router fiber.Router) {
+// The MinIO client is used by routes that move file contents (e.g. import).
+func RegisterProjectRoutes(router fiber.Router, minioClient *minio.Client, minioCfg config.MinioConfig) {
 	// All project routes require Firebase auth + whitelisted role, as in Python.
 	router.Use(auth.FirebaseAuthMiddleware())
 	router.Use(auth.RequireRoles("whitelisted"))
 
 	// GET /projects
-	router.Get("/", listProjects)
+	router.Get("/", func(c fiber.Ctx) error {
+		return listProjects(c, minioCfg)
+	})
+	// GET /projects/overview - every project with its totals
+	router.Get("/overview", getProjectsOverview)
 	// POST /projects
-	router.Post("/", createProject)
+	router.Post("/", func(c fiber.Ctx) error {
+		return createProject(c, minioCfg)
+	})
+	// POST /projects/import
+	router.Post("/import", func(c fiber.Ctx) error {
+		return importProject(c, minioClient, minioCfg)
+	})
 	// GET /projects/:id
 	router.Get("/:project_id", getProject)
 	// DELETE /projects/:id
-	router.Delete("/:project_id", deleteProject)
+	router.Delete("/:project_id", func(c fiber.Ctx) error {
+		return deleteProject(c, minioClient, minioCfg)
+	})
 	// GET /projects/:id/stats
-	router.Get("/:project_id/stats", getProjectStats)
+	router.Get("/:project_id/stats", func(c fiber.Ctx) error {
+		return getProjectStats(c, minioCfg)
+	})
+	// GET /projects/:id/export
+	router.Get("/:project_id/export", exportProject)
+
+	router.Get("/:project_id/archive", func(c fiber.Ctx) error {
+		return archiveProject(c, minioClient, minioCfg)
+	})
+	// GET /projects/:id/errors - recent failed API requests
+	router.Get("/:project_id/errors", getProjectErrors)
+	// GET/PUT /projects/:id/presets - custom image presets
+	router.Get("/:project_id/retention", getProjectRetention)
+	router.Put("/:project_id/retention", updateProjectRetention)
+
+	router.Get("/:project_id/presets", getProjectPresets)
+	router.Put("/:project_id/presets", updateProjectPresets)
+
+	// POST /projects/:id/warm-cache - render a preset for every image
+	router.Post("/:project_id/warm-cache", func(c fiber.Ctx) error {
+		return warmProjectCache(c, minioCfg)
+	})
+
+	// POST /projects/:id/generate-derivatives - store a preset rendering of
+	// every image in MinIO
+	router.Post("/:project_id/generate-derivatives", func(c fiber.Ctx) error {
+		return generateProjectDerivatives(c, minioCfg)
+	})
+
+	// /projects/:id/webhooks - endpoints notified of file uploads and deletes
+	router.Get("/:project_id/webhooks", listWebhooks)
+	router.Post("/:project_id/webhooks", func(c fiber.Ctx) error {
+		return createWebhook(c, minioCfg)
+	})
+	router.Put("/:project_id/webhooks/:webhook_id", func(c fiber.Ctx) error {
+		return updateWebhook(c, minioCfg)
+	})
+	router.Delete("/:project_id/webhooks/:webhook_id", deleteWebhook)
+	router.Get("/:project_id/webhooks/:webhook_id/deliveries", listWebhookDeliveries)
 }
 
-func listProjects(c fiber.Ctx) error {
+// listProjects handles GET /projects: the user's projects, ordered by sort
+// (created_at or name) and order, or PROJECT_SORT without them.
+func listProjects(c fiber.Ctx, cfg config.MinioConfig) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+	sortColumn, order, err := parseSort(c, config.ProjectSortKeys, cfg.ProjectSort)
+	if err != nil {
+		return err
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -65,7 +175,7 @@ func listProjects(c fiber.Ctx) error {
 		SELECT id, name, description, created_at, user_firebase_uid
 		FROM project
 		WHERE user_firebase_uid = ?
-		ORDER BY created_at DESC
+		ORDER BY `+sortColumn+` `+order+`, id `+order+`
 	`, user.UID)
 	if err != nil {
 		// Log the actual error for debugging
@@ -111,40 +221,43 @@ type projectCreatePayload struct {
 	UserFirebaseUID string  `json:"user_firebase_uid"`
 }
 
-func createProject(c fiber.Ctx) error {
+func createProject(c fiber.Ctx, cfg config.MinioConfig) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	var payload projectCreatePayload
 	if err := c.Bind().Body(&payload); err != nil {
-		return fiber.NewError(http.StatusBadRequest, "invalid project payload")
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project payload")
 	}
 
 	if payload.UserFirebaseUID != user.UID {
-		return fiber.NewError(http.StatusForbidden, "Cannot create project for another user")
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Cannot create project for another user")
+	}
+	if payload.Name, err = validateName("name", payload.Name, cfg.MaxNameLength); err != nil {
+		return err
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	res, err := conn.ExecContext(ctx, `
+	res, err := db.ExecWithRetry(ctx, conn, `
 		INSERT INTO project (name, description, created_at, user_firebase_uid)
 		VALUES (?, ?, CURRENT_TIMESTAMP, ?)
 	`, payload.Name, payload.Description, user.UID)
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to create project")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to create project")
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to get new project id")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to get new project id")
 	}
 
 	// Return the created project
@@ -161,11 +274,12 @@ func createProject(c fiber.Ctx) error {
 		&project.CreatedAt,
 		&project.UserFirebaseUID,
 	); err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to load created project")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load created project")
 	}
 	if desc.Valid {
 		project.Description = &desc.String
 	}
+	audit.Record(ctx, user.UID, audit.ActionCreate, audit.TargetProject, strconv.FormatInt(project.ID, 10), project.ID, project.Name)
 
 	return c.Status(http.StatusCreated).JSON(project)
 }
@@ -173,17 +287,17 @@ func createProject(c fiber.Ctx) error {
 func getProject(c fiber.Ctx) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
 	if err != nil || projectID <= 0 {
-		return fiber.NewError(http.StatusBadRequest, "invalid project id")
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -203,26 +317,26 @@ func getProject(c fiber.Ctx) error {
 		&project.UserFirebaseUID,
 	); err != nil {
 		if err == sql.ErrNoRows {
-			return fiber.NewError(http.StatusNotFound, "Project not found")
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
 		}
-		return fiber.NewError(http.StatusInternalServerError, "failed to load project")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
 	}
 	if desc.Valid {
 		project.Description = &desc.String
 	}
 
 	if project.UserFirebaseUID != user.UID {
-		return fiber.NewError(http.StatusForbidden, "Not authorized to access this project")
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
 	}
 
 	// Load API keys for this project, matching ProjectReadWithKeys/api_keys.
 	rows, err := conn.QueryContext(ctx, `
-		SELECT id, key, name, is_active, created_at, last_used_at, user_firebase_uid, project_id
+		SELECT `+db.APIKeyColumns+`
 		FROM apikey
 		WHERE project_id = ?
 	`, project.ID)
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to load project API keys")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project API keys")
 	}
 	defer rows.Close()
 
@@ -230,29 +344,15 @@ func getProject(c fiber.Ctx) error {
 	apiKeys := make([]db.ApiKey, 0)
 	for rows.Next() {
 		var k db.ApiKey
-		var lastUsed sql.NullTime
-		if err := rows.Scan(
-			&k.ID,
-			&k.Key,
-			&k.Name,
-			&k.IsActive,
-			&k.CreatedAt,
-			&lastUsed,
-			&k.UserFirebaseUID,
-			&k.ProjectID,
-		); err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "failed to scan API key")
-		}
-		if lastUsed.Valid {
-			t := lastUsed.Time
-			k.LastUsedAt = &t
+		if err := db.ScanAPIKey(rows, &k); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan API key")
 		}
 		apiKeys = append(apiKeys, k)
 	}
 
 	// Check for errors during iteration
 	if err := rows.Err(); err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to iterate API keys")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate API keys")
 	}
 
 	resp := ProjectWithKeys{
@@ -263,20 +363,20 @@ func getProject(c fiber.Ctx) error {
 	return c.JSON(resp)
 }
 
-func deleteProject(c fiber.Ctx) error {
+func deleteProject(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
 	if err != nil || projectID <= 0 {
-		return fiber.NewError(http.StatusBadRequest, "invalid project id")
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -290,36 +390,152 @@ func deleteProject(c fiber.Ctx) error {
 		WHERE id = ?
 	`, projectID).Scan(&ownerUID); err != nil {
 		if err == sql.ErrNoRows {
-			return fiber.NewError(http.StatusNotFound, "Project not found")
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
 		}
-		return fiber.NewError(http.StatusInternalServerError, "failed to load project")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
 	}
 
 	if ownerUID != user.UID {
-		return fiber.NewError(http.StatusForbidden, "Not authorized to delete this project")
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to delete this project")
 	}
 
-	if _, err := conn.ExecContext(ctx, `DELETE FROM project WHERE id = ?`, projectID); err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to delete project")
+	if c.Query("dry_run") == "true" {
+		impact, err := projectDeletionImpact(ctx, conn, projectID)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to compute deletion impact")
+		}
+		return c.JSON(impact)
+	}
+
+	// A project can't be deleted while it holds locked files (developers may override)
+	if !user.HasRole("developer") {
+		var lockedFiles int
+		if err := conn.QueryRowContext(ctx, `
+			SELECT COUNT(*)
+			FROM file
+			WHERE project_id = ? AND locked_until > ?
+		`, projectID, time.Now().UTC()).Scan(&lockedFiles); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to check file locks")
+		}
+		if lockedFiles > 0 {
+			return apiError(http.StatusForbidden, apierror.FileLocked, "Project has "+strconv.Itoa(lockedFiles)+" locked files")
+		}
+	}
+
+	files, err := deleteProjectRows(ctx, conn, projectID)
+	if err != nil {
+		log.Printf("deleteProject: failed to delete project %d: %v", projectID, err)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to delete project")
+	}
+	audit.Record(ctx, user.UID, audit.ActionDelete, audit.TargetProject, strconv.FormatInt(projectID, 10), projectID, "")
+
+	// Blobs another project's files still reference stay (deduplication);
+	// removing the rest can take a while for a large project
+	cleanupCtx, cancelCleanup := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancelCleanup()
+	for _, f := range files {
+		removeUnreferencedBlob(cleanupCtx, conn, client, cfg, f)
 	}
 
 	return c.SendStatus(http.StatusNoContent)
 }
 
-func getProjectStats(c fiber.Ctx) error {
+// deleteProjectRows deletes a project with its files, trashed files, API
+// keys, share links and webhooks in one transaction. It returns the deleted
+// file and trashed file rows, whose blobs the caller removes.
+func deleteProjectRows(ctx context.Context, conn *sql.DB, projectID int64) ([]db.File, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	files := make([]db.File, 0)
+	for _, table := range []string{"file", "file_trash"} {
+		rows, err := tx.QueryContext(ctx, `SELECT `+db.FileColumns+` FROM `+table+` WHERE project_id = ?`, projectID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var f db.File
+			if err := db.ScanFile(rows, &f); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			files = append(files, f)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM share_link
+		WHERE file_id IN (SELECT id FROM file WHERE project_id = ? UNION ALL SELECT id FROM file_trash WHERE project_id = ?)
+	`, projectID, projectID); err != nil {
+		return nil, err
+	}
+	for _, table := range []string{"file", "file_trash", "apikey", "project_webhook", "webhook_delivery"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE project_id = ?`, projectID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM project WHERE id = ?`, projectID); err != nil {
+		return nil, err
+	}
+	return files, tx.Commit()
+}
+
+// projectDeletionImpact counts what deleting a project removes (see
+// deleteProjectRows), without changing anything.
+func projectDeletionImpact(ctx context.Context, conn *sql.DB, projectID int64) (projectDeleteImpact, error) {
+	var impact projectDeleteImpact
+	if err := conn.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM file WHERE project_id = ?),
+			(SELECT COUNT(*) FROM file_trash WHERE project_id = ?),
+			(SELECT COALESCE(SUM(size), 0) FROM file WHERE project_id = ?),
+			(SELECT COUNT(*) FROM apikey WHERE project_id = ?),
+			(SELECT COUNT(*) FROM file WHERE project_id = ? AND locked_until > ?)
+	`, projectID, projectID, projectID, projectID, projectID, time.Now().UTC()).Scan(
+		&impact.FileCount, &impact.TrashFileCount, &impact.TotalStorage, &impact.APIKeyCount, &impact.LockedFiles,
+	); err != nil {
+		return impact, err
+	}
+
+	// Rows sharing a storage_path share the object (see removeUnreferencedBlob)
+	err := conn.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(size), 0)
+		FROM (
+			SELECT storage_path, MAX(size) AS size
+			FROM (
+				SELECT storage_path, size FROM file WHERE project_id = ?
+				UNION ALL
+				SELECT storage_path, size FROM file_trash WHERE project_id = ?
+			)
+			GROUP BY storage_path
+		) p
+		WHERE NOT EXISTS (SELECT 1 FROM file f WHERE f.storage_path = p.storage_path AND f.project_id <> ?)
+			AND NOT EXISTS (SELECT 1 FROM file_trash t WHERE t.storage_path = p.storage_path AND t.project_id <> ?)
+	`, projectID, projectID, projectID, projectID).Scan(&impact.ObjectsToDelete, &impact.StorageFreed)
+	return impact, err
+}
+
+func getProjectStats(c fiber.Ctx, cfg config.MinioConfig) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
 	if err != nil || projectID <= 0 {
-		return fiber.NewError(http.StatusBadRequest, "invalid project id")
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -333,12 +549,12 @@ func getProjectStats(c fiber.Ctx) error {
 		WHERE id = ?
 	`, projectID).Scan(&ownerUID); err != nil {
 		if err == sql.ErrNoRows {
-			return fiber.NewError(http.StatusNotFound, "Project not found")
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
 		}
-		return fiber.NewError(http.StatusInternalServerError, "failed to load project")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
 	}
 	if ownerUID != user.UID {
-		return fiber.NewError(http.StatusForbidden, "Not authorized to access this project")
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
 	}
 
 	// Initialize stats with zero values
@@ -362,5 +578,212 @@ func getProjectStats(c fiber.Ctx) error {
 		return c.JSON(stats)
 	}
 
+	if limit, err := projectFileLimit(ctx, conn, cfg, projectID); err == nil {
+		stats.FileLimit = limit
+		if limit > 0 {
+			remaining := max(limit-stats.TotalFiles, 0)
+			stats.RemainingFiles = &remaining
+		}
+	}
+
 	return c.JSON(stats)
 }
+
+func exportProject(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var project db.Project
+	var desc sql.NullString
+	if err := conn.QueryRowContext(ctx, `
+		SELECT id, name, description, created_at, user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(
+		&project.ID,
+		&project.Name,
+		&desc,
+		&project.CreatedAt,
+		&project.UserFirebaseUID,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if desc.Valid {
+		project.Description = &desc.String
+	}
+	if project.UserFirebaseUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
+	}
+
+	manifest, err := loadProjectManifest(ctx, conn, project)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(manifest)
+}
+
+// loadProjectManifest builds the export manifest for a project the caller
+// owns. Errors are API errors.
+func loadProjectManifest(ctx context.Context, conn *sql.DB, project db.Project) (ProjectManifest, error) {
+	manifest := ProjectManifest{
+		Version:    manifestVersion,
+		ExportedAt: time.Now().UTC(),
+		Project:    project,
+		Files:      make([]ManifestFile, 0),
+		APIKeys:    make([]ManifestAPIKey, 0),
+	}
+
+	fileRows, err := conn.QueryContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE project_id = ?
+		ORDER BY created_at
+	`, project.ID)
+	if err != nil {
+		return ProjectManifest{}, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project files")
+	}
+	defer fileRows.Close()
+
+	for fileRows.Next() {
+		var f db.File
+		if err := db.ScanFile(fileRows, &f); err != nil {
+			return ProjectManifest{}, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan file")
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{
+			ID:          f.ID,
+			Filename:    f.Filename,
+			Size:        f.Size,
+			MimeType:    f.MimeType,
+			ContentHash: f.ContentHash,
+			CreatedAt:   f.CreatedAt,
+		})
+	}
+	if err := fileRows.Err(); err != nil {
+		return ProjectManifest{}, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate project files")
+	}
+
+	// Only names and state are exported; key secrets never leave the server.
+	keyRows, err := conn.QueryContext(ctx, `
+		SELECT name, is_active, created_at
+		FROM apikey
+		WHERE project_id = ?
+		ORDER BY created_at
+	`, project.ID)
+	if err != nil {
+		return ProjectManifest{}, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project API keys")
+	}
+	defer keyRows.Close()
+
+	for keyRows.Next() {
+		var k ManifestAPIKey
+		if err := keyRows.Scan(&k.Name, &k.IsActive, &k.CreatedAt); err != nil {
+			return ProjectManifest{}, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan API key")
+		}
+		manifest.APIKeys = append(manifest.APIKeys, k)
+	}
+	if err := keyRows.Err(); err != nil {
+		return ProjectManifest{}, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate API keys")
+	}
+
+	return manifest, nil
+}
+
+// getProjectErrors returns the project's most recent API requests that failed
+// (status >= 400), newest first, so API consumers can debug their integration.
+func getProjectErrors(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT id, timestamp, endpoint, response_time, status_code, user_firebase_uid, project_id, api_key_id
+		FROM apiusage
+		WHERE project_id = ? AND status_code >= 400
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`, projectID, limit)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project errors")
+	}
+	defer rows.Close()
+
+	// Initialize as empty slice (not nil) to ensure JSON returns []
+	errorsList := make([]db.ApiUsage, 0)
+	for rows.Next() {
+		var u db.ApiUsage
+		if err := rows.Scan(
+			&u.ID,
+			&u.Timestamp,
+			&u.Endpoint,
+			&u.ResponseTimeMs,
+			&u.StatusCode,
+			&u.UserFirebaseUID,
+			&u.ProjectID,
+			&u.ApiKeyID,
+		); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan project errors")
+		}
+		errorsList = append(errorsList, u)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate project errors")
+	}
+
+	return c.JSON(errorsList)
+}
@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+func TestDeleteProjectCascades(t *testing.T) {
+	const uid = "cascade-user"
+	projectID := createTestProject(t, uid)
+	otherID := createTestProject(t, uid)
+	conn, err := db.GetDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertFile := func(table, id string, projectID int64, storagePath string) {
+		t.Helper()
+		query := `INSERT INTO ` + table + ` (id, filename, size, mime_type, created_at, project_id, user_firebase_uid, storage_path`
+		if table == "file_trash" {
+			query += `, deleted_at, deleted_by) VALUES (?, 'f', 10, 'text/plain', CURRENT_TIMESTAMP, ?, ?, ?, CURRENT_TIMESTAMP, '` + uid + `')`
+		} else {
+			query += `) VALUES (?, 'f', 10, 'text/plain', CURRENT_TIMESTAMP, ?, ?, ?)`
+		}
+		if _, err := conn.Exec(query, id, projectID, uid, storagePath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insertFile("file", "cascade-own", projectID, "s3://uploads/cascade/own.txt")
+	insertFile("file", "cascade-shared", projectID, "s3://uploads/cascade/shared.txt")
+	insertFile("file_trash", "cascade-trashed", projectID, "s3://uploads/cascade/trashed.txt")
+	// Deduplicated into another project: its blob stays
+	insertFile("file", "cascade-other", otherID, "s3://uploads/cascade/shared.txt")
+	if _, err := conn.Exec(`INSERT INTO apikey (key, name, created_at, user_firebase_uid, project_id) VALUES ('cascade-key', 'k', CURRENT_TIMESTAMP, ?, ?)`, uid, projectID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`INSERT INTO share_link (token, file_id, user_firebase_uid, created_at) VALUES ('cascade-link', 'cascade-own', ?, CURRENT_TIMESTAMP)`, uid); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	removed := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			mu.Lock()
+			removed = append(removed, strings.TrimPrefix(r.URL.Path, "/"))
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	client, err := minio.New(strings.TrimPrefix(srv.URL, "http://"), &minio.Options{
+		Creds:  credentials.NewStaticV4("test", "testsecret", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	app.Delete("/:project_id", func(c fiber.Ctx) error {
+		c.Locals("firebase_user", &auth.FirebaseUser{UID: uid})
+		return deleteProject(c, client, config.MinioConfig{Bucket: "uploads"})
+	})
+	target := "/" + strconv.FormatInt(projectID, 10)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodDelete, target+"?dry_run=true", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var impact projectDeleteImpact
+	if err := json.NewDecoder(resp.Body).Decode(&impact); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if impact.FileCount != 2 || impact.TrashFileCount != 1 || impact.APIKeyCount != 1 || impact.ObjectsToDelete != 2 {
+		t.Fatalf("dry run impact = %+v, want 2 files, 1 trashed, 1 key, 2 objects", impact)
+	}
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodDelete, target, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete status %d", resp.StatusCode)
+	}
+
+	slices.Sort(removed)
+	if want := []string{"uploads/cascade/own.txt", "uploads/cascade/trashed.txt"}; !slices.Equal(removed, want) {
+		t.Errorf("removed objects %v, want %v (the dry run's objects_to_delete)", removed, want)
+	}
+	var left int
+	if err := conn.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM project WHERE id = ?)
+			+ (SELECT COUNT(*) FROM file WHERE project_id = ?)
+			+ (SELECT COUNT(*) FROM file_trash WHERE project_id = ?)
+			+ (SELECT COUNT(*) FROM apikey WHERE project_id = ?)
+			+ (SELECT COUNT(*) FROM share_link WHERE token = 'cascade-link')
+	`, projectID, projectID, projectID, projectID).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d rows of the deleted project remain", left)
+	}
+	var other int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM file WHERE id = 'cascade-other'`).Scan(&other); err != nil || other != 1 {
+		t.Errorf("the other project's file was touched: count %d, %v", other, err)
+	}
+}
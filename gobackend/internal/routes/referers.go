@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+)
+
+// RestrictPublicReferers applies PUBLIC_FILE_ALLOWED_ORIGINS to the public
+// /files routes: a request whose Origin, or else Referer, names a host that
+// isn't listed (nor this server) gets 403, so other sites can't hotlink the
+// files. Requests with neither header pass unless
+// PUBLIC_FILE_ALLOW_EMPTY_REFERER is false. With no origins configured
+// every request passes.
+func RestrictPublicReferers(cfg config.MinioConfig) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if len(cfg.PublicAllowedOrigins) == 0 || c.Method() == fiber.MethodOptions {
+			return c.Next()
+		}
+		// Responses depend on these headers now, shared caches must not mix them
+		c.Vary(fiber.HeaderOrigin, fiber.HeaderReferer)
+
+		// Sandboxed frames and file:// pages send Origin: null
+		source := c.Get(fiber.HeaderOrigin)
+		if source == "" || source == "null" {
+			source = c.Get(fiber.HeaderReferer)
+		}
+		if source == "" {
+			if cfg.PublicAllowEmptyReferer {
+				return c.Next()
+			}
+			return apiError(http.StatusForbidden, apierror.Forbidden, "requests without a Referer are not allowed")
+		}
+
+		u, err := url.Parse(source)
+		if err != nil || u.Host == "" {
+			return apiError(http.StatusForbidden, apierror.Forbidden, "invalid Referer")
+		}
+		host := strings.ToLower(u.Host)
+		if host == strings.ToLower(c.Host()) || originHostAllowed(cfg.PublicAllowedOrigins, host) {
+			return c.Next()
+		}
+		return apiError(http.StatusForbidden, apierror.Forbidden, "files may not be embedded from "+u.Hostname())
+	}
+}
+
+// originHostAllowed reports whether host (with an optional port) matches one
+// of the allowed entries. An entry without a port matches any port, and
+// "*.example.com" matches subdomains of example.com but not example.com.
+func originHostAllowed(allowed []string, host string) bool {
+	name, port := host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		name, port = h, p
+	}
+	for _, entry := range allowed {
+		entryName, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryName, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(entryName, "*."); ok {
+			if strings.HasSuffix(name, "."+suffix) {
+				return true
+			}
+		} else if name == entryName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// maxRetentionDays caps a project's retention period (10 years).
+const maxRetentionDays = 3650
+
+type retentionPayload struct {
+	RetentionDays int64 `json:"retention_days"`
+}
+
+// projectLockUntil returns when files uploaded to a project now stay locked
+// until, or nil when the project has no retention period.
+func projectLockUntil(ctx context.Context, conn *sql.DB, projectID int64, now time.Time) (*time.Time, error) {
+	var days sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT retention_days FROM project WHERE id = ?`, projectID).Scan(&days); err != nil {
+		return nil, err
+	}
+	if !days.Valid || days.Int64 <= 0 {
+		return nil, nil
+	}
+	until := now.UTC().AddDate(0, 0, int(days.Int64))
+	return &until, nil
+}
+
+// lockUntilForUpload is projectLockUntil for upload handlers: it returns the
+// value for the locked_until column (nil or a time) and applies MinIO object
+// retention to the stored object when OBJECT_LOCK_MODE is set. Retention
+// failures are logged; the database lock still blocks deletion via the API.
+func lockUntilForUpload(ctx context.Context, conn *sql.DB, client *minio.Client, cfg config.MinioConfig, projectID int64, key string) (any, error) {
+	until, err := projectLockUntil(ctx, conn, projectID, time.Now())
+	if err != nil || until == nil {
+		return nil, err
+	}
+	if cfg.ObjectLockMode != "" && key != "" {
+		mode := minio.RetentionMode(cfg.ObjectLockMode)
+		if err := client.PutObjectRetention(ctx, cfg.Bucket, key, minio.PutObjectRetentionOptions{
+			Mode:            &mode,
+			RetainUntilDate: until,
+		}); err != nil {
+			log.Printf("retention: failed to set object retention on %s: %v", key, err)
+		}
+	}
+	return *until, nil
+}
+
+// checkFileUnlocked returns a 403 while a file is locked. Users with the
+// developer role may override the lock; pass a nil user for API-key callers.
+func checkFileUnlocked(f db.File, user *auth.FirebaseUser) error {
+	if f.LockedUntil == nil || !time.Now().Before(*f.LockedUntil) {
+		return nil
+	}
+	if user != nil && user.HasRole("developer") {
+		log.Printf("retention: developer %s overriding lock on file %s (locked until %s)", user.UID, f.ID, f.LockedUntil.Format(time.RFC3339))
+		return nil
+	}
+	return apiError(http.StatusForbidden, apierror.FileLocked, "File is locked until "+f.LockedUntil.UTC().Format(time.RFC3339))
+}
+
+// getProjectRetention returns the project's retention period
+// (GET /projects/:project_id/retention).
+func getProjectRetention(c fiber.Ctx) error {
+	return projectRetentionHandler(c, false)
+}
+
+// updateProjectRetention sets the retention period applied to files uploaded
+// from now on (PUT /projects/:project_id/retention); 0 disables it. Files
+// already uploaded keep their lock.
+func updateProjectRetention(c fiber.Ctx) error {
+	return projectRetentionHandler(c, true)
+}
+
+func projectRetentionHandler(c fiber.Ctx, update bool) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+
+	var payload retentionPayload
+	if update {
+		if err := c.Bind().Body(&payload); err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid request body")
+		}
+		if payload.RetentionDays < 0 || payload.RetentionDays > maxRetentionDays {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "retention_days must be between 0 and "+strconv.Itoa(maxRetentionDays))
+		}
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ownerUID string
+	var days sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid, retention_days
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(&ownerUID, &days); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
+	}
+
+	if !update {
+		return c.JSON(retentionPayload{RetentionDays: days.Int64})
+	}
+
+	var stored any
+	if payload.RetentionDays > 0 {
+		stored = payload.RetentionDays
+	}
+	if _, err := conn.ExecContext(ctx, `UPDATE project SET retention_days = ? WHERE id = ?`, stored, projectID); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to save retention")
+	}
+	audit.Record(ctx, user.UID, audit.ActionUpdate, audit.TargetProject, strconv.FormatInt(projectID, 10), projectID,
+		"retention_days="+strconv.FormatInt(payload.RetentionDays, 10))
+	return c.JSON(payload)
+}
@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+)
+
+type sessionResponse struct {
+	UID       string    `json:"uid"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RegisterSessionRoutes wires the cookie session exchange under /auth.
+func RegisterSessionRoutes(router fiber.Router) {
+	// POST /auth/session - exchange a Firebase ID token for a session cookie
+	router.Post("/session", createSession)
+
+	// DELETE /auth/session - clear the session cookie
+	router.Delete("/session", deleteSession)
+}
+
+// createSession verifies the Bearer Firebase ID token once and sets a signed
+// session cookie that FirebaseAuthMiddleware accepts instead of the token
+// until it expires (SESSION_TTL). Only a Bearer token is accepted here, so a
+// session cannot renew itself past the ID token it came from.
+func createSession(c fiber.Ctx) error {
+	if !auth.SessionsEnabled() {
+		return apiError(http.StatusNotFound, apierror.NotFound, "session cookies are not enabled")
+	}
+
+	user, err := auth.VerifyBearer(c)
+	if err != nil {
+		return err
+	}
+
+	expires := time.Now().UTC().Add(auth.SessionTTL()).Truncate(time.Second)
+	value, err := auth.NewSessionValue(user, expires)
+	if err != nil {
+		log.Printf("createSession: failed to sign session for %s: %v", user.UID, err)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to create session")
+	}
+	auth.SetSessionCookie(c, value, expires)
+	c.Set("Cache-Control", "no-store")
+
+	return c.JSON(sessionResponse{UID: user.UID, ExpiresAt: expires})
+}
+
+// deleteSession clears the session cookie (sign-out). Sessions are stateless,
+// so a copied cookie stays valid until it expires.
+func deleteSession(c fiber.Ctx) error {
+	auth.SetSessionCookie(c, "", time.Unix(0, 0))
+	return c.SendStatus(http.StatusNoContent)
+}
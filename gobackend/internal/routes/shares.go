@@ -0,0 +1,356 @@
+package routes
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+const (
+	// SharePasswordHeader carries the password for a protected share link;
+	// the password query parameter works too.
+	SharePasswordHeader = "X-Share-Password"
+
+	// maxSharePasswordLength bounds the input hashed per attempt.
+	maxSharePasswordLength = 1024
+
+	sharePasswordIterations = 100000
+	sharePasswordKeyLength  = 32
+
+	// Failed password attempts allowed per link and client IP within
+	// sharePasswordWindow before further attempts get 429.
+	sharePasswordMaxFailures = 5
+	sharePasswordWindow      = 15 * time.Minute
+)
+
+type createShareRequest struct {
+	// Password, when set, is required to open the link.
+	Password string `json:"password"`
+	// ExpiresIn is the link lifetime in seconds; 0 means it never expires.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type shareResponse struct {
+	Token             string     `json:"token"`
+	URL               string     `json:"url"`
+	FileID            string     `json:"file_id"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+	PasswordProtected bool       `json:"password_protected"`
+}
+
+// createShare creates a public link to one of the user's files
+// (POST /frontend/files/:file_id/share). With a password, only its PBKDF2
+// hash is stored and GET /share/:token asks for it before serving.
+func createShare(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	fileID := c.Params("file_id")
+	if fileID == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
+	}
+
+	var req createShareRequest
+	if len(c.Body()) > 0 {
+		if err := c.Bind().Body(&req); err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid JSON body")
+		}
+	}
+	if req.ExpiresIn < 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "expires_in must not be negative")
+	}
+	if len(req.Password) > maxSharePasswordLength {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "password longer than "+strconv.Itoa(maxSharePasswordLength)+" bytes")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ownerUID string
+	var projectID int64
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid, project_id
+		FROM file
+		WHERE id = ?
+	`, fileID).Scan(&ownerUID, &projectID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to share this file")
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to create share token")
+	}
+	var passwordHash sql.NullString
+	if req.Password != "" {
+		hash, err := hashSharePassword(req.Password)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to hash password")
+		}
+		passwordHash = sql.NullString{String: hash, Valid: true}
+	}
+
+	resp := shareResponse{
+		Token:             token,
+		URL:               c.Scheme() + "://" + c.Host() + "/share/" + token,
+		FileID:            fileID,
+		CreatedAt:         time.Now().UTC(),
+		PasswordProtected: passwordHash.Valid,
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := resp.CreatedAt.Add(time.Duration(req.ExpiresIn) * time.Second)
+		resp.ExpiresAt = &expiresAt
+	}
+
+	if _, err := db.ExecWithRetry(ctx, conn, `
+		INSERT INTO share_link (token, file_id, user_firebase_uid, created_at, expires_at, password_hash)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, token, fileID, user.UID, resp.CreatedAt, resp.ExpiresAt, passwordHash); err != nil {
+		log.Printf("share: db insert error for file %s: %v", fileID, err)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to save share link")
+	}
+	detail := "share link"
+	if resp.PasswordProtected {
+		detail = "password-protected share link"
+	}
+	audit.Record(ctx, user.UID, audit.ActionCreate, audit.TargetShare, fileID, projectID, detail)
+
+	return c.Status(http.StatusCreated).JSON(resp)
+}
+
+// RegisterShareRoutes registers the public share-link route. Links need no
+// authentication beyond the link's own password, if it has one.
+func RegisterShareRoutes(router fiber.Router, client *minio.Client, cfg config.MinioConfig) {
+	// GET /share/:token - the shared file
+	router.Get("/:token", func(c fiber.Ctx) error {
+		return serveShare(c, client, cfg)
+	})
+}
+
+// serveShare serves the file behind a share link. Protected links need the
+// password in the X-Share-Password header or the password query parameter;
+// a missing one gets 401 PASSWORD_REQUIRED, a wrong one 401 INVALID_PASSWORD,
+// and too many wrong ones from the same IP 429 TOO_MANY_ATTEMPTS.
+func serveShare(c fiber.Ctx, client *minio.Client, cfg config.MinioConfig) error {
+	c.Set("Access-Control-Allow-Origin", "*")
+	c.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Set("Access-Control-Allow-Headers", "*")
+	// The password may be in the query string; keep it out of shared caches
+	c.Set("Cache-Control", "private, no-store")
+
+	token := c.Params("token")
+	if token == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "token is required")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	dbCtx, dbCancel := context.WithTimeout(c.Context(), 5*time.Second)
+	defer dbCancel()
+
+	var fileID string
+	var expiresAt sql.NullTime
+	var passwordHash sql.NullString
+	if err := conn.QueryRowContext(dbCtx, `
+		SELECT file_id, expires_at, password_hash
+		FROM share_link
+		WHERE token = ?
+	`, token).Scan(&fileID, &expiresAt, &passwordHash); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.NotFound, "Share link not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load share link")
+	}
+	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
+		return apiError(http.StatusGone, apierror.ShareExpired, "Share link has expired")
+	}
+
+	if passwordHash.Valid {
+		password := c.Get(SharePasswordHeader)
+		if password == "" {
+			password = c.Query("password")
+		}
+		if password == "" {
+			return apiError(http.StatusUnauthorized, apierror.PasswordRequired, "This share link requires a password")
+		}
+		attemptKey := token + "\x00" + c.IP()
+		if sharePasswordFailures.blocked(attemptKey, time.Now()) {
+			c.Set("Retry-After", strconv.Itoa(int(sharePasswordWindow.Seconds())))
+			return apiError(http.StatusTooManyRequests, apierror.TooManyAttempts, "Too many wrong passwords, try again later")
+		}
+		if len(password) > maxSharePasswordLength || !checkSharePassword(passwordHash.String, password) {
+			sharePasswordFailures.record(attemptKey, time.Now())
+			return apiError(http.StatusUnauthorized, apierror.InvalidPassword, "Wrong password")
+		}
+		sharePasswordFailures.reset(attemptKey)
+	}
+
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(dbCtx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &f); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
+	}
+
+	if strings.HasPrefix(f.StoragePath, "s3://") {
+		if client == nil {
+			return apiError(http.StatusInternalServerError, apierror.StorageError, "storage service unavailable")
+		}
+		key, err := extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.StorageError, "invalid storage path")
+		}
+		if err := serveFileFromMinIO(c, context.Background(), client, cfg, f, key); err != nil {
+			return err
+		}
+		// Replaces the file's own Cache-Control (the response is buffered,
+		// so headers can still change)
+		c.Set("Cache-Control", "private, no-store")
+		return nil
+	}
+
+	// Legacy local path
+	if _, err := os.Stat(f.StoragePath); err != nil {
+		return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found on storage")
+	}
+	return c.SendFile(f.StoragePath)
+}
+
+// newShareToken returns a random URL-safe share token.
+func newShareToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSharePassword returns "pbkdf2-sha256$<iterations>$<salt>$<hash>" with
+// hex-encoded salt and hash.
+func hashSharePassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, sharePasswordIterations, sharePasswordKeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", sharePasswordIterations, hex.EncodeToString(salt), hex.EncodeToString(key)), nil
+}
+
+// checkSharePassword reports whether password matches a hash from
+// hashSharePassword.
+func checkSharePassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// failureLimiter counts failed attempts per key within a fixed window. It is
+// in-memory, so each server process counts separately.
+type failureLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*failureWindow
+}
+
+type failureWindow struct {
+	start time.Time
+	count int
+}
+
+var sharePasswordFailures = &failureLimiter{failures: make(map[string]*failureWindow)}
+
+func (l *failureLimiter) blocked(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.failures[key]
+	if !ok || now.Sub(w.start) >= sharePasswordWindow {
+		return false
+	}
+	return w.count >= sharePasswordMaxFailures
+}
+
+func (l *failureLimiter) record(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Drop expired windows so the map only holds recent failures
+	for k, w := range l.failures {
+		if now.Sub(w.start) >= sharePasswordWindow {
+			delete(l.failures, k)
+		}
+	}
+	w, ok := l.failures[key]
+	if !ok {
+		w = &failureWindow{start: now}
+		l.failures[key] = w
+	}
+	w.count++
+}
+
+func (l *failureLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
@@ -0,0 +1,265 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+)
+
+// maxSVGSize bounds the SVG uploads read into memory to be sanitized.
+const maxSVGSize = 10 * 1024 * 1024
+
+// svgDroppedElements are removed with everything inside them: they run
+// script, embed other documents or change how URLs resolve.
+var svgDroppedElements = []string{
+	"script", "foreignobject", "iframe", "embed", "object", "frame", "frameset",
+	"handler", "listener", "base", "link", "meta", "audio", "video",
+}
+
+// svgAnimationElements can set attributes over time, including href.
+var svgAnimationElements = []string{"set", "animate", "animatemotion", "animatetransform"}
+
+// sanitizeSVGUpload reads an SVG upload, sanitizes it and returns the bytes to
+// store with their SHA-256. Unparseable SVGs get 422.
+func sanitizeSVGUpload(fileHeader *multipart.FileHeader) ([]byte, string, error) {
+	if fileHeader.Size > maxSVGSize {
+		return nil, "", svgTooLarge()
+	}
+	src, err := fileHeader.Open()
+	if err != nil {
+		return nil, "", apiError(http.StatusInternalServerError, apierror.StorageError, "failed to open uploaded file")
+	}
+	defer src.Close()
+	return sanitizeSVGReader(src)
+}
+
+// sanitizeSVGReader is sanitizeSVGUpload for an SVG read from src, e.g. an
+// archive entry.
+func sanitizeSVGReader(src io.Reader) ([]byte, string, error) {
+	data, err := io.ReadAll(io.LimitReader(src, maxSVGSize+1))
+	if err != nil {
+		return nil, "", apiError(http.StatusInternalServerError, apierror.StorageError, "failed to read uploaded file")
+	}
+	if len(data) > maxSVGSize {
+		return nil, "", svgTooLarge()
+	}
+
+	clean, err := sanitizeSVG(data)
+	if err != nil {
+		return nil, "", apiError(http.StatusUnprocessableEntity, apierror.InvalidSVG, "SVG could not be sanitized: "+err.Error())
+	}
+	sum := sha256.Sum256(clean)
+	return clean, hex.EncodeToString(sum[:]), nil
+}
+
+func svgTooLarge() error {
+	return apiError(http.StatusUnprocessableEntity, apierror.InvalidSVG, fmt.Sprintf("SVG uploads are limited to %d bytes", maxSVGSize))
+}
+
+// sanitizeSVG re-serializes an SVG document without scripts, event handler
+// attributes, external references (href, url() and @import pointing
+// anywhere but into the document or at an embedded image), comments,
+// processing instructions other than the XML declaration, and DOCTYPEs.
+// Anything that isn't well-formed XML with an <svg> root is an error.
+func sanitizeSVG(data []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var out bytes.Buffer
+
+	var (
+		sawRoot bool
+		// RawToken leaves matching end tags to the caller
+		open []string
+		// skipDepth > 0 while inside a dropped element
+		skipDepth int
+		// styleDepth > 0 while buffering a <style> element's text
+		styleDepth int
+		style      bytes.Buffer
+		styleStart string
+	)
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if !sawRoot {
+				if strings.ToLower(t.Name.Local) != "svg" {
+					return nil, errors.New("root element is not <svg>")
+				}
+				sawRoot = true
+			}
+			open = append(open, svgName(t.Name))
+			if skipDepth > 0 || styleDepth > 0 {
+				if skipDepth > 0 {
+					skipDepth++
+				} else {
+					// markup inside <style> isn't CSS; drop the element
+					skipDepth, styleDepth = 2, 0
+				}
+				continue
+			}
+			if svgElementDropped(t) {
+				skipDepth = 1
+				continue
+			}
+			start := writeSVGStart(t)
+			if strings.ToLower(t.Name.Local) == "style" {
+				styleDepth, styleStart = 1, start
+				style.Reset()
+				continue
+			}
+			out.WriteString(start)
+
+		case xml.EndElement:
+			if len(open) == 0 || open[len(open)-1] != svgName(t.Name) {
+				return nil, fmt.Errorf("unexpected </%s>", svgName(t.Name))
+			}
+			open = open[:len(open)-1]
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			if styleDepth > 0 {
+				styleDepth = 0
+				if cssUnsafe(style.String()) {
+					continue
+				}
+				out.WriteString(styleStart)
+				_ = xml.EscapeText(&out, style.Bytes())
+			}
+			out.WriteString("</" + svgName(t.Name) + ">")
+
+		case xml.CharData:
+			if skipDepth > 0 {
+				continue
+			}
+			if styleDepth > 0 {
+				style.Write(t)
+				continue
+			}
+			if len(open) > 0 {
+				_ = xml.EscapeText(&out, t)
+			}
+
+		case xml.ProcInst:
+			if t.Target == "xml" && !sawRoot {
+				out.WriteString("<?xml " + string(t.Inst) + "?>")
+			}
+
+		case xml.Comment, xml.Directive:
+			// dropped: comments can hide markup from naive filters and
+			// DOCTYPEs can declare entities
+		}
+	}
+	if !sawRoot || len(open) != 0 {
+		return nil, errors.New("document is incomplete")
+	}
+	return out.Bytes(), nil
+}
+
+// svgName is an element or attribute name with its namespace prefix.
+func svgName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+func svgElementDropped(t xml.StartElement) bool {
+	local := strings.ToLower(t.Name.Local)
+	if slices.Contains(svgDroppedElements, local) {
+		return true
+	}
+	// <set attributeName="href" to="javascript:..."> and the like
+	if slices.Contains(svgAnimationElements, local) {
+		for _, a := range t.Attr {
+			if strings.ToLower(a.Name.Local) == "attributename" && strings.HasSuffix(strings.ToLower(strings.TrimSpace(a.Value)), "href") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeSVGStart serializes a start element, keeping only safe attributes.
+func writeSVGStart(t xml.StartElement) string {
+	var b bytes.Buffer
+	b.WriteString("<" + svgName(t.Name))
+	for _, a := range t.Attr {
+		if !svgAttrAllowed(a) {
+			continue
+		}
+		b.WriteString(" " + svgName(a.Name) + `="`)
+		_ = xml.EscapeText(&b, []byte(a.Value))
+		b.WriteString(`"`)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+func svgAttrAllowed(a xml.Attr) bool {
+	local := strings.ToLower(a.Name.Local)
+	value := strings.ToLower(strings.Join(strings.Fields(a.Value), ""))
+	switch {
+	case strings.HasPrefix(local, "on"):
+		return false
+	case a.Name.Space == "xml" && local == "base":
+		return false
+	case strings.Contains(value, "javascript:") || strings.Contains(value, "vbscript:"):
+		return false
+	case local == "href" || local == "src":
+		return svgSafeReference(value)
+	case local == "style" || strings.Contains(value, "url("):
+		return !cssUnsafe(a.Value)
+	}
+	return true
+}
+
+// svgSafeReference reports whether a URL stays inside the document (#id) or
+// is an embedded raster image.
+func svgSafeReference(ref string) bool {
+	if strings.HasPrefix(ref, "#") {
+		return true
+	}
+	for _, t := range []string{"png", "jpeg", "jpg", "gif", "webp"} {
+		if strings.HasPrefix(ref, "data:image/"+t+";") || strings.HasPrefix(ref, "data:image/"+t+",") {
+			return true
+		}
+	}
+	return false
+}
+
+// cssUnsafe reports whether CSS imports, scripts or loads anything outside
+// the document.
+func cssUnsafe(css string) bool {
+	s := strings.ToLower(strings.Join(strings.Fields(css), ""))
+	// CSS escapes could spell any of the checks below
+	if strings.Contains(s, "@import") || strings.Contains(s, "expression(") || strings.Contains(s, "javascript:") || strings.Contains(s, `\`) {
+		return true
+	}
+	for rest := s; ; {
+		i := strings.Index(rest, "url(")
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len("url("):]
+		if !svgSafeReference(strings.TrimLeft(rest, `"'`)) {
+			return true
+		}
+	}
+}
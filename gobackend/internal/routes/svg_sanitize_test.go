@@ -0,0 +1,194 @@
+package routes
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	const open = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">`
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+		// absent must not survive; present must
+		absent  []string
+		present []string
+	}{
+		{
+			name:    "script element",
+			in:      open + `<script>alert(1)</script><rect width="1"/></svg>`,
+			absent:  []string{"script", "alert"},
+			present: []string{`<rect width="1">`},
+		},
+		{
+			name:    "event handlers",
+			in:      open + `<rect onclick="alert(1)" OnLoad="alert(2)" width="1"/></svg>`,
+			absent:  []string{"onclick", "OnLoad", "alert"},
+			present: []string{`width="1"`},
+		},
+		{
+			name:   "javascript href",
+			in:     open + `<a href="javascript:alert(1)"><text>x</text></a></svg>`,
+			absent: []string{"javascript", "href"},
+		},
+		{
+			name:   "javascript xlink:href with whitespace and case",
+			in:     open + `<a xlink:href=" JaVa&#x09;Script:alert(1)">x</a></svg>`,
+			absent: []string{"alert", "href"},
+		},
+		{
+			name:   "vbscript href",
+			in:     open + `<a href="vbscript:msgbox(1)">x</a></svg>`,
+			absent: []string{"vbscript", "href"},
+		},
+		{
+			name:   "external href",
+			in:     open + `<image href="https://evil.example/x.png"/></svg>`,
+			absent: []string{"evil.example"},
+		},
+		{
+			name:    "internal and data image references",
+			in:      open + `<use href="#shape"/><image href="data:image/png;base64,AAAA"/></svg>`,
+			present: []string{`href="#shape"`, `href="data:image/png;base64,AAAA"`},
+		},
+		{
+			name:   "data svg reference",
+			in:     open + `<image href="data:image/svg+xml;base64,PHN2Zz4="/></svg>`,
+			absent: []string{"data:image/svg+xml"},
+		},
+		{
+			name:   "set xlink:href",
+			in:     open + `<a><set attributeName="xlink:href" to="javascript:alert(1)"/>x</a></svg>`,
+			absent: []string{"<set", "javascript"},
+		},
+		{
+			name:   "animate href",
+			in:     open + `<a><animate attributeName=" href " values="javascript:alert(1)"/>x</a></svg>`,
+			absent: []string{"<animate", "javascript"},
+		},
+		{
+			name:    "harmless animation",
+			in:      open + `<rect><animate attributeName="width" from="1" to="2"/></rect></svg>`,
+			present: []string{`<animate attributeName="width"`},
+		},
+		{
+			name:    "foreignObject",
+			in:      open + `<foreignObject><body xmlns="http://www.w3.org/1999/xhtml"><iframe src="https://evil.example"/></body></foreignObject><circle r="1"/></svg>`,
+			absent:  []string{"foreignObject", "body", "iframe", "evil.example"},
+			present: []string{`<circle r="1">`},
+		},
+		{
+			name:   "style @import",
+			in:     open + `<style>@import url("https://evil.example/a.css");</style></svg>`,
+			absent: []string{"@import", "evil.example", "<style"},
+		},
+		{
+			name:   "style external url",
+			in:     open + `<style>rect { fill: url(http://evil.example/p.svg#a) }</style></svg>`,
+			absent: []string{"evil.example"},
+		},
+		{
+			name:    "style internal url",
+			in:      open + `<style>rect { fill: url(#grad) }</style></svg>`,
+			present: []string{"<style>rect { fill: url(#grad) }</style>"},
+		},
+		{
+			name:   "style attribute url",
+			in:     open + `<rect style="fill: url('https://evil.example/p')"/></svg>`,
+			absent: []string{"evil.example", "style="},
+		},
+		{
+			name:   "presentation attribute url",
+			in:     open + `<rect fill="url(https://evil.example/p#a)"/></svg>`,
+			absent: []string{"evil.example"},
+		},
+		{
+			name:   "CSS escape sequence",
+			in:     open + `<style>rect { background: \75 rl(https://evil.example/p) }</style></svg>`,
+			absent: []string{"evil.example", "<style"},
+		},
+		{
+			name:   "CSS escape in style attribute",
+			in:     open + `<rect style="background: u\72l(https://evil.example/p)"/></svg>`,
+			absent: []string{"evil.example"},
+		},
+		{
+			name:   "markup inside style",
+			in:     open + `<style><script>alert(1)</script></style></svg>`,
+			absent: []string{"script", "alert"},
+		},
+		{
+			name:    "DOCTYPE and comments",
+			in:      `<?xml version="1.0"?><!DOCTYPE svg [<!ENTITY x "y">]>` + open + `<!-- <script>alert(1)</script> --><rect/></svg>`,
+			absent:  []string{"DOCTYPE", "ENTITY", "<!--", "alert"},
+			present: []string{`<?xml version="1.0"?>`, "<rect>"},
+		},
+		{
+			name:    "entity reference",
+			in:      `<!DOCTYPE svg [<!ENTITY xxe SYSTEM "file:///etc/passwd">]>` + open + `<text>&xxe;</text></svg>`,
+			wantErr: true,
+		},
+		{
+			name:    "processing instruction",
+			in:      open + `<?xml-stylesheet href="https://evil.example/a.xsl"?><rect/></svg>`,
+			absent:  []string{"xml-stylesheet", "evil.example"},
+			present: []string{"<rect>"},
+		},
+		{
+			name:    "xml:base",
+			in:      open + `<g xml:base="https://evil.example/"><use href="#a"/></g></svg>`,
+			absent:  []string{"xml:base", "evil.example"},
+			present: []string{`href="#a"`},
+		},
+		{
+			name:    "HTML root",
+			in:      `<html><body><script>alert(1)</script></body></html>`,
+			wantErr: true,
+		},
+		{
+			name:    "XHTML root with svg inside",
+			in:      `<div>` + open + `</svg></div>`,
+			wantErr: true,
+		},
+		{
+			name:    "not XML",
+			in:      `GIF89a`,
+			wantErr: true,
+		},
+		{
+			name:    "unclosed root",
+			in:      open + `<rect/>`,
+			wantErr: true,
+		},
+		{
+			name:    "mismatched end tag",
+			in:      open + `<g></a></svg>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := sanitizeSVG([]byte(tt.in))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("sanitized to %s, want an error", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(string(out), s) {
+					t.Errorf("output keeps %q: %s", s, out)
+				}
+			}
+			for _, s := range tt.present {
+				if !strings.Contains(string(out), s) {
+					t.Errorf("output lost %q: %s", s, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// trashPurgeBatch bounds how many trashed files one purge run removes.
+const trashPurgeBatch = 500
+
+// trashFile soft-deletes a file by moving its row into file_trash. The blob
+// stays in MinIO until the trashed row is purged.
+func trashFile(ctx context.Context, conn *sql.DB, fileID, deletedBy string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO file_trash (`+db.FileColumns+`, deleted_at, deleted_by)
+		SELECT `+db.FileColumns+`, ?, ?
+		FROM file
+		WHERE id = ?
+	`, time.Now().UTC(), deletedBy, fileID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM file WHERE id = ?`, fileID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeUnreferencedBlob deletes a file's stored derivatives, and its blob
+// once no file or trashed file references it any more. Call it after the
+// file's own row is gone. Failures are logged.
+func removeUnreferencedBlob(ctx context.Context, conn *sql.DB, client *minio.Client, cfg config.MinioConfig, f db.File) {
+	removeFileDerivatives(ctx, conn, client, cfg, f.ID)
+
+	// Deduplicated uploads copy the storage_path of the blob they reuse, and
+	// a later upload can have reused the same key, so rows with the same
+	// storage_path are the references. Matching content hashes alone don't
+	// count: under DEDUP_SCOPE=per_user another user's identical file has its
+	// own blob.
+	var references int
+	if err := conn.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM file WHERE storage_path = ?)
+			+ (SELECT COUNT(*) FROM file_trash WHERE storage_path = ?)
+	`, f.StoragePath, f.StoragePath).Scan(&references); err != nil {
+		log.Printf("failed to count file references, keeping blob %s: %v", f.StoragePath, err)
+		return
+	}
+	if references > 0 {
+		log.Printf("skipping MinIO deletion: %d files still reference storage_path=%s", references, f.StoragePath)
+		return
+	}
+
+	if !strings.HasPrefix(f.StoragePath, "s3://") {
+		// Legacy local path - best-effort delete from disk
+		_ = os.Remove(f.StoragePath)
+		return
+	}
+	key, err := extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
+	if err != nil {
+		log.Printf("failed to extract key from storage path for deletion: %v", err)
+		return
+	}
+	ctxDel, cancelDel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelDel()
+	if err := client.RemoveObject(ctxDel, cfg.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		log.Printf("delete object error: %v", err)
+		return
+	}
+	log.Printf("deleted MinIO object: %s (last reference)", key)
+}
+
+// listTrash lists the user's soft-deleted files, most recently deleted first
+// (GET /frontend/files/trash?project_id=N). purge_at is when each file stops
+// being restorable.
+func listTrash(c fiber.Ctx, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	var projectID int64
+	if v := c.Query("project_id"); v != "" {
+		projectID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || projectID <= 0 {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project_id")
+		}
+	}
+
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	where := "user_firebase_uid = ?"
+	args := []any{user.UID}
+	if projectID > 0 {
+		var ownerUID string
+		if err := conn.QueryRowContext(ctx, `
+			SELECT user_firebase_uid
+			FROM project
+			WHERE id = ?
+		`, projectID).Scan(&ownerUID); err != nil {
+			if err == sql.ErrNoRows {
+				return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+			}
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+		}
+		if ownerUID != user.UID {
+			return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
+		}
+		where += " AND project_id = ?"
+		args = append(args, projectID)
+	}
+
+	page := pageResponse[db.TrashedFile]{Items: make([]db.TrashedFile, 0), Limit: limit, Offset: offset}
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM file_trash WHERE `+where, args...).Scan(&page.Total); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to count trashed files")
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT `+db.FileColumns+`, deleted_at, deleted_by
+		FROM file_trash
+		WHERE `+where+`
+		ORDER BY deleted_at DESC, id
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query trash")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t db.TrashedFile
+		if err := db.ScanTrashedFile(rows, &t); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan trashed file")
+		}
+		t.PurgeAt = t.DeletedAt.AddDate(0, 0, cfg.TrashRetentionDays)
+		page.Items = append(page.Items, t)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate trash")
+	}
+
+	return c.JSON(page)
+}
+
+// restoreFile moves a trashed file back into its project
+// (POST /frontend/files/trash/:file_id/restore). The project must still exist
+// and have room under its file and storage limits.
+func restoreFile(c fiber.Ctx, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	fileID := c.Params("file_id")
+	if fileID == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var t db.TrashedFile
+	if err := db.ScanTrashedFile(conn.QueryRowContext(ctx, `
+		SELECT `+db.FileColumns+`, deleted_at, deleted_by
+		FROM file_trash
+		WHERE id = ?
+	`, fileID), &t); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found in trash")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load trashed file")
+	}
+	if t.UserFirebaseUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to restore this file")
+	}
+
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, t.ProjectID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project no longer exists")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
+	}
+
+	if err := checkProjectFileLimit(ctx, conn, cfg, t.ProjectID, 1); err != nil {
+		return err
+	}
+	totalStorage, err := UserStorageUsage(ctx, conn, user.UID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to compute storage usage")
+	}
+	quota, err := userStorageLimit(ctx, conn, user.UID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to load storage limit")
+	}
+	// A trashed blob no file references is already charged to the quota;
+	// restoring it only adds to usage when its blob is shared with a file
+	restoreSize := t.Size
+	var refs int64
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM file WHERE storage_path = ?`, t.StoragePath).Scan(&refs); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.StorageError, "failed to compute storage usage")
+	}
+	if refs == 0 {
+		restoreSize = 0
+	}
+	if totalStorage+restoreSize > quota {
+		return apiError(http.StatusRequestEntityTooLarge, apierror.StorageLimitExceeded, "Restore would exceed storage limit")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to restore file")
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO file (`+db.FileColumns+`)
+		SELECT `+db.FileColumns+`
+		FROM file_trash
+		WHERE id = ?
+	`, fileID); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to restore file")
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM file_trash WHERE id = ?`, fileID); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to restore file")
+	}
+	if err := tx.Commit(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to restore file")
+	}
+	audit.Record(ctx, user.UID, audit.ActionRestore, audit.TargetFile, fileID, t.ProjectID, t.Filename)
+
+	return c.JSON(t.File)
+}
+
+// purgeTrash permanently removes files trashed more than TRASH_RETENTION_DAYS
+// ago, and their blobs once nothing else references them.
+func purgeTrash(ctx context.Context, client *minio.Client, cfg config.MinioConfig) error {
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -cfg.TrashRetentionDays)
+	rows, err := conn.QueryContext(ctx, `
+		SELECT `+db.FileColumns+`, deleted_at, deleted_by
+		FROM file_trash
+		WHERE deleted_at < ?
+		ORDER BY deleted_at
+		LIMIT ?
+	`, cutoff, trashPurgeBatch)
+	if err != nil {
+		return err
+	}
+	expired := make([]db.TrashedFile, 0)
+	for rows.Next() {
+		var t db.TrashedFile
+		if err := db.ScanTrashedFile(rows, &t); err != nil {
+			rows.Close()
+			return err
+		}
+		expired = append(expired, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range expired {
+		if _, err := db.ExecWithRetry(ctx, conn, `DELETE FROM file_trash WHERE id = ?`, t.ID); err != nil {
+			return err
+		}
+		if _, err := db.ExecWithRetry(ctx, conn, `DELETE FROM share_link WHERE file_id = ?`, t.ID); err != nil {
+			log.Printf("trash: failed to delete share links for file %s: %v", t.ID, err)
+		}
+		removeUnreferencedBlob(ctx, conn, client, cfg, t.File)
+	}
+	if len(expired) > 0 {
+		log.Printf("trash: purged %d files deleted before %s", len(expired), cutoff.Format(time.RFC3339))
+	}
+	return nil
+}
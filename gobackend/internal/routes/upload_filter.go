@@ -0,0 +1,178 @@
+package routes
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+)
+
+// extensionTypes adds the MIME types executables are commonly sent as that
+// mime.TypeByExtension doesn't know, so a blocked .exe is also caught when it
+// arrives named report.pdf.
+var extensionTypes = map[string][]string{
+	".exe": {"application/x-msdownload", "application/x-dosexec", "application/x-msdos-program", "application/vnd.microsoft.portable-executable"},
+	".msi": {"application/x-msi", "application/x-ms-installer", "application/x-msdownload"},
+	".bat": {"application/x-bat", "application/x-msdos-program"},
+	".cmd": {"application/x-bat", "application/x-msdos-program"},
+	".com": {"application/x-msdos-program"},
+	".scr": {"application/x-msdownload"},
+}
+
+// sniffedImageTypes are the image types http.DetectContentType always
+// recognizes, so an upload claiming one must also sniff as an image.
+var sniffedImageTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true, "image/bmp": true,
+}
+
+// uploadExtension returns the lowercase extension of an uploaded file's
+// base name. Windows ignores trailing dots and spaces, so "setup.exe. " is
+// treated as .exe.
+func uploadExtension(filename string) (string, bool) {
+	name := path.Base(strings.ReplaceAll(filename, `\`, "/"))
+	name = strings.TrimRight(name, ". ")
+	if name == "" || name == "/" {
+		return "", false
+	}
+	return strings.ToLower(path.Ext(name)), true
+}
+
+// checkUploadType applies UPLOAD_ALLOWED_EXTENSIONS and
+// UPLOAD_BLOCKED_EXTENSIONS to an upload. Besides the filename's extension,
+// the type the client declared and the type the upload is stored as are
+// checked against the types of the blocked extensions. Rejections are 415s,
+// a filename without a name is a 400.
+func checkUploadType(cfg config.MinioConfig, filename, clientType, contentType string) error {
+	ext, ok := uploadExtension(filename)
+	if !ok {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "filename is required")
+	}
+	if slices.Contains(cfg.BlockedExtensions, ext) {
+		return apiError(http.StatusUnsupportedMediaType, apierror.UnsupportedFileType, "uploads with the extension "+ext+" are not allowed")
+	}
+	if len(cfg.AllowedExtensions) > 0 && !slices.Contains(cfg.AllowedExtensions, ext) {
+		if ext == "" {
+			return apiError(http.StatusUnsupportedMediaType, apierror.UnsupportedFileType, "uploads must have one of the extensions "+strings.Join(cfg.AllowedExtensions, ", "))
+		}
+		return apiError(http.StatusUnsupportedMediaType, apierror.UnsupportedFileType, "uploads with the extension "+ext+" are not allowed")
+	}
+
+	for _, ct := range []string{clientType, contentType} {
+		// Generic types say nothing about the file (some mime.types files map
+		// .exe to application/octet-stream)
+		ct = mediaType(ct)
+		if ct == "" || ct == "application/octet-stream" || ct == "binary/octet-stream" || ct == "text/plain" {
+			continue
+		}
+		for _, blocked := range cfg.BlockedExtensions {
+			if mediaType(mime.TypeByExtension(blocked)) == ct || slices.Contains(extensionTypes[blocked], ct) {
+				return apiError(http.StatusUnsupportedMediaType, apierror.UnsupportedFileType, "uploads of type "+ct+" are not allowed")
+			}
+		}
+	}
+	return nil
+}
+
+// sniffedAliases lists, per type http.DetectContentType reports, the other
+// types a file with that content is legitimately stored as: other names for
+// the same format, and formats built on the sniffed container (Office
+// documents are zip files, M4A audio is MP4).
+var sniffedAliases = map[string][]string{
+	"application/zip": {
+		"application/vnd.openxmlformats-officedocument.*", "application/vnd.oasis.opendocument.*",
+		"application/epub+zip", "application/java-archive", "application/vnd.android.package-archive",
+		"application/x-zip-compressed",
+	},
+	"application/x-gzip": {"application/gzip", "application/x-tar"},
+	"application/ogg":    {"audio/ogg", "video/ogg", "audio/opus"},
+	"audio/mpeg":         {"audio/mp3"},
+	"audio/wave":         {"audio/wav", "audio/x-wav", "audio/vnd.wave"},
+	"image/x-icon":       {"image/vnd.microsoft.icon"},
+	"video/mp4":          {"video/*", "audio/mp4", "audio/x-m4a", "audio/aac"},
+	"video/webm":         {"audio/webm"},
+	"text/html":          {"text/*", "application/xhtml+xml", "application/xml", "image/svg+xml"},
+}
+
+// sniffedAlias reports whether content sniffed as sniffed may be stored as
+// stored. A trailing * in sniffedAliases matches any suffix.
+func sniffedAlias(sniffed, stored string) bool {
+	for _, a := range sniffedAliases[sniffed] {
+		if prefix, ok := strings.CutSuffix(a, "*"); ok && strings.HasPrefix(stored, prefix) || a == stored {
+			return true
+		}
+	}
+	return false
+}
+
+// textualTypes are non-text/* types whose content is text. Types with a
+// +json or +xml suffix are textual too.
+var textualTypes = map[string]bool{
+	"application/json": true, "application/xml": true, "application/javascript": true,
+	"application/ecmascript": true, "application/x-ndjson": true, "application/yaml": true,
+	"application/x-yaml": true, "application/toml": true, "application/sql": true,
+	"application/x-sh": true,
+}
+
+// isTextualType reports whether content stored as t should be text.
+func isTextualType(t string) bool {
+	return strings.HasPrefix(t, "text/") || textualTypes[t] ||
+		strings.HasSuffix(t, "+json") || strings.HasSuffix(t, "+xml")
+}
+
+// checkUploadContent applies ALLOWED_MIME_TYPES to an upload given its first
+// bytes (up to 512 are sniffed). The type it is stored as must be allowed,
+// and the content must agree with it: when the sniffer recognizes the
+// content, it must be the stored type (or a compatible one, see
+// sniffedAliases), and binary content the sniffer doesn't recognize (ELF,
+// PE and Mach-O executables among it) can't be stored as a text type or an
+// image. So a disallowed file can't pass by declaring an allowed type.
+// Rejections are 415s.
+func checkUploadContent(cfg config.MinioConfig, contentType string, head []byte) error {
+	if len(cfg.AllowedMimeTypes) == 0 {
+		return nil
+	}
+	stored := mediaType(contentType)
+	if !mediaTypeAllowed(cfg.AllowedMimeTypes, stored) {
+		return apiError(http.StatusUnsupportedMediaType, apierror.UnsupportedFileType, "uploads of type "+stored+" are not allowed")
+	}
+	if len(head) == 0 {
+		return nil
+	}
+
+	sniffed := mediaType(http.DetectContentType(head))
+	switch sniffed {
+	case "application/octet-stream":
+		if sniffedImageTypes[stored] || isTextualType(stored) {
+			return apiError(http.StatusUnsupportedMediaType, apierror.UnsupportedFileType, "binary content is not a valid "+stored+" file")
+		}
+		return nil
+	case "text/plain", "text/xml":
+		// Text the sniffer can't tell apart (CSV, JSON, SVG, ...)
+		if sniffedImageTypes[stored] {
+			return apiError(http.StatusUnsupportedMediaType, apierror.UnsupportedFileType, "content is not a valid "+stored+" file")
+		}
+		return nil
+	}
+	if sniffed != stored && !sniffedAlias(sniffed, stored) {
+		return apiError(http.StatusUnsupportedMediaType, apierror.UnsupportedFileType, "content of type "+sniffed+" does not match the declared type "+stored)
+	}
+	return nil
+}
+
+// mediaTypeAllowed reports whether t matches one of allowed, where "image/*"
+// matches every image type.
+func mediaTypeAllowed(allowed []string, t string) bool {
+	for _, a := range allowed {
+		if a == t {
+			return true
+		}
+		if family, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(t, family+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+)
+
+func TestCheckUploadContent(t *testing.T) {
+	var (
+		elf   = []byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x3e\x00")
+		pe    = []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff\x00\x00")
+		macho = []byte("\xcf\xfa\xed\xfe\x07\x00\x00\x01\x03\x00\x00\x00\x02\x00\x00\x00")
+		png   = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x02\x00\x00\x00")
+		zip   = []byte("PK\x03\x04\x14\x00\x06\x00\x08\x00\x00\x00!\x00")
+		csv   = []byte("id,name,size\n1,report.pdf,2048\n2,photo.png,512\n")
+	)
+	cfg := config.MinioConfig{AllowedMimeTypes: []string{
+		"image/*", "text/*", "application/json",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	}}
+
+	tests := []struct {
+		name        string
+		contentType string
+		head        []byte
+		want        int
+	}{
+		{"csv as text/csv", "text/csv", csv, 0},
+		{"png as image/png", "image/png", png, 0},
+		{"docx sniffed as zip", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", zip, 0},
+		{"no content yet", "text/plain", nil, 0},
+		{"elf as text/plain", "text/plain", elf, http.StatusUnsupportedMediaType},
+		{"elf as application/json", "application/json", elf, http.StatusUnsupportedMediaType},
+		{"pe as text/csv", "text/csv", pe, http.StatusUnsupportedMediaType},
+		{"mach-o as application/json", "application/json", macho, http.StatusUnsupportedMediaType},
+		{"elf as image/png", "image/png", elf, http.StatusUnsupportedMediaType},
+		{"png as image/jpeg", "image/jpeg", png, http.StatusUnsupportedMediaType},
+		{"png as text/csv", "text/csv", png, http.StatusUnsupportedMediaType},
+		{"zip as application/json", "application/json", zip, http.StatusUnsupportedMediaType},
+		{"csv as image/png", "image/png", csv, http.StatusUnsupportedMediaType},
+		{"type not allowed", "application/pdf", nil, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		err := checkUploadContent(cfg, tt.contentType, tt.head)
+		if tt.want == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: accepted, want %d", tt.name, tt.want)
+		} else if got := errorStatus(err); got != tt.want {
+			t.Errorf("%s: status %d, want %d (%v)", tt.name, got, tt.want, err)
+		}
+	}
+}
+
+func TestCheckUploadContentUnrestricted(t *testing.T) {
+	if err := checkUploadContent(config.MinioConfig{}, "text/plain", []byte("\x7fELF\x02\x01\x01\x00")); err != nil {
+		t.Fatalf("checkUploadContent without ALLOWED_MIME_TYPES = %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package routes
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+)
+
+const (
+	// maxUploadIDLength bounds the client-chosen upload_id.
+	maxUploadIDLength = 128
+
+	// uploadProgressKeep is how long a finished upload's progress stays
+	// readable, so a client that connects late still sees the outcome.
+	uploadProgressKeep = time.Minute
+
+	// uploadProgressInterval is how often the SSE stream checks for changes.
+	uploadProgressInterval = 250 * time.Millisecond
+
+	// uploadProgressWait is how long the SSE stream waits for an upload_id
+	// that hasn't started yet (the stream is usually opened first).
+	uploadProgressWait = 30 * time.Second
+)
+
+// Upload phases reported by GET /frontend/files/upload-progress/:upload_id.
+// The request body has been fully received by the time an upload starts, so
+// the phases cover the server's own work: storing the file in MinIO, which
+// hashes it on the way.
+const (
+	uploadPhasePending = "pending"
+	uploadPhaseStoring = "storing"
+	uploadPhaseDone    = "done"
+	uploadPhaseError   = "error"
+)
+
+// uploadProgressEvent is the data of one SSE progress event.
+type uploadProgressEvent struct {
+	UploadID string `json:"upload_id"`
+	Phase    string `json:"phase"`
+	// Bytes of Total processed in the current phase
+	Bytes  int64  `json:"bytes"`
+	Total  int64  `json:"total"`
+	FileID string `json:"file_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// uploadProgress tracks one in-flight upload. A nil *uploadProgress is valid
+// and records nothing, for uploads without an upload_id.
+type uploadProgress struct {
+	mu    sync.Mutex
+	event uploadProgressEvent
+}
+
+var uploadProgressRegistry = struct {
+	mu      sync.Mutex
+	entries map[string]*uploadProgress
+}{entries: make(map[string]*uploadProgress)}
+
+// uploadProgressKey scopes upload ids to their user, so nobody can follow
+// another user's upload.
+func uploadProgressKey(uid, uploadID string) string {
+	return uid + "\x00" + uploadID
+}
+
+// startUploadProgress registers an upload under the user's upload_id. It
+// returns nil when uploadID is empty.
+func startUploadProgress(uid, uploadID string, total int64) *uploadProgress {
+	if uploadID == "" {
+		return nil
+	}
+	p := &uploadProgress{event: uploadProgressEvent{UploadID: uploadID, Phase: uploadPhaseStoring, Total: total}}
+	uploadProgressRegistry.mu.Lock()
+	uploadProgressRegistry.entries[uploadProgressKey(uid, uploadID)] = p
+	uploadProgressRegistry.mu.Unlock()
+	return p
+}
+
+func lookupUploadProgress(uid, uploadID string) *uploadProgress {
+	uploadProgressRegistry.mu.Lock()
+	defer uploadProgressRegistry.mu.Unlock()
+	return uploadProgressRegistry.entries[uploadProgressKey(uid, uploadID)]
+}
+
+// finish records the outcome and removes the entry after uploadProgressKeep.
+func (p *uploadProgress) finish(uid string, fileID string, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if err != nil {
+		p.event.Phase = uploadPhaseError
+		p.event.Error = err.Error()
+	} else {
+		p.event.Phase = uploadPhaseDone
+		p.event.Bytes = p.event.Total
+		p.event.FileID = fileID
+	}
+	key := uploadProgressKey(uid, p.event.UploadID)
+	p.mu.Unlock()
+
+	time.AfterFunc(uploadProgressKeep, func() {
+		uploadProgressRegistry.mu.Lock()
+		defer uploadProgressRegistry.mu.Unlock()
+		// A retry may have reused the upload_id in the meantime
+		if uploadProgressRegistry.entries[key] == p {
+			delete(uploadProgressRegistry.entries, key)
+		}
+	})
+}
+
+// setPhase starts a new phase with its byte count reset.
+func (p *uploadProgress) setPhase(phase string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.event.Phase = phase
+	p.event.Bytes = 0
+}
+
+func (p *uploadProgress) add(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.event.Bytes += n
+}
+
+func (p *uploadProgress) snapshot() uploadProgressEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.event
+}
+
+// reader counts the bytes read from r toward the current phase.
+func (p *uploadProgress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, p: p}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *uploadProgress
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.p.add(int64(n))
+	return n, err
+}
+
+// streamUploadProgress handles GET /frontend/files/upload-progress/:upload_id,
+// a Server-Sent Events stream of progress events for the user's upload sent
+// with the same upload_id form field. The stream ends after the done or error
+// event. Authentication uses the Authorization header like every frontend
+// route, so browsers need a fetch-based SSE client rather than EventSource.
+func streamUploadProgress(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	uploadID := c.Params("upload_id")
+	if uploadID == "" || len(uploadID) > maxUploadIDLength {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid upload_id")
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("X-Accel-Buffering", "no")
+
+	uid := user.UID
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		ticker := time.NewTicker(uploadProgressInterval)
+		defer ticker.Stop()
+
+		deadline := time.Now().Add(uploadProgressWait)
+		var last uploadProgressEvent
+		sent := false
+		for {
+			event := uploadProgressEvent{UploadID: uploadID, Phase: uploadPhasePending}
+			if p := lookupUploadProgress(uid, uploadID); p != nil {
+				event = p.snapshot()
+			} else if time.Now().After(deadline) {
+				event.Phase = uploadPhaseError
+				event.Error = "upload not found"
+			}
+
+			if !sent || event != last {
+				data, _ := json.Marshal(event)
+				if _, err := w.WriteString("event: progress\ndata: " + string(data) + "\n\n"); err != nil {
+					return
+				}
+				// Flush fails once the client has disconnected
+				if err := w.Flush(); err != nil {
+					return
+				}
+				last, sent = event, true
+			}
+			if event.Phase == uploadPhaseDone || event.Phase == uploadPhaseError {
+				return
+			}
+			<-ticker.C
+		}
+	})
+}
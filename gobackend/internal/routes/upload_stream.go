@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+)
+
+const (
+	// uploadTempDir is where streamed uploads are stored, under
+	// STORAGE_PREFIX, until their hash decides where they belong. Project
+	// keys start with the project id, so it can't collide with them.
+	uploadTempDir = ".tmp"
+
+	// uploadTempMaxAge is when a temporary object left behind (e.g. by a
+	// restart mid-upload) is removed by the cleanup job.
+	uploadTempMaxAge = 24 * time.Hour
+
+	// maxSingleCopySize is the largest object one S3 CopyObject can copy.
+	maxSingleCopySize = 5 << 30
+)
+
+// uploadTempPrefix is the key prefix of temporary upload objects, with a
+// trailing slash.
+func uploadTempPrefix(cfg config.MinioConfig) string {
+	return path.Join(cfg.StoragePrefix, uploadTempDir) + "/"
+}
+
+// streamUploadToTemp stores an upload under a temporary key while hashing
+// it, so the file is read once however large it is. It returns the key, the
+// hex SHA-256 of the content and the stored content encoding (see
+// storeObject). On error nothing is left behind.
+func streamUploadToTemp(ctx context.Context, client *minio.Client, cfg config.MinioConfig, src io.Reader, size int64, contentType string) (tmpKey, contentHash, contentEncoding string, err error) {
+	tmpKey = uploadTempPrefix(cfg) + uuid.NewString()
+	hash := sha256.New()
+	contentEncoding, err = storeObject(ctx, client, cfg, tmpKey, io.TeeReader(src, hash), size, contentType)
+	if err != nil {
+		removeTempUpload(client, cfg, tmpKey)
+		return "", "", "", err
+	}
+	return tmpKey, hex.EncodeToString(hash.Sum(nil)), contentEncoding, nil
+}
+
+// promoteTempUpload moves a temporary upload of size bytes to its final key
+// with a server-side copy. Objects over 5 GiB, the limit of a single copy,
+// are copied in parts with ComposeObject, which doesn't carry the source's
+// headers, so the content type and encoding are always set explicitly.
+func promoteTempUpload(ctx context.Context, client *minio.Client, cfg config.MinioConfig, tmpKey, key string, size int64, contentType, contentEncoding string) error {
+	meta := map[string]string{"Content-Type": contentType}
+	if contentEncoding != "" {
+		meta["Content-Encoding"] = contentEncoding
+	}
+	dst := minio.CopyDestOptions{Bucket: cfg.Bucket, Object: key, ReplaceMetadata: true, UserMetadata: meta}
+	src := minio.CopySrcOptions{Bucket: cfg.Bucket, Object: tmpKey}
+	var err error
+	if size <= maxSingleCopySize {
+		_, err = client.CopyObject(ctx, dst, src)
+	} else {
+		_, err = client.ComposeObject(ctx, dst, src)
+	}
+	if err != nil {
+		return err
+	}
+	removeTempUpload(client, cfg, tmpKey)
+	return nil
+}
+
+// removeTempUpload deletes a temporary upload, logging failures; the cleanup
+// job catches what is left.
+func removeTempUpload(client *minio.Client, cfg config.MinioConfig, tmpKey string) {
+	// Also after the request's context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.RemoveObject(ctx, cfg.Bucket, tmpKey, minio.RemoveObjectOptions{}); err != nil {
+		log.Printf("upload: failed to remove temporary object %s: %v", tmpKey, err)
+	}
+}
+
+// cleanupTempUploads removes temporary upload objects older than
+// uploadTempMaxAge.
+func cleanupTempUploads(ctx context.Context, client *minio.Client, cfg config.MinioConfig) error {
+	if client == nil {
+		return nil
+	}
+	cutoff := time.Now().Add(-uploadTempMaxAge)
+	removed := 0
+	for obj := range client.ListObjects(ctx, cfg.Bucket, minio.ListObjectsOptions{Prefix: uploadTempPrefix(cfg), Recursive: true}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if obj.LastModified.After(cutoff) {
+			continue
+		}
+		if err := client.RemoveObject(ctx, cfg.Bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("upload: failed to remove stale temporary object %s: %v", obj.Key, err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("upload: removed %d stale temporary objects", removed)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package routes
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/cors"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// uploadTokenPrefix versions the token format.
+const uploadTokenPrefix = "ut1."
+
+// uploadTokenClaims is the signed payload of an upload token: who may upload,
+// into which project, until when (unix seconds).
+type uploadTokenClaims struct {
+	ProjectID int64  `json:"pid"`
+	UserUID   string `json:"uid"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type uploadTokenRequest struct {
+	ProjectID int64 `json:"project_id"`
+	// ExpiresIn is the token lifetime in seconds (default UPLOAD_TOKEN_TTL)
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type uploadTokenResponse struct {
+	Token     string    `json:"token"`
+	ProjectID int64     `json:"project_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the concatenated parts.
+func hmacSHA256(key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return mac.Sum(nil)
+}
+
+// signUploadToken encodes and signs claims as "ut1.<payload>.<signature>",
+// both parts base64url without padding.
+func signUploadToken(secret []byte, claims uploadTokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	sig := hmacSHA256(secret, []byte(uploadTokenPrefix+encoded))
+	return uploadTokenPrefix + encoded + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// verifyUploadToken checks the signature and expiry of a token and returns
+// its claims.
+func verifyUploadToken(secret []byte, token string, now time.Time) (uploadTokenClaims, error) {
+	var claims uploadTokenClaims
+	invalid := apiError(http.StatusUnauthorized, apierror.Unauthenticated, "invalid upload token")
+
+	rest, ok := strings.CutPrefix(token, uploadTokenPrefix)
+	if !ok {
+		return claims, invalid
+	}
+	encoded, sigPart, ok := strings.Cut(rest, ".")
+	if !ok {
+		return claims, invalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil || !hmac.Equal(sig, hmacSHA256(secret, []byte(uploadTokenPrefix+encoded))) {
+		return claims, invalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(payload, &claims) != nil || claims.ProjectID <= 0 || claims.UserUID == "" {
+		return claims, invalid
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return claims, apiError(http.StatusUnauthorized, apierror.Unauthenticated, "upload token expired")
+	}
+	return claims, nil
+}
+
+// createUploadToken handles POST /frontend/files/upload-token: a short-lived
+// token that lets a browser upload into one of the user's projects without
+// an API key.
+func createUploadToken(c fiber.Ctx, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	var req uploadTokenRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid request body")
+	}
+	if req.ProjectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project_id")
+	}
+	ttl := cfg.UploadTokenTTL
+	if req.ExpiresIn < 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "expires_in must be positive")
+	}
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+		if ttl > cfg.UploadTokenMaxTTL {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "expires_in exceeds the maximum of "+cfg.UploadTokenMaxTTL.String())
+		}
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, req.ProjectID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if ownerUID != user.UID {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to upload to this project")
+	}
+
+	expiresAt := time.Now().Add(ttl).UTC().Truncate(time.Second)
+	token, err := signUploadToken(cfg.UploadTokenSecret, uploadTokenClaims{
+		ProjectID: req.ProjectID,
+		UserUID:   user.UID,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to create upload token")
+	}
+
+	return c.Status(http.StatusCreated).JSON(uploadTokenResponse{
+		Token:     token,
+		ProjectID: req.ProjectID,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// RegisterTokenUploadRoutes registers the upload route authenticated by an
+// upload token (Authorization: Bearer <token>) instead of an API key, so
+// browsers on any origin can upload directly.
+func RegisterTokenUploadRoutes(router fiber.Router, client *minio.Client, cfg config.MinioConfig) {
+	router.Use(cors.New(cors.Config{
+		AllowMethods:     []string{"POST", "OPTIONS"},
+		AllowHeaders:     []string{"Authorization", "Content-Type"},
+		AllowCredentials: false,
+		AllowOriginsFunc: func(origin string) bool { return true },
+	}))
+
+	// POST /upload - multipart "file", token in the Authorization header
+	router.Post("/", func(c fiber.Ctx) error {
+		token, ok := strings.CutPrefix(c.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "missing upload token")
+		}
+		claims, err := verifyUploadToken(cfg.UploadTokenSecret, strings.TrimSpace(token), time.Now())
+		if err != nil {
+			return err
+		}
+
+		if err := checkUploadRequestSize(c, cfg); err != nil {
+			return err
+		}
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file is required")
+		}
+		if err := checkUploadSize(cfg, fileHeader.Size); err != nil {
+			return err
+		}
+
+		conn, err := db.GetDB()
+		if err != nil {
+			return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// The project may have been deleted since the token was issued
+		var ownerUID string
+		if err := conn.QueryRowContext(ctx, `
+			SELECT user_firebase_uid
+			FROM project
+			WHERE id = ?
+		`, claims.ProjectID).Scan(&ownerUID); err != nil {
+			if err == sql.ErrNoRows {
+				return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+			}
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+		}
+		if ownerUID != claims.UserUID {
+			return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to upload to this project")
+		}
+
+		f, err := saveUpload(ctx, conn, client, cfg, claims.UserUID, claims.ProjectID, fileHeader, nil)
+		if err != nil {
+			return err
+		}
+
+		return c.Status(http.StatusCreated).JSON(f)
+	})
+}
@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+)
+
+func TestVerifyUploadToken(t *testing.T) {
+	secret := []byte("upload-token-secret")
+	now := time.Unix(1_700_000_000, 0)
+	claims := uploadTokenClaims{ProjectID: 7, UserUID: "token-user", ExpiresAt: now.Add(time.Minute).Unix()}
+	token, err := signUploadToken(secret, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(strings.TrimPrefix(token, uploadTokenPrefix), ".")
+
+	// Another project's claims under the original signature
+	otherPayload, _ := json.Marshal(uploadTokenClaims{ProjectID: 8, UserUID: "token-user", ExpiresAt: claims.ExpiresAt})
+	tamperedPayload := uploadTokenPrefix + base64.RawURLEncoding.EncodeToString(otherPayload) + "." + sig
+	// Flip the first signature byte
+	sigBytes, _ := base64.RawURLEncoding.DecodeString(sig)
+	sigBytes[0] ^= 1
+	tamperedSig := uploadTokenPrefix + payload + "." + base64.RawURLEncoding.EncodeToString(sigBytes)
+
+	tests := []struct {
+		name   string
+		secret []byte
+		token  string
+		now    time.Time
+		detail string
+	}{
+		{"valid", secret, token, now, ""},
+		{"tampered payload", secret, tamperedPayload, now, "invalid upload token"},
+		{"tampered signature", secret, tamperedSig, now, "invalid upload token"},
+		{"truncated signature", secret, token[:len(token)-2], now, "invalid upload token"},
+		{"wrong secret", []byte("another-secret"), token, now, "invalid upload token"},
+		{"wrong prefix", secret, "ut2." + payload + "." + sig, now, "invalid upload token"},
+		{"no signature", secret, uploadTokenPrefix + payload, now, "invalid upload token"},
+		{"expired", secret, token, now.Add(time.Minute), "upload token expired"},
+	}
+	for _, tt := range tests {
+		got, err := verifyUploadToken(tt.secret, tt.token, tt.now)
+		if tt.detail == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if got != claims {
+				t.Errorf("%s: claims %+v, want %+v", tt.name, got, claims)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: accepted with claims %+v", tt.name, got)
+			continue
+		}
+		if errorStatus(err) != http.StatusUnauthorized || err.Error() != tt.detail {
+			t.Errorf("%s: got %d %q, want 401 %q", tt.name, errorStatus(err), err.Error(), tt.detail)
+		}
+	}
+}
+
+// TestTokenUploadProjectMismatch uses a validly signed token whose user no
+// longer owns (or never owned) the project.
+func TestTokenUploadProjectMismatch(t *testing.T) {
+	projectID := createTestProject(t, "token-owner")
+	cfg := config.MinioConfig{UploadTokenSecret: []byte("upload-token-secret")}
+	token, err := signUploadToken(cfg.UploadTokenSecret, uploadTokenClaims{
+		ProjectID: projectID,
+		UserUID:   "token-intruder",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("notes\n"))
+	mw.Close()
+
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.Handler})
+	// Rejected before anything is stored, so no storage client is needed
+	RegisterTokenUploadRoutes(app.Group("/upload"), nil, cfg)
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out apierror.Body
+	json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != http.StatusForbidden || out.Code != apierror.Forbidden {
+		t.Errorf("got %d %q, want 403 %q", resp.StatusCode, out.Code, apierror.Forbidden)
+	}
+}
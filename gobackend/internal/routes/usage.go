@@ -3,11 +3,13 @@ package routes
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
 	"github.com/gabriel/open_upload_gobackend/internal/auth"
 	"github.com/gabriel/open_upload_gobackend/internal/config"
 	"github.com/gabriel/open_upload_gobackend/internal/db"
@@ -36,8 +38,47 @@ type StorageStats struct {
 	MinIOObjects    int64               `json:"minio_objects"`         // Number of objects in MinIO
 	StorageLimit    int64               `json:"storage_limit"`         // User storage limit
 	MinIOStats      *config.BucketStats `json:"minio_stats,omitempty"` // Detailed MinIO stats
+
+	// MinIOStatsAvailable is false when the bucket listing failed or timed
+	// out; the minio_* numbers are then 0 and StatsError says why. The
+	// database numbers are what quotas use either way.
+	MinIOStatsAvailable bool   `json:"minio_stats_available"`
+	StatsError          string `json:"stats_error,omitempty"`
+	// MinIOStatsTruncated is set when the listing stopped at
+	// BUCKET_STATS_MAX_OBJECTS, so the minio_* numbers are a lower bound.
+	MinIOStatsTruncated bool `json:"minio_stats_truncated"`
+}
+
+// StorageHistoryPoint is one day of the storage trend.
+type StorageHistoryPoint struct {
+	Date       string `json:"date"`
+	TotalSize  int64  `json:"total_size"`
+	TotalFiles int64  `json:"total_files"`
+}
+
+// ProjectStorageUsage is one project's storage over a period.
+type ProjectStorageUsage struct {
+	ProjectID int64 `json:"project_id"`
+	// ProjectName is null for projects deleted since.
+	ProjectName  *string `json:"project_name"`
+	AverageSize  float64 `json:"average_size"`
+	MaxSize      int64   `json:"max_size"`
+	AverageFiles float64 `json:"average_files"`
+	MaxFiles     int64   `json:"max_files"`
+}
+
+// ProjectStorageReport is the envelope of GET /usage/storage/by-project.
+type ProjectStorageReport struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	// Days is how many days the averages are taken over.
+	Days  int                   `json:"days"`
+	Items []ProjectStorageUsage `json:"items"`
 }
 
+// maxStorageReportDays bounds the by-project window.
+const maxStorageReportDays = 366
+
 // RegisterUsageRoutes registers /usage* routes that mirror backend/routes/usage.py
 // and are used by the frontend dashboard.
 func RegisterUsageRoutes(router fiber.Router, minioClient *minio.Client, minioCfg config.MinioConfig) {
@@ -50,17 +91,19 @@ func RegisterUsageRoutes(router fiber.Router, minioClient *minio.Client, minioCf
 	})
 	router.Get("/", getUsageStats)
 	router.Get("/details", getUsageDetails)
+	router.Get("/storage/history", getStorageHistory)
+	router.Get("/storage/by-project", getStorageByProject)
 }
 
 func getDashboardStats(c fiber.Ctx) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -70,17 +113,19 @@ func getDashboardStats(c fiber.Ctx) error {
 	var totalStorage, totalFiles int64 = 0, 0
 
 	err = conn.QueryRowContext(ctx, `
-		SELECT
-			COALESCE(SUM(size), 0) AS total_storage,
-			COALESCE(COUNT(id), 0) AS total_files
+		SELECT COUNT(id)
 		FROM file
 		WHERE user_firebase_uid = ?
-	`, user.UID).Scan(&totalStorage, &totalFiles)
+	`, user.UID).Scan(&totalFiles)
 	if err != nil && err != sql.ErrNoRows {
 		// If query fails, return zero values instead of error
-		totalStorage = 0
 		totalFiles = 0
 	}
+	// Same figure the quota is enforced against
+	totalStorage, err = UserStorageUsage(ctx, conn, user.UID)
+	if err != nil {
+		totalStorage = 0
+	}
 
 	// API requests in last 30 days - initialize with zero values
 	endDate := time.Now().UTC()
@@ -122,8 +167,11 @@ func getDashboardStats(c fiber.Ctx) error {
 		}
 	}
 
-	// 50GB limit like Python
-	const storageLimit = 50 * 1024 * 1024 * 1024
+	storageLimit, err := userStorageLimit(ctx, conn, user.UID)
+	if err != nil {
+		log.Printf("Failed to load storage limit: %v", err)
+		storageLimit = db.DefaultStorageLimit
+	}
 
 	stats := DashboardStats{
 		TotalStorage:      totalStorage,
@@ -139,49 +187,50 @@ func getDashboardStats(c fiber.Ctx) error {
 func getStorageStats(c fiber.Ctx, minioClient *minio.Client, minioCfg config.MinioConfig) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	// Get storage tracked in database
-	var databaseStorage int64 = 0
-	err = conn.QueryRowContext(ctx, `
-		SELECT COALESCE(SUM(size), 0)
-		FROM file
-		WHERE user_firebase_uid = ?
-	`, user.UID).Scan(&databaseStorage)
-	if err != nil && err != sql.ErrNoRows {
+	databaseStorage, err := UserStorageUsage(ctx, conn, user.UID)
+	if err != nil {
 		log.Printf("Failed to query database storage: %v", err)
 		databaseStorage = 0
 	}
 
-	// Get MinIO bucket statistics
-	minioStats, err := config.GetBucketStats(ctx, minioClient, minioCfg.Bucket)
+	storageLimit, err := userStorageLimit(ctx, conn, user.UID)
 	if err != nil {
-		log.Printf("Failed to get MinIO bucket stats: %v", err)
-		// Continue with database stats even if MinIO query fails
-		minioStats = config.BucketStats{
-			TotalSize:   0,
-			ObjectCount: 0,
-		}
+		log.Printf("Failed to load storage limit: %v", err)
+		storageLimit = db.DefaultStorageLimit
 	}
 
-	// 50GB limit like Python
-	const storageLimit = 50 * 1024 * 1024 * 1024
-
 	stats := StorageStats{
 		DatabaseStorage: databaseStorage,
-		MinIOStorage:    minioStats.TotalSize,
-		MinIOObjects:    minioStats.ObjectCount,
 		StorageLimit:    storageLimit,
-		MinIOStats:      &minioStats,
+	}
+
+	// Get MinIO bucket statistics. On failure, continue with the database
+	// stats and flag the MinIO numbers as unavailable rather than zero.
+	minioStats, err := config.GetBucketStats(ctx, minioClient, minioCfg.Bucket, minioCfg.BucketStatsMaxObjects)
+	if err != nil {
+		log.Printf("Failed to get MinIO bucket stats: %v", err)
+		stats.StatsError = "live storage stats unavailable: " + err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			stats.StatsError = "live storage stats unavailable: listing the bucket timed out"
+		}
+	} else {
+		stats.MinIOStatsAvailable = true
+		stats.MinIOStatsTruncated = minioStats.Truncated
+		stats.MinIOStorage = minioStats.TotalSize
+		stats.MinIOObjects = minioStats.ObjectCount
+		stats.MinIOStats = &minioStats
 	}
 
 	return c.JSON(stats)
@@ -190,12 +239,12 @@ func getStorageStats(c fiber.Ctx, minioClient *minio.Client, minioCfg config.Min
 func getUsageStats(c fiber.Ctx) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -205,9 +254,16 @@ func getUsageStats(c fiber.Ctx) error {
 	startDateStr := c.Query("start_date", "")
 	endDateStr := c.Query("end_date", "")
 
+	// Days already rolled up into usage_daily come from there (their raw
+	// apiusage rows may have been deleted); later days from apiusage.
+	rolledThrough, err := usageRolledUpThrough(ctx, conn)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query usage stats")
+	}
+
 	query := `
 		SELECT
-			DATE(timestamp) AS date,
+			substr(timestamp, 1, 10) AS date,
 			COUNT(id) AS api_calls,
 			COALESCE(AVG(response_time), 0.0) AS avg_response_time,
 			COALESCE((CAST(SUM(CASE WHEN status_code < 400 THEN 1 ELSE 0 END) AS FLOAT) * 100.0 / NULLIF(COUNT(id), 0)), 0.0) AS success_rate
@@ -215,71 +271,107 @@ func getUsageStats(c fiber.Ctx) error {
 		WHERE user_firebase_uid = ?
 	`
 	args := []any{user.UID}
+	rollupQuery := `
+		SELECT
+			day AS date,
+			SUM(api_calls) AS api_calls,
+			COALESCE(SUM(total_response_time) / NULLIF(SUM(api_calls), 0), 0.0) AS avg_response_time,
+			COALESCE(CAST(SUM(success_count) AS FLOAT) * 100.0 / NULLIF(SUM(api_calls), 0), 0.0) AS success_rate
+		FROM usage_daily
+		WHERE user_firebase_uid = ? AND day < ?
+	`
+	rollupArgs := []any{user.UID, rolledThrough.Format("2006-01-02")}
+	if !rolledThrough.IsZero() {
+		query += " AND timestamp >= ?"
+		args = append(args, rolledThrough)
+	}
 
 	if projectIDStr != "" {
 		projectID, err := strconv.ParseInt(projectIDStr, 10, 64)
 		if err != nil || projectID <= 0 {
-			return fiber.NewError(http.StatusBadRequest, "invalid project_id")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project_id")
 		}
 		query += " AND project_id = ?"
 		args = append(args, projectID)
+		rollupQuery += " AND project_id = ?"
+		rollupArgs = append(rollupArgs, projectID)
 	}
 
 	if startDateStr != "" {
 		start, err := time.Parse("2006-01-02", startDateStr)
 		if err != nil {
-			return fiber.NewError(http.StatusBadRequest, "invalid start_date")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid start_date")
 		}
 		query += " AND timestamp >= ?"
 		args = append(args, start)
+		rollupQuery += " AND day >= ?"
+		rollupArgs = append(rollupArgs, start.Format("2006-01-02"))
 	}
 
 	if endDateStr != "" {
 		end, err := time.Parse("2006-01-02", endDateStr)
 		if err != nil {
-			return fiber.NewError(http.StatusBadRequest, "invalid end_date")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid end_date")
 		}
 		// include full end day
 		end = end.AddDate(0, 0, 1)
 		query += " AND timestamp < ?"
 		args = append(args, end)
+		rollupQuery += " AND day < ?"
+		rollupArgs = append(rollupArgs, end.Format("2006-01-02"))
 	}
 
-	query += " GROUP BY DATE(timestamp) ORDER BY DATE(timestamp)"
+	query += " GROUP BY substr(timestamp, 1, 10) ORDER BY date"
+	rollupQuery += " GROUP BY day ORDER BY day"
+
+	// Initialize as empty slice (not nil) to ensure JSON returns []
+	stats := make([]UsageStats, 0)
+	// Rolled-up days all come before the raw ones, so appending keeps date order
+	if !rolledThrough.IsZero() {
+		if stats, err = appendUsageStats(ctx, conn, stats, rollupQuery, rollupArgs...); err != nil {
+			return err
+		}
+	}
+	if stats, err = appendUsageStats(ctx, conn, stats, query, args...); err != nil {
+		return err
+	}
+
+	return c.JSON(stats)
+}
 
+// appendUsageStats runs a per-day usage query (date, api_calls,
+// avg_response_time, success_rate) and appends its rows to stats.
+func appendUsageStats(ctx context.Context, conn *sql.DB, stats []UsageStats, query string, args ...any) ([]UsageStats, error) {
 	rows, err := conn.QueryContext(ctx, query, args...)
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to query usage stats")
+		return stats, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query usage stats")
 	}
 	defer rows.Close()
 
-	// Initialize as empty slice (not nil) to ensure JSON returns []
-	stats := make([]UsageStats, 0)
 	for rows.Next() {
 		var s UsageStats
 		if err := rows.Scan(&s.Date, &s.APICalls, &s.AvgResponseTime, &s.SuccessRate); err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "failed to scan usage stats")
+			return stats, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan usage stats")
 		}
 		stats = append(stats, s)
 	}
 
 	// Check for errors during iteration
 	if err := rows.Err(); err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to iterate usage stats")
+		return stats, apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate usage stats")
 	}
-
-	return c.JSON(stats)
+	return stats, nil
 }
 
 func getUsageDetails(c fiber.Ctx) error {
 	user, err := auth.GetCurrentFirebaseUser(c)
 	if err != nil {
-		return fiber.NewError(http.StatusUnauthorized, "User not authenticated")
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
 	}
 
 	conn, err := db.GetDB()
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "database not available")
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -306,7 +398,7 @@ func getUsageDetails(c fiber.Ctx) error {
 	if projectIDStr != "" {
 		projectID, err := strconv.ParseInt(projectIDStr, 10, 64)
 		if err != nil || projectID <= 0 {
-			return fiber.NewError(http.StatusBadRequest, "invalid project_id")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project_id")
 		}
 		query += " AND project_id = ?"
 		args = append(args, projectID)
@@ -315,7 +407,7 @@ func getUsageDetails(c fiber.Ctx) error {
 	if apiKeyIDStr != "" {
 		apiKeyID, err := strconv.ParseInt(apiKeyIDStr, 10, 64)
 		if err != nil || apiKeyID <= 0 {
-			return fiber.NewError(http.StatusBadRequest, "invalid api_key_id")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid api_key_id")
 		}
 		query += " AND api_key_id = ?"
 		args = append(args, apiKeyID)
@@ -324,7 +416,7 @@ func getUsageDetails(c fiber.Ctx) error {
 	if startDateStr != "" {
 		start, err := time.Parse("2006-01-02", startDateStr)
 		if err != nil {
-			return fiber.NewError(http.StatusBadRequest, "invalid start_date")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid start_date")
 		}
 		query += " AND timestamp >= ?"
 		args = append(args, start)
@@ -333,7 +425,7 @@ func getUsageDetails(c fiber.Ctx) error {
 	if endDateStr != "" {
 		end, err := time.Parse("2006-01-02", endDateStr)
 		if err != nil {
-			return fiber.NewError(http.StatusBadRequest, "invalid end_date")
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid end_date")
 		}
 		end = end.AddDate(0, 0, 1)
 		query += " AND timestamp < ?"
@@ -345,7 +437,7 @@ func getUsageDetails(c fiber.Ctx) error {
 
 	rows, err := conn.QueryContext(ctx, query, args...)
 	if err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to query usage details")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query usage details")
 	}
 	defer rows.Close()
 
@@ -363,17 +455,164 @@ func getUsageDetails(c fiber.Ctx) error {
 			&r.ProjectID,
 			&r.ApiKeyID,
 		); err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "failed to scan usage record")
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan usage record")
 		}
 		records = append(records, r)
 	}
 
 	// Check for errors during iteration
 	if err := rows.Err(); err != nil {
-		return fiber.NewError(http.StatusInternalServerError, "failed to iterate usage details")
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate usage details")
 	}
 
 	// Frontend accepts either a raw array or a paginated envelope; we return just the array,
 	// which is compatible with the current logic.
 	return c.JSON(records)
 }
+
+// getStorageHistory returns daily storage usage from storage_snapshot
+// (GET /usage/storage/history?days=30[&project_id=]). Days without a snapshot
+// are omitted rather than reported as zero.
+func getStorageHistory(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	days, err := strconv.Atoi(c.Query("days", "30"))
+	if err != nil || days <= 0 || days > 365 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "days must be between 1 and 365")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	since := time.Now().UTC().AddDate(0, 0, -(days - 1)).Format("2006-01-02")
+	query := `
+		SELECT day, COALESCE(SUM(total_size), 0), COALESCE(SUM(file_count), 0)
+		FROM storage_snapshot
+		WHERE user_firebase_uid = ? AND day >= ?
+	`
+	args := []any{user.UID, since}
+
+	if projectIDStr := c.Query("project_id", ""); projectIDStr != "" {
+		projectID, err := strconv.ParseInt(projectIDStr, 10, 64)
+		if err != nil || projectID <= 0 {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project_id")
+		}
+		query += " AND project_id = ?"
+		args = append(args, projectID)
+	}
+	query += " GROUP BY day ORDER BY day ASC"
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("getStorageHistory query error: %v", err)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load storage history")
+	}
+	defer rows.Close()
+
+	// Initialize as empty slice (not nil) to ensure JSON returns []
+	points := make([]StorageHistoryPoint, 0)
+	for rows.Next() {
+		var p StorageHistoryPoint
+		if err := rows.Scan(&p.Date, &p.TotalSize, &p.TotalFiles); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan storage history")
+		}
+		points = append(points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate storage history")
+	}
+
+	return c.JSON(points)
+}
+
+// getStorageByProject handles GET /usage/storage/by-project?start_date=&end_date=:
+// each of the user's projects with its average and maximum daily storage over
+// the window (default the last 30 days, end_date inclusive), from the daily
+// storage_snapshot rows. Days without a snapshot count as zero, so a project
+// created or emptied mid-window averages lower, as it would be billed. The
+// window ends today at the latest.
+func getStorageByProject(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	end := today
+	if endDateStr := c.Query("end_date", ""); endDateStr != "" {
+		if end, err = time.Parse("2006-01-02", endDateStr); err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid end_date")
+		}
+	}
+	start := end.AddDate(0, 0, -29)
+	if startDateStr := c.Query("start_date", ""); startDateStr != "" {
+		if start, err = time.Parse("2006-01-02", startDateStr); err != nil {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid start_date")
+		}
+	}
+	if end.After(today) {
+		end = today
+	}
+	if start.After(end) {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "start_date must not be after end_date or today")
+	}
+	days := int(end.Sub(start).Hours()/24) + 1
+	if days > maxStorageReportDays {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "the window can be at most "+strconv.Itoa(maxStorageReportDays)+" days")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT s.project_id, p.name,
+			CAST(SUM(s.total_size) AS FLOAT) / ?, MAX(s.total_size),
+			CAST(SUM(s.file_count) AS FLOAT) / ?, MAX(s.file_count)
+		FROM storage_snapshot s
+		LEFT JOIN project p ON p.id = s.project_id
+		WHERE s.user_firebase_uid = ? AND s.day >= ? AND s.day <= ?
+		GROUP BY s.project_id
+		ORDER BY 3 DESC
+	`, days, days, user.UID, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		log.Printf("getStorageByProject query error: %v", err)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load storage usage")
+	}
+	defer rows.Close()
+
+	report := ProjectStorageReport{
+		StartDate: start.Format("2006-01-02"),
+		EndDate:   end.Format("2006-01-02"),
+		Days:      days,
+		Items:     make([]ProjectStorageUsage, 0),
+	}
+	for rows.Next() {
+		var u ProjectStorageUsage
+		var name sql.NullString
+		if err := rows.Scan(&u.ProjectID, &name, &u.AverageSize, &u.MaxSize, &u.AverageFiles, &u.MaxFiles); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan storage usage")
+		}
+		if name.Valid {
+			u.ProjectName = &name.String
+		}
+		report.Items = append(report.Items, u)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate storage usage")
+	}
+
+	return c.JSON(report)
+}
@@ -0,0 +1,136 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+	"github.com/gabriel/open_upload_gobackend/internal/thumbcache"
+)
+
+// maxPosterSecond bounds ?t=, the second of the video a poster is taken from.
+const maxPosterSecond = 24 * 60 * 60
+
+// serveVideoPoster handles GET /files/:file_id/poster: a frame of a video
+// file, rendered by imgproxy at a preset size like the image routes, for
+// galleries to show instead of a generic icon. ?t= picks the second the
+// frame is taken from (default 0, imgproxy's own choice). Posters are cached
+// like other renderings.
+func serveVideoPoster(c fiber.Ctx, cfg config.MinioConfig, client *minio.Client, cache *thumbcache.Cache) error {
+	if err := checkTransformQuery(c); err != nil {
+		return err
+	}
+	fileID := c.Params("file_id")
+	if fileID == "" {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "file_id is required")
+	}
+	preset := c.Query("preset", "medium")
+	if !presetNamePattern.MatchString(preset) {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid preset")
+	}
+	format := c.Query("format", "webp")
+	if !isAllowedFormat(format) {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid format")
+	}
+	second := 0
+	if v := c.Query("t"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxPosterSecond {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "t must be a whole number of seconds between 0 and "+strconv.Itoa(maxPosterSecond))
+		}
+		second = n
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	dbCtx, dbCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer dbCancel()
+
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(dbCtx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &f); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.FileNotFound, "File not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load file")
+	}
+
+	if !strings.HasPrefix(normalizeContentType(cfg, f.Filename, f.MimeType), "video/") {
+		return apiError(http.StatusBadRequest, apierror.NotAVideo, "Posters are only available for video files")
+	}
+	if !cfg.VideoPosters {
+		return apiError(http.StatusNotImplemented, apierror.ImageServiceError, "video posters are not enabled (VIDEO_POSTERS)")
+	}
+	if !strings.HasPrefix(f.StoragePath, "s3://") || f.ContentEncoding != "" || f.Size == 0 {
+		return apiError(http.StatusNotFound, apierror.FileNotFound, "No poster available for this file")
+	}
+	key, err := extractKeyFromStoragePath(f.StoragePath, cfg.Bucket)
+	if err != nil {
+		return err
+	}
+
+	width, height, ok := resolvePreset(dbCtx, conn, cfg, f.ProjectID, preset)
+	if !ok {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid preset")
+	}
+
+	expectedType := formatContentType(format)
+	cacheVariant := "poster-" + presetCacheVariant(preset, width, height) + "-" + strconv.Itoa(second)
+	etag := imageETag(cfg, f.ContentHash, cacheVariant, format)
+	if etag != "" && etagMatches(c.Get("If-None-Match"), etag) {
+		c.Set("ETag", etag)
+		c.Set("Cache-Control", "public, max-age=3600")
+		return c.SendStatus(http.StatusNotModified)
+	}
+
+	c.Set("Cache-Control", "public, max-age=3600")
+	c.Set("Content-Disposition", `inline; filename="poster_`+downloadFilename(cfg, f, key)+`.`+format+`"`)
+	if body, ok := cache.Get(f.ID, cacheVariant, format); ok {
+		c.Set("Content-Type", expectedType)
+		c.Set("X-Cache", "HIT")
+		if etag != "" {
+			c.Set("ETag", etag)
+		}
+		return c.Send(body)
+	}
+
+	// video_thumbnail_second picks the frame; without it imgproxy takes the
+	// first keyframe
+	path := imgproxyPath(cfg, key, "fit", width, height, format)
+	if second > 0 {
+		path = "/vts:" + strconv.Itoa(second) + path
+	}
+	log.Printf("poster: fileID=%s, key=%s, second=%d", f.ID, key, second)
+	body, contentType, err := fetchImgproxyURL(c.Context(), cfg, signedImgproxyURL(cfg, path), key, "poster")
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = expectedType
+	}
+	c.Set("Content-Type", contentType)
+	if contentType == expectedType {
+		cache.Put(f.ID, cacheVariant, format, body)
+		if etag != "" {
+			c.Set("ETag", etag)
+		}
+	}
+	c.Set("X-Cache", "MISS")
+	return c.Send(body)
+}
@@ -0,0 +1,359 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// Delivery statuses stored in webhook_delivery.status. A failed attempt
+// leaves the delivery pending with a later next_retry_at until
+// webhookMaxAttempts is reached, then it is dead.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+const (
+	// WebhookDeliveryHeader carries the delivery id, the same on every
+	// attempt, so receivers can drop duplicates.
+	WebhookDeliveryHeader = "X-Webhook-Delivery"
+
+	// webhookPollInterval is how often due deliveries are sent.
+	webhookPollInterval = 5 * time.Second
+
+	// webhookBatch is how many due deliveries one poll sends.
+	webhookBatch = 20
+
+	// webhookMaxAttempts is when a failing delivery is marked dead, about
+	// four hours after the event with webhookRetryDelay.
+	webhookMaxAttempts = 10
+
+	// webhookTimeout bounds one delivery attempt; slower receivers are
+	// retried like failing ones.
+	webhookTimeout = 10 * time.Second
+
+	// webhookDeliveryRetentionDays is how long delivered and dead deliveries
+	// stay inspectable.
+	webhookDeliveryRetentionDays = 30
+)
+
+// newWebhookClient returns the client deliveries are sent with. It checks
+// the address of every connection it makes (see webhookDialControl). It
+// doesn't use HTTP_PROXY, which would make the proxy the only address
+// checked, and doesn't follow redirects: a 3xx counts as a failed attempt.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	return &http.Client{
+		Timeout: webhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Transport: &http.Transport{
+			DialContext:         (&net.Dialer{Timeout: webhookTimeout, Control: webhookDialControl(allowPrivate)}).DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// webhookDelivery is a row of webhook_delivery.
+type webhookDelivery struct {
+	ID           int64           `json:"id"`
+	WebhookID    int64           `json:"webhook_id"`
+	Event        string          `json:"event"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	ResponseCode *int            `json:"response_code"`
+	LastError    *string         `json:"last_error"`
+	NextRetryAt  *time.Time      `json:"next_retry_at"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	Payload      json.RawMessage `json:"payload"`
+}
+
+// dueDelivery is a pending delivery with its webhook's URL and secret.
+type dueDelivery struct {
+	id       int64
+	event    string
+	body     string
+	attempts int
+	url      string
+	secret   string
+}
+
+// webhookRetryDelay is an exponential backoff: 30s, 1m, 2m, ... capped at 2h.
+func webhookRetryDelay(attempts int) time.Duration {
+	d := 30 * time.Second << (attempts - 1)
+	if d <= 0 || d > 2*time.Hour {
+		return 2 * time.Hour
+	}
+	return d
+}
+
+// deliverDueWebhooks sends the pending deliveries whose next_retry_at has
+// passed and records each attempt. It runs periodically on the job pool, so
+// deliveries survive restarts and are sent at least once.
+func deliverDueWebhooks(ctx context.Context, client *http.Client) error {
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT d.id, d.event, d.body, d.attempts, w.url, w.secret
+		FROM webhook_delivery d
+		JOIN project_webhook w ON w.id = d.webhook_id
+		WHERE d.status = ? AND d.next_retry_at <= ?
+		ORDER BY d.next_retry_at, d.id
+		LIMIT ?
+	`, deliveryPending, time.Now().UTC(), webhookBatch)
+	if err != nil {
+		return err
+	}
+	due := make([]dueDelivery, 0)
+	for rows.Next() {
+		var d dueDelivery
+		if err := rows.Scan(&d.id, &d.event, &d.body, &d.attempts, &d.url, &d.secret); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return nil
+		}
+		code, sendErr := sendWebhook(ctx, client, d)
+		if err := recordDeliveryAttempt(conn, d, code, sendErr); err != nil {
+			log.Printf("webhooks: failed to record delivery %d: %v", d.id, err)
+		}
+	}
+	return nil
+}
+
+// sendWebhook POSTs a delivery's body, signed with the webhook's secret. It
+// returns the response status (0 without a response) and an error for
+// anything but 2xx.
+func sendWebhook(ctx context.Context, client *http.Client, d dueDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader([]byte(d.body)))
+	if err != nil {
+		return 0, err
+	}
+	mac := hmac.New(sha256.New, []byte(d.secret))
+	mac.Write([]byte(d.body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "open-upload-webhooks")
+	req.Header.Set(WebhookEventHeader, d.event)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(d.id, 10))
+	req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookErrorClass is the last_error stored for a failed attempt: the
+// response status, or a generic class of the network error. Raw dial errors
+// would tell project owners which internal ports are open; they are only
+// logged.
+func webhookErrorClass(code int, err error) string {
+	if code != 0 {
+		return "responded " + strconv.Itoa(code)
+	}
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errWebhookAddress):
+		return "address not allowed"
+	case errors.As(err, &dnsErr):
+		return "host not found"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timed out"
+	default:
+		return "connection failed"
+	}
+}
+
+// recordDeliveryAttempt stores the outcome of one attempt: delivered, retried
+// after webhookRetryDelay, or dead after webhookMaxAttempts.
+func recordDeliveryAttempt(conn *sql.DB, d dueDelivery, code int, sendErr error) error {
+	// Record the outcome even if we're shutting down
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	attempts := d.attempts + 1
+	status := deliveryDelivered
+	var nextRetry, lastError, responseCode any
+	if code != 0 {
+		responseCode = code
+	}
+	if sendErr != nil {
+		lastError = webhookErrorClass(code, sendErr)
+		if code == 0 {
+			log.Printf("webhooks: delivery %d attempt %d failed: %v", d.id, attempts, sendErr)
+		}
+		if attempts >= webhookMaxAttempts {
+			status = deliveryDead
+			log.Printf("webhooks: delivery %d dead after %d attempts: %v", d.id, attempts, sendErr)
+		} else {
+			status = deliveryPending
+			nextRetry = now.Add(webhookRetryDelay(attempts))
+		}
+	}
+
+	_, err := db.ExecWithRetry(ctx, conn, `
+		UPDATE webhook_delivery
+		SET status = ?, attempts = ?, response_code = ?, last_error = ?, next_retry_at = ?, updated_at = ?
+		WHERE id = ?
+	`, status, attempts, responseCode, lastError, nextRetry, now, d.id)
+	return err
+}
+
+// cleanupWebhookDeliveries deletes delivered and dead deliveries older than
+// webhookDeliveryRetentionDays.
+func cleanupWebhookDeliveries(ctx context.Context) error {
+	conn, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecWithRetry(ctx, conn, `
+		DELETE FROM webhook_delivery
+		WHERE status != ? AND updated_at < ?
+	`, deliveryPending, time.Now().UTC().AddDate(0, 0, -webhookDeliveryRetentionDays))
+	return err
+}
+
+// listWebhookDeliveries handles
+// GET /projects/:project_id/webhooks/:webhook_id/deliveries: the webhook's
+// deliveries, newest first, optionally filtered by status (pending,
+// delivered, dead).
+func listWebhookDeliveries(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+	webhookID, err := strconv.ParseInt(c.Params("webhook_id"), 10, 64)
+	if err != nil || webhookID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid webhook id")
+	}
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	where := `webhook_id = ?`
+	args := []any{webhookID}
+	if status := c.Query("status"); status != "" {
+		if status != deliveryPending && status != deliveryDelivered && status != deliveryDead {
+			return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid status (expected pending, delivered or dead)")
+		}
+		where += ` AND status = ?`
+		args = append(args, status)
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := requireProjectOwner(ctx, conn, projectID, user.UID); err != nil {
+		return err
+	}
+	var exists int
+	if err := conn.QueryRowContext(ctx, `
+		SELECT 1
+		FROM project_webhook
+		WHERE id = ? AND project_id = ?
+	`, webhookID, projectID).Scan(&exists); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.NotFound, "Webhook not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load webhook")
+	}
+
+	resp := pageResponse[webhookDelivery]{Items: make([]webhookDelivery, 0), Limit: limit, Offset: offset}
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM webhook_delivery WHERE `+where, args...).Scan(&resp.Total); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to count deliveries")
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT id, webhook_id, event, status, attempts, response_code, last_error, next_retry_at, created_at, updated_at, body
+		FROM webhook_delivery
+		WHERE `+where+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query deliveries")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d webhookDelivery
+		var code sql.NullInt64
+		var lastError sql.NullString
+		var nextRetry sql.NullTime
+		var body string
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Status, &d.Attempts, &code, &lastError, &nextRetry, &d.CreatedAt, &d.UpdatedAt, &body); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan delivery")
+		}
+		if code.Valid {
+			n := int(code.Int64)
+			d.ResponseCode = &n
+		}
+		if lastError.Valid {
+			d.LastError = &lastError.String
+		}
+		if nextRetry.Valid {
+			d.NextRetryAt = &nextRetry.Time
+		}
+		d.Payload = json.RawMessage(body)
+		resp.Items = append(resp.Items, d)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate deliveries")
+	}
+
+	return c.JSON(resp)
+}
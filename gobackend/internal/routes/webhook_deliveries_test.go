@@ -0,0 +1,46 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestWebhookErrorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		err  error
+		want string
+	}{
+		{"status", 302, errors.New("responded 302"), "responded 302"},
+		{"blocked", 0, fmt.Errorf("dial tcp 10.0.0.1:9000: %w", errWebhookAddress), "address not allowed"},
+		{"dns", 0, &net.DNSError{Err: "no such host", Name: "minio.internal", IsNotFound: true}, "host not found"},
+		{"deadline", 0, fmt.Errorf("post: %w", context.DeadlineExceeded), "timed out"},
+		{"net timeout", 0, &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, "timed out"},
+		{"refused", 0, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}, "connection failed"},
+	}
+	for _, tt := range tests {
+		if got := webhookErrorClass(tt.code, tt.err); got != tt.want {
+			t.Errorf("%s: webhookErrorClass = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookClientRefusesRedirects(t *testing.T) {
+	client := newWebhookClient(false)
+	if client.CheckRedirect == nil {
+		t.Fatal("the webhook client follows redirects")
+	}
+	if err := client.CheckRedirect(nil, nil); err == nil {
+		t.Fatal("CheckRedirect allowed a redirect")
+	}
+}
@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errWebhookAddress is returned when a webhook resolves to an address it may
+// not be sent to.
+var errWebhookAddress = errors.New("webhook address not allowed")
+
+// sharedAddressSpace is 100.64.0.0/10 (carrier-grade NAT), internal like the
+// RFC 1918 ranges but not covered by netip.Addr.IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// webhookAddrAllowed reports whether a webhook may be sent to addr.
+// allowPrivate is WEBHOOK_ALLOW_PRIVATE: every address is allowed.
+func webhookAddrAllowed(addr netip.Addr, allowPrivate bool) bool {
+	if allowPrivate {
+		return true
+	}
+	addr = addr.Unmap()
+	return addr.IsValid() && addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// checkWebhookHost rejects webhook hosts that are, or resolve to, addresses
+// webhooks may not be sent to. Hosts that don't resolve yet are accepted;
+// the dialer checks every address again when sending.
+func checkWebhookHost(ctx context.Context, host string, allowPrivate bool) error {
+	if allowPrivate {
+		return nil
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errWebhookAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !webhookAddrAllowed(addr, allowPrivate) {
+			return errWebhookAddress
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !webhookAddrAllowed(addr, allowPrivate) {
+			return errWebhookAddress
+		}
+	}
+	return nil
+}
+
+// webhookDialControl returns a net.Dialer Control that refuses connections
+// to addresses webhooks may not be sent to. It runs on the resolved address
+// of every connection, so a host re-pointed at an internal address after it
+// was saved (DNS rebinding) is still refused.
+func webhookDialControl(allowPrivate bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return errWebhookAddress
+		}
+		addr, err := netip.ParseAddr(host)
+		if err != nil || !webhookAddrAllowed(addr, allowPrivate) {
+			return errWebhookAddress
+		}
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestWebhookAddrAllowed(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.5", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"::ffff:127.0.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := webhookAddrAllowed(netip.MustParseAddr(tt.addr), false); got != tt.want {
+			t.Errorf("webhookAddrAllowed(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+		if !webhookAddrAllowed(netip.MustParseAddr(tt.addr), true) {
+			t.Errorf("webhookAddrAllowed(%s) with WEBHOOK_ALLOW_PRIVATE refused it", tt.addr)
+		}
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	for _, host := range []string{"localhost", "api.localhost", "127.0.0.1", "169.254.169.254", "::1"} {
+		if err := checkWebhookHost(context.Background(), host, false); err == nil {
+			t.Errorf("checkWebhookHost(%q) accepted an internal host", host)
+		}
+		if err := checkWebhookHost(context.Background(), host, true); err != nil {
+			t.Errorf("checkWebhookHost(%q) with WEBHOOK_ALLOW_PRIVATE = %v", host, err)
+		}
+	}
+	if err := checkWebhookHost(context.Background(), "93.184.216.34", false); err != nil {
+		t.Errorf("checkWebhookHost(public IP) = %v", err)
+	}
+}
+
+func TestWebhookDialControl(t *testing.T) {
+	control := webhookDialControl(false)
+	if err := control("tcp4", "127.0.0.1:9000", nil); err == nil {
+		t.Error("dial to loopback was allowed")
+	}
+	if err := control("tcp4", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("dial to public address: %v", err)
+	}
+	if err := webhookDialControl(true)("tcp4", "127.0.0.1:9000", nil); err != nil {
+		t.Errorf("dial to loopback with WEBHOOK_ALLOW_PRIVATE: %v", err)
+	}
+}
+
+func TestSendWebhookRefusesLoopback(t *testing.T) {
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hit = true }))
+	defer srv.Close()
+
+	d := dueDelivery{id: 1, event: webhookEventFileUploaded, body: "{}", url: srv.URL, secret: "s"}
+	_, err := sendWebhook(context.Background(), newWebhookClient(false), d)
+	if !errors.Is(err, errWebhookAddress) {
+		t.Fatalf("sendWebhook to %s: err = %v, want errWebhookAddress", srv.URL, err)
+	}
+	if hit {
+		t.Fatal("the loopback receiver was reached")
+	}
+
+	// WEBHOOK_ALLOW_PRIVATE lets it through
+	if code, err := sendWebhook(context.Background(), newWebhookClient(true), d); err != nil || code != http.StatusOK {
+		t.Fatalf("sendWebhook with WEBHOOK_ALLOW_PRIVATE: %d, %v", code, err)
+	}
+	if !hit {
+		t.Fatal("the loopback receiver was not reached")
+	}
+}
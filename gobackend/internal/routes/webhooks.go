@@ -0,0 +1,444 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/gabriel/open_upload_gobackend/internal/apierror"
+	"github.com/gabriel/open_upload_gobackend/internal/audit"
+	"github.com/gabriel/open_upload_gobackend/internal/auth"
+	"github.com/gabriel/open_upload_gobackend/internal/config"
+	"github.com/gabriel/open_upload_gobackend/internal/db"
+)
+
+// Events a project webhook can subscribe to.
+const (
+	webhookEventFileUploaded   = "file.uploaded"
+	webhookEventFileDeleted    = "file.deleted"
+	webhookEventAPIKeyDisabled = "api_key.disabled"
+)
+
+var webhookEvents = []string{webhookEventFileUploaded, webhookEventFileDeleted, webhookEventAPIKeyDisabled}
+
+const (
+	// WebhookEventHeader names the event of a delivery and
+	// WebhookSignatureHeader carries "sha256=<hex HMAC-SHA256 of the body>",
+	// keyed with the webhook's secret.
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+
+	// maxWebhooksPerProject caps how many endpoints one event fans out to.
+	maxWebhooksPerProject = 10
+
+	maxWebhookURLLength = 2048
+
+	// minWebhookSecretLength applies to secrets chosen by the client.
+	minWebhookSecretLength = 16
+)
+
+// projectWebhook is a row of project_webhook. The secret is only returned
+// when the webhook is created or its secret is replaced.
+type projectWebhook struct {
+	ID        int64     `json:"id"`
+	ProjectID int64     `json:"project_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type webhookRequest struct {
+	URL string `json:"url"`
+	// Events defaults to every event.
+	Events []string `json:"events"`
+	// Secret defaults to a random one on create and is kept on update.
+	Secret string `json:"secret"`
+}
+
+// webhookEvent is the JSON body POSTed to a webhook. File events carry the
+// file, API key events the key.
+type webhookEvent struct {
+	Event     string         `json:"event"`
+	CreatedAt time.Time      `json:"created_at"`
+	ProjectID int64          `json:"project_id"`
+	File      *db.File       `json:"file,omitempty"`
+	APIKey    *webhookAPIKey `json:"api_key,omitempty"`
+}
+
+// webhookAPIKey is an API key in a webhook event, without the key itself.
+type webhookAPIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// requireProjectOwner checks that the project exists and belongs to uid.
+func requireProjectOwner(ctx context.Context, conn *sql.DB, projectID int64, uid string) error {
+	var ownerUID string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT user_firebase_uid
+		FROM project
+		WHERE id = ?
+	`, projectID).Scan(&ownerUID); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.ProjectNotFound, "Project not found")
+		}
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to load project")
+	}
+	if ownerUID != uid {
+		return apiError(http.StatusForbidden, apierror.Forbidden, "Not authorized to access this project")
+	}
+	return nil
+}
+
+// validateWebhookRequest checks the URL and events and returns the events to
+// store. URLs pointing at loopback, private or link-local addresses are
+// refused unless WEBHOOK_ALLOW_PRIVATE is set.
+func validateWebhookRequest(cfg config.MinioConfig, req webhookRequest) ([]string, error) {
+	if len(req.URL) > maxWebhookURLLength {
+		return nil, apiError(http.StatusBadRequest, apierror.InvalidRequest, "url is too long")
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, apiError(http.StatusBadRequest, apierror.InvalidRequest, "url must be an absolute http or https URL")
+	}
+	if err := checkWebhookHost(context.Background(), u.Hostname(), cfg.WebhookAllowPrivate); err != nil {
+		return nil, apiError(http.StatusBadRequest, apierror.InvalidRequest, "url must not point at a loopback, private or link-local address")
+	}
+	if req.Secret != "" && len(req.Secret) < minWebhookSecretLength {
+		return nil, apiError(http.StatusBadRequest, apierror.InvalidRequest, "secret must be at least "+strconv.Itoa(minWebhookSecretLength)+" characters")
+	}
+
+	if len(req.Events) == 0 {
+		return webhookEvents, nil
+	}
+	events := make([]string, 0, len(req.Events))
+	for _, e := range req.Events {
+		if !slices.Contains(webhookEvents, e) {
+			return nil, apiError(http.StatusBadRequest, apierror.InvalidRequest, "unknown event "+strconv.Quote(e)+" (expected "+strings.Join(webhookEvents, ", ")+")")
+		}
+		if !slices.Contains(events, e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// listWebhooks handles GET /projects/:project_id/webhooks.
+func listWebhooks(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := requireProjectOwner(ctx, conn, projectID, user.UID); err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT id, project_id, url, events, created_at
+		FROM project_webhook
+		WHERE project_id = ?
+		ORDER BY id
+	`, projectID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to query webhooks")
+	}
+	defer rows.Close()
+
+	webhooks := make([]projectWebhook, 0)
+	for rows.Next() {
+		var w projectWebhook
+		var events string
+		if err := rows.Scan(&w.ID, &w.ProjectID, &w.URL, &events, &w.CreatedAt); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to scan webhook")
+		}
+		w.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to iterate webhooks")
+	}
+
+	return c.JSON(webhooks)
+}
+
+// createWebhook handles POST /projects/:project_id/webhooks. The response is
+// the only time a generated secret is shown.
+func createWebhook(c fiber.Ctx, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+
+	var req webhookRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid JSON body")
+	}
+	events, err := validateWebhookRequest(cfg, req)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := requireProjectOwner(ctx, conn, projectID, user.UID); err != nil {
+		return err
+	}
+
+	var count int
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM project_webhook WHERE project_id = ?`, projectID).Scan(&count); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to count webhooks")
+	}
+	if count >= maxWebhooksPerProject {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "a project can have at most "+strconv.Itoa(maxWebhooksPerProject)+" webhooks")
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		if secret, err = newWebhookSecret(); err != nil {
+			return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to generate secret")
+		}
+	}
+
+	w := projectWebhook{ProjectID: projectID, URL: req.URL, Events: events, Secret: secret, CreatedAt: time.Now().UTC()}
+	res, err := db.ExecWithRetry(ctx, conn, `
+		INSERT INTO project_webhook (project_id, url, secret, events, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, projectID, w.URL, secret, strings.Join(events, ","), w.CreatedAt)
+	if err != nil {
+		log.Printf("createWebhook insert error: %v", err)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to create webhook")
+	}
+	if w.ID, err = res.LastInsertId(); err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to create webhook")
+	}
+	audit.Record(ctx, user.UID, audit.ActionCreate, audit.TargetWebhook, strconv.FormatInt(w.ID, 10), projectID, w.URL)
+
+	return c.Status(http.StatusCreated).JSON(w)
+}
+
+// updateWebhook handles PUT /projects/:project_id/webhooks/:webhook_id,
+// replacing the URL and events. A non-empty secret replaces the old one and
+// is echoed back.
+func updateWebhook(c fiber.Ctx, cfg config.MinioConfig) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+	webhookID, err := strconv.ParseInt(c.Params("webhook_id"), 10, 64)
+	if err != nil || webhookID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid webhook id")
+	}
+
+	var req webhookRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid JSON body")
+	}
+	events, err := validateWebhookRequest(cfg, req)
+	if err != nil {
+		return err
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := requireProjectOwner(ctx, conn, projectID, user.UID); err != nil {
+		return err
+	}
+
+	w := projectWebhook{ID: webhookID, ProjectID: projectID, URL: req.URL, Events: events, Secret: req.Secret}
+	if err := conn.QueryRowContext(ctx, `
+		UPDATE project_webhook
+		SET url = ?, events = ?, secret = COALESCE(NULLIF(?, ''), secret)
+		WHERE id = ? AND project_id = ?
+		RETURNING created_at
+	`, w.URL, strings.Join(events, ","), req.Secret, webhookID, projectID).Scan(&w.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return apiError(http.StatusNotFound, apierror.NotFound, "Webhook not found")
+		}
+		log.Printf("updateWebhook error: %v", err)
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to update webhook")
+	}
+	audit.Record(ctx, user.UID, audit.ActionUpdate, audit.TargetWebhook, strconv.FormatInt(webhookID, 10), projectID, w.URL)
+
+	return c.JSON(w)
+}
+
+// deleteWebhook handles DELETE /projects/:project_id/webhooks/:webhook_id.
+// Deliveries already queued for it are dropped.
+func deleteWebhook(c fiber.Ctx) error {
+	user, err := auth.GetCurrentFirebaseUser(c)
+	if err != nil {
+		return apiError(http.StatusUnauthorized, apierror.Unauthenticated, "User not authenticated")
+	}
+
+	projectID, err := strconv.ParseInt(c.Params("project_id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid project id")
+	}
+	webhookID, err := strconv.ParseInt(c.Params("webhook_id"), 10, 64)
+	if err != nil || webhookID <= 0 {
+		return apiError(http.StatusBadRequest, apierror.InvalidRequest, "invalid webhook id")
+	}
+
+	conn, err := db.GetDB()
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.DatabaseUnavailable, "database not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := requireProjectOwner(ctx, conn, projectID, user.UID); err != nil {
+		return err
+	}
+
+	res, err := db.ExecWithRetry(ctx, conn, `DELETE FROM project_webhook WHERE id = ? AND project_id = ?`, webhookID, projectID)
+	if err != nil {
+		return apiError(http.StatusInternalServerError, apierror.InternalError, "failed to delete webhook")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return apiError(http.StatusNotFound, apierror.NotFound, "Webhook not found")
+	}
+	if _, err := db.ExecWithRetry(ctx, conn, `DELETE FROM webhook_delivery WHERE webhook_id = ?`, webhookID); err != nil {
+		log.Printf("deleteWebhook: failed to delete deliveries of webhook %d: %v", webhookID, err)
+	}
+	audit.Record(ctx, user.UID, audit.ActionDelete, audit.TargetWebhook, strconv.FormatInt(webhookID, 10), projectID, "")
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// dispatchFileEvent queues a delivery of event for f (a webhook_delivery row)
+// to each of its project's webhooks subscribed to it. Failures are logged only; the request that
+// caused the event has already succeeded.
+func dispatchFileEvent(ctx context.Context, event string, f db.File) {
+	dispatchWebhookEvent(ctx, webhookEvent{Event: event, ProjectID: f.ProjectID, File: &f})
+}
+
+// dispatchWebhookEvent queues e for every webhook of its project subscribed
+// to it. Failures are logged.
+func dispatchWebhookEvent(ctx context.Context, e webhookEvent) {
+	event := e.Event
+	conn, err := db.GetDB()
+	if err != nil {
+		log.Printf("webhooks: database unavailable: %v", err)
+		return
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT id, events
+		FROM project_webhook
+		WHERE project_id = ?
+	`, e.ProjectID)
+	if err != nil {
+		log.Printf("webhooks: failed to load webhooks of project %d: %v", e.ProjectID, err)
+		return
+	}
+	hookIDs := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		var events string
+		if err := rows.Scan(&id, &events); err != nil {
+			log.Printf("webhooks: failed to scan webhook: %v", err)
+			continue
+		}
+		if slices.Contains(strings.Split(events, ","), event) {
+			hookIDs = append(hookIDs, id)
+		}
+	}
+	rows.Close()
+	if len(hookIDs) == 0 {
+		return
+	}
+
+	e.CreatedAt = time.Now().UTC()
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("webhooks: failed to encode %s event: %v", event, err)
+		return
+	}
+	now := time.Now().UTC()
+	for _, id := range hookIDs {
+		if _, err := db.ExecWithRetry(ctx, conn, `
+			INSERT INTO webhook_delivery (webhook_id, project_id, event, body, status, attempts, next_retry_at, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, 0, ?, ?, ?)
+		`, id, e.ProjectID, event, string(body), deliveryPending, now, now, now); err != nil {
+			log.Printf("webhooks: failed to queue %s for webhook %d: %v", event, id, err)
+		}
+	}
+}
+
+// dispatchFileUploaded sends file.uploaded for a newly inserted file.
+func dispatchFileUploaded(ctx context.Context, fileID string) {
+	conn, err := db.GetDB()
+	if err != nil {
+		log.Printf("webhooks: database unavailable: %v", err)
+		return
+	}
+	var f db.File
+	if err := db.ScanFile(conn.QueryRowContext(ctx, `
+		SELECT `+db.FileColumns+`
+		FROM file
+		WHERE id = ?
+	`, fileID), &f); err != nil {
+		log.Printf("webhooks: failed to load file %s: %v", fileID, err)
+		return
+	}
+	dispatchFileEvent(ctx, webhookEventFileUploaded, f)
+}
@@ -0,0 +1,227 @@
+// Package thumbcache is a small on-disk cache for images generated by
+// imgproxy, so popular thumbnails are not re-rendered on every request.
+package thumbcache
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores generated images under dir/<file_id>/<preset>.<format>.
+// Entries older than ttl are treated as misses, and the oldest entries are
+// evicted once the total size goes over maxBytes. A nil *Cache is a valid,
+// disabled cache.
+type Cache struct {
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+
+	mu    sync.Mutex
+	total int64
+}
+
+// New returns a cache rooted at dir, or nil when dir is empty (caching disabled).
+func New(dir string, ttl time.Duration, maxBytes int64) *Cache {
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("thumbcache: failed to create %s, caching disabled: %v", dir, err)
+		return nil
+	}
+
+	c := &Cache{dir: dir, ttl: ttl, maxBytes: maxBytes}
+	for _, e := range c.entries() {
+		c.total += e.size
+	}
+	log.Printf("thumbcache: using %s (ttl=%s, max_bytes=%d, current=%d)", dir, ttl, maxBytes, c.total)
+	return c
+}
+
+// Get returns the cached image for (fileID, preset, format), if present and fresh.
+func (c *Cache) Get(fileID, preset, format string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	path, ok := c.path(fileID, preset, format)
+	if !ok {
+		return nil, false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+		c.remove(path, info.Size())
+		return nil, false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Put stores an image, evicting the oldest entries if the size cap is exceeded.
+// Errors are logged and otherwise ignored; the cache is best-effort.
+func (c *Cache) Put(fileID, preset, format string, data []byte) {
+	if c == nil {
+		return
+	}
+	if c.maxBytes > 0 && int64(len(data)) > c.maxBytes {
+		return
+	}
+	path, ok := c.path(fileID, preset, format)
+	if !ok {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Printf("thumbcache: mkdir error: %v", err)
+		return
+	}
+
+	var previous int64
+	if info, err := os.Stat(path); err == nil {
+		previous = info.Size()
+	}
+
+	// Write to a temp file and rename so readers never see a partial image
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		log.Printf("thumbcache: create temp error: %v", err)
+		return
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		log.Printf("thumbcache: write error: %v", err)
+		return
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("thumbcache: rename error: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	c.total += int64(len(data)) - previous
+	over := c.maxBytes > 0 && c.total > c.maxBytes
+	c.mu.Unlock()
+
+	if over {
+		c.evict()
+	}
+}
+
+// DeleteFile removes every cached image of a file, e.g. before regenerating
+// its thumbnails.
+func (c *Cache) DeleteFile(fileID string) {
+	if c == nil {
+		return
+	}
+	if fileID == "" || fileID == "." || fileID == ".." || strings.ContainsAny(fileID, `/\`) {
+		return
+	}
+	dir := filepath.Join(c.dir, fileID)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		// Temp files belong to a Put in progress and aren't counted yet
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".tmp-") {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		c.remove(filepath.Join(dir, f.Name()), info.Size())
+	}
+}
+
+// path builds the entry path, rejecting components that could escape dir.
+func (c *Cache) path(fileID, preset, format string) (string, bool) {
+	for _, part := range []string{fileID, preset, format} {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return "", false
+		}
+	}
+	return filepath.Join(c.dir, fileID, preset+"."+format), true
+}
+
+func (c *Cache) remove(path string, size int64) {
+	if err := os.Remove(path); err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.total -= size
+	c.mu.Unlock()
+	// Drop the per-file directory once it is empty
+	_ = os.Remove(filepath.Dir(path))
+}
+
+type entry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *Cache) entries() []entry {
+	var out []entry
+	_ = filepath.WalkDir(c.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		out = append(out, entry{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return out
+}
+
+// evict removes expired entries, then the oldest ones until the cache is
+// back under 90% of maxBytes so we don't evict on every Put.
+func (c *Cache) evict() {
+	entries := c.entries()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	target := c.maxBytes * 9 / 10
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+
+	removed := 0
+	for _, e := range entries {
+		expired := c.ttl > 0 && time.Since(e.modTime) > c.ttl
+		if !expired && total <= target {
+			continue
+		}
+		if err := os.Remove(e.path); err != nil {
+			continue
+		}
+		_ = os.Remove(filepath.Dir(e.path))
+		total -= e.size
+		removed++
+	}
+
+	c.mu.Lock()
+	c.total = total
+	c.mu.Unlock()
+	log.Printf("thumbcache: evicted %d entries, size now %d bytes", removed, total)
+}